@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// Modes of the -annotations flag.
+const (
+	annotationsGitHub = "github"
+	annotationsGitLab = "gitlab"
+	annotationsAuto   = "auto"
+)
+
+// annotationMode returns the CI system to annotate failures for, detecting
+// it from the environment in auto mode, or "" for none.
+func annotationMode(mode string) (string, error) {
+	switch mode {
+	case "", annotationsGitHub, annotationsGitLab:
+		return mode, nil
+	case annotationsAuto:
+		switch {
+		case os.Getenv("GITHUB_ACTIONS") == "true":
+			return annotationsGitHub, nil
+		case os.Getenv("GITLAB_CI") == "true":
+			return annotationsGitLab, nil
+		}
+		return "", nil
+	}
+	return "", fmt.Errorf("unknown annotations %q (want %s, %s or %s)", mode, annotationsGitHub, annotationsGitLab, annotationsAuto)
+}
+
+// printAnnotations prints the failures of a run in the format of a CI
+// system: GitHub Actions error commands, which annotate the failing
+// migration file at the failing line in the pull request, or GitLab
+// collapsible sections, one per failed database.
+func printAnnotations(mode string, results []pgmigrate.MigrationResult) {
+	section := 0
+	for _, result := range results {
+		if result.Success {
+			continue
+		}
+		name := displayName(result.Cluster, result.Database, result.Schema)
+		var migrationErr *pgmigrate.MigrationError
+		errors.As(result.Error, &migrationErr)
+		switch mode {
+		case annotationsGitHub:
+			printGitHubError(name, result.Error, migrationErr)
+		case annotationsGitLab:
+			section++
+			printGitLabSection(section, name, result.Error, migrationErr)
+		}
+	}
+}
+
+// printGitHubError prints an ::error workflow command for a failed
+// database, located in the file of its failed migration if there is one.
+func printGitHubError(name string, err error, migrationErr *pgmigrate.MigrationError) {
+	var properties []string
+	title := "Migration failed on " + name
+	if migrationErr != nil {
+		title = fmt.Sprintf("Migration %s failed on %s", migrationErr.Version, name)
+		if migrationErr.File != "" {
+			properties = append(properties, "file="+escapeGitHubProperty(workspacePath(migrationErr.File)))
+			if migrationErr.Line > 0 {
+				properties = append(properties, fmt.Sprintf("line=%d", migrationErr.Line))
+			}
+			if migrationErr.Column > 0 {
+				properties = append(properties, fmt.Sprintf("col=%d", migrationErr.Column))
+			}
+		}
+	}
+	properties = append(properties, "title="+escapeGitHubProperty(title))
+	fmt.Printf("::error %s::%s\n", strings.Join(properties, ","), escapeGitHubData(err.Error()))
+}
+
+// workspacePath returns the path of a migration file relative to the
+// checkout, as GitHub expects in annotations.
+func workspacePath(path string) string {
+	if workspace := os.Getenv("GITHUB_WORKSPACE"); workspace != "" && filepath.IsAbs(path) {
+		if rel, err := filepath.Rel(workspace, path); err == nil && !strings.HasPrefix(rel, "..") {
+			path = rel
+		}
+	}
+	return filepath.ToSlash(filepath.Clean(path))
+}
+
+// escapeGitHubData escapes the message of a workflow command.
+func escapeGitHubData(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// escapeGitHubProperty escapes a property of a workflow command.
+func escapeGitHubProperty(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A", ":", "%3A", ",", "%2C").Replace(s)
+}
+
+// printGitLabSection prints the failure of a database in a collapsible
+// section of the job log.
+func printGitLabSection(n int, name string, err error, migrationErr *pgmigrate.MigrationError) {
+	id := fmt.Sprintf("pgmigrate_failure_%d", n)
+	fmt.Printf("\x1b[0Ksection_start:%d:%s\r\x1b[0KMigration failed on %s\n", time.Now().Unix(), id, name)
+	if migrationErr != nil && migrationErr.File != "" {
+		location := filepath.ToSlash(migrationErr.File)
+		if migrationErr.Line > 0 {
+			location += fmt.Sprintf(":%d", migrationErr.Line)
+		}
+		fmt.Printf("File: %s\n", location)
+	}
+	fmt.Printf("Error: %v\n", err)
+	fmt.Printf("\x1b[0Ksection_end:%d:%s\r\x1b[0K\n", time.Now().Unix(), id)
+}
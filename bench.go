@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runBench implements the "bench" command, which applies the pending
+// migrations to clones of a sample of the databases, reports the time each
+// statement took and estimates how long migrating the whole fleet takes.
+func runBench(args []string) error {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the databases to sample from (defaults to all)")
+	sample := fs.Int("sample", 5, "number of databases to clone and benchmark, 0 for all")
+	fs.Parse(args)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	targets = selectTargets(targets, *database)
+	if len(targets) == 0 {
+		return errors.New("no database to benchmark")
+	}
+	result := migrator.Bench(ctx, targets, *sample)
+
+	var total time.Duration
+	succeeded := 0
+	for _, db := range result.Databases {
+		if db.Error != nil {
+			fmt.Printf("[Failed] Cluster: %s Database: %s\nError: %v\n", db.Cluster, db.Database, db.Error)
+			continue
+		}
+		fmt.Printf("[Success] Cluster: %s Database: %s (%s)\n", db.Cluster, db.Database, db.Duration.Round(time.Millisecond))
+		total += db.Duration
+		succeeded++
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "\nVERSION\tLINE\tSAMPLES\tP50\tP95\tMAX\tROWS\tSTATEMENT")
+	for _, s := range result.Statements {
+		rows := "?"
+		if s.EstimatedRows >= 0 {
+			rows = fmt.Sprint(s.EstimatedRows)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%s\t%s\t%s\t%s\t%s\n", s.Version, s.Line, s.Samples,
+			s.P50.Round(time.Millisecond), s.P95.Round(time.Millisecond), s.Max.Round(time.Millisecond), rows, s.SQL)
+	}
+	w.Flush()
+
+	if succeeded > 0 {
+		// Databases are migrated in waves of the configured concurrency.
+		mean := total / time.Duration(succeeded)
+		waves := 1
+		if config.MaxConcurrency > 0 {
+			waves = (len(targets) + config.MaxConcurrency - 1) / config.MaxConcurrency
+		}
+		fmt.Printf("\nMean per database: %s; estimated for %d databases: %s\n",
+			mean.Round(time.Millisecond), len(targets), (mean * time.Duration(waves)).Round(time.Second))
+	}
+	if succeeded < len(result.Databases) {
+		return fmt.Errorf("%d of %d benchmarks failed", len(result.Databases)-succeeded, len(result.Databases))
+	}
+	return nil
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// commands maps subcommand names to their implementations. Running the tool
+// without a subcommand runs "migrate".
+var commands = map[string]func(args []string) error{
+	"migrate":     runMigrate,
+	"bench":       runBench,
+	"completion":  runCompletion,
+	"config":      runConfig,
+	"deprovision": runDeprovision,
+	"doctor":      runDoctor,
+	"export":      runExport,
+	"history":     runHistory,
+	"import":      runImport,
+	"init":        runInit,
+	"serve":       runServe,
+	"unlock":      runUnlock,
+	"partitions":  runPartitions,
+	"provision":   runProvision,
+	"rehearse":    runRehearse,
+	"rollback":    runRollback,
+	"sequences":   runSequences,
+	"verify":      runVerify,
+	"version":     runVersion,
+
+	"validate-constraints": runValidateConstraints,
+	"verify-down":          runVerifyDown,
+	"verify-idempotent":    runVerifyIdempotent,
+}
+
+// commonFlags are the flags shared by every command that connects to the
+// configured clusters.
+type commonFlags struct {
+	configPath     string
+	passwordPrompt bool
+	passwordFile   string
+	context        string
+	echoSQL        bool
+	shard          string
+
+	// prompted is the password read at the prompt, which is asked once
+	// however many times the configuration is loaded.
+	prompted string
+}
+
+func addCommonFlags(fs *flag.FlagSet) *commonFlags {
+	f := &commonFlags{}
+	fs.StringVar(&f.configPath, "config", "", "path to the JSON configuration file")
+	fs.BoolVar(&f.passwordPrompt, "password-prompt", false, "prompt for the database password")
+	fs.StringVar(&f.passwordFile, "password-file", "", "read the database password from a file, or from stdin if \"-\"")
+	fs.StringVar(&f.context, "context", "", "environment the run is for, selecting the migrations with context directives (overrides the configuration)")
+	fs.BoolVar(&f.echoSQL, "echo-sql", false, "log every statement of the migrations and the server's notices")
+	fs.StringVar(&f.shard, "shard", "", "run on the i-th of n slices of the fleet, given as i/n (overrides the configuration)")
+	return f
+}
+
+// load reads the configuration and applies the password options.
+func (f *commonFlags) load() (pgmigrate.Configuration, error) {
+	config, err := pgmigrate.LoadConfiguration(f.configPath)
+	if err != nil {
+		return config, fmt.Errorf("failed to load configuration: %w", err)
+	}
+
+	switch {
+	case f.passwordPrompt && f.passwordFile != "":
+		return config, errors.New("-password-prompt and -password-file are mutually exclusive")
+	case f.passwordPrompt:
+		if f.prompted == "" {
+			f.prompted, err = promptPassword()
+		}
+		config.Password = f.prompted
+	case f.passwordFile != "":
+		config.Password, err = readPasswordFile(f.passwordFile)
+	}
+	if err != nil {
+		return config, fmt.Errorf("failed to read password: %w", err)
+	}
+	if f.context != "" {
+		config.Context = f.context
+	}
+	if f.echoSQL {
+		config.EchoSQL = true
+	}
+	if f.shard != "" {
+		config.Shard = f.shard
+	}
+	return config, nil
+}
+
+// runConfig implements the "config" command and its subcommands.
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: config encrypt|decrypt|validate [flags] [value]")
+	}
+	switch args[0] {
+	case "validate":
+		return runConfigValidate(args[1:])
+	case "encrypt":
+		return runConfigEncrypt(args[1:])
+	case "decrypt":
+		return runConfigDecrypt(args[1:])
+	}
+	return fmt.Errorf("unknown config command %q", args[0])
+}
+
+// runConfigValidate checks the configuration file and prints every problem
+// found in it.
+func runConfigValidate(args []string) error {
+	fs := flag.NewFlagSet("config validate", flag.ExitOnError)
+	configPath := fs.String("config", "", "path to the JSON configuration file")
+	fs.Parse(args)
+
+	if *configPath == "" {
+		return errors.New("config validate: -config is required")
+	}
+	problems := pgmigrate.CheckConfiguration(*configPath)
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("%s: %d problems found", *configPath, len(problems))
+	}
+	fmt.Printf("%s: OK\n", *configPath)
+	return nil
+}
+
+// runConfigEncrypt prints the encrypted form of a value, read from the
+// arguments or standard input, for pasting into the configuration file.
+func runConfigEncrypt(args []string) error {
+	fs := flag.NewFlagSet("config encrypt", flag.ExitOnError)
+	var opts pgmigrate.EncryptOptions
+	recipients := fs.String("age-recipient", "", "comma-separated age public keys to encrypt to")
+	fs.StringVar(&opts.KMSKeyID, "kms-key-id", "", "AWS KMS key ID, ARN or alias to encrypt with")
+	fs.StringVar(&opts.Region, "region", "", "AWS region of the KMS key")
+	fs.Parse(args)
+
+	if *recipients != "" {
+		opts.AgeRecipients = strings.Split(*recipients, ",")
+	}
+	plaintext, err := valueArgument(fs)
+	if err != nil {
+		return err
+	}
+	encrypted, err := pgmigrate.EncryptValue(context.Background(), plaintext, opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(encrypted)
+	return nil
+}
+
+// runConfigDecrypt prints the plaintext of an encrypted configuration value.
+func runConfigDecrypt(args []string) error {
+	fs := flag.NewFlagSet("config decrypt", flag.ExitOnError)
+	identityFile := fs.String("age-identity", "", "age identity file (defaults to $PGMIGRATE_AGE_IDENTITY_FILE)")
+	region := fs.String("region", "", "AWS region of the KMS key")
+	fs.Parse(args)
+
+	value, err := valueArgument(fs)
+	if err != nil {
+		return err
+	}
+	d := &pgmigrate.Decrypter{IdentityFile: *identityFile, Region: *region}
+	if d.IdentityFile == "" {
+		d.IdentityFile = pgmigrate.AgeIdentityFile("")
+	}
+	plaintext, err := d.Decrypt(context.Background(), value)
+	if err != nil {
+		return err
+	}
+	fmt.Println(plaintext)
+	return nil
+}
+
+// valueArgument returns the single positional argument of a command, or the
+// contents of standard input when there is none, so secrets need not appear
+// in argv.
+func valueArgument(fs *flag.FlagSet) (string, error) {
+	switch fs.NArg() {
+	case 0:
+		data, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case 1:
+		return fs.Arg(0), nil
+	}
+	return "", fmt.Errorf("%s: expected a single value", fs.Name())
+}
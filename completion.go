@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// subcommands lists the subcommands of the commands that have them,
+// sorted.
+var subcommands = map[string][]string{
+	"config":  {"decrypt", "encrypt", "validate"},
+	"history": {"export", "import", "prune", "repair"},
+}
+
+// versionFlags are the flags whose values are migration versions, and
+// releaseFlags those whose values are release names.
+var (
+	versionFlags = map[string]bool{"version": true, "target-version": true}
+	releaseFlags = map[string]bool{"release": true}
+)
+
+// databaseArgs are the commands whose positional argument is a database.
+var databaseArgs = map[string]bool{"deprovision": true}
+
+// The hidden "__complete" command lists the other commands, so it is
+// registered once they are.
+func init() {
+	commands["__complete"] = runComplete
+}
+
+// runCompletion implements the "completion" command, which prints the
+// completion script of a shell, e.g.
+//
+//	source <(pgmigrate completion bash)
+//	pgmigrate completion fish > ~/.config/fish/completions/pgmigrate.fish
+func runCompletion(args []string) error {
+	fs := flag.NewFlagSet("completion", flag.ExitOnError)
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: completion bash|zsh|fish")
+	}
+	script, ok := completionScripts[fs.Arg(0)]
+	if !ok {
+		return fmt.Errorf("completion: unsupported shell %q", fs.Arg(0))
+	}
+	fmt.Print(script)
+	return nil
+}
+
+// runComplete implements the hidden "__complete" command the completion
+// scripts call with the words of the command line after the program name,
+// the last being the word to complete. It prints the candidates, one per
+// line, and nothing when the shell should complete file names.
+func runComplete(args []string) error {
+	if len(args) == 0 {
+		args = []string{""}
+	}
+	word := args[len(args)-1]
+	for _, candidate := range completions(args[:len(args)-1], word) {
+		if strings.HasPrefix(candidate, word) {
+			fmt.Println(candidate)
+		}
+	}
+	return nil
+}
+
+// completions returns the candidates for the word following words.
+func completions(words []string, word string) []string {
+	if len(words) == 0 && !strings.HasPrefix(word, "-") {
+		var names []string
+		for name := range commands {
+			if !strings.HasPrefix(name, "__") {
+				names = append(names, name)
+			}
+		}
+		sort.Strings(names)
+		return names
+	}
+	// Without a command, the flags are those of "migrate".
+	command := "migrate"
+	if len(words) > 0 && commands[words[0]] != nil {
+		command = words[0]
+	}
+	if subs, ok := subcommands[command]; ok {
+		if len(words) == 1 {
+			return subs
+		}
+		i := sort.SearchStrings(subs, words[1])
+		if i == len(subs) || subs[i] != words[1] {
+			return nil
+		}
+		command += " " + words[1]
+	}
+	flags := commandFlags(command)
+
+	previous := ""
+	if len(words) > 0 && strings.HasPrefix(words[len(words)-1], "-") {
+		previous = strings.TrimLeft(words[len(words)-1], "-")
+	}
+	if flags[previous] {
+		switch {
+		case previous == "database":
+			return completeDatabases(words)
+		case versionFlags[previous]:
+			return completeMigrations(words, func(m pgmigrate.Migration) string { return m.Version })
+		case releaseFlags[previous]:
+			return completeMigrations(words, func(m pgmigrate.Migration) string { return m.Release })
+		}
+		return nil
+	}
+	if strings.HasPrefix(word, "-") {
+		var names []string
+		for name := range flags {
+			names = append(names, "-"+name)
+		}
+		sort.Strings(names)
+		return names
+	}
+	if databaseArgs[command] && len(words) > 0 {
+		return completeDatabases(words)
+	}
+	return nil
+}
+
+// commandFlags returns the flags of a command, given as "config validate"
+// for subcommands, and whether each takes a value. They are read from the
+// usage the command prints for -h, so that they need not be declared twice.
+func commandFlags(command string) map[string]bool {
+	executable, err := os.Executable()
+	if err != nil {
+		return nil
+	}
+	var usage bytes.Buffer
+	cmd := exec.Command(executable, append(strings.Fields(command), "-h")...)
+	cmd.Stderr = &usage
+	cmd.Run()
+
+	flags := make(map[string]bool)
+	scanner := bufio.NewScanner(&usage)
+	for scanner.Scan() {
+		// flag.PrintDefaults lists "  -name" for booleans and
+		// "  -name type" for flags taking a value.
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "  -") {
+			continue
+		}
+		fields := strings.Fields(line)
+		flags[strings.TrimPrefix(fields[0], "-")] = len(fields) > 1
+	}
+	return flags
+}
+
+// completionMigrator sets up a migrator with the configuration given by
+// the -config flag of words, or nil when there is none or it fails.
+func completionMigrator(words []string) *pgmigrate.Migrator {
+	configPath := ""
+	for i, word := range words {
+		switch {
+		case (word == "-config" || word == "--config") && i+1 < len(words):
+			configPath = words[i+1]
+		case strings.HasPrefix(word, "-config="), strings.HasPrefix(word, "--config="):
+			configPath = word[strings.Index(word, "=")+1:]
+		}
+	}
+	if configPath == "" {
+		return nil
+	}
+	config, err := pgmigrate.LoadConfiguration(configPath)
+	if err != nil {
+		return nil
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return nil
+	}
+	return migrator
+}
+
+// completeDatabases returns the names of the databases of the configured
+// clusters, giving up when the clusters are slow to answer.
+func completeDatabases(words []string) []string {
+	migrator := completionMigrator(words)
+	if migrator == nil {
+		return nil
+	}
+	defer migrator.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return nil
+	}
+	seen := make(map[string]bool)
+	var names []string
+	for _, target := range targets {
+		if !seen[target.Database] {
+			seen[target.Database] = true
+			names = append(names, target.Database)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// completeMigrations returns the distinct non-empty values of field for
+// the configured migrations, in migration order.
+func completeMigrations(words []string, field func(pgmigrate.Migration) string) []string {
+	migrator := completionMigrator(words)
+	if migrator == nil {
+		return nil
+	}
+	defer migrator.Close()
+	seen := make(map[string]bool)
+	var values []string
+	for _, migration := range migrator.Migrations() {
+		if value := field(migration); value != "" && !seen[value] {
+			seen[value] = true
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// completionScripts are the completion scripts of each shell. They pass the
+// command line to "pgmigrate __complete" and fall back to file names when
+// it has no candidates.
+var completionScripts = map[string]string{
+	"bash": `_pgmigrate() {
+	local candidates
+	candidates=$(pgmigrate __complete "${COMP_WORDS[@]:1:COMP_CWORD}" 2>/dev/null)
+	if [ -n "$candidates" ]; then
+		COMPREPLY=($(compgen -W "$candidates" -- "${COMP_WORDS[COMP_CWORD]}"))
+	else
+		COMPREPLY=($(compgen -f -- "${COMP_WORDS[COMP_CWORD]}"))
+	fi
+}
+complete -o filenames -F _pgmigrate pgmigrate
+`,
+	"zsh": `#compdef pgmigrate
+_pgmigrate() {
+	local -a candidates
+	candidates=("${(@f)$(pgmigrate __complete "${(@)words[2,CURRENT]}" 2>/dev/null)}")
+	if [[ -n "${candidates[1]}" ]]; then
+		compadd -a candidates
+	else
+		_files
+	fi
+}
+compdef _pgmigrate pgmigrate
+`,
+	"fish": `function __pgmigrate_complete
+	set -l words (commandline -opc)[2..-1] (commandline -ct)
+	pgmigrate __complete $words 2>/dev/null
+end
+complete -c pgmigrate -a '(__pgmigrate_complete)'
+`,
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runValidateConstraints implements the "validate-constraints" command,
+// which validates the constraints added as NOT VALID in every database,
+// within the configured window. It is meant to be scheduled off-peak.
+func runValidateConstraints(args []string) error {
+	fs := flag.NewFlagSet("validate-constraints", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the databases to validate (defaults to all)")
+	fs.Parse(args)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	return printMaintenanceResults(migrator.ValidateConstraints(ctx, selectTargets(targets, *database)))
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runDeprovision implements the "deprovision" command, which backs up,
+// retires and deregisters a tenant database.
+func runDeprovision(args []string) error {
+	fs := flag.NewFlagSet("deprovision", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	cluster := fs.String("cluster", "", "cluster holding the database (defaults to the only configured one)")
+	backupDir := fs.String("backup-dir", "", "write the final pg_dump to this directory (overrides the configured one)")
+	rename := fs.Bool("rename", false, "rename the database out of the way instead of dropping it")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: deprovision [flags] <database>")
+	}
+	name := fs.Arg(0)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	provisioning := pgmigrate.ProvisioningConfig{}
+	if config.Provisioning != nil {
+		provisioning = *config.Provisioning
+	}
+	if *backupDir != "" {
+		provisioning.BackupDir = *backupDir
+	}
+	if *rename {
+		provisioning.Archive = pgmigrate.ArchiveRename
+	}
+	config.Provisioning = &provisioning
+
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	result, err := migrator.Deprovision(context.Background(), *cluster, name)
+	if result.Backup != "" {
+		fmt.Printf("Backup: %s\n", result.Backup)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to deprovision %s: %w", name, err)
+	}
+	if result.Archived != "" {
+		fmt.Printf("Archived %s as %s\n", name, result.Archived)
+	} else {
+		fmt.Printf("Dropped %s\n", name)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runDoctor implements the "doctor" command, which checks that the clusters
+// and databases are ready for a run and prints a pass/fail report.
+func runDoctor(args []string) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the databases to check (defaults to all)")
+	fs.Parse(args)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	failed := 0
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		// The cluster checks below tell which cluster discovery failed on.
+		fmt.Printf("[FAIL] discovery: %v\n", err)
+		failed++
+	}
+	targets = selectTargets(targets, *database)
+
+	for _, result := range migrator.Diagnose(ctx, targets) {
+		name := result.Cluster
+		if result.Database != "" {
+			name += "/" + result.Database
+		}
+		for _, check := range result.Checks {
+			switch {
+			case check.Err != nil:
+				failed++
+				fmt.Printf("[FAIL] %s: %s: %v\n", name, check.Name, check.Err)
+			case check.Detail != "":
+				fmt.Printf("[PASS] %s: %s: %s\n", name, check.Name, check.Detail)
+			default:
+				fmt.Printf("[PASS] %s: %s\n", name, check.Name)
+			}
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d checks failed", failed)
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runExport implements the "export" command, which writes the history of
+// every database in the format of another migration tool.
+func runExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	format := fs.String("format", pgmigrate.FormatGolangMigrate, "format of the other tool: "+pgmigrate.FormatGolangMigrate)
+	database := fs.String("database", "", "name or pattern of the databases to export (defaults to all)")
+	fs.Parse(args)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	targets = selectTargets(targets, *database)
+	return printMaintenanceResults(migrator.ExportHistory(ctx, targets, *format))
+}
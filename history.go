@@ -0,0 +1,243 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runHistory dispatches the "history" subcommands.
+func runHistory(args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: history export|import|prune|repair [flags]")
+	}
+	switch args[0] {
+	case "export":
+		return runHistoryExport(args[1:])
+	case "import":
+		return runHistoryImport(args[1:])
+	case "prune":
+		return runHistoryPrune(args[1:])
+	case "repair":
+		return runHistoryRepair(args[1:])
+	}
+	return fmt.Errorf("unknown history command %q", args[0])
+}
+
+// runHistoryExport writes the history of one database as JSON, to be
+// imported with "history import" once the database is restored on another
+// cluster.
+func runHistoryExport(args []string) error {
+	fs := flag.NewFlagSet("history export", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name of the database to export")
+	schema := fs.String("schema", "", "tenant schema to export, in schema-per-tenant mode")
+	output := fs.String("output", "-", "file to write the history to, or \"-\" for stdout")
+	fs.Parse(args)
+	if *database == "" {
+		return errors.New("history export: -database is required")
+	}
+
+	migrator, target, err := historyTarget(common, *database, *schema)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+	dump, err := migrator.DumpHistory(context.Background(), target)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if *output == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := os.WriteFile(*output, data, 0o644); err != nil {
+		return err
+	}
+	fmt.Fprintf(os.Stderr, "Exported %d migrations of %s to %s\n", len(dump.Migrations), *database, *output)
+	return nil
+}
+
+// runHistoryImport records the history written by "history export" in a
+// database, so that the next run continues where the original left off.
+func runHistoryImport(args []string) error {
+	fs := flag.NewFlagSet("history import", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name of the database to import into (defaults to the exported database's)")
+	schema := fs.String("schema", "", "tenant schema to import into (defaults to the exported schema)")
+	input := fs.String("input", "-", "file to read the history from, or \"-\" for stdin")
+	fs.Parse(args)
+
+	var r io.Reader = os.Stdin
+	if *input != "-" {
+		file, err := os.Open(*input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	var dump pgmigrate.HistoryDump
+	if err := json.NewDecoder(r).Decode(&dump); err != nil {
+		return fmt.Errorf("failed to read history: %w", err)
+	}
+	if *database == "" {
+		*database = dump.Database
+	}
+	if *schema == "" {
+		*schema = dump.Schema
+	}
+
+	migrator, target, err := historyTarget(common, *database, *schema)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+	return printMaintenanceResults([]pgmigrate.MaintenanceResult{migrator.RestoreHistory(context.Background(), target, dump)})
+}
+
+// runHistoryPrune trims the old rows of the history tables of the selected
+// databases after archiving them.
+func runHistoryPrune(args []string) error {
+	fs := flag.NewFlagSet("history prune", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the databases to prune (defaults to all)")
+	keepLast := fs.Int("keep-last", 0, "number of the newest history rows to keep")
+	archive := fs.String("archive", "", "directory to write the pruned rows to, one JSON file per database")
+	fs.Parse(args)
+	if *keepLast < 1 {
+		return errors.New("history prune: -keep-last must be at least 1")
+	}
+	if *archive == "" {
+		return errors.New("history prune: -archive is required")
+	}
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	return printMaintenanceResults(migrator.PruneHistory(ctx, selectTargets(targets, *database), *keepLast, *archive))
+}
+
+// runHistoryRepair repairs the history tables of the selected databases,
+// printing what it changes, or with -dry-run what it would change, in each.
+func runHistoryRepair(args []string) error {
+	fs := flag.NewFlagSet("history repair", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the databases to repair (defaults to all)")
+	checksums := fs.Bool("checksums", false, "record the current checksum of applied migrations whose file was edited")
+	versions := fs.String("version", "", "comma-separated versions to limit -checksums to")
+	deprecate := fs.Bool("deprecate-missing", false, "mark applied migrations whose file was removed as deprecated")
+	gaps := fs.Bool("gaps", false, "record unapplied migrations older than the latest applied one as applied, without running them")
+	dryRun := fs.Bool("dry-run", false, "print the changes without making them")
+	fs.Parse(args)
+
+	options := pgmigrate.RepairOptions{Checksums: *checksums, Deprecate: *deprecate, Gaps: *gaps, DryRun: *dryRun}
+	if *versions != "" {
+		if !*checksums {
+			return errors.New("history repair: -version requires -checksums")
+		}
+		options.Versions = strings.Split(*versions, ",")
+	}
+	if !options.Checksums && !options.Deprecate && !options.Gaps {
+		return errors.New("history repair: select a repair with -checksums, -deprecate-missing or -gaps")
+	}
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	results := migrator.RepairHistory(ctx, selectTargets(targets, *database), options)
+
+	failed := 0
+	for _, result := range results {
+		if result.Error != nil {
+			failed++
+			fmt.Printf("[Failed] Cluster: %s Database: %s\n", result.Cluster, result.Database)
+			fmt.Printf("Error: %v\n", result.Error)
+			continue
+		}
+		fmt.Printf("[Success] Cluster: %s Database: %s\n", result.Cluster, result.Database)
+		for _, change := range result.Changes {
+			fmt.Printf("  %s\n", change)
+		}
+	}
+	if *dryRun {
+		fmt.Println("Dry run: nothing was changed")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d databases failed", failed, len(results))
+	}
+	return nil
+}
+
+// historyTarget sets up a migrator and finds the target of a database, and
+// of a tenant schema in schema-per-tenant mode, which must be unique.
+func historyTarget(common *commonFlags, database, schema string) (*pgmigrate.Migrator, pgmigrate.Target, error) {
+	config, err := common.load()
+	if err != nil {
+		return nil, pgmigrate.Target{}, err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return nil, pgmigrate.Target{}, err
+	}
+	targets, err := migrator.Targets(context.Background())
+	if err != nil {
+		migrator.Close()
+		return nil, pgmigrate.Target{}, err
+	}
+	var found []pgmigrate.Target
+	for _, target := range targets {
+		if target.Database == database && (schema == "" || target.Schema == schema) {
+			found = append(found, target)
+		}
+	}
+	switch len(found) {
+	case 1:
+		return migrator, found[0], nil
+	case 0:
+		migrator.Close()
+		return nil, pgmigrate.Target{}, fmt.Errorf("database %s is not a target", database)
+	}
+	var names []string
+	for _, target := range found {
+		names = append(names, target.Cluster.Name+"/"+target.Database+"/"+target.Schema)
+	}
+	migrator.Close()
+	return nil, pgmigrate.Target{}, fmt.Errorf("%s matches several targets, select one with -schema: %s", database, strings.Join(names, ", "))
+}
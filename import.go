@@ -0,0 +1,58 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runImport implements the "import" command, which adopts the migrations
+// and applied-version state of another migration tool.
+func runImport(args []string) error {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	format := fs.String("format", "", "format of the other tool: "+pgmigrate.FormatGolangMigrate+", "+pgmigrate.FormatFlyway+" or "+pgmigrate.FormatLiquibase)
+	from := fs.String("from", "", "golang-migrate directory or Liquibase changelog to convert into the migration directory")
+	database := fs.String("database", "", "name or pattern of the databases to import (defaults to all)")
+	fs.Parse(args)
+	if *format == "" {
+		return errors.New("import: -format is required")
+	}
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	if *from != "" {
+		var written []string
+		switch *format {
+		case pgmigrate.FormatGolangMigrate:
+			written, err = pgmigrate.ConvertGolangMigrateFiles(*from, config.MigrationDir)
+		case pgmigrate.FormatLiquibase:
+			written, err = pgmigrate.ConvertLiquibaseChangelog(*from, config.MigrationDir)
+		default:
+			return fmt.Errorf("import: -from is not supported for %s", *format)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to convert migrations: %w", err)
+		}
+		fmt.Printf("Converted %d files into %s\n", len(written), config.MigrationDir)
+	}
+
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	targets = selectTargets(targets, *database)
+	return printMaintenanceResults(migrator.ImportHistory(ctx, targets, *format))
+}
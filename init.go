@@ -0,0 +1,273 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runInit implements the "init" command, which asks a few questions on the
+// terminal, writes a commented configuration file from the answers and
+// creates the migration directory and, optionally, the history tables.
+func runInit(args []string) error {
+	fs := flag.NewFlagSet("init", flag.ExitOnError)
+	configPath := fs.String("config", "pgmigrate.json", "path of the configuration file to write")
+	force := fs.Bool("force", false, "overwrite an existing configuration file")
+	fs.Parse(args)
+
+	if _, err := os.Stat(*configPath); err == nil && !*force {
+		return fmt.Errorf("%s already exists; use -force to overwrite it", *configPath)
+	}
+
+	p := &prompter{in: bufio.NewReader(os.Stdin)}
+	answers := initAnswers{
+		host: p.ask("PostgreSQL host", "localhost"),
+		port: p.askInt("Port", 5432),
+		user: p.ask("User", "postgres"),
+	}
+	answers.credentials = p.choose("Where does the password come from?", []string{
+		"prompt", "prompted for with -password-prompt on each run",
+		"pgpass", "a password file in the .pgpass format",
+		"secretsmanager", "an AWS Secrets Manager secret",
+		"ssm", "an AWS Systems Manager parameter",
+		"env", "the PGPASSWORD environment variable",
+	})
+	switch answers.credentials {
+	case "pgpass":
+		passFile := ".pgpass"
+		if home, err := os.UserHomeDir(); err == nil {
+			passFile = filepath.Join(home, ".pgpass")
+		}
+		answers.credentialsRef = p.ask("Password file", passFile)
+	case "secretsmanager":
+		answers.credentialsRef = "aws-secretsmanager://" + p.ask("Secret id", "pgmigrate") + "#" + p.ask("JSON key of the password", "password")
+	case "ssm":
+		answers.credentialsRef = "aws-ssm://" + strings.TrimPrefix(p.ask("Parameter name", "/pgmigrate/password"), "/")
+	}
+	answers.discovery = p.choose("Which databases should be migrated?", []string{
+		"all", "every database of the server except postgres and the templates",
+		"srv", "every database of the servers a DNS SRV record lists",
+		"consul", "every database of the servers a Consul service lists",
+	})
+	switch answers.discovery {
+	case "srv":
+		answers.srv = p.ask("SRV record", "_postgresql._tcp."+answers.host)
+	case "consul":
+		answers.consulAddress = p.ask("Consul address", "http://127.0.0.1:8500")
+		answers.consulService = p.ask("Consul service", "postgresql")
+	}
+	answers.migrationDir = p.ask("Migration directory", "migrations")
+	createHistory := p.confirm("Create the history tables now?", false)
+	if p.err != nil {
+		return fmt.Errorf("init: %w", p.err)
+	}
+
+	if err := os.WriteFile(*configPath, []byte(answers.configFile()), 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("wrote %s\n", *configPath)
+	if err := os.MkdirAll(answers.migrationDir, 0o755); err != nil {
+		return err
+	}
+	fmt.Printf("created %s\n", answers.migrationDir)
+	if !createHistory {
+		return nil
+	}
+
+	config, err := pgmigrate.LoadConfiguration(*configPath)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	if answers.credentials == "prompt" {
+		if config.Password, err = promptPassword(); err != nil {
+			return fmt.Errorf("failed to read password: %w", err)
+		}
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	return printMaintenanceResults(migrator.CreateHistoryTables(ctx, targets))
+}
+
+// initAnswers holds the answers to the questions of "init".
+type initAnswers struct {
+	host string
+	port int
+	user string
+
+	// credentials is the chosen password source, and credentialsRef the
+	// password file or secret reference it needs.
+	credentials    string
+	credentialsRef string
+
+	discovery     string
+	srv           string
+	consulAddress string
+	consulService string
+
+	migrationDir string
+}
+
+// configFile returns the configuration file for the answers, with comments
+// explaining the settings.
+func (a initAnswers) configFile() string {
+	var b strings.Builder
+	line := func(format string, args ...interface{}) {
+		fmt.Fprintf(&b, format+"\n", args...)
+	}
+
+	line("// Configuration written by \"pgmigrate init\". Run \"pgmigrate config validate\"")
+	line("// after editing it to check it.")
+	line("{")
+	line("  // Migration files, named like 0001_create_users.sql, applied in")
+	line("  // version order.")
+	line("  \"migration_dir\": %s,", quote(a.migrationDir))
+	line("")
+
+	cluster := []string{}
+	if a.discovery == "srv" {
+		line("  // The host and port are looked up in the SRV record before each run.")
+		cluster = append(cluster, fmt.Sprintf("\"srv\": %s", quote(a.srv)))
+	} else if a.discovery == "all" {
+		cluster = append(cluster, fmt.Sprintf("\"host\": %s", quote(a.host)), fmt.Sprintf("\"port\": %d", a.port))
+	}
+	cluster = append(cluster, fmt.Sprintf("\"username\": %s", quote(a.user)))
+	switch a.credentials {
+	case "pgpass":
+		cluster = append(cluster, fmt.Sprintf("\"pass_file\": %s", quote(a.credentialsRef)))
+	case "secretsmanager", "ssm":
+		cluster = append(cluster, fmt.Sprintf("\"password\": %s", quote(a.credentialsRef)))
+	}
+
+	switch a.credentials {
+	case "prompt":
+		line("  // No password is stored: run with -password-prompt or -password-file.")
+	case "pgpass":
+		line("  // The password is read from the password file, in the .pgpass format.")
+	case "secretsmanager", "ssm":
+		line("  // The password is a secret reference, resolved when connecting.")
+	case "env":
+		line("  // The password is read from the PGPASSWORD environment variable.")
+	}
+	if a.discovery == "consul" {
+		line("  // Servers are discovered from the Consul catalog before each run; the")
+		line("  // cluster settings apply to every server found.")
+		line("  \"consul\": {")
+		line("    \"address\": %s,", quote(a.consulAddress))
+		line("    \"service\": %s,", quote(a.consulService))
+		line("    \"cluster\": {%s}", strings.Join(cluster, ", "))
+		line("  },")
+	} else {
+		line("  \"clusters\": [")
+		line("    {%s}", strings.Join(cluster, ", "))
+		line("  ],")
+	}
+	line("")
+	line("  // Every database of each server is migrated except postgres, the")
+	line("  // templates and the databases of managed services. List names or")
+	line("  // patterns to leave out instead:")
+	line("  // \"exclude_databases\": [\"postgres\", \"analytics_*\"],")
+	line("")
+	line("  // Number of databases migrated at the same time.")
+	line("  \"max_concurrency\": 4")
+	line("}")
+	return b.String()
+}
+
+// quote returns s as a JSON string.
+func quote(s string) string {
+	data, _ := json.Marshal(s)
+	return string(data)
+}
+
+// prompter asks questions on the terminal. The first error reading the
+// answers is kept in err, after which every question gets its default.
+type prompter struct {
+	in  *bufio.Reader
+	err error
+}
+
+// ask asks a question and returns the answer, or def when it is empty.
+func (p *prompter) ask(question, def string) string {
+	if p.err != nil {
+		return def
+	}
+	if def != "" {
+		fmt.Fprintf(os.Stderr, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(os.Stderr, "%s: ", question)
+	}
+	answer, err := p.in.ReadString('\n')
+	if err != nil && !(errors.Is(err, io.EOF) && answer != "") {
+		p.err = err
+		return def
+	}
+	if answer = strings.TrimSpace(answer); answer == "" {
+		return def
+	}
+	return answer
+}
+
+// askInt asks for a number until the answer is one.
+func (p *prompter) askInt(question string, def int) int {
+	for {
+		answer := p.ask(question, strconv.Itoa(def))
+		n, err := strconv.Atoi(answer)
+		if err == nil && n > 0 {
+			return n
+		}
+		fmt.Fprintf(os.Stderr, "%q is not a valid number\n", answer)
+	}
+}
+
+// choose asks to choose among options, given as pairs of a name and a
+// description, by number or name, and returns the chosen name. The first
+// option is the default.
+func (p *prompter) choose(question string, options []string) string {
+	fmt.Fprintln(os.Stderr, question)
+	for i := 0; i < len(options); i += 2 {
+		fmt.Fprintf(os.Stderr, "  %d) %s: %s\n", i/2+1, options[i], options[i+1])
+	}
+	for {
+		answer := p.ask("Choice", options[0])
+		for i := 0; i < len(options); i += 2 {
+			if answer == options[i] || answer == strconv.Itoa(i/2+1) {
+				return options[i]
+			}
+		}
+		fmt.Fprintf(os.Stderr, "%q is not one of the choices\n", answer)
+	}
+}
+
+// confirm asks a yes or no question.
+func (p *prompter) confirm(question string, def bool) bool {
+	defAnswer := "n"
+	if def {
+		defAnswer = "y"
+	}
+	for {
+		switch strings.ToLower(p.ask(question+" (y/n)", defAnswer)) {
+		case "y", "yes":
+			return true
+		case "n", "no":
+			return false
+		}
+	}
+}
@@ -1,160 +1,266 @@
 package main
 
 import (
-	"database/sql"
+	"context"
+	"errors"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/postresql-migration-golang/pgmigrate"
 )
 
-// Configuration defines the parameters for the migration process.
-type Configuration struct {
-	DBUsername   string
-	MigrationDir string
+func main() {
+	args := os.Args[1:]
+	name := "migrate"
+	if len(args) > 0 && commands[args[0]] != nil {
+		name, args = args[0], args[1:]
+	}
+	if err := commands[name](args); err != nil {
+		var exit *exitError
+		if errors.As(err, &exit) {
+			log.Print(exit.err)
+			os.Exit(exit.code)
+		}
+		log.Fatal(err)
+	}
 }
 
-// MigrationResult holds information about the result of a migration.
-type MigrationResult struct {
-	Database string
-	Success  bool
-	Error    error
+// exitTransient is the exit status of runs whose failures were all
+// transient and may succeed when retried (EX_TEMPFAIL).
+const exitTransient = 75
+
+// exitError ends the program with a specific exit status.
+type exitError struct {
+	code int
+	err  error
 }
 
-func main() {
-	// Define configuration
-	config := Configuration{
-		DBUsername:   "username",
-		MigrationDir: "src/migration",
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+// migrationFailure returns the error ending a run in which databases
+// failed: exit status 1 if any failure was permanent and exitTransient if
+// all of them were transient.
+func migrationFailure(results []pgmigrate.MigrationResult) error {
+	failed, permanent := 0, false
+	for _, result := range results {
+		if !result.Success {
+			failed++
+			permanent = permanent || result.ErrorClass != pgmigrate.ErrorTransient
+		}
 	}
+	switch {
+	case failed == 0:
+		return nil
+	case permanent:
+		return &exitError{code: 1, err: fmt.Errorf("%d databases failed", failed)}
+	}
+	return &exitError{code: exitTransient, err: fmt.Errorf("%d databases failed with transient errors; retry the run", failed)}
+}
 
-	// Fetch list of databases
-	databases, err := fetchDatabases(config.DBUsername)
+// runMigrate implements the "migrate" command, which applies the pending
+// migrations to every database of the configured clusters.
+func runMigrate(args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	verbose := fs.Bool("verbose", false, "report the schema changes made to each database")
+	changelog := fs.String("changelog", "", "write the migrations applied as a changelog fragment, in JSON if the file ends in .json and markdown otherwise")
+	releaseNotes := fs.String("release-notes", "", "write the migrations applied grouped by the tickets of their headers, in JSON if the file ends in .json and markdown otherwise")
+	labels := fs.String("labels", "", "apply only the migrations with one of these comma-separated labels")
+	excludeLabels := fs.String("exclude-labels", "", "leave the migrations with any of these comma-separated labels pending")
+	snapshotDir := fs.String("snapshot-dir", "", "write the schema of each database after the run to this directory")
+	stream := fs.Bool("stream", false, "print the result of each database as soon as it is done rather than all of them at the end; the results are still kept for the summary and reports")
+	annotations := fs.String("annotations", "", "also print failures as annotations for github or gitlab, or for the CI system running the command with auto")
+	fs.Parse(args)
+
+	annotate, err := annotationMode(*annotations)
 	if err != nil {
-		log.Fatal("Failed to fetch databases:", err)
+		return err
 	}
 
-	// Perform migrations
-	results := migrateDatabases(config, databases)
+	// Define configuration
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	if *verbose {
+		config.SchemaDiff = true
+	}
+	if *snapshotDir != "" {
+		config.SnapshotDir = *snapshotDir
+	}
+	if *labels != "" {
+		config.Labels = strings.Split(*labels, ",")
+	}
+	if *excludeLabels != "" {
+		config.ExcludeLabels = strings.Split(*excludeLabels, ",")
+	}
 
-	// Print results
-	printMigrationResults(results)
-}
+	if *stream {
+		fmt.Println("Migration Results:")
+		config.Reporter = &resultPrinter{verbose: *verbose}
+	}
+	var display *liveDisplay
+	if interactive() {
+		display = startLiveDisplay(*stream, *verbose)
+		config.Reporter = display
+	}
 
-// fetchDatabases fetches the list of databases from PostgreSQL.
-func fetchDatabases(username string) ([]string, error) {
-	connectionString := fmt.Sprintf("user=%s sslmode=disable", username)
-	db, err := sql.Open("postgres", connectionString)
+	// Load migration scripts
+	migrator, err := pgmigrate.New(config)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer db.Close()
+	defer migrator.Close()
 
-	rows, err := db.Query("SELECT datname FROM pg_database WHERE datistemplate = false")
+	// Fetch list of databases and perform migrations
+	results, err := migrator.Run(context.Background())
+	if display != nil {
+		display.close()
+	}
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	// Print results
+	if *stream {
+		printMigrationSummary(results)
+	} else {
+		printMigrationResults(results, *verbose)
+	}
+	if annotate != "" {
+		printAnnotations(annotate, results)
 	}
-	defer rows.Close()
 
-	var databases []string
-	for rows.Next() {
-		var dbName string
-		err := rows.Scan(&dbName)
+	if *changelog != "" {
+		entries := migrator.Changelog(results)
+		err := writeReport(*changelog, func(w io.Writer, format string) error {
+			return pgmigrate.WriteChangelog(w, entries, format)
+		})
 		if err != nil {
-			return nil, err
+			return fmt.Errorf("failed to write changelog: %w", err)
 		}
-		databases = append(databases, dbName)
 	}
-
-	return databases, nil
+	if *releaseNotes != "" {
+		notes := migrator.ReleaseNotes(results)
+		err := writeReport(*releaseNotes, func(w io.Writer, format string) error {
+			return pgmigrate.WriteReleaseNotes(w, notes, format)
+		})
+		if err != nil {
+			return fmt.Errorf("failed to write release notes: %w", err)
+		}
+	}
+	return migrationFailure(results)
 }
 
-// migrateDatabases performs schema migrations for multiple databases.
-func migrateDatabases(config Configuration, databases []string) []MigrationResult {
-	var wg sync.WaitGroup
-	resultsCh := make(chan MigrationResult, len(databases))
-
-	for _, dbName := range databases {
-		wg.Add(1)
-		go func(dbName string) {
-			defer wg.Done()
-
-			// Connect to the database
-			db, err := connectToDatabase(config.DBUsername, dbName)
-			if err != nil {
-				resultsCh <- MigrationResult{Database: dbName, Success: false, Error: err}
-				return
-			}
-			defer db.Close()
-
-			// Read migration script from file
-			migrationScript, err := readMigrationScript(config.MigrationDir)
-			if err != nil {
-				resultsCh <- MigrationResult{Database: dbName, Success: false, Error: err}
-				return
-			}
-
-			// Execute migration script
-			err = executeMigration(db, migrationScript)
-			if err != nil {
-				resultsCh <- MigrationResult{Database: dbName, Success: false, Error: err}
-				return
-			}
-
-			// If migration succeeded
-			resultsCh <- MigrationResult{Database: dbName, Success: true, Error: nil}
-		}(dbName)
-	}
-
-	go func() {
-		wg.Wait()
-		close(resultsCh)
-	}()
-
-	var results []MigrationResult
-	for result := range resultsCh {
-		results = append(results, result)
-	}
-
-	return results
+// writeReport creates the file at path and writes a report to it, in the
+// format its extension implies.
+func writeReport(path string, write func(w io.Writer, format string) error) error {
+	format := "markdown"
+	if filepath.Ext(path) == ".json" {
+		format = "json"
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := write(f, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-// connectToDatabase connects to the specified database.
-func connectToDatabase(username, dbName string) (*sql.DB, error) {
-	connectionString := fmt.Sprintf("user=%s dbname=%s sslmode=disable", username, dbName)
-	return sql.Open("postgres", connectionString)
+// printMigrationResults prints the results of the migration process and,
+// when verbose, the schema changes made to each database.
+func printMigrationResults(results []pgmigrate.MigrationResult, verbose bool) {
+	fmt.Println("Migration Results:")
+	columns := alignResults(results)
+	for _, result := range results {
+		printMigrationResult(result, verbose, columns)
+	}
 }
 
-// readMigrationScript reads the migration script from the specified directory.
-func readMigrationScript(migrationDir string) (string, error) {
-	scriptPath := filepath.Join(migrationDir, "migration_script.sql")
-	migrationScript, err := os.ReadFile(scriptPath)
-	if err != nil {
-		return "", err
-	}
-	return string(migrationScript), nil
+// resultPrinter prints the result of each database as it is reported.
+type resultPrinter struct {
+	mu      sync.Mutex
+	verbose bool
 }
 
-// executeMigration executes the migration script on the given database.
-func executeMigration(db *sql.DB, migrationScript string) error {
-	_, err := db.Exec(migrationScript)
-	return err
+func (p *resultPrinter) Report(result pgmigrate.MigrationResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	printMigrationResult(result, p.verbose, resultColumns{})
 }
 
-// printMigrationResults prints the results of the migration process.
-func printMigrationResults(results []MigrationResult) {
-	fmt.Println("Migration Results:")
+// printMigrationSummary prints the number of databases migrated and failed,
+// closing results already printed as they came.
+func printMigrationSummary(results []pgmigrate.MigrationResult) {
+	failed := 0
 	for _, result := range results {
-		successStr := "Success"
 		if !result.Success {
-			successStr = "Failed"
+			failed++
 		}
-		fmt.Printf("[%s] Database: %s\n", successStr, result.Database)
-		if !result.Success {
-			fmt.Printf("Error: %v\n", result.Error)
+	}
+	fmt.Printf("%d databases migrated, %d failed\n", len(results)-failed, failed)
+}
+
+// printMigrationResult prints the result of a single database, with its
+// names padded to columns.
+func printMigrationResult(result pgmigrate.MigrationResult, verbose bool, columns resultColumns) {
+	status := statusLabel(result.Success, result.ErrorClass)
+	if columns.cluster > 0 && !result.Success {
+		// [Failed] is a character shorter than [Success].
+		status += " "
+	}
+	cluster := pad(result.Cluster, columns.cluster)
+	if result.Schema != "" {
+		fmt.Printf("%s Cluster: %s Database: %s Schema: %s\n", status, cluster, pad(result.Database, columns.database), result.Schema)
+	} else {
+		fmt.Printf("%s Cluster: %s Database: %s\n", status, cluster, result.Database)
+	}
+	if len(result.Extensions) > 0 {
+		fmt.Printf("Extensions: %s\n", strings.Join(result.Extensions, ", "))
+	}
+	if result.Bootstrapped != "" {
+		fmt.Printf("Bootstrapped: snapshot %s\n", result.Bootstrapped)
+	}
+	if len(result.Applied) > 0 {
+		fmt.Printf("Applied: %s\n", strings.Join(result.Applied, ", "))
+	}
+	if len(result.Repeated) > 0 {
+		fmt.Printf("Repeated: %s\n", strings.Join(result.Repeated, ", "))
+	}
+	if len(result.Skipped) > 0 {
+		fmt.Printf("Skipped: %s\n", strings.Join(result.Skipped, ", "))
+	}
+	for _, refresh := range result.Refreshed {
+		fmt.Printf("Refreshed: %s (%s)\n", refresh.View, refresh.Duration.Round(time.Millisecond))
+	}
+	if len(result.Validated) > 0 {
+		fmt.Printf("Constraints: %s\n", strings.Join(result.Validated, ", "))
+	}
+	for _, sequence := range result.Sequences {
+		fmt.Printf("Sequence: %s\n", sequence)
+	}
+	if !result.Success {
+		fmt.Printf("Error (%s): %v\n", result.ErrorClass, result.Error)
+	}
+	if result.Diagnostics != "" {
+		fmt.Printf("Diagnostics: %s\n", result.Diagnostics)
+	}
+	if verbose && result.SchemaAfter != "" {
+		fmt.Printf("Schema: %s -> %s\n", result.SchemaBefore, result.SchemaAfter)
+		for _, change := range result.SchemaChanges {
+			fmt.Printf("  %s\n", change)
 		}
 	}
 }
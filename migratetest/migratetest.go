@@ -0,0 +1,206 @@
+// Package migratetest applies a project's migrations to a disposable
+// PostgreSQL container, with the same execution semantics as the migrate
+// command, and provides assertions on the resulting schema for use in
+// go test. It requires a Docker daemon.
+package migratetest
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+	"github.com/postresql-migration-golang/pgmigrate"
+	"github.com/testcontainers/testcontainers-go"
+	"github.com/testcontainers/testcontainers-go/modules/postgres"
+	"github.com/testcontainers/testcontainers-go/wait"
+)
+
+// DefaultImage is the PostgreSQL image started when Options.Image is empty.
+const DefaultImage = "postgres:16-alpine"
+
+const (
+	databaseName = "migratetest"
+	username     = "postgres"
+	password     = "postgres"
+)
+
+// Options adjust the container and the migrations.
+type Options struct {
+	// Image is the PostgreSQL image to start, which should match the
+	// server version of production. Defaults to DefaultImage.
+	Image string
+
+	// Config is the configuration the migrations run with. Its migration
+	// directory and clusters are replaced by those of the test.
+	Config pgmigrate.Configuration
+}
+
+// Database is a migrated database in a container, terminated when the test
+// ends.
+type Database struct {
+	// DB is connected to the migrated database.
+	DB *sql.DB
+
+	// ConnectionString connects to the migrated database.
+	ConnectionString string
+
+	// Result is the result of applying the migrations.
+	Result pgmigrate.MigrationResult
+
+	t testing.TB
+}
+
+// Start starts a PostgreSQL container and applies the migrations of
+// migrationDir to a database in it, failing the test if any does not apply.
+func Start(t testing.TB, migrationDir string) *Database {
+	return StartWith(t, migrationDir, Options{})
+}
+
+// StartWith is Start with options.
+func StartWith(t testing.TB, migrationDir string, options Options) *Database {
+	t.Helper()
+	ctx := context.Background()
+
+	image := options.Image
+	if image == "" {
+		image = DefaultImage
+	}
+	container, err := postgres.RunContainer(ctx,
+		testcontainers.WithImage(image),
+		postgres.WithDatabase(databaseName),
+		postgres.WithUsername(username),
+		postgres.WithPassword(password),
+		// The server restarts once after running the init scripts.
+		testcontainers.WithWaitStrategy(wait.ForLog("database system is ready to accept connections").
+			WithOccurrence(2).WithStartupTimeout(time.Minute)),
+	)
+	if err != nil {
+		t.Fatalf("start PostgreSQL container: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := container.Terminate(context.Background()); err != nil {
+			t.Logf("terminate PostgreSQL container: %v", err)
+		}
+	})
+
+	host, err := container.Host(ctx)
+	if err != nil {
+		t.Fatalf("read container host: %v", err)
+	}
+	port, err := container.MappedPort(ctx, "5432/tcp")
+	if err != nil {
+		t.Fatalf("read container port: %v", err)
+	}
+	connectionString, err := container.ConnectionString(ctx, "sslmode=disable")
+	if err != nil {
+		t.Fatalf("read connection string: %v", err)
+	}
+
+	config := options.Config
+	config.MigrationDir = migrationDir
+	config.Clusters = []pgmigrate.ClusterConfig{{
+		Name:     "migratetest",
+		Host:     host,
+		Port:     port.Int(),
+		Username: username,
+		Password: password,
+		SSLMode:  "disable",
+	}}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		t.Fatalf("load migrations: %v", err)
+	}
+	defer migrator.Close()
+
+	target := pgmigrate.Target{Cluster: migrator.Config().Clusters[0], Database: databaseName}
+	result := migrator.Migrate(ctx, []pgmigrate.Target{target})[0]
+	if !result.Success {
+		t.Fatalf("apply migrations: %v", result.Error)
+	}
+
+	db, err := sql.Open("postgres", connectionString)
+	if err != nil {
+		t.Fatalf("connect: %v", err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	return &Database{DB: db, ConnectionString: connectionString, Result: result, t: t}
+}
+
+// Schema reads the current schema of the database, failing the test on
+// error.
+func (d *Database) Schema() pgmigrate.Schema {
+	d.t.Helper()
+	schema, err := pgmigrate.ReadSchema(context.Background(), d.DB)
+	if err != nil {
+		d.t.Fatalf("read schema: %v", err)
+	}
+	return schema
+}
+
+// Object returns the object of the given kind and name, such as "table" and
+// "public.users", and whether it exists. Names are qualified with their
+// schema and quoted as PostgreSQL quotes identifiers.
+func (d *Database) Object(kind, name string) (pgmigrate.SchemaObject, bool) {
+	d.t.Helper()
+	for _, object := range d.Schema() {
+		if object.Kind == kind && object.Name == name {
+			return object, true
+		}
+	}
+	return pgmigrate.SchemaObject{}, false
+}
+
+// AssertObject fails the test unless the object exists.
+func (d *Database) AssertObject(kind, name string) {
+	d.t.Helper()
+	if _, ok := d.Object(kind, name); !ok {
+		d.t.Errorf("%s %s does not exist", kind, name)
+	}
+}
+
+// AssertNoObject fails the test if the object exists.
+func (d *Database) AssertNoObject(kind, name string) {
+	d.t.Helper()
+	if _, ok := d.Object(kind, name); ok {
+		d.t.Errorf("%s %s exists", kind, name)
+	}
+}
+
+// AssertTable fails the test unless the table exists.
+func (d *Database) AssertTable(table string) {
+	d.t.Helper()
+	d.AssertObject("table", table)
+}
+
+// AssertColumn fails the test unless the column exists with the given type,
+// as formatted by PostgreSQL, e.g. "character varying(100)" or "bigint".
+// Constraints and defaults of the column are ignored.
+func (d *Database) AssertColumn(table, column, typ string) {
+	d.t.Helper()
+	object, ok := d.Object("column", table+"."+column)
+	switch {
+	case !ok:
+		d.t.Errorf("column %s.%s does not exist", table, column)
+	case object.Definition != typ && !strings.HasPrefix(object.Definition, typ+" "):
+		d.t.Errorf("column %s.%s is %s, want %s", table, column, object.Definition, typ)
+	}
+}
+
+// AssertIndex fails the test unless the index exists.
+func (d *Database) AssertIndex(index string) {
+	d.t.Helper()
+	d.AssertObject("index", index)
+}
+
+// QueryValue runs a query returning a single value and scans it into dest,
+// failing the test on error.
+func (d *Database) QueryValue(dest any, query string, args ...any) {
+	d.t.Helper()
+	if err := d.DB.QueryRow(query, args...).Scan(dest); err != nil {
+		d.t.Fatalf("%s: %v", query, err)
+	}
+}
@@ -0,0 +1,39 @@
+package migratetest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/testcontainers/testcontainers-go"
+)
+
+func TestStart(t *testing.T) {
+	testcontainers.SkipIfProviderIsNotHealthy(t)
+
+	dir := t.TempDir()
+	for name, script := range map[string]string{
+		"1_create_users.sql":   "CREATE TABLE users (id bigint PRIMARY KEY, email varchar(100) NOT NULL);",
+		"2_index_users.sql":    "CREATE INDEX users_email ON users (email);",
+		"3_drop_temporary.sql": "CREATE TABLE temporary (); DROP TABLE temporary;",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	db := Start(t, dir)
+	if len(db.Result.Applied) != 3 {
+		t.Errorf("applied %v, want 3 migrations", db.Result.Applied)
+	}
+	db.AssertTable("public.users")
+	db.AssertColumn("public.users", "email", "character varying(100)")
+	db.AssertIndex("public.users_email")
+	db.AssertNoObject("table", "public.temporary")
+
+	var count int
+	db.QueryValue(&count, "SELECT count(*) FROM users")
+	if count != 0 {
+		t.Errorf("users has %d rows, want 0", count)
+	}
+}
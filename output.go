@@ -0,0 +1,229 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+	"golang.org/x/term"
+)
+
+// ANSI colors of statuses.
+const (
+	colorGreen  = "\x1b[32m"
+	colorRed    = "\x1b[31m"
+	colorYellow = "\x1b[33m"
+	colorReset  = "\x1b[0m"
+)
+
+// interactive reports whether the output goes to a terminal that a person
+// watches. Output piped to a file or another program, or written in CI, is
+// plain text that is the same from run to run.
+func interactive() bool {
+	return term.IsTerminal(int(os.Stdout.Fd())) && os.Getenv("CI") == "" && os.Getenv("TERM") != "dumb"
+}
+
+// colored reports whether statuses are colored, which NO_COLOR turns off.
+func colored() bool {
+	return interactive() && os.Getenv("NO_COLOR") == ""
+}
+
+// statusLabel returns the bracketed status of a database: green when it
+// succeeded, yellow when it failed with a transient error a retry may fix
+// and red otherwise.
+func statusLabel(success bool, errorClass string) string {
+	label, color := "[Success]", colorGreen
+	if !success {
+		label, color = "[Failed]", colorRed
+		if errorClass == pgmigrate.ErrorTransient {
+			color = colorYellow
+		}
+	}
+	if !colored() {
+		return label
+	}
+	return color + label + colorReset
+}
+
+// resultColumns are the widths the cluster and database names of results
+// are padded to so that they line up, or zero when they are not aligned.
+type resultColumns struct {
+	cluster, database int
+}
+
+// alignResults returns the columns aligning results in a terminal.
+func alignResults(results []pgmigrate.MigrationResult) resultColumns {
+	var columns resultColumns
+	if !interactive() {
+		return columns
+	}
+	for _, result := range results {
+		if n := utf8.RuneCountInString(result.Cluster); n > columns.cluster {
+			columns.cluster = n
+		}
+		if n := utf8.RuneCountInString(result.Database); n > columns.database {
+			columns.database = n
+		}
+	}
+	return columns
+}
+
+// pad pads s with spaces to width runes.
+func pad(s string, width int) string {
+	if n := utf8.RuneCountInString(s); n < width {
+		return s + strings.Repeat(" ", width-n)
+	}
+	return s
+}
+
+// spinnerFrames are the frames of the spinner of in-flight databases.
+var spinnerFrames = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// liveDisplay shows a spinner line listing the databases in flight at the
+// bottom of a terminal. Results printed as they come, when streaming, and
+// log lines are written above it.
+type liveDisplay struct {
+	mu      sync.Mutex
+	stream  bool
+	verbose bool
+	running map[string]time.Time
+	frame   int
+	shown   bool
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+// startLiveDisplay starts the spinner and sends the log through it until
+// close is called. When stream is set, results are printed as they come.
+func startLiveDisplay(stream, verbose bool) *liveDisplay {
+	d := &liveDisplay{
+		stream:  stream,
+		verbose: verbose,
+		running: make(map[string]time.Time),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	log.SetOutput(d)
+	go func() {
+		defer close(d.done)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.mu.Lock()
+				d.frame++
+				d.draw()
+				d.mu.Unlock()
+			}
+		}
+	}()
+	return d
+}
+
+// close clears the spinner and gives the log its output back.
+func (d *liveDisplay) close() {
+	close(d.stop)
+	<-d.done
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+	log.SetOutput(os.Stderr)
+}
+
+func (d *liveDisplay) ReportStart(target pgmigrate.Target) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.running[displayName(target.Cluster.Name, target.Database, target.Schema)] = time.Now()
+	d.draw()
+}
+
+func (d *liveDisplay) Report(result pgmigrate.MigrationResult) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.running, displayName(result.Cluster, result.Database, result.Schema))
+	if d.stream {
+		d.clear()
+		printMigrationResult(result, d.verbose, resultColumns{})
+	}
+	d.draw()
+}
+
+// Write writes a log line above the spinner.
+func (d *liveDisplay) Write(p []byte) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.clear()
+	n, err := os.Stderr.Write(p)
+	d.draw()
+	return n, err
+}
+
+// clear erases the spinner line.
+func (d *liveDisplay) clear() {
+	if d.shown {
+		fmt.Print("\r\x1b[K")
+		d.shown = false
+	}
+}
+
+// draw redraws the spinner line, which lists the databases in flight,
+// longest running first, as far as the terminal is wide.
+func (d *liveDisplay) draw() {
+	d.clear()
+	if len(d.running) == 0 {
+		return
+	}
+	names := make([]string, 0, len(d.running))
+	for name := range d.running {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if !d.running[names[i]].Equal(d.running[names[j]]) {
+			return d.running[names[i]].Before(d.running[names[j]])
+		}
+		return names[i] < names[j]
+	})
+	width, _, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil || width <= 0 {
+		width = 80
+	}
+	// The line is kept shorter than the terminal, since a wrapped line
+	// could not be erased.
+	line := fmt.Sprintf("%s %d running:", spinnerFrames[d.frame%len(spinnerFrames)], len(names))
+	for i, name := range names {
+		entry := fmt.Sprintf(" %s (%s)", name, time.Since(d.running[name]).Truncate(time.Second))
+		room := width - 1
+		if i < len(names)-1 {
+			room -= len(fmt.Sprintf(" +%d more", len(names)-i-1))
+		}
+		if utf8.RuneCountInString(line+entry) > room {
+			line += fmt.Sprintf(" +%d more", len(names)-i)
+			break
+		}
+		line += entry
+	}
+	if runes := []rune(line); len(runes) > width-1 {
+		line = string(runes[:width-1])
+	}
+	fmt.Print(line)
+	d.shown = true
+}
+
+// displayName names a database by its cluster, and its schema in
+// schema-per-tenant mode.
+func displayName(cluster, database, schema string) string {
+	name := cluster + "/" + database
+	if schema != "" {
+		name += "/" + schema
+	}
+	return name
+}
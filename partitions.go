@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runPartitions implements the "partitions" command. Without a subcommand it
+// maintains the partitions of the configured tables; "attach" and "detach"
+// change a single partition in every database.
+func runPartitions(args []string) error {
+	action := "maintain"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		action, args = args[0], args[1:]
+	}
+
+	fs := flag.NewFlagSet("partitions "+action, flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the databases to change (defaults to all)")
+	var table, partition, from, to string
+	if action == "attach" || action == "detach" {
+		fs.StringVar(&table, "table", "", "partitioned table")
+		fs.StringVar(&partition, "partition", "", "partition to attach or detach")
+	}
+	if action == "attach" {
+		fs.StringVar(&from, "from", "", "lower bound of the partition's range (inclusive)")
+		fs.StringVar(&to, "to", "", "upper bound of the partition's range (exclusive)")
+	}
+	fs.Parse(args)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	targets = selectTargets(targets, *database)
+
+	var results []pgmigrate.MaintenanceResult
+	switch action {
+	case "maintain":
+		if len(config.Partitions) == 0 {
+			return errors.New("no partitioned tables configured")
+		}
+		results = migrator.MaintainPartitions(ctx, targets)
+	case "attach":
+		if table == "" || partition == "" || from == "" || to == "" {
+			return errors.New("partitions attach: -table, -partition, -from and -to are required")
+		}
+		results = migrator.AttachPartition(ctx, targets, table, partition, from, to)
+	case "detach":
+		if table == "" || partition == "" {
+			return errors.New("partitions detach: -table and -partition are required")
+		}
+		results = migrator.DetachPartition(ctx, targets, table, partition)
+	default:
+		return fmt.Errorf("unknown partitions command %q", action)
+	}
+	return printMaintenanceResults(results)
+}
+
+// printMaintenanceResults prints the changes made to each database and
+// returns an error if any of them failed.
+func printMaintenanceResults(results []pgmigrate.MaintenanceResult) error {
+	failed := 0
+	for _, result := range results {
+		if result.Error != nil {
+			failed++
+			fmt.Printf("%s Cluster: %s Database: %s\n", statusLabel(false, ""), result.Cluster, result.Database)
+			fmt.Printf("Error: %v\n", result.Error)
+			continue
+		}
+		fmt.Printf("%s Cluster: %s Database: %s\n", statusLabel(true, ""), result.Cluster, result.Database)
+		if len(result.Changes) > 0 {
+			fmt.Printf("Changes: %s\n", strings.Join(result.Changes, ", "))
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d databases failed", failed, len(results))
+	}
+	return nil
+}
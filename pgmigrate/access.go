@@ -0,0 +1,238 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// AccessConfig declares the roles and privileges every migrated database
+// must have. It is applied after the migrations of each database, so that
+// grants cover the tables they create.
+type AccessConfig struct {
+	Roles  []RoleConfig  `json:"roles"`
+	Grants []GrantConfig `json:"grants"`
+
+	// RevokeExtras revokes the privileges that roles with declared grants
+	// hold in those schemas beyond the declared ones.
+	RevokeExtras bool `json:"revoke_extras"`
+}
+
+// RoleConfig is a role created when it does not exist. Roles are shared by
+// all databases of a cluster.
+type RoleConfig struct {
+	Name     string   `json:"name"`
+	Login    bool     `json:"login"`
+	MemberOf []string `json:"member_of"`
+}
+
+// GrantConfig lists the privileges of a role in a schema. Table and
+// sequence privileges are granted on the existing objects and, as default
+// privileges, on those created later by the migrating user.
+type GrantConfig struct {
+	Role               string   `json:"role"`
+	Schema             string   `json:"schema"`
+	SchemaPrivileges   []string `json:"schema_privileges"`
+	TablePrivileges    []string `json:"table_privileges"`
+	SequencePrivileges []string `json:"sequence_privileges"`
+}
+
+// privilegeSets are the privileges that can be granted on each kind of
+// object; ALL stands for all of them.
+var privilegeSets = map[string][]string{
+	"SCHEMA":   {"USAGE", "CREATE"},
+	"TABLE":    {"SELECT", "INSERT", "UPDATE", "DELETE", "TRUNCATE", "REFERENCES", "TRIGGER"},
+	"SEQUENCE": {"USAGE", "SELECT", "UPDATE"},
+}
+
+// LoadAccess reads a roles and grants file.
+func LoadAccess(path string) (*AccessConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var access AccessConfig
+	if err := json.Unmarshal(data, &access); err != nil {
+		return nil, fmt.Errorf("parse %s: %w", path, err)
+	}
+	if err := access.validate(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &access, nil
+}
+
+func (a *AccessConfig) validate() error {
+	for i, role := range a.Roles {
+		if role.Name == "" {
+			return fmt.Errorf("roles[%d]: name is required", i)
+		}
+	}
+	for i, grant := range a.Grants {
+		if grant.Role == "" || grant.Schema == "" {
+			return fmt.Errorf("grants[%d]: role and schema are required", i)
+		}
+		for kind, privileges := range map[string][]string{
+			"SCHEMA":   grant.SchemaPrivileges,
+			"TABLE":    grant.TablePrivileges,
+			"SEQUENCE": grant.SequencePrivileges,
+		} {
+			if _, err := expandPrivileges(kind, privileges); err != nil {
+				return fmt.Errorf("grants[%d]: %w", i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// expandPrivileges upper-cases the privileges, replacing ALL with the
+// privileges it stands for.
+func expandPrivileges(kind string, privileges []string) ([]string, error) {
+	var expanded []string
+	for _, privilege := range privileges {
+		privilege = strings.ToUpper(privilege)
+		if privilege == "ALL" {
+			expanded = append(expanded, privilegeSets[kind]...)
+			continue
+		}
+		if !contains(privilegeSets[kind], privilege) {
+			return nil, fmt.Errorf("invalid %s privilege %q", strings.ToLower(kind), privilege)
+		}
+		expanded = append(expanded, privilege)
+	}
+	return expanded, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// applyAccess creates the missing roles and applies the grants to the
+// database.
+func applyAccess(ctx context.Context, conn *sql.Conn, access *AccessConfig) error {
+	if access == nil {
+		return nil
+	}
+	for _, role := range access.Roles {
+		if err := ensureRole(ctx, conn, role); err != nil {
+			return fmt.Errorf("role %s: %w", role.Name, err)
+		}
+	}
+	for _, grant := range access.Grants {
+		if err := applyGrant(ctx, conn, grant, access.RevokeExtras); err != nil {
+			return fmt.Errorf("grants of %s on schema %s: %w", grant.Role, grant.Schema, err)
+		}
+	}
+	return nil
+}
+
+func ensureRole(ctx context.Context, conn *sql.Conn, role RoleConfig) error {
+	login := "NOLOGIN"
+	if role.Login {
+		login = "LOGIN"
+	}
+	_, err := conn.ExecContext(ctx, "CREATE ROLE "+pq.QuoteIdentifier(role.Name)+" "+login)
+	// Databases of the same cluster are migrated concurrently, so the role
+	// may have been created by another one since.
+	var pqErr *pq.Error
+	if err != nil && !(errors.As(err, &pqErr) && pqErr.Code == "42710") {
+		return err
+	}
+	for _, parent := range role.MemberOf {
+		if _, err := conn.ExecContext(ctx, "GRANT "+pq.QuoteIdentifier(parent)+" TO "+pq.QuoteIdentifier(role.Name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyGrant(ctx context.Context, conn *sql.Conn, grant GrantConfig, revokeExtras bool) error {
+	schema := pq.QuoteIdentifier(grant.Schema)
+	role := pq.QuoteIdentifier(grant.Role)
+	schemaPrivileges, _ := expandPrivileges("SCHEMA", grant.SchemaPrivileges)
+	tablePrivileges, _ := expandPrivileges("TABLE", grant.TablePrivileges)
+	sequencePrivileges, _ := expandPrivileges("SEQUENCE", grant.SequencePrivileges)
+
+	var statements []string
+	if len(schemaPrivileges) > 0 {
+		statements = append(statements, fmt.Sprintf("GRANT %s ON SCHEMA %s TO %s", strings.Join(schemaPrivileges, ", "), schema, role))
+	}
+	if len(tablePrivileges) > 0 {
+		privileges := strings.Join(tablePrivileges, ", ")
+		statements = append(statements,
+			fmt.Sprintf("GRANT %s ON ALL TABLES IN SCHEMA %s TO %s", privileges, schema, role),
+			fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA %s GRANT %s ON TABLES TO %s", schema, privileges, role))
+	}
+	if len(sequencePrivileges) > 0 {
+		privileges := strings.Join(sequencePrivileges, ", ")
+		statements = append(statements,
+			fmt.Sprintf("GRANT %s ON ALL SEQUENCES IN SCHEMA %s TO %s", privileges, schema, role),
+			fmt.Sprintf("ALTER DEFAULT PRIVILEGES IN SCHEMA %s GRANT %s ON SEQUENCES TO %s", schema, privileges, role))
+	}
+	for _, statement := range statements {
+		if _, err := conn.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if !revokeExtras {
+		return nil
+	}
+	return revokeExtraPrivileges(ctx, conn, grant, schemaPrivileges, tablePrivileges, sequencePrivileges)
+}
+
+// grantedPrivilegesQuery lists the privileges granted to role $2 on schema
+// $1 and on the tables and sequences in it.
+const grantedPrivilegesQuery = `
+SELECT 'SCHEMA', format('%I', n.nspname), a.privilege_type
+FROM pg_namespace n, aclexplode(n.nspacl) a
+WHERE n.nspname = $1 AND a.grantee = $2::regrole
+UNION ALL
+SELECT CASE c.relkind WHEN 'S' THEN 'SEQUENCE' ELSE 'TABLE' END, format('%I.%I', n.nspname, c.relname), a.privilege_type
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace, aclexplode(c.relacl) a
+WHERE n.nspname = $1 AND c.relkind IN ('r', 'p', 'v', 'm', 'f', 'S') AND a.grantee = $2::regrole`
+
+func revokeExtraPrivileges(ctx context.Context, conn *sql.Conn, grant GrantConfig, schemaPrivileges, tablePrivileges, sequencePrivileges []string) error {
+	declared := map[string][]string{
+		"SCHEMA":   schemaPrivileges,
+		"TABLE":    tablePrivileges,
+		"SEQUENCE": sequencePrivileges,
+	}
+
+	rows, err := conn.QueryContext(ctx, grantedPrivilegesQuery, grant.Schema, grant.Role)
+	if err != nil {
+		return err
+	}
+	var revokes []string
+	for rows.Next() {
+		var kind, object, privilege string
+		if err := rows.Scan(&kind, &object, &privilege); err != nil {
+			rows.Close()
+			return err
+		}
+		if !contains(declared[kind], privilege) {
+			revokes = append(revokes, fmt.Sprintf("REVOKE %s ON %s %s FROM %s", privilege, kind, object, pq.QuoteIdentifier(grant.Role)))
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, revoke := range revokes {
+		if _, err := conn.ExecContext(ctx, revoke); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Alerting providers.
+const (
+	AlertPagerDuty = "pagerduty"
+	AlertOpsgenie  = "opsgenie"
+)
+
+// AlertConfig opens an incident when a run fails badly enough, so that
+// on-call learns about broken tenants immediately.
+type AlertConfig struct {
+	// Provider is "pagerduty" (Events API v2) or "opsgenie".
+	Provider string `json:"provider"`
+
+	// Key is the PagerDuty integration (routing) key or the Opsgenie API
+	// key. It may be a secret reference.
+	Key string `json:"key"`
+
+	// URL overrides the provider's endpoint, e.g. for Opsgenie's EU
+	// instance.
+	URL string `json:"url"`
+
+	// FailureRate triggers an incident when the share of databases that
+	// failed exceeds it, from 0 (any failure) to 1.
+	FailureRate float64 `json:"failure_rate"`
+
+	// Critical lists names or patterns of databases whose failure triggers
+	// an incident whatever the failure rate.
+	Critical []string `json:"critical"`
+
+	// Severity is the PagerDuty severity (critical, error, warning or
+	// info) or the Opsgenie priority (P1 to P5). Defaults to error or P2.
+	Severity string `json:"severity"`
+}
+
+func (c *AlertConfig) validate() error {
+	switch c.Provider {
+	case AlertPagerDuty, AlertOpsgenie:
+	default:
+		return fmt.Errorf("unknown provider %q (want %s or %s)", c.Provider, AlertPagerDuty, AlertOpsgenie)
+	}
+	if c.Key == "" {
+		return errors.New("key is required")
+	}
+	if c.FailureRate < 0 || c.FailureRate > 1 {
+		return errors.New("failure_rate must be between 0 and 1")
+	}
+	return nil
+}
+
+// alertReason returns why the run warrants an incident, or "" if it does
+// not.
+func (c *AlertConfig) alertReason(summary RunSummary) string {
+	for _, failure := range summary.Failures() {
+		for _, pattern := range c.Critical {
+			if MatchDatabase(pattern, failure.Database) {
+				return fmt.Sprintf("critical database %s/%s failed", failure.Cluster, failure.Database)
+			}
+		}
+	}
+	total := summary.Succeeded + summary.Failed
+	if summary.Failed > 0 && float64(summary.Failed)/float64(total) > c.FailureRate {
+		return fmt.Sprintf("%d of %d databases failed", summary.Failed, total)
+	}
+	return ""
+}
+
+// TopError returns the most common error of the failed databases.
+func (s RunSummary) TopError() string {
+	counts := make(map[string]int)
+	for _, failure := range s.Failures() {
+		counts[failure.Error]++
+	}
+	var top string
+	for err, n := range counts {
+		if n > counts[top] || (n == counts[top] && err < top) {
+			top = err
+		}
+	}
+	return top
+}
+
+// sendAlert opens an incident for the run if it warrants one. The run ID
+// deduplicates incidents raised more than once for the same run.
+func sendAlert(ctx context.Context, config *AlertConfig, summary RunSummary) error {
+	reason := config.alertReason(summary)
+	if reason == "" {
+		return nil
+	}
+	key, err := resolveSecret(ctx, config.Key)
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for _, failure := range summary.Failures() {
+		failed = append(failed, failure.Cluster+"/"+failure.Database)
+	}
+	sort.Strings(failed)
+	message := fmt.Sprintf("Migration run %s: %s", summary.ID, reason)
+	details := map[string]interface{}{
+		"run_id":           summary.ID,
+		"succeeded":        summary.Succeeded,
+		"failed":           summary.Failed,
+		"top_error":        summary.TopError(),
+		"failed_databases": failed,
+	}
+
+	switch config.Provider {
+	case AlertPagerDuty:
+		url := config.URL
+		if url == "" {
+			url = "https://events.pagerduty.com/v2/enqueue"
+		}
+		severity := config.Severity
+		if severity == "" {
+			severity = "error"
+		}
+		return postJSON(ctx, url, nil, map[string]interface{}{
+			"routing_key":  key,
+			"event_action": "trigger",
+			"dedup_key":    "pgmigrate-" + summary.ID,
+			"payload": map[string]interface{}{
+				"summary":        message,
+				"source":         "pgmigrate",
+				"severity":       severity,
+				"custom_details": details,
+			},
+		})
+	default:
+		url := config.URL
+		if url == "" {
+			url = "https://api.opsgenie.com/v2/alerts"
+		}
+		priority := config.Severity
+		if priority == "" {
+			priority = "P2"
+		}
+		return postJSON(ctx, url, map[string]string{"Authorization": "GenieKey " + key}, map[string]interface{}{
+			"message":     message,
+			"alias":       "pgmigrate-" + summary.ID,
+			"description": "Top error: " + summary.TopError(),
+			"priority":    priority,
+			"source":      "pgmigrate",
+			"details":     map[string]string{"run_id": summary.ID, "succeeded": fmt.Sprint(summary.Succeeded), "failed": fmt.Sprint(summary.Failed)},
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package pgmigrate
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// isArchive reports whether the migration directory is a .zip, .tar.gz or
+// .tgz file, whose members are read as migration files in place of a
+// directory's, e.g. build/migrations.zip.
+func isArchive(dir string) bool {
+	if !hasArchiveExtension(dir) {
+		return false
+	}
+	info, err := os.Stat(dir)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// hasArchiveExtension reports whether path names a .zip, .tar.gz or .tgz
+// file.
+func hasArchiveExtension(path string) bool {
+	name := strings.ToLower(path)
+	return strings.HasSuffix(name, ".zip") || strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+}
+
+// migrationArchive holds the regular files of an archive, read into memory
+// without extracting them. Their paths are the archive's path followed by
+// the member's, e.g. build/migrations.zip/0001_init.sql.
+type migrationArchive struct {
+	path  string
+	files map[string][]byte
+}
+
+// openArchive reads the regular files of a zip or gzipped tar archive.
+func openArchive(file string) (*migrationArchive, error) {
+	a := &migrationArchive{path: file, files: make(map[string][]byte)}
+	add := func(name string, r io.Reader) error {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", file, name, err)
+		}
+		a.files[path.Clean(strings.TrimPrefix(name, "./"))] = data
+		return nil
+	}
+
+	if strings.HasSuffix(strings.ToLower(file), ".zip") {
+		zr, err := zip.OpenReader(file)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		for _, f := range zr.File {
+			if !f.Mode().IsRegular() {
+				continue
+			}
+			r, err := f.Open()
+			if err != nil {
+				return nil, err
+			}
+			err = add(f.Name, r)
+			r.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+		return a, nil
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			return a, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", file, err)
+		}
+		if header.Typeflag == tar.TypeReg {
+			if err := add(header.Name, tr); err != nil {
+				return nil, err
+			}
+		}
+	}
+}
+
+// paths returns the paths of the archive's .sql files whose member names
+// match, sorted.
+func (a *migrationArchive) paths(match func(name string) bool) []string {
+	var paths []string
+	for name := range a.files {
+		if isScriptFile(name) && match(name) {
+			paths = append(paths, a.path+"/"+name)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// readFile returns the content of a file of the archive.
+func (a *migrationArchive) readFile(name string) ([]byte, error) {
+	if member, ok := strings.CutPrefix(name, a.path+"/"); ok {
+		if data, ok := a.files[member]; ok {
+			return data, nil
+		}
+	}
+	return nil, &os.PathError{Op: "open", Path: name, Err: os.ErrNotExist}
+}
+
+// loadArchive reads the migrations of an archive. Without a pattern, only
+// the members at the top of the archive are migrations, as in a directory.
+func loadArchive(file, pattern string) ([]Migration, error) {
+	a, err := openArchive(file)
+	if err != nil {
+		return nil, err
+	}
+	paths := a.paths(func(name string) bool {
+		if pattern == "" {
+			return !strings.Contains(name, "/")
+		}
+		return matchGlob(pattern, name)
+	})
+	return loadMigrationFiles(paths, a.readFile)
+}
+
+// migrationFileReader returns a function reading the files of migrations
+// loaded from dirs, from the archives among them or from disk.
+func migrationFileReader(dirs ...string) (func(string) ([]byte, error), error) {
+	var archives []*migrationArchive
+	for _, dir := range dirs {
+		if isArchive(dir) {
+			a, err := openArchive(dir)
+			if err != nil {
+				return nil, err
+			}
+			archives = append(archives, a)
+		}
+	}
+	return func(name string) ([]byte, error) {
+		for _, a := range archives {
+			if strings.HasPrefix(name, a.path+"/") {
+				return a.readFile(name)
+			}
+		}
+		return os.ReadFile(name)
+	}, nil
+}
@@ -0,0 +1,217 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// backfillDirective marks the UPDATE statement following it as a backfill
+// that is run in batches, e.g.
+//
+//	-- pgmigrate:backfill table=orders batch=5000 where="total IS NULL" sleep=100ms
+//	UPDATE orders SET total = price * quantity;
+//
+// Each batch updates up to batch rows of the table matching where, and is
+// committed on its own, so where must stop matching the rows once they are
+// updated. Migrations containing a backfill run outside a transaction.
+const backfillDirective = "-- pgmigrate:backfill"
+
+const defaultBackfillBatch = 1000
+
+// backfill holds the parameters of a backfill directive.
+type backfill struct {
+	table string
+	batch int
+	where string
+	sleep time.Duration
+}
+
+// parseBackfill parses the arguments of a backfill directive line.
+func parseBackfill(line string) (*backfill, error) {
+	args, err := splitDirectiveArgs(strings.TrimPrefix(strings.TrimSpace(line), backfillDirective))
+	if err != nil {
+		return nil, err
+	}
+	b := &backfill{batch: defaultBackfillBatch}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("backfill: expected key=value, got %q", arg)
+		}
+		switch key {
+		case "table":
+			b.table = value
+		case "batch":
+			b.batch, err = strconv.Atoi(value)
+			if err != nil || b.batch <= 0 {
+				return nil, fmt.Errorf("backfill: invalid batch %q", value)
+			}
+		case "where":
+			b.where = value
+		case "sleep":
+			b.sleep, err = time.ParseDuration(value)
+			if err != nil {
+				return nil, fmt.Errorf("backfill: invalid sleep %q", value)
+			}
+		default:
+			return nil, fmt.Errorf("backfill: unknown argument %q", key)
+		}
+	}
+	if b.where == "" {
+		return nil, errors.New("backfill: where is required")
+	}
+	return b, nil
+}
+
+// splitDirectiveArgs splits directive arguments on whitespace. Values may be
+// double-quoted, with Go escaping, to include spaces.
+func splitDirectiveArgs(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated quote in %q", s)
+			}
+			value, err := strconv.Unquote(s[i : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value %s", s[i:end+1])
+			}
+			arg.WriteString(value)
+			inArg = true
+			i = end
+		case c == ' ' || c == '\t':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteByte(c)
+			inArg = true
+		}
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// scriptBackfills validates the backfill directives of a script and reports
+// whether it has any.
+func scriptBackfills(script string) (bool, error) {
+	found := false
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), backfillDirective) {
+			if _, err := parseBackfill(line); err != nil {
+				return false, err
+			}
+			found = true
+		}
+	}
+	return found, nil
+}
+
+// statementBackfill returns the backfill directive among the comment lines
+// leading the statement, or nil if there is none.
+func statementBackfill(sql string) (*backfill, error) {
+	for _, line := range strings.Split(sql, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, backfillDirective) {
+			return parseBackfill(line)
+		}
+		if line != "" && !strings.HasPrefix(line, "--") {
+			break
+		}
+	}
+	return nil, nil
+}
+
+// runBackfill executes an UPDATE statement in batches of rows selected by
+// the directive, until a batch updates no rows.
+func runBackfill(ctx context.Context, conn execer, statement Statement, b *backfill) error {
+	query, table, err := backfillQuery(statement.SQL, b)
+	if err != nil {
+		return err
+	}
+
+	var total int64
+	for batch := 1; ; batch++ {
+		result, err := conn.ExecContext(ctx, query)
+		if err != nil {
+			return fmt.Errorf("backfill batch %d: %w", batch, err)
+		}
+		rows, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rows == 0 {
+			return nil
+		}
+		total += rows
+		log.Printf("backfill %s: batch %d updated %d rows (%d total)", table, batch, rows, total)
+
+		if b.sleep > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(b.sleep):
+			}
+		}
+	}
+}
+
+// backfillQuery restricts the UPDATE to one batch of rows, identified by
+// ctid, and returns it with the name of the backfilled table.
+func backfillQuery(sql string, b *backfill) (string, string, error) {
+	tree, err := parseSQL(sql)
+	if err != nil {
+		return "", "", err
+	}
+	if len(tree.Stmts) != 1 || tree.Stmts[0].Stmt.GetUpdateStmt() == nil {
+		return "", "", errors.New("backfill directive must precede a single UPDATE statement")
+	}
+	update := tree.Stmts[0].Stmt.GetUpdateStmt()
+
+	table := b.table
+	if table == "" {
+		table = quoteRelation(update.Relation)
+	}
+	qualifier := quoteRelation(update.Relation)
+	if update.Relation.Alias != nil {
+		qualifier = update.Relation.Alias.Aliasname
+	}
+
+	batch, err := parseSQL(fmt.Sprintf("SELECT WHERE %s.ctid = ANY (ARRAY(SELECT ctid FROM %s WHERE %s LIMIT %d))", qualifier, table, b.where, b.batch))
+	if err != nil {
+		return "", "", fmt.Errorf("backfill: %w", err)
+	}
+	condition := batch.Stmts[0].Stmt.GetSelectStmt().WhereClause
+	if update.WhereClause != nil {
+		condition = pg_query.MakeBoolExprNode(pg_query.BoolExprType_AND_EXPR, []*pg_query.Node{update.WhereClause, condition}, -1)
+	}
+	update.WhereClause = condition
+
+	query, err := deparseSQL(tree)
+	if err != nil {
+		return "", "", err
+	}
+	return query, table, nil
+}
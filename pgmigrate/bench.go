@@ -0,0 +1,290 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BenchResult reports the timings of the pending migrations on clones of a
+// sample of databases.
+type BenchResult struct {
+	Databases  []BenchDatabase
+	Statements []BenchStatement
+}
+
+// BenchDatabase is the total time the statements of the pending migrations
+// took on the clone of a database.
+type BenchDatabase struct {
+	Cluster  string
+	Database string
+	Duration time.Duration
+	Error    error
+}
+
+// BenchStatement is the distribution of the time a statement took across
+// the sampled databases.
+type BenchStatement struct {
+	Version string
+	Line    int
+	SQL     string // first line of the statement
+	Samples int
+	P50     time.Duration
+	P95     time.Duration
+	Max     time.Duration
+	// EstimatedRows is the largest number of rows the planner expected the
+	// statement to touch, or the size of the table it alters, and -1 when
+	// unknown.
+	EstimatedRows int64
+}
+
+// statementTiming is a statement run on a single clone.
+type statementTiming struct {
+	version  string
+	index    int
+	line     int
+	sql      string
+	duration time.Duration
+	rows     int64
+}
+
+// Bench applies the pending migrations to clones of sample databases
+// spread evenly over targets, or of every target when sample is zero,
+// timing each statement. The clones are dropped afterwards and the
+// databases themselves are not modified.
+func (m *Migrator) Bench(ctx context.Context, targets []Target, sample int) BenchResult {
+	if sample > 0 && sample < len(targets) {
+		sampled := make([]Target, sample)
+		for i := range sampled {
+			sampled[i] = targets[i*len(targets)/sample]
+		}
+		targets = sampled
+	}
+
+	type run struct {
+		database BenchDatabase
+		timings  []statementTiming
+	}
+	runs := forEachTarget(ctx, m.config, targets, func(ctx context.Context, target Target) run {
+		timings, err := m.benchDatabase(ctx, target)
+		database := BenchDatabase{Cluster: target.Cluster.Name, Database: target.Database, Error: err}
+		for _, timing := range timings {
+			database.Duration += timing.duration
+		}
+		return run{database: database, timings: timings}
+	}, func(target Target, err error) run {
+		return run{database: BenchDatabase{Cluster: target.Cluster.Name, Database: target.Database, Error: err}}
+	})
+
+	var result BenchResult
+	type key struct {
+		version string
+		index   int
+	}
+	statements := make(map[key]*BenchStatement)
+	durations := make(map[key][]time.Duration)
+	var order []key
+	for _, run := range runs {
+		result.Databases = append(result.Databases, run.database)
+		for _, timing := range run.timings {
+			k := key{timing.version, timing.index}
+			s, ok := statements[k]
+			if !ok {
+				s = &BenchStatement{Version: timing.version, Line: timing.line, SQL: timing.sql, EstimatedRows: -1}
+				statements[k] = s
+				order = append(order, k)
+			}
+			s.EstimatedRows = max64(s.EstimatedRows, timing.rows)
+			durations[k] = append(durations[k], timing.duration)
+		}
+	}
+
+	sort.Slice(order, func(i, j int) bool {
+		if c := compareVersions(order[i].version, order[j].version); c != 0 {
+			return c < 0
+		}
+		return order[i].index < order[j].index
+	})
+	for _, k := range order {
+		s := statements[k]
+		d := durations[k]
+		sort.Slice(d, func(i, j int) bool { return d[i] < d[j] })
+		s.Samples = len(d)
+		s.P50 = percentile(d, 0.50)
+		s.P95 = percentile(d, 0.95)
+		s.Max = d[len(d)-1]
+		result.Statements = append(result.Statements, *s)
+	}
+	return result
+}
+
+// benchDatabase clones target and applies its pending migrations to the
+// clone statement by statement.
+func (m *Migrator) benchDatabase(ctx context.Context, target Target) ([]statementTiming, error) {
+	clone, drop, err := createScratchDatabase(ctx, target.Cluster, "bench", target.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer drop()
+
+	settings := m.config.settingsFor(target)
+	data := templateData{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema, Vars: settings.Variables}
+
+	db, err := connectToDatabase(ctx, target.Cluster, clone)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := applySessionSettings(ctx, conn, settings); err != nil {
+		return nil, err
+	}
+	if err := lookupVariables(ctx, m.config, target, conn, &settings); err != nil {
+		return nil, err
+	}
+	if err := ensureHistoryTable(ctx, conn); err != nil {
+		return nil, fmt.Errorf("create history table: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn, m.migrations)
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+
+	var timings []statementTiming
+	for _, migration := range pendingMigrations(m.migrations, applied, settings.TargetVersion) {
+		t, err := benchMigration(ctx, conn, migration, data, settings)
+		timings = append(timings, t...)
+		if err != nil {
+			return timings, newMigrationError(migration, err)
+		}
+	}
+	return timings, nil
+}
+
+// benchMigration applies a migration, timing each statement, and records it
+// so that the following migrations see it applied.
+func benchMigration(ctx context.Context, conn *sql.Conn, migration Migration, data templateData, settings DatabaseSettings) ([]statementTiming, error) {
+	script, err := renderScript(migration, data)
+	if err != nil {
+		return nil, fmt.Errorf("render: %w", err)
+	}
+
+	var exec queryExecer = conn
+	var tx *sql.Tx
+	if !settings.NoTransaction && !migration.NoTransaction {
+		if tx, err = conn.BeginTx(ctx, nil); err != nil {
+			return nil, err
+		}
+		defer tx.Rollback()
+		exec = tx
+	}
+
+	var timings []statementTiming
+	for i, statement := range splitStatements(script) {
+		timing := statementTiming{
+			version: migration.Version,
+			index:   i,
+			line:    statement.Line,
+			sql:     firstLine(skipComments(statement.SQL)),
+			rows:    -1,
+		}
+		if tx == nil {
+			timing.rows = estimateRows(ctx, exec, statement.SQL)
+		} else if _, err := tx.ExecContext(ctx, "SAVEPOINT pgmigrate_estimate"); err == nil {
+			// A failed estimate must not abort the migration's transaction.
+			timing.rows = estimateRows(ctx, exec, statement.SQL)
+			if _, err := tx.ExecContext(ctx, "ROLLBACK TO SAVEPOINT pgmigrate_estimate; RELEASE SAVEPOINT pgmigrate_estimate"); err != nil {
+				return timings, err
+			}
+		}
+		started := time.Now()
+		if err := executeStatement(ctx, exec, statement); err != nil {
+			return timings, newStatementError(i+1, statement, err)
+		}
+		timing.duration = time.Since(started)
+		timings = append(timings, timing)
+	}
+
+	if err := recordMigration(ctx, exec, migration, 0); err != nil {
+		return timings, err
+	}
+	if tx != nil {
+		return timings, tx.Commit()
+	}
+	return timings, nil
+}
+
+// estimateRows returns the rows the planner expects a data-modifying
+// statement to return or touch, or the size of the table an ALTER TABLE or
+// CREATE INDEX statement works on, and -1 when unknown.
+func estimateRows(ctx context.Context, conn queryExecer, statement string) int64 {
+	tree, err := parseSQL(statement)
+	if err != nil || len(tree.Stmts) != 1 {
+		return -1
+	}
+	stmt := tree.Stmts[0].Stmt
+
+	var table string
+	switch {
+	case stmt.GetSelectStmt() != nil, stmt.GetInsertStmt() != nil, stmt.GetUpdateStmt() != nil,
+		stmt.GetDeleteStmt() != nil, stmt.GetMergeStmt() != nil:
+		var plan []struct {
+			Plan struct {
+				Rows float64 `json:"Plan Rows"`
+			}
+		}
+		var raw []byte
+		if err := conn.QueryRowContext(ctx, "EXPLAIN (FORMAT JSON) "+statement).Scan(&raw); err != nil {
+			return -1
+		}
+		if err := json.Unmarshal(raw, &plan); err != nil || len(plan) == 0 {
+			return -1
+		}
+		return int64(plan[0].Plan.Rows)
+	case stmt.GetAlterTableStmt() != nil && stmt.GetAlterTableStmt().Relation != nil:
+		table = quoteRelation(stmt.GetAlterTableStmt().Relation)
+	case stmt.GetIndexStmt() != nil && stmt.GetIndexStmt().Relation != nil:
+		table = quoteRelation(stmt.GetIndexStmt().Relation)
+	default:
+		return -1
+	}
+
+	var rows sql.NullInt64
+	err = conn.QueryRowContext(ctx, "SELECT reltuples::bigint FROM pg_class WHERE oid = to_regclass($1)", table).Scan(&rows)
+	if err != nil || !rows.Valid || rows.Int64 < 0 {
+		return -1
+	}
+	return rows.Int64
+}
+
+// percentile returns the q-quantile of sorted durations, by the nearest
+// rank method.
+func percentile(sorted []time.Duration, q float64) time.Duration {
+	rank := int(math.Ceil(q*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
+
+func firstLine(s string) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(s), "\n")
+	return line
+}
+
+func max64(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,104 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// BootstrapConfig initializes brand-new databases from a squashed schema
+// snapshot instead of replaying the whole migration history: the snapshot
+// is run in place of the migrations up to and including its version, which
+// are recorded as applied, and only the newer migrations are applied one by
+// one.
+type BootstrapConfig struct {
+	// Snapshot is the SQL file creating the schema as of Version. It is
+	// rendered like a migration.
+	Snapshot string `json:"snapshot"`
+
+	// Version is the last migration the snapshot includes. Defaults to the
+	// version header of the snapshot, e.g. "-- version: 150".
+	Version string `json:"version"`
+
+	// Script is the snapshot's content, read from Snapshot when the
+	// migrator is created unless set by the program.
+	Script string `json:"-"`
+}
+
+func (c *BootstrapConfig) validate() error {
+	if c.Snapshot == "" && c.Script == "" {
+		return errors.New("snapshot is required")
+	}
+	if c.Version != "" {
+		if _, err := parseVersion(c.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// load reads the snapshot and resolves its version.
+func (c *BootstrapConfig) load() error {
+	if c.Script == "" {
+		script, err := os.ReadFile(c.Snapshot)
+		if err != nil {
+			return err
+		}
+		c.Script = string(script)
+	}
+	if c.Version == "" {
+		c.Version = scriptHeaders(c.Script)["version"]
+		if c.Version == "" {
+			return fmt.Errorf("%s has no version header and no version is configured", c.Snapshot)
+		}
+	}
+	version, err := parseVersion(c.Version)
+	if err != nil {
+		return fmt.Errorf("%s: %w", c.Snapshot, err)
+	}
+	c.Version = version
+	return nil
+}
+
+// bootstrapDatabase runs the snapshot on a brand-new database, one with an
+// empty history and no tables of its own (or, in schema-per-tenant mode, a
+// tenant schema without tables), and records the migrations it
+// includes as applied. It reports whether the database was bootstrapped.
+func bootstrapDatabase(ctx context.Context, conn *sql.Conn, config *BootstrapConfig, migrations []Migration, applied map[string]AppliedMigration, data templateData) (bool, error) {
+	if config == nil || len(applied) > 0 {
+		return false, nil
+	}
+	var empty bool
+	err := conn.QueryRowContext(ctx, `SELECT NOT EXISTS (
+	SELECT 1 FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+	WHERE c.relkind IN ('r', 'p') AND c.relname <> $1
+		AND ($2 = '' OR n.nspname = $2) AND `+userNamespaces+`)`, historyTable, data.Schema).Scan(&empty)
+	if err != nil || !empty {
+		return false, err
+	}
+
+	script, err := renderScript(Migration{Path: config.Snapshot, Script: config.Script}, data)
+	if err != nil {
+		return false, fmt.Errorf("render: %w", err)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+	if err := executeStatements(ctx, tx, splitStatements(script)); err != nil {
+		return false, err
+	}
+	for _, migration := range migrations {
+		if compareVersions(migration.Version, config.Version) > 0 {
+			break
+		}
+		if err := recordMigration(ctx, tx, migration, 0); err != nil {
+			return false, err
+		}
+		applied[migration.Version] = AppliedMigration{Version: migration.Version, Description: migration.Description, Checksum: migration.Checksum}
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,192 @@
+package pgmigrate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ChangelogEntry is a migration applied during a run, for release tooling.
+type ChangelogEntry struct {
+	Version string `json:"version"`
+	// Description is the description header of the migration, or the
+	// one of its file name.
+	Description string `json:"description"`
+	Author      string `json:"author,omitempty"`
+	// Databases lists the databases the migration was applied to, as
+	// cluster/database, or cluster/database/schema for tenant schemas.
+	Databases []string `json:"databases"`
+}
+
+// Changelog lists the migrations applied in the given results, ordered by
+// version.
+func (m *Migrator) Changelog(results []MigrationResult) []ChangelogEntry {
+	databases := make(map[string][]string)
+	for _, result := range results {
+		for _, version := range result.Applied {
+			name := result.Cluster + "/" + result.Database
+			if result.Schema != "" {
+				name += "/" + result.Schema
+			}
+			databases[version] = append(databases[version], name)
+		}
+	}
+
+	var entries []ChangelogEntry
+	for _, migration := range m.migrations {
+		if len(databases[migration.Version]) == 0 {
+			continue
+		}
+		description := migration.Headers["description"]
+		if description == "" {
+			description = migration.Description
+		}
+		entries = append(entries, ChangelogEntry{
+			Version:     migration.Version,
+			Description: description,
+			Author:      migration.Headers["author"],
+			Databases:   databases[migration.Version],
+		})
+	}
+	return entries
+}
+
+// WriteChangelog writes the entries as a "markdown" fragment or as "json".
+func WriteChangelog(w io.Writer, entries []ChangelogEntry, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if entries == nil {
+			entries = []ChangelogEntry{}
+		}
+		return encoder.Encode(entries)
+	case "markdown":
+	default:
+		return fmt.Errorf("unknown changelog format %q", format)
+	}
+
+	var b strings.Builder
+	b.WriteString("### Schema changes\n\n")
+	if len(entries) == 0 {
+		b.WriteString("No migrations applied.\n")
+	}
+	for _, entry := range entries {
+		fmt.Fprintf(&b, "- **%s** %s", entry.Version, entry.Description)
+		if entry.Author != "" {
+			fmt.Fprintf(&b, " (%s)", entry.Author)
+		}
+		fmt.Fprintf(&b, " — %s\n", databaseList(entry.Databases))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// databaseList abbreviates long lists of databases to their number.
+func databaseList(databases []string) string {
+	if len(databases) > 5 {
+		return fmt.Sprintf("%d databases", len(databases))
+	}
+	return strings.Join(databases, ", ")
+}
+
+// ReleaseNote groups the migrations applied for a ticket, named by the
+// ticket or tickets header of the migrations.
+type ReleaseNote struct {
+	// Ticket is empty for the migrations that name no ticket.
+	Ticket     string           `json:"ticket"`
+	Migrations []ChangelogEntry `json:"migrations"`
+}
+
+// ReleaseNotes groups the migrations applied in the given results by ticket,
+// in the order tickets first appear, followed by the migrations naming no
+// ticket. A migration naming several tickets is listed under each.
+func (m *Migrator) ReleaseNotes(results []MigrationResult) []ReleaseNote {
+	entries := m.Changelog(results)
+	headers := make(map[string]map[string]string, len(m.migrations))
+	for _, migration := range m.migrations {
+		headers[migration.Version] = migration.Headers
+	}
+
+	var notes []ReleaseNote
+	index := make(map[string]int)
+	var untracked []ChangelogEntry
+	for _, entry := range entries {
+		tickets := migrationTickets(headers[entry.Version])
+		if len(tickets) == 0 {
+			untracked = append(untracked, entry)
+		}
+		for _, ticket := range tickets {
+			i, ok := index[ticket]
+			if !ok {
+				i = len(notes)
+				index[ticket] = i
+				notes = append(notes, ReleaseNote{Ticket: ticket})
+			}
+			notes[i].Migrations = append(notes[i].Migrations, entry)
+		}
+	}
+	if len(untracked) > 0 {
+		notes = append(notes, ReleaseNote{Migrations: untracked})
+	}
+	return notes
+}
+
+// migrationTickets returns the ticket IDs of the ticket and tickets headers,
+// separated by commas or spaces.
+func migrationTickets(headers map[string]string) []string {
+	var tickets []string
+	seen := make(map[string]bool)
+	for _, field := range strings.FieldsFunc(headers["ticket"]+","+headers["tickets"], func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	}) {
+		if !seen[field] {
+			seen[field] = true
+			tickets = append(tickets, field)
+		}
+	}
+	return tickets
+}
+
+// WriteReleaseNotes writes the notes as "markdown" or "json", listing every
+// database each migration was applied to.
+func WriteReleaseNotes(w io.Writer, notes []ReleaseNote, format string) error {
+	switch format {
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		if notes == nil {
+			notes = []ReleaseNote{}
+		}
+		return encoder.Encode(notes)
+	case "markdown":
+	default:
+		return fmt.Errorf("unknown release notes format %q", format)
+	}
+
+	var b strings.Builder
+	b.WriteString("## Release notes\n")
+	if len(notes) == 0 {
+		b.WriteString("\nNo migrations applied.\n")
+	}
+	for _, note := range notes {
+		ticket := note.Ticket
+		if ticket == "" {
+			ticket = "No ticket"
+		}
+		fmt.Fprintf(&b, "\n### %s\n\n", ticket)
+		for _, entry := range note.Migrations {
+			fmt.Fprintf(&b, "- **%s** %s", entry.Version, entry.Description)
+			if entry.Author != "" {
+				fmt.Fprintf(&b, " (%s)", entry.Author)
+			}
+			b.WriteString("\n")
+			for _, database := range entry.Databases {
+				fmt.Fprintf(&b, "  - %s\n", database)
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
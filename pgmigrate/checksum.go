@@ -0,0 +1,116 @@
+package pgmigrate
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when an applied migration's file no
+// longer matches the checksum recorded when it was applied.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// crc32Prefix marks CRC32 checksums in the history table. SHA-256 checksums
+// are stored as bare hex, as they always have been.
+const crc32Prefix = "crc32:"
+
+// ChecksumConfig selects how migration checksums are computed.
+type ChecksumConfig struct {
+	// Algorithm is "sha256" (the default) or "crc32", which computes the
+	// same value as Flyway so imported Flyway histories keep matching.
+	Algorithm string `json:"algorithm"`
+
+	// Normalize lists normalizations applied before a SHA-256 checksum is
+	// computed: "line_endings" treats CRLF and CR as LF, and
+	// "trailing_whitespace" ignores whitespace at the end of lines and of
+	// the file. CRC32 always ignores line endings, like Flyway.
+	Normalize []string `json:"normalize"`
+}
+
+func (c ChecksumConfig) validate() error {
+	switch c.Algorithm {
+	case "", "sha256", "crc32":
+	default:
+		return fmt.Errorf("checksum.algorithm: unknown algorithm %q", c.Algorithm)
+	}
+	for _, n := range c.Normalize {
+		if n != "line_endings" && n != "trailing_whitespace" {
+			return fmt.Errorf("checksum.normalize: unknown normalization %q", n)
+		}
+	}
+	return nil
+}
+
+// Sum returns the checksum of a migration script as stored in the history
+// table.
+func (c ChecksumConfig) Sum(script string) string {
+	if c.Algorithm == "crc32" {
+		return crc32Prefix + strconv.Itoa(int(flywayCRC32(script)))
+	}
+
+	for _, n := range c.Normalize {
+		switch n {
+		case "line_endings":
+			script = strings.ReplaceAll(script, "\r\n", "\n")
+			script = strings.ReplaceAll(script, "\r", "\n")
+		case "trailing_whitespace":
+			lines := strings.Split(script, "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimRight(line, " \t\r")
+			}
+			script = strings.TrimRight(strings.Join(lines, "\n"), "\n")
+		}
+	}
+	sum := sha256.Sum256([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+// matches reports whether a stored checksum matches the script. The stored
+// value's own algorithm is used, so switching algorithms does not invalidate
+// migrations applied earlier.
+func (c ChecksumConfig) matches(stored, script string) bool {
+	if strings.HasPrefix(stored, crc32Prefix) {
+		return stored == ChecksumConfig{Algorithm: "crc32"}.Sum(script)
+	}
+	sha := c
+	sha.Algorithm = "sha256"
+	return stored == sha.Sum(script)
+}
+
+// flywayCRC32 computes Flyway's checksum: the CRC32 of the script's lines
+// without their line terminators and without a leading byte order mark,
+// as a signed 32-bit integer.
+func flywayCRC32(script string) int32 {
+	hash := crc32.NewIEEE()
+	scanner := bufio.NewScanner(strings.NewReader(script))
+	scanner.Buffer(make([]byte, 64*1024), len(script)+1)
+	first := true
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if first {
+			line = strings.TrimPrefix(line, "\uFEFF")
+			first = false
+		}
+		hash.Write([]byte(line))
+	}
+	return int32(hash.Sum32())
+}
+
+// verifyChecksums checks that the files of applied migrations still match
+// the checksums recorded for them.
+func verifyChecksums(migrations []Migration, applied map[string]AppliedMigration, config ChecksumConfig) error {
+	for _, migration := range migrations {
+		record, ok := applied[migration.Version]
+		if !ok || config.matches(record.Checksum, migration.Script) {
+			continue
+		}
+		return fmt.Errorf("migration %s (%s): %w: recorded %s, file has %s",
+			migration.Version, migration.Description, ErrChecksumMismatch, record.Checksum, config.Sum(migration.Script))
+	}
+	return nil
+}
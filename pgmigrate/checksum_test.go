@@ -0,0 +1,74 @@
+package pgmigrate
+
+import "testing"
+
+func TestFlywayCRC32(t *testing.T) {
+	// -873187034 is 0xCBF43926, the CRC32 check value of "123456789", which
+	// Flyway records for a script of that single line.
+	for _, test := range []struct {
+		name   string
+		script string
+		want   int32
+	}{
+		{name: "check value", script: "123456789", want: -873187034},
+		{name: "line feed", script: "123456789\n", want: -873187034},
+		{name: "lines", script: "1234\n56789\n", want: -873187034},
+		{name: "CRLF", script: "1234\r\n56789\r\n", want: -873187034},
+		{name: "byte order mark", script: "\uFEFF1234\n56789", want: -873187034},
+		{name: "empty", script: "", want: 0},
+	} {
+		if got := flywayCRC32(test.script); got != test.want {
+			t.Errorf("%s: got %d, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChecksumSum(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		config ChecksumConfig
+		script string
+		want   string
+	}{
+		{
+			name:   "sha256",
+			script: "SELECT 1;\nSELECT 2;",
+			want:   "f64316e71b25e7e950d2440c39420b51dfcc2931e172a923d5b7f89fdc67342d",
+		},
+		{
+			name:   "sha256 keeps line endings and whitespace",
+			script: "SELECT 1;\r\nSELECT 2;  \n\n",
+			want:   "d9a9836bfe3bb4e14f5b12c287f408a978a14bf019ed0308ba4a3463595ecab3",
+		},
+		{
+			name:   "normalized",
+			config: ChecksumConfig{Normalize: []string{"line_endings", "trailing_whitespace"}},
+			script: "SELECT 1;\r\nSELECT 2;  \n\n",
+			want:   "f64316e71b25e7e950d2440c39420b51dfcc2931e172a923d5b7f89fdc67342d",
+		},
+		{
+			name:   "crc32",
+			config: ChecksumConfig{Algorithm: "crc32"},
+			script: "1234\r\n56789\n",
+			want:   "crc32:-873187034",
+		},
+	} {
+		if got := test.config.Sum(test.script); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
+
+func TestChecksumMatches(t *testing.T) {
+	script := "SELECT 1;\n"
+	sha := ChecksumConfig{}.Sum(script)
+	crc := ChecksumConfig{Algorithm: "crc32"}.Sum(script)
+	for _, config := range []ChecksumConfig{{}, {Algorithm: "crc32"}} {
+		if !config.matches(sha, script) || !config.matches(crc, script) {
+			t.Errorf("%q: a checksum of the other algorithm does not match", config.Algorithm)
+		}
+		if config.matches(sha, "SELECT 2;\n") || config.matches(crc, "SELECT 2;\n") {
+			t.Errorf("%q: a changed script matches", config.Algorithm)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+)
+
+// Commit status providers.
+const (
+	StatusGitHub = "github"
+	StatusGitLab = "gitlab"
+)
+
+// CommitStatusConfig posts the state of each run as a commit status of the
+// migrations repository, pending when the run starts and success or
+// failure when it ends, so schema rollouts show up next to code deploys.
+type CommitStatusConfig struct {
+	// Provider is "github" or "gitlab".
+	Provider string `json:"provider"`
+
+	// URL is the API root. Defaults to https://api.github.com or
+	// https://gitlab.com/api/v4.
+	URL string `json:"url"`
+
+	// Repository is the GitHub "owner/repo" or the GitLab project path or
+	// ID.
+	Repository string `json:"repository"`
+
+	// SHA is the commit the migrations were taken from. Defaults to
+	// $GITHUB_SHA, $CI_COMMIT_SHA or the HEAD of the migration directory's
+	// git checkout.
+	SHA string `json:"sha"`
+
+	// Token authenticates with the API. It may be a secret reference.
+	Token string `json:"token"`
+
+	// Context names the status. Defaults to "pgmigrate".
+	Context string `json:"context"`
+
+	// ReportURL is a text/template executed with the RunSummary giving the
+	// link of the status, e.g. https://ci.example.com/runs/{{.ID}}.
+	ReportURL string `json:"report_url"`
+}
+
+func (c *CommitStatusConfig) validate() error {
+	switch c.Provider {
+	case StatusGitHub, StatusGitLab:
+	default:
+		return fmt.Errorf("unknown provider %q (want %s or %s)", c.Provider, StatusGitHub, StatusGitLab)
+	}
+	if c.Repository == "" {
+		return errors.New("repository is required")
+	}
+	if c.Token == "" {
+		return errors.New("token is required")
+	}
+	if _, err := template.New("report_url").Funcs(summaryFuncs).Parse(c.ReportURL); err != nil {
+		return fmt.Errorf("report_url: %w", err)
+	}
+	return nil
+}
+
+// commitSHA returns the commit to post statuses for.
+func (c *CommitStatusConfig) commitSHA(migrationDir string) (string, error) {
+	for _, sha := range []string{c.SHA, os.Getenv("GITHUB_SHA"), os.Getenv("CI_COMMIT_SHA")} {
+		if sha != "" {
+			return sha, nil
+		}
+	}
+	cmd := exec.Command("git", "rev-parse", "HEAD")
+	cmd.Dir = migrationDir
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("find commit of %s: %w", migrationDir, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// postCommitStatus posts a state of the run: "pending", "success" or
+// "failure".
+func postCommitStatus(ctx context.Context, config *CommitStatusConfig, migrationDir, state, description string, summary RunSummary) error {
+	sha, err := config.commitSHA(migrationDir)
+	if err != nil {
+		return err
+	}
+	token, err := resolveSecret(ctx, config.Token)
+	if err != nil {
+		return err
+	}
+	var target string
+	if config.ReportURL != "" {
+		if target, err = executeTemplate(config.ReportURL, "", summary); err != nil {
+			return fmt.Errorf("report_url: %w", err)
+		}
+	}
+	name := config.Context
+	if name == "" {
+		name = "pgmigrate"
+	}
+
+	if config.Provider == StatusGitHub {
+		root := config.URL
+		if root == "" {
+			root = "https://api.github.com"
+		}
+		return postJSON(ctx, fmt.Sprintf("%s/repos/%s/statuses/%s", strings.TrimSuffix(root, "/"), config.Repository, sha),
+			map[string]string{"Authorization": "Bearer " + token, "Accept": "application/vnd.github+json"},
+			map[string]string{"state": state, "target_url": target, "description": description, "context": name})
+	}
+	root := config.URL
+	if root == "" {
+		root = "https://gitlab.com/api/v4"
+	}
+	if state == "failure" {
+		state = "failed"
+	}
+	return postJSON(ctx, fmt.Sprintf("%s/projects/%s/statuses/%s", strings.TrimSuffix(root, "/"), url.PathEscape(config.Repository), sha),
+		map[string]string{"PRIVATE-TOKEN": token},
+		map[string]string{"state": state, "target_url": target, "description": description, "name": name})
+}
@@ -0,0 +1,771 @@
+package pgmigrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// Configuration defines the parameters for the migration process.
+type Configuration struct {
+	DBUsername string `json:"db_username"`
+	// MigrationDir is the directory of the migrations, a .zip, .tar.gz or
+	// .tgz archive of them read without extracting it, or the URL of a
+	// remote source whose files are downloaded at run time, such as
+	// s3://bucket/prefix?region=eu-west-1, gs://bucket/prefix or the HTTPS
+	// URL of a bundle, https://host/migrations.tar.gz#sha256=9f86d0..., or a
+	// git repository, git+https://host/repo.git#ref=v1.4&dir=migrations,
+	// or an OCI artifact, oci://registry/repo:1.4@sha256:3f2c1e....
+	// Its _before.sql and _after.sql files are SessionScripts rather than
+	// migrations.
+	MigrationDir string `json:"migration_dir"`
+
+	// MigrationGlob, when set, loads the migrations from the directories
+	// under MigrationDir whose files match it, such as
+	// "**/[0-9]*_*.sql", instead of from MigrationDir alone. See
+	// LoadMigrationsGlob.
+	MigrationGlob string `json:"migration_glob"`
+
+	// Sources, when set, replace MigrationDir with several migration
+	// sources merged into one sequence, in increasing precedence. See
+	// SourceConfig.
+	Sources []SourceConfig `json:"sources"`
+
+	// MaxConcurrency caps the number of databases migrated at once across
+	// all clusters. Zero means no limit.
+	MaxConcurrency int `json:"max_concurrency"`
+
+	// HostConcurrency caps the number of databases migrated at once on a
+	// single host, keyed by host name, regardless of which cluster they
+	// belong to.
+	HostConcurrency map[string]int `json:"host_concurrency"`
+
+	// OrderBy is the order databases are started in: "name" (the default),
+	// "created" or "size". See OrderTargets.
+	OrderBy string `json:"order_by"`
+
+	// Priorities assign priorities to databases, such as critical tenants,
+	// which are started before the others. PriorityTiers also finishes
+	// every database of a priority before starting the next lower one,
+	// and starts none of the lower ones once any of them failed.
+	Priorities    []PriorityConfig `json:"priorities"`
+	PriorityTiers bool             `json:"priority_tiers"`
+
+	// DiscoveryCache caches the database list of each cluster on disk.
+	DiscoveryCache *DiscoveryCacheConfig `json:"discovery_cache"`
+
+	// Shard restricts the run to a slice of the fleet, such as "2/8", so
+	// that several instances of the tool can split a fleet between them.
+	// See ShardTargets.
+	Shard string `json:"shard"`
+
+	// ExcludeDatabases lists database names or patterns that are never
+	// migrated. When unset, defaultExcludedDatabases is used; set it to an
+	// empty list to migrate every database. The scratch databases the tool
+	// creates for rehearsals and checks are excluded either way.
+	ExcludeDatabases []string `json:"exclude_databases"`
+
+	// Password is used for clusters that have no password of their own. It
+	// is normally supplied with -password-prompt or -password-file rather
+	// than written to the configuration file.
+	Password string `json:"password"`
+
+	// AgeIdentityFile is the age identity file used to decrypt values
+	// written as enc:age:..., and KMSRegion the AWS region used for values
+	// written as enc:aws-kms:.... Any string value of the configuration may
+	// be encrypted with "pgmigrate config encrypt".
+	AgeIdentityFile string `json:"age_identity_file"`
+	KMSRegion       string `json:"kms_region"`
+
+	// PassFile is the .pgpass-format password file used for clusters that
+	// have no password configured. Defaults to $PGPASSFILE or ~/.pgpass.
+	PassFile string `json:"pass_file"`
+
+	// Proxy is the default proxy for clusters that reach their server
+	// neither through SSH nor through a proxy of their own.
+	Proxy string `json:"proxy"`
+
+	// Dialer is the default dialer for clusters without their own dialer,
+	// SSH tunnel or proxy.
+	Dialer Dialer `json:"-"`
+
+	// Lister, Source, Executor and Reporter replace the built-in database
+	// discovery, migration loading and execution, and receive the results
+	// as they come. See DatabaseLister.
+	Lister   DatabaseLister `json:"-"`
+	Source   ScriptSource   `json:"-"`
+	Executor Executor       `json:"-"`
+	Reporter Reporter       `json:"-"`
+
+	// SessionScripts are read by New from the _before.sql and _after.sql
+	// files of the migration directory. Set them along with Source.
+	SessionScripts *SessionScripts `json:"-"`
+
+	// ReportBuffer is the number of results waiting for a slow Reporter
+	// before finishing databases wait for it. Defaults to 100.
+	ReportBuffer int `json:"report_buffer"`
+
+	// PgRepack is the default pg_repack executable. Defaults to pg_repack
+	// on the PATH.
+	PgRepack string `json:"pg_repack"`
+
+	// EchoSQL logs every statement of the migrations with its duration and
+	// rows affected, and the NOTICE and WARNING messages of the server, on
+	// every cluster.
+	EchoSQL bool `json:"echo_sql"`
+
+	// Pool sizes the connection pool of every database, on clusters that
+	// have no pool of their own.
+	Pool *PoolConfig `json:"pool"`
+
+	// PgDump is the pg_dump executable taking the final backup of
+	// deprovisioned tenants. Defaults to pg_dump on the PATH.
+	PgDump string `json:"pg_dump"`
+
+	// Clusters lists the PostgreSQL servers to migrate. When empty and no
+	// discovery provider is configured, a single cluster on the default
+	// host is used with DBUsername.
+	Clusters []ClusterConfig `json:"clusters"`
+
+	// Consul discovers further clusters from the Consul service catalog
+	// before each run.
+	Consul *ConsulDiscovery `json:"consul"`
+
+	// RunLock holds a lock in etcd or Consul for the whole run, for
+	// deployments running several replicas of the migrator.
+	RunLock *RunLockConfig `json:"run_lock"`
+
+	// Checksum selects the checksum algorithm and normalization used to
+	// detect edits to applied migrations.
+	Checksum ChecksumConfig `json:"checksum"`
+
+	// Signatures, when set, only allows migrations signed by trusted keys.
+	Signatures *SignatureConfig `json:"signatures"`
+
+	// SkipSyntaxCheck disables parsing migrations before the run, for
+	// scripts using syntax newer than the bundled PostgreSQL parser.
+	SkipSyntaxCheck bool `json:"skip_syntax_check"`
+
+	// Lint configures the warnings for operations that lock or rewrite
+	// large tables. It has no effect when SkipSyntaxCheck is set.
+	Lint LintConfig `json:"lint"`
+
+	// StatementTimeout and LockTimeout are applied to each migration
+	// session, in any format PostgreSQL accepts (e.g. "30s", "5min").
+	StatementTimeout string `json:"statement_timeout"`
+	LockTimeout      string `json:"lock_timeout"`
+
+	// NoTransaction runs migrations outside of a transaction, which is
+	// required for statements such as CREATE INDEX CONCURRENTLY.
+	NoTransaction bool `json:"no_transaction"`
+
+	// Variables are exposed to templated migration scripts as
+	// {{.Vars.name}}.
+	Variables map[string]string `json:"variables"`
+
+	// VariablesQuery looks up additional variables for each database.
+	VariablesQuery *VariablesQueryConfig `json:"variables_query"`
+
+	// Provisioning describes how the "provision" command creates tenant
+	// databases.
+	Provisioning *ProvisioningConfig `json:"provisioning"`
+
+	// Flyway switches the migration directory to Flyway's naming
+	// conventions.
+	Flyway *FlywayConfig `json:"flyway"`
+
+	// ErrorPolicies handle statement errors by SQLSTATE, in order: the
+	// first policy covering an error applies.
+	ErrorPolicies []ErrorPolicy `json:"error_policies"`
+
+	// Retry retries databases that fail with a transient error.
+	Retry *RetryConfig `json:"retry"`
+
+	// RunID identifies runs in notifications. Defaults to a random ID per
+	// run.
+	RunID string `json:"run_id"`
+
+	// Notifications lists where the outcome of each run is sent.
+	Notifications *NotificationConfig `json:"notifications"`
+
+	// Hooks runs shell commands after each database succeeds or fails.
+	Hooks *HooksConfig `json:"hooks"`
+
+	// MigrationHooks run SQL before and after every migration.
+	MigrationHooks []MigrationHook `json:"migration_hooks"`
+
+	// Diagnostics collects a diagnostic bundle for each database that
+	// fails.
+	Diagnostics *DiagnosticsConfig `json:"diagnostics"`
+
+	// HistoryExport keeps the history table of another migration tool up
+	// to date after every run of a database. Only "golang-migrate" is
+	// supported.
+	HistoryExport string `json:"history_export"`
+
+	// Bootstrap initializes brand-new databases from a schema snapshot.
+	Bootstrap *BootstrapConfig `json:"bootstrap"`
+
+	// TenantSchemas enables schema-per-tenant mode.
+	TenantSchemas *TenantSchemaConfig `json:"tenant_schemas"`
+
+	// Context is the environment the run is for, such as "prod" or
+	// "staging", which selects the migrations with context directives.
+	Context string `json:"context"`
+
+	// Labels selects the migrations labeled with at least one of them,
+	// and ExcludeLabels leaves out those labeled with any of them, e.g. to
+	// defer heavy migrations to a maintenance window. Migrations left out
+	// stay pending, and selection does not account for migrations that
+	// depend on each other.
+	Labels        []string `json:"labels"`
+	ExcludeLabels []string `json:"exclude_labels"`
+
+	// Features decides which feature flags are enabled for each database,
+	// and so which migrations gated by feature directives apply to it.
+	Features *FeatureConfig `json:"features"`
+
+	// Extensions are created or updated in each database before its
+	// migrations run.
+	Extensions []ExtensionConfig `json:"extensions"`
+
+	// RefreshMaterializedViews are refreshed in each database after
+	// migrations were applied to it, before the views declared with
+	// refresh directives in those migrations.
+	RefreshMaterializedViews []RefreshConfig `json:"refresh_materialized_views"`
+
+	// ConstraintValidation schedules the validation of constraints added
+	// as NOT VALID.
+	ConstraintValidation *ConstraintValidationConfig `json:"constraint_validation"`
+
+	// SchemaDiff captures the schema of each database before and after the
+	// run and reports the objects that changed.
+	SchemaDiff bool `json:"schema_diff"`
+
+	// SnapshotDir receives the schema of each database after the run, one
+	// file per object under <cluster>/<database>, for committing as
+	// schema-as-code.
+	SnapshotDir string `json:"snapshot_dir"`
+
+	// Diagram emits an entity-relationship diagram of each database after
+	// the run.
+	Diagram *DiagramConfig `json:"diagram"`
+
+	// Sequences checks after migrations that the sequences of serial and
+	// identity columns are ahead of the values in them: "check" reports the
+	// sequences that are behind and "fix" advances them.
+	Sequences string `json:"sequences"`
+
+	// Partitions are the range-partitioned tables maintained by the
+	// "partitions" command.
+	Partitions []PartitionConfig `json:"partitions"`
+
+	// AccessFile is a JSON file of roles and grants applied to each database
+	// after its migrations. New loads it into Access.
+	AccessFile string        `json:"access_file"`
+	Access     *AccessConfig `json:"-"`
+
+	// DefinitionsDir holds the managed definitions of views and functions.
+	// When set, those depending on a column a migration drops or changes
+	// the type of are dropped before it and recreated from there after it.
+	DefinitionsDir string `json:"definitions_dir"`
+
+	// TargetVersion stops the migration after the given version. Empty
+	// means the latest version.
+	TargetVersion string `json:"target_version"`
+
+	// Overrides adjust the settings above for individual databases. When
+	// several overrides match a database they are applied in order.
+	Overrides []DatabaseOverride `json:"overrides"`
+}
+
+// DatabaseOverride replaces settings for the databases whose name matches
+// Match, either exactly or as a path.Match pattern such as "tenant_*". Unset
+// fields keep the global value; Variables are merged into the global ones.
+type DatabaseOverride struct {
+	Match            string            `json:"match"`
+	StatementTimeout *string           `json:"statement_timeout"`
+	LockTimeout      *string           `json:"lock_timeout"`
+	NoTransaction    *bool             `json:"no_transaction"`
+	Variables        map[string]string `json:"variables"`
+	TargetVersion    *string           `json:"target_version"`
+}
+
+// DatabaseSettings are the effective settings for a single database after
+// overrides are applied.
+type DatabaseSettings struct {
+	StatementTimeout string
+	LockTimeout      string
+	NoTransaction    bool
+	Variables        map[string]string
+	TargetVersion    string
+	DefinitionsDir   string
+	// Schema is the tenant schema in schema-per-tenant mode.
+	Schema string
+	// ErrorPolicies handle statement errors by SQLSTATE.
+	ErrorPolicies []ErrorPolicy
+	// MigrationHooks are the migration hooks of the configured context.
+	MigrationHooks []MigrationHook
+	// FlywayHistory records applied migrations in flyway_schema_history
+	// too.
+	FlywayHistory bool
+}
+
+// ClusterConfig describes a single PostgreSQL server whose databases are
+// migrated.
+type ClusterConfig struct {
+	Name string `json:"name"`
+
+	// Host is a host name or address, or the directory holding the server's
+	// Unix domain socket when it starts with a slash.
+	Host string `json:"host"`
+	Port int    `json:"port"`
+
+	// SRV is a DNS SRV record (e.g. _postgresql._tcp.db.example.com)
+	// listing the cluster's servers. It is looked up before each run and
+	// replaces Host and Port with the target that is currently primary.
+	SRV string `json:"srv"`
+
+	// Username and Password may be secret references such as
+	// aws-secretsmanager://prod/pg-admin#password or
+	// aws-ssm://prod/pg/password, resolved when connecting.
+	Username string `json:"username"`
+	Password string `json:"password"`
+	SSLMode  string `json:"sslmode"`
+
+	// Service names an entry of the connection service file whose
+	// parameters fill in the fields left unset above. Defaults to
+	// $PGSERVICE.
+	Service string `json:"service"`
+
+	// PassFile is the password file consulted when Password is empty.
+	// Defaults to the configuration's pass_file, $PGPASSFILE or ~/.pgpass.
+	PassFile string `json:"pass_file"`
+
+	// SSH tunnels connections to the cluster through a bastion host, for
+	// databases that are not directly reachable.
+	SSH *SSHConfig `json:"ssh"`
+
+	// Proxy dials connections to the cluster through a SOCKS5
+	// (socks5://host:port) or HTTP CONNECT (http://host:port) proxy.
+	// Defaults to the configuration's proxy.
+	Proxy string `json:"proxy"`
+
+	// Dialer opens the connections to the cluster when set, replacing the
+	// default TCP and Unix socket dialing. It is only available to programs
+	// using the package and defaults to the configuration's dialer.
+	Dialer Dialer `json:"-"`
+
+	// MaxConcurrency caps the number of databases of this cluster migrated
+	// at once. Zero means no limit.
+	MaxConcurrency int `json:"max_concurrency"`
+
+	// PgRepack is the pg_repack executable run for repack directives, which
+	// must match the extension version installed on the cluster. Defaults
+	// to the configuration's pg_repack.
+	PgRepack string `json:"pg_repack"`
+
+	// EchoSQL logs the statements of migrations and the notices of the
+	// server for this cluster's databases.
+	EchoSQL bool `json:"echo_sql"`
+
+	// Pool sizes the connection pools of this cluster's databases.
+	// Defaults to the configuration's pool.
+	Pool *PoolConfig `json:"pool"`
+}
+
+// defaultExcludedDatabases are system and maintenance databases created by
+// PostgreSQL itself and by managed database services.
+var defaultExcludedDatabases = []string{
+	"postgres",
+	"rdsadmin",
+	"azure_maintenance",
+	"azure_sys",
+	"cloudsqladmin",
+}
+
+// defaultConfiguration returns the configuration used when no config file is
+// given.
+func defaultConfiguration() Configuration {
+	return Configuration{
+		MigrationDir: "src/migration",
+	}
+}
+
+// LoadConfiguration reads the JSON configuration file at path on top of the
+// defaults. The file may hold // comments running to the end of a line. An
+// empty path returns the defaults unchanged.
+func LoadConfiguration(path string) (Configuration, error) {
+	config := defaultConfiguration()
+	if path == "" {
+		return config, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, err
+	}
+	data = stripComments(data)
+	data, err = decryptConfigValues(context.Background(), data)
+	if err != nil {
+		return config, fmt.Errorf("decrypt %s: %w", path, err)
+	}
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("parse %s: %w", path, err)
+	}
+	return config, config.validate()
+}
+
+// stripComments blanks the // comments of a JSON document outside its
+// strings, keeping line breaks so that offsets stay on the same line.
+func stripComments(data []byte) []byte {
+	out := make([]byte, 0, len(data))
+	inString, escaped, inComment := false, false, false
+	for i := 0; i < len(data); i++ {
+		c := data[i]
+		switch {
+		case inComment:
+			if c != '\n' {
+				continue
+			}
+			inComment = false
+		case inString:
+			switch {
+			case escaped:
+				escaped = false
+			case c == '\\':
+				escaped = true
+			case c == '"':
+				inString = false
+			}
+		case c == '"':
+			inString = true
+		case c == '/' && i+1 < len(data) && data[i+1] == '/':
+			inComment = true
+			continue
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+// validate checks settings that would otherwise only fail mid-run.
+func (c Configuration) validate() error {
+	if problems := c.problems(); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// problems returns every error of the settings validate checks.
+func (c Configuration) problems() []error {
+	var problems []error
+	for _, policy := range c.ErrorPolicies {
+		if err := policy.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("error_policies: %w", err))
+		}
+	}
+	if c.Pool != nil {
+		if err := c.Pool.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("pool: %w", err))
+		}
+	}
+	for _, cluster := range c.Clusters {
+		if cluster.Pool != nil {
+			if err := cluster.Pool.validate(); err != nil {
+				problems = append(problems, fmt.Errorf("cluster %s: pool: %w", cluster.Name, err))
+			}
+		}
+	}
+	if c.Retry != nil {
+		if err := c.Retry.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("retry: %w", err))
+		}
+	}
+	if c.Notifications != nil {
+		if err := c.Notifications.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("notifications: %w", err))
+		}
+	}
+	if c.Hooks != nil {
+		if err := c.Hooks.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("hooks: %w", err))
+		}
+	}
+	if c.Diagnostics != nil {
+		if err := c.Diagnostics.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("diagnostics: %w", err))
+		}
+	}
+	for i, hook := range c.MigrationHooks {
+		if err := hook.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("migration_hooks[%d]: %w", i, err))
+		}
+	}
+	sourceNames := make(map[string]bool)
+	for i, source := range c.Sources {
+		if err := source.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("sources[%d]: %w", i, err))
+		}
+		if sourceNames[source.Name] {
+			problems = append(problems, fmt.Errorf("sources[%d]: duplicate name %q", i, source.Name))
+		}
+		sourceNames[source.Name] = true
+	}
+	if c.MigrationGlob != "" {
+		if _, err := path.Match(strings.ReplaceAll(c.MigrationGlob, "**", "*"), ""); err != nil {
+			problems = append(problems, fmt.Errorf("migration_glob: invalid pattern %q: %w", c.MigrationGlob, err))
+		}
+	}
+	if c.HistoryExport != "" && c.HistoryExport != FormatGolangMigrate {
+		problems = append(problems, fmt.Errorf("history_export: unknown format %q", c.HistoryExport))
+	}
+	if err := c.Checksum.validate(); err != nil {
+		problems = append(problems, err)
+	}
+	if err := c.Lint.validate(); err != nil {
+		problems = append(problems, err)
+	}
+	if c.TargetVersion != "" {
+		if _, err := parseVersion(c.TargetVersion); err != nil {
+			problems = append(problems, fmt.Errorf("target_version: %w", err))
+		}
+	}
+	for i, extension := range c.Extensions {
+		if err := extension.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("extensions[%d]: %w", i, err))
+		}
+	}
+	if c.ConstraintValidation != nil {
+		if err := c.ConstraintValidation.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("constraint_validation: %w", err))
+		}
+	}
+	if c.VariablesQuery != nil {
+		if err := c.VariablesQuery.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("variables_query: %w", err))
+		}
+	}
+	if c.Provisioning != nil {
+		if err := c.Provisioning.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("provisioning: %w", err))
+		}
+	}
+	if c.Bootstrap != nil {
+		if err := c.Bootstrap.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("bootstrap: %w", err))
+		}
+	}
+	if c.TenantSchemas != nil {
+		if err := c.TenantSchemas.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("tenant_schemas: %w", err))
+		}
+	}
+	if c.Features != nil {
+		if err := c.Features.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("features: %w", err))
+		}
+	}
+	if err := validateOrderBy(c.OrderBy); err != nil {
+		problems = append(problems, fmt.Errorf("order_by: %w", err))
+	}
+	if c.DiscoveryCache != nil {
+		if err := c.DiscoveryCache.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("discovery_cache: %w", err))
+		}
+	}
+	if _, _, err := parseShard(c.Shard); err != nil {
+		problems = append(problems, fmt.Errorf("shard: %w", err))
+	}
+	for i, priority := range c.Priorities {
+		if err := priority.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("priorities[%d]: %w", i, err))
+		}
+	}
+	if c.Diagram != nil {
+		if err := c.Diagram.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("diagram: %w", err))
+		}
+	}
+	switch c.Sequences {
+	case "", "check", "fix":
+	default:
+		problems = append(problems, fmt.Errorf("sequences: expected check or fix, got %q", c.Sequences))
+	}
+	for i, view := range c.RefreshMaterializedViews {
+		if err := view.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("refresh_materialized_views[%d]: %w", i, err))
+		}
+	}
+	for i, partition := range c.Partitions {
+		if err := partition.validate(); err != nil {
+			problems = append(problems, fmt.Errorf("partitions[%d]: %w", i, err))
+		}
+	}
+	for _, pattern := range c.ExcludeDatabases {
+		if _, err := path.Match(pattern, ""); err != nil {
+			problems = append(problems, fmt.Errorf("exclude_databases: invalid pattern %q: %w", pattern, err))
+		}
+	}
+	for i, override := range c.Overrides {
+		if _, err := path.Match(override.Match, ""); err != nil {
+			problems = append(problems, fmt.Errorf("overrides[%d]: invalid match %q: %w", i, override.Match, err))
+		}
+		if override.TargetVersion != nil && *override.TargetVersion != "" {
+			if _, err := parseVersion(*override.TargetVersion); err != nil {
+				problems = append(problems, fmt.Errorf("overrides[%d]: target_version: %w", i, err))
+			}
+		}
+	}
+	return problems
+}
+
+// settingsFor returns the effective settings for the given database.
+func (c Configuration) settingsFor(target Target) DatabaseSettings {
+	settings := DatabaseSettings{
+		StatementTimeout: c.StatementTimeout,
+		LockTimeout:      c.LockTimeout,
+		NoTransaction:    c.NoTransaction,
+		Variables:        make(map[string]string),
+		TargetVersion:    c.TargetVersion,
+		DefinitionsDir:   c.DefinitionsDir,
+		Schema:           target.Schema,
+		ErrorPolicies:    c.ErrorPolicies,
+		MigrationHooks:   c.migrationHooks(),
+		FlywayHistory:    c.Flyway != nil && c.Flyway.WriteHistory,
+	}
+	for name, value := range c.Variables {
+		settings.Variables[name] = value
+	}
+
+	for _, override := range c.Overrides {
+		if !MatchDatabase(override.Match, target.Database) {
+			continue
+		}
+		if override.StatementTimeout != nil {
+			settings.StatementTimeout = *override.StatementTimeout
+		}
+		if override.LockTimeout != nil {
+			settings.LockTimeout = *override.LockTimeout
+		}
+		if override.NoTransaction != nil {
+			settings.NoTransaction = *override.NoTransaction
+		}
+		for name, value := range override.Variables {
+			settings.Variables[name] = value
+		}
+		if override.TargetVersion != nil {
+			settings.TargetVersion = *override.TargetVersion
+		}
+	}
+	return settings
+}
+
+// excluded reports whether the database must be skipped.
+func (c Configuration) excluded(dbName string) bool {
+	if strings.HasPrefix(dbName, scratchDatabasePrefix) {
+		return true
+	}
+	patterns := c.ExcludeDatabases
+	if patterns == nil {
+		patterns = defaultExcludedDatabases
+	}
+	for _, pattern := range patterns {
+		if MatchDatabase(pattern, dbName) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchDatabase reports whether the database name matches pattern, either
+// exactly or as a path.Match pattern.
+func MatchDatabase(pattern, dbName string) bool {
+	if pattern == dbName {
+		return true
+	}
+	matched, err := path.Match(pattern, dbName)
+	return err == nil && matched
+}
+
+// ResolveClusters returns the configured clusters with unset connection
+// parameters filled in from the global settings, the connection service file
+// and the standard libpq environment variables, in that order. When no
+// cluster is configured, a single one is built from those sources alone.
+func (c Configuration) ResolveClusters() ([]ClusterConfig, error) {
+	clusters := c.Clusters
+	if len(clusters) == 0 && c.Consul == nil {
+		clusters = []ClusterConfig{{Name: "default"}}
+	}
+
+	resolved := make([]ClusterConfig, len(clusters))
+	for i, cluster := range clusters {
+		cluster, err := c.resolveCluster(cluster)
+		if err != nil {
+			return nil, err
+		}
+		resolved[i] = cluster
+	}
+	return resolved, nil
+}
+
+// resolveCluster fills in the unset connection parameters of one cluster.
+func (c Configuration) resolveCluster(cluster ClusterConfig) (ClusterConfig, error) {
+	if cluster.Username == "" {
+		cluster.Username = c.DBUsername
+	}
+	if cluster.Password == "" {
+		cluster.Password = c.Password
+	}
+	if cluster.PassFile == "" {
+		cluster.PassFile = c.PassFile
+	}
+	if cluster.PgRepack == "" {
+		cluster.PgRepack = c.PgRepack
+	}
+	cluster.EchoSQL = cluster.EchoSQL || c.EchoSQL
+	if cluster.Pool == nil {
+		cluster.Pool = c.Pool
+	}
+	if cluster.Dialer == nil && cluster.SSH == nil && cluster.Proxy == "" {
+		cluster.Proxy = c.Proxy
+		if cluster.Proxy == "" {
+			cluster.Dialer = c.Dialer
+		}
+	}
+	cluster, err := applyLibpqDefaults(cluster)
+	if err != nil {
+		return cluster, fmt.Errorf("cluster %s: %w", cluster.address(), err)
+	}
+	if cluster.Name == "" && cluster.SRV != "" {
+		cluster.Name = cluster.SRV
+	}
+	if cluster.Name == "" {
+		cluster.Name = cluster.address()
+	}
+	return cluster, nil
+}
+
+// address returns the host:port of the cluster, as used for per-host limits
+// and display.
+func (c ClusterConfig) address() string {
+	host := c.Host
+	if host == "" {
+		host = "localhost"
+	}
+	if c.Port == 0 {
+		return host
+	}
+	return fmt.Sprintf("%s:%d", host, c.Port)
+}
+
+// hostName returns the host the cluster runs on.
+func (c ClusterConfig) hostName() string {
+	if c.Host == "" {
+		return "localhost"
+	}
+	return c.Host
+}
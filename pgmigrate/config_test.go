@@ -0,0 +1,26 @@
+package pgmigrate
+
+import "testing"
+
+func TestExcluded(t *testing.T) {
+	scratch := scratchDatabasePrefix + "rehearse_1718000000000000000"
+	for _, test := range []struct {
+		name     string
+		exclude  []string
+		database string
+		want     bool
+	}{
+		{name: "default system database", database: "postgres", want: true},
+		{name: "default tenant", database: "tenant_a"},
+		{name: "default scratch database", database: scratch, want: true},
+		{name: "configured pattern", exclude: []string{"tenant_*"}, database: "tenant_a", want: true},
+		{name: "configured without defaults", exclude: []string{"tenant_*"}, database: "postgres"},
+		{name: "configured scratch database", exclude: []string{"tenant_*"}, database: scratch, want: true},
+		{name: "empty list scratch database", exclude: []string{}, database: scratch, want: true},
+	} {
+		config := Configuration{ExcludeDatabases: test.exclude}
+		if got := config.excluded(test.database); got != test.want {
+			t.Errorf("%s: excluded(%q) = %v, want %v", test.name, test.database, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+package pgmigrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// CheckConfiguration reads the configuration file at path and returns every
+// problem found in it, rather than the first one as LoadConfiguration does:
+// keys the tool does not know, invalid settings, options that conflict or
+// have no effect together, files and programs that cannot be found, and
+// credentials references that cannot be resolved. Nothing is connected to.
+func CheckConfiguration(path string) []error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return []error{err}
+	}
+	data = stripComments(data)
+	var problems []error
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return []error{fmt.Errorf("parse %s: %w", path, err)}
+	}
+	for _, key := range unknownKeys(doc, reflect.TypeOf(Configuration{}), "") {
+		problems = append(problems, fmt.Errorf("%s: unknown key", key))
+	}
+
+	if decrypted, err := decryptConfigValues(context.Background(), data); err != nil {
+		problems = append(problems, fmt.Errorf("decrypt: %w", err))
+	} else {
+		data = decrypted
+	}
+	config := defaultConfiguration()
+	if err := json.Unmarshal(data, &config); err != nil {
+		return append(problems, fmt.Errorf("parse %s: %w", path, err))
+	}
+	problems = append(problems, config.problems()...)
+	problems = append(problems, config.conflicts()...)
+	problems = append(problems, config.missingPaths()...)
+	problems = append(problems, config.unresolvableCredentials()...)
+	return problems
+}
+
+// unknownKeys returns the keys of a decoded JSON document that do not
+// correspond to a field of t, matching them as encoding/json does.
+func unknownKeys(node interface{}, t reflect.Type, path string) []string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var unknown []string
+	switch node := node.(type) {
+	case map[string]interface{}:
+		switch t.Kind() {
+		case reflect.Map:
+			for key, value := range node {
+				unknown = append(unknown, unknownKeys(value, t.Elem(), joinPath(path, key))...)
+			}
+		case reflect.Struct:
+			for key, value := range node {
+				field, ok := jsonField(t, key)
+				if !ok {
+					unknown = append(unknown, joinPath(path, key))
+					continue
+				}
+				unknown = append(unknown, unknownKeys(value, field.Type, joinPath(path, key))...)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, value := range node {
+				unknown = append(unknown, unknownKeys(value, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	sort.Strings(unknown)
+	return unknown
+}
+
+// jsonField returns the field of struct type t a JSON key decodes into.
+func jsonField(t reflect.Type, key string) (reflect.StructField, bool) {
+	var folded *reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		if name == key {
+			return field, true
+		}
+		if folded == nil && strings.EqualFold(name, key) {
+			folded = &field
+		}
+	}
+	if folded != nil {
+		return *folded, true
+	}
+	return reflect.StructField{}, false
+}
+
+// conflicts returns the options that contradict each other or are ignored
+// because of another.
+func (c Configuration) conflicts() []error {
+	var problems []error
+	if len(c.Sources) > 0 {
+		if c.MigrationGlob != "" {
+			problems = append(problems, errors.New("migration_glob: ignored when sources are set; set glob on each source instead"))
+		}
+		if c.Flyway != nil {
+			problems = append(problems, errors.New("flyway: cannot be combined with sources"))
+		}
+	}
+	if c.Flyway != nil && c.MigrationGlob != "" {
+		problems = append(problems, errors.New("migration_glob: ignored with flyway naming"))
+	}
+	if c.PriorityTiers && len(c.Priorities) == 0 {
+		problems = append(problems, errors.New("priority_tiers: has no effect without priorities"))
+	}
+	for _, cluster := range c.Clusters {
+		if cluster.SSH != nil && cluster.Proxy != "" {
+			problems = append(problems, fmt.Errorf("cluster %s: ssh and proxy are mutually exclusive", clusterLabel(cluster)))
+		}
+		if cluster.SRV != "" && cluster.Host != "" {
+			problems = append(problems, fmt.Errorf("cluster %s: host is replaced by the target of srv", clusterLabel(cluster)))
+		}
+	}
+	if c.SkipSyntaxCheck && (len(c.Lint.Rules) > 0 || c.Lint.Baseline != "") {
+		problems = append(problems, errors.New("lint: has no effect with skip_syntax_check"))
+	}
+	return problems
+}
+
+// missingPaths returns the files, directories and programs of the
+// configuration that do not exist. Relative paths are resolved against the
+// working directory, as they are when the tool runs.
+func (c Configuration) missingPaths() []error {
+	var problems []error
+	check := func(key, path string, dir bool) {
+		if path == "" || strings.Contains(path, "://") {
+			return
+		}
+		info, err := os.Stat(path)
+		switch {
+		case err != nil:
+			problems = append(problems, fmt.Errorf("%s: %w", key, err))
+		case dir && !info.IsDir() && !isArchive(path):
+			problems = append(problems, fmt.Errorf("%s: %s is not a directory", key, path))
+		case !dir && info.IsDir():
+			problems = append(problems, fmt.Errorf("%s: %s is a directory", key, path))
+		}
+	}
+	program := func(key, name, fallback string) {
+		if name == "" {
+			name = fallback
+		}
+		if _, err := exec.LookPath(name); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", key, err))
+		}
+	}
+
+	if len(c.Sources) == 0 && c.Source == nil {
+		check("migration_dir", c.MigrationDir, true)
+	}
+	for i, source := range c.Sources {
+		check(fmt.Sprintf("sources[%d].dir", i), source.Dir, true)
+	}
+	check("definitions_dir", c.DefinitionsDir, true)
+	check("access_file", c.AccessFile, false)
+	check("pass_file", c.PassFile, false)
+	check("age_identity_file", c.AgeIdentityFile, false)
+	if c.Signatures != nil {
+		check("signatures.keyring", c.Signatures.Keyring, false)
+	}
+	if c.Bootstrap != nil {
+		check("bootstrap.snapshot", c.Bootstrap.Snapshot, false)
+	}
+	for _, cluster := range c.Clusters {
+		key := "cluster " + clusterLabel(cluster)
+		check(key+": pass_file", cluster.PassFile, false)
+		if cluster.SSH != nil {
+			check(key+": ssh.key_file", cluster.SSH.KeyFile, false)
+			check(key+": ssh.known_hosts_file", cluster.SSH.KnownHostsFile, false)
+		}
+		if cluster.PgRepack != "" {
+			program(key+": pg_repack", cluster.PgRepack, "")
+		}
+	}
+	if c.PgRepack != "" {
+		program("pg_repack", c.PgRepack, "")
+	}
+	if c.Provisioning != nil {
+		check("provisioning.seed_dir", c.Provisioning.SeedDir, true)
+		if c.Provisioning.BackupDir != "" {
+			program("pg_dump", c.PgDump, "pg_dump")
+		}
+	}
+	return problems
+}
+
+// unresolvableCredentials returns the credentials references that cannot
+// be resolved: malformed references to a secret store, and values that
+// look like a reference to a store the tool does not know.
+func (c Configuration) unresolvableCredentials() []error {
+	var problems []error
+	check := func(key, value string) {
+		if value == "" {
+			return
+		}
+		if _, ok, err := parseSecretRef(value); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", key, err))
+		} else if scheme, _, found := strings.Cut(value, "://"); !ok && found && !strings.ContainsAny(scheme, " /:@") {
+			problems = append(problems, fmt.Errorf("%s: unknown secret store %q", key, scheme))
+		}
+	}
+	check("password", c.Password)
+	for _, cluster := range c.Clusters {
+		key := "cluster " + clusterLabel(cluster)
+		check(key+": username", cluster.Username)
+		check(key+": password", cluster.Password)
+	}
+	if c.Notifications != nil && c.Notifications.CommitStatus != nil {
+		check("notifications.commit_status.token", c.Notifications.CommitStatus.Token)
+	}
+	return problems
+}
+
+// clusterLabel names a cluster in problems, by name when it has one.
+func clusterLabel(cluster ClusterConfig) string {
+	if cluster.Name != "" {
+		return cluster.Name
+	}
+	return cluster.address()
+}
@@ -0,0 +1,175 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// connectionString builds the libpq connection string for a database on the
+// given cluster, resolving secret references in the credentials. An empty
+// dbName connects to the user's default database.
+func connectionString(ctx context.Context, cluster ClusterConfig, dbName string) (string, error) {
+	username, err := resolveSecret(ctx, cluster.Username)
+	if err != nil {
+		return "", err
+	}
+	cluster.Username = username
+
+	var params []string
+	if cluster.Username != "" {
+		params = append(params, "user="+quoteConnValue(cluster.Username))
+	}
+	if cluster.Host != "" {
+		params = append(params, "host="+quoteConnValue(cluster.Host))
+	}
+	if cluster.Port != 0 {
+		params = append(params, fmt.Sprintf("port=%d", cluster.Port))
+	}
+	if dbName != "" {
+		params = append(params, "dbname="+quoteConnValue(dbName))
+	}
+	password, ok, err := cluster.password(ctx, dbName)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		params = append(params, "password="+quoteConnValue(password))
+	}
+	params = append(params, "sslmode="+quoteConnValue(sslMode(cluster)))
+	return strings.Join(params, " "), nil
+}
+
+// sslMode returns the SSL mode used to connect to the cluster.
+func sslMode(cluster ClusterConfig) string {
+	if cluster.SSLMode == "" {
+		return "disable"
+	}
+	return cluster.SSLMode
+}
+
+// quoteConnValue quotes a connection string value so that spaces, quotes and
+// backslashes survive libpq parsing.
+func quoteConnValue(value string) string {
+	if value != "" && !strings.ContainsAny(value, " '\\") {
+		return value
+	}
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// fetchDatabases fetches the list of databases from PostgreSQL, in batches
+// of discoveryBatch ordered by oid so that servers with tens of thousands of
+// databases are listed with short queries.
+func fetchDatabases(ctx context.Context, cluster ClusterConfig) ([]string, error) {
+	db, err := connectToDatabase(ctx, cluster, "")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var databases []string
+	var after int64
+	for {
+		n := 0
+		rows, err := db.QueryContext(ctx, `SELECT oid::bigint, datname FROM pg_database
+			WHERE datistemplate = false AND oid::bigint > $1 ORDER BY oid LIMIT $2`, after, discoveryBatch)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var dbName string
+			if err := rows.Scan(&after, &dbName); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			databases = append(databases, dbName)
+			n++
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+		if n < discoveryBatch {
+			return databases, nil
+		}
+	}
+}
+
+// fetchDatabaseSizes fetches the size in bytes of every database of the
+// cluster, keyed by name.
+func fetchDatabaseSizes(ctx context.Context, cluster ClusterConfig) (map[string]int64, error) {
+	db, err := connectToDatabase(ctx, cluster, "")
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// Databases the user may not connect to have no measurable size.
+	rows, err := db.QueryContext(ctx, `SELECT datname, pg_database_size(oid) FROM pg_database
+		WHERE datistemplate = false AND has_database_privilege(oid, 'CONNECT')`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sizes := make(map[string]int64)
+	for rows.Next() {
+		var dbName string
+		var size int64
+		if err := rows.Scan(&dbName, &size); err != nil {
+			return nil, err
+		}
+		sizes[dbName] = size
+	}
+	return sizes, rows.Err()
+}
+
+// connectToDatabase connects to the specified database. When the server
+// rejects credentials that come from a secret store, the secrets are fetched
+// again once in case they were rotated.
+func connectToDatabase(ctx context.Context, cluster ClusterConfig, dbName string) (*sql.DB, error) {
+	db, err := openDatabase(ctx, cluster, dbName)
+	if isAuthFailure(err) && invalidateSecrets(cluster.Username, cluster.Password) {
+		db, err = openDatabase(ctx, cluster, dbName)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrConnectionFailed, err)
+	}
+	return db, nil
+}
+
+// openDatabase opens and pings a connection pool for the database.
+func openDatabase(ctx context.Context, cluster ClusterConfig, dbName string) (*sql.DB, error) {
+	connStr, err := connectionString(ctx, cluster, dbName)
+	if err != nil {
+		return nil, err
+	}
+	connector, err := pq.NewConnector(connStr)
+	if err != nil {
+		return nil, err
+	}
+	dialer, err := clusterDialer(cluster)
+	if err != nil {
+		return nil, fmt.Errorf("cluster %s: %w", cluster.Name, err)
+	}
+	if dialer != nil {
+		connector.Dialer(dialer)
+	}
+	var c driver.Connector = connector
+	if cluster.EchoSQL {
+		c = pq.ConnectorWithNoticeHandler(connector, logNotices(cluster.Name, dbName))
+	}
+	db := sql.OpenDB(c)
+	cluster.Pool.apply(db)
+	if err := db.PingContext(ctx); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,205 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// ConstraintValidationConfig schedules the validation of constraints that
+// migrations add as NOT VALID. Validation scans the table but, unlike adding
+// a validated constraint, does not block writes, so it is run as its own
+// phase at low concurrency, optionally only off-peak.
+type ConstraintValidationConfig struct {
+	// AfterMigrate validates constraints once every database has been
+	// migrated. Otherwise they are only validated by the
+	// "validate-constraints" command.
+	AfterMigrate bool `json:"after_migrate"`
+
+	// Window restricts validation to a daily time range such as
+	// "22:00-06:00". Constraints left when it closes are validated on the
+	// next run.
+	Window string `json:"window"`
+
+	// TimeZone is the IANA time zone of Window. Defaults to UTC.
+	TimeZone string `json:"time_zone"`
+
+	// Pause is waited between two validations on a database, e.g. "30s".
+	Pause string `json:"pause"`
+
+	// Concurrency is the number of databases validated at once. Defaults
+	// to 1.
+	Concurrency int `json:"concurrency"`
+
+	// Exclude lists names or patterns of constraints left NOT VALID on
+	// purpose.
+	Exclude []string `json:"exclude"`
+}
+
+// validationWindow is a parsed daily time range.
+type validationWindow struct {
+	start, end time.Duration // since midnight
+	location   *time.Location
+}
+
+func (c *ConstraintValidationConfig) validate() error {
+	if _, err := c.window(); err != nil {
+		return err
+	}
+	if c.Pause != "" {
+		if _, err := time.ParseDuration(c.Pause); err != nil {
+			return fmt.Errorf("pause: %w", err)
+		}
+	}
+	return nil
+}
+
+// window parses Window, returning nil when validation may run at any time.
+func (c *ConstraintValidationConfig) window() (*validationWindow, error) {
+	if c.Window == "" {
+		return nil, nil
+	}
+	var startHour, startMinute, endHour, endMinute int
+	if _, err := fmt.Sscanf(c.Window, "%d:%d-%d:%d", &startHour, &startMinute, &endHour, &endMinute); err != nil {
+		return nil, fmt.Errorf("window: expected HH:MM-HH:MM, got %q", c.Window)
+	}
+	location := time.UTC
+	if c.TimeZone != "" {
+		var err error
+		if location, err = time.LoadLocation(c.TimeZone); err != nil {
+			return nil, fmt.Errorf("time_zone: %w", err)
+		}
+	}
+	return &validationWindow{
+		start:    time.Duration(startHour)*time.Hour + time.Duration(startMinute)*time.Minute,
+		end:      time.Duration(endHour)*time.Hour + time.Duration(endMinute)*time.Minute,
+		location: location,
+	}, nil
+}
+
+// open reports whether t falls within the window, which may span midnight.
+func (w *validationWindow) open(t time.Time) bool {
+	if w == nil {
+		return true
+	}
+	t = t.In(w.location)
+	now := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	if w.start <= w.end {
+		return now >= w.start && now < w.end
+	}
+	return now >= w.start || now < w.end
+}
+
+// ValidateConstraints validates the NOT VALID constraints of each database,
+// one at a time, while the configured window is open. Each result lists the
+// constraints validated; those left for a later run are noted last.
+func (m *Migrator) ValidateConstraints(ctx context.Context, targets []Target) []MaintenanceResult {
+	config := m.config.ConstraintValidation
+	if config == nil {
+		config = &ConstraintValidationConfig{}
+	}
+	window, _ := config.window()
+	var pause time.Duration
+	if config.Pause != "" {
+		pause, _ = time.ParseDuration(config.Pause)
+	}
+
+	// Validation runs at its own, lower concurrency.
+	budgetConfig := m.config
+	budgetConfig.MaxConcurrency = config.Concurrency
+	if budgetConfig.MaxConcurrency == 0 {
+		budgetConfig.MaxConcurrency = 1
+	}
+
+	return forEachTarget(ctx, budgetConfig, targets, func(ctx context.Context, target Target) MaintenanceResult {
+		result := MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database}
+		db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		defer db.Close()
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		defer conn.Close()
+
+		if err := lockDatabase(ctx, conn); err != nil {
+			result.Error = err
+			return result
+		}
+		defer unlockDatabase(conn)
+
+		constraints, err := invalidConstraints(ctx, conn, config.Exclude)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		for i, constraint := range constraints {
+			if !window.open(time.Now()) {
+				result.Changes = append(result.Changes, fmt.Sprintf("%d left for the next window", len(constraints)-i))
+				break
+			}
+			if i > 0 && pause > 0 {
+				select {
+				case <-ctx.Done():
+					result.Error = ctx.Err()
+					return result
+				case <-time.After(pause):
+				}
+			}
+			if _, err := conn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s VALIDATE CONSTRAINT %s", constraint.table, constraint.name)); err != nil {
+				result.Error = fmt.Errorf("validate %s on %s: %w", constraint.name, constraint.table, err)
+				return result
+			}
+			result.Changes = append(result.Changes, "validated "+constraint.name+" on "+constraint.table)
+		}
+		return result
+	}, func(target Target, err error) MaintenanceResult {
+		return MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database, Error: err}
+	})
+}
+
+type invalidConstraint struct {
+	table string // quoted and qualified as needed
+	name  string // quoted
+	raw   string
+}
+
+// invalidConstraints lists the constraints not yet validated, apart from
+// the excluded ones.
+func invalidConstraints(ctx context.Context, conn queryExecer, exclude []string) ([]invalidConstraint, error) {
+	rows, err := conn.QueryContext(ctx, `
+		SELECT c.conrelid::regclass::text, quote_ident(c.conname), c.conname
+		FROM pg_constraint c
+		WHERE NOT c.convalidated AND c.contype IN ('c', 'f') AND c.conrelid <> 0
+		ORDER BY 1, 2`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var constraints []invalidConstraint
+	for rows.Next() {
+		var c invalidConstraint
+		if err := rows.Scan(&c.table, &c.name, &c.raw); err != nil {
+			return nil, err
+		}
+		if matchesAny(exclude, c.raw) {
+			continue
+		}
+		constraints = append(constraints, c)
+	}
+	return constraints, rows.Err()
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if MatchDatabase(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
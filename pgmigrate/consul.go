@@ -0,0 +1,173 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// ConsulDiscovery finds clusters by querying the Consul health API for the
+// healthy instances of a service.
+type ConsulDiscovery struct {
+	// Address of the Consul agent. Defaults to $CONSUL_HTTP_ADDR, then
+	// http://127.0.0.1:8500.
+	Address string `json:"address"`
+	// Token is the ACL token. Defaults to $CONSUL_HTTP_TOKEN.
+	Token      string `json:"token"`
+	Datacenter string `json:"datacenter"`
+
+	Service string `json:"service"`
+	// Tags restricts discovery to instances carrying all of the tags, such
+	// as "primary".
+	Tags []string `json:"tags"`
+
+	// Cluster holds the settings shared by every discovered cluster, such
+	// as credentials and concurrency limits. Host, port and name are taken
+	// from the catalog.
+	Cluster ClusterConfig `json:"cluster"`
+}
+
+// consulServiceEntry is the subset of a /v1/health/service entry used here.
+type consulServiceEntry struct {
+	Node struct {
+		Node    string
+		Address string
+	}
+	Service struct {
+		ID      string
+		Address string
+		Port    int
+		Tags    []string
+	}
+}
+
+// discoverConsulClusters returns one cluster per healthy instance of the
+// configured service.
+func discoverConsulClusters(ctx context.Context, config Configuration) ([]ClusterConfig, error) {
+	consul := config.Consul
+	if consul == nil {
+		return nil, nil
+	}
+	if consul.Service == "" {
+		return nil, fmt.Errorf("consul: service is required")
+	}
+
+	query := url.Values{"passing": {"true"}}
+	for _, tag := range consul.Tags {
+		query.Add("tag", tag)
+	}
+	if consul.Datacenter != "" {
+		query.Set("dc", consul.Datacenter)
+	}
+	var entries []consulServiceEntry
+	client := newConsulClient(consul.Address, consul.Token)
+	if err := client.do(ctx, http.MethodGet, "/v1/health/service/"+url.PathEscape(consul.Service), query, nil, &entries); err != nil {
+		return nil, err
+	}
+
+	var clusters []ClusterConfig
+	for _, entry := range entries {
+		if !hasAllTags(entry.Service.Tags, consul.Tags) {
+			continue
+		}
+		cluster := consul.Cluster
+		cluster.Name = consul.Service + "/" + entry.Service.ID
+		cluster.Host = entry.Service.Address
+		if cluster.Host == "" {
+			cluster.Host = entry.Node.Address
+		}
+		cluster.Port = entry.Service.Port
+
+		cluster, err := config.resolveCluster(cluster)
+		if err != nil {
+			return nil, err
+		}
+		clusters = append(clusters, cluster)
+	}
+	return clusters, nil
+}
+
+func hasAllTags(tags, required []string) bool {
+	have := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		have[tag] = true
+	}
+	for _, tag := range required {
+		if !have[tag] {
+			return false
+		}
+	}
+	return true
+}
+
+// consulClient is a minimal client for the Consul HTTP API.
+type consulClient struct {
+	address string
+	token   string
+}
+
+// newConsulClient returns a client for the agent at address, defaulting the
+// address and token to $CONSUL_HTTP_ADDR and $CONSUL_HTTP_TOKEN.
+func newConsulClient(address, token string) *consulClient {
+	if address == "" {
+		address = os.Getenv("CONSUL_HTTP_ADDR")
+	}
+	if address == "" {
+		address = "http://127.0.0.1:8500"
+	}
+	if !strings.Contains(address, "://") {
+		address = "http://" + address
+	}
+	if token == "" {
+		token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	return &consulClient{address: strings.TrimSuffix(address, "/"), token: token}
+}
+
+// do sends a request with an optional JSON body and decodes the JSON
+// response into out when it is non-nil.
+func (c *consulClient) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	endpoint := c.address + path
+	if len(query) > 0 {
+		endpoint += "?" + query.Encode()
+	}
+
+	var reqBody io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reqBody = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, endpoint, reqBody)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("X-Consul-Token", c.token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("consul: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("consul: %s %s: %s %s", method, path, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("consul: %w", err)
+	}
+	return nil
+}
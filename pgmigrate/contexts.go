@@ -0,0 +1,62 @@
+package pgmigrate
+
+import (
+	"errors"
+	"strings"
+)
+
+// contextDirective restricts a migration to some environments, e.g.
+//
+//	-- pgmigrate:context prod
+//	-- pgmigrate:context !dev,!test
+//
+// The migration applies when the configured context is one of those listed,
+// or, for a context negated with "!", is not that one. Migrations without a
+// context directive apply in every context. Migrations not applied stay
+// pending.
+const contextDirective = "-- pgmigrate:context"
+
+// scriptContexts returns the contexts of the context directives of a script.
+func scriptContexts(script string) ([]string, error) {
+	var contexts []string
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, contextDirective) {
+			continue
+		}
+		names := splitList(strings.TrimPrefix(line, contextDirective))
+		if len(names) == 0 {
+			return nil, errors.New("context: context is required")
+		}
+		for _, name := range names {
+			if strings.TrimPrefix(name, "!") == "" {
+				return nil, errors.New("context: empty negated context")
+			}
+		}
+		contexts = append(contexts, names...)
+	}
+	return contexts, nil
+}
+
+// contextSelected reports whether a migration restricted to contexts
+// applies in the configured context. Listed contexts match when any of them
+// is the configured one; negated ones rule the configured one out.
+func (c Configuration) contextSelected(contexts []string) bool {
+	if len(contexts) == 0 {
+		return true
+	}
+	listed, matched := false, false
+	for _, name := range contexts {
+		if negated := strings.TrimPrefix(name, "!"); negated != name {
+			if negated == c.Context {
+				return false
+			}
+			continue
+		}
+		listed = true
+		if name == c.Context {
+			matched = true
+		}
+	}
+	return !listed || matched
+}
@@ -0,0 +1,70 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// DatadogConfig sends an event per run to Datadog and the run's metrics to
+// a DogStatsD agent, for teams that dashboard and alert on Datadog.
+type DatadogConfig struct {
+	// APIKey enables events. It may be a secret reference.
+	APIKey string `json:"api_key"`
+
+	// Site is the Datadog site receiving events. Defaults to
+	// datadoghq.com.
+	Site string `json:"site"`
+
+	// StatsD is the DogStatsD agent address receiving metrics. Defaults to
+	// 127.0.0.1:8125.
+	StatsD string `json:"statsd"`
+
+	// Tags are added to every event and metric, e.g. env:prod.
+	Tags []string `json:"tags"`
+}
+
+// sendDatadog sends the event and metrics of a run.
+func sendDatadog(ctx context.Context, config *DatadogConfig, summary RunSummary) error {
+	address := config.StatsD
+	if address == "" {
+		address = "127.0.0.1:8125"
+	}
+	client, err := dialStatsD(address, "pgmigrate", config.Tags, true)
+	if err != nil {
+		return fmt.Errorf("dogstatsd: %w", err)
+	}
+	sendRunMetrics(client, summary)
+	client.Close()
+
+	if config.APIKey == "" {
+		return nil
+	}
+	key, err := resolveSecret(ctx, config.APIKey)
+	if err != nil {
+		return err
+	}
+	site := config.Site
+	if site == "" {
+		site = "datadoghq.com"
+	}
+	alertType, title := "success", fmt.Sprintf("Migration run %s: %d databases migrated", summary.ID, summary.Succeeded)
+	text := ""
+	if summary.Failed > 0 {
+		alertType = "error"
+		title = fmt.Sprintf("Migration run %s: %d of %d databases failed", summary.ID, summary.Failed, summary.Failed+summary.Succeeded)
+		var lines []string
+		for _, failure := range summary.Failures() {
+			lines = append(lines, fmt.Sprintf("%s/%s: %s", failure.Cluster, failure.Database, failure.Error))
+		}
+		text = strings.Join(lines, "\n")
+	}
+	return postJSON(ctx, "https://api."+site+"/api/v1/events", map[string]string{"DD-API-KEY": key}, map[string]interface{}{
+		"title":            title,
+		"text":             text,
+		"alert_type":       alertType,
+		"aggregation_key":  "pgmigrate-" + summary.ID,
+		"source_type_name": "pgmigrate",
+		"tags":             append([]string{"run_id:" + summary.ID}, config.Tags...),
+	})
+}
@@ -0,0 +1,191 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/lib/pq"
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// queryExecer is implemented by *sql.Conn and *sql.Tx.
+type queryExecer interface {
+	execer
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// dependent is a view, materialized view or function depending on a table.
+type dependent struct {
+	schema   string
+	name     string
+	kind     string // VIEW, MATERIALIZED VIEW or FUNCTION
+	identity string // quoted name, with the argument types of functions
+	depth    int
+}
+
+// dependentsQuery lists the views depending on the tables in $1, directly
+// or through other views, and the functions with SQL-standard bodies using
+// them, deepest dependents first.
+const dependentsQuery = `
+WITH RECURSIVE tables AS (
+	SELECT to_regclass(t) AS oid FROM unnest($1::text[]) AS t
+), views (oid, depth) AS (
+	SELECT r.ev_class, 1
+	FROM pg_depend d
+	JOIN pg_rewrite r ON r.oid = d.objid
+	WHERE d.classid = 'pg_rewrite'::regclass
+		AND d.refclassid = 'pg_class'::regclass
+		AND d.refobjid IN (SELECT oid FROM tables)
+		AND r.ev_class <> d.refobjid
+	UNION
+	SELECT r.ev_class, views.depth + 1
+	FROM views
+	JOIN pg_depend d ON d.refclassid = 'pg_class'::regclass AND d.refobjid = views.oid
+	JOIN pg_rewrite r ON r.oid = d.objid AND d.classid = 'pg_rewrite'::regclass
+	WHERE r.ev_class <> views.oid
+)
+SELECT n.nspname, c.relname,
+	CASE c.relkind WHEN 'm' THEN 'MATERIALIZED VIEW' ELSE 'VIEW' END,
+	format('%I.%I', n.nspname, c.relname), max(views.depth)
+FROM views
+JOIN pg_class c ON c.oid = views.oid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+GROUP BY 1, 2, 3, 4
+UNION ALL
+SELECT DISTINCT n.nspname, p.proname, 'FUNCTION', p.oid::regprocedure::text, 1
+FROM pg_depend d
+JOIN pg_proc p ON p.oid = d.objid
+JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE d.classid = 'pg_proc'::regclass
+	AND d.refclassid = 'pg_class'::regclass
+	AND d.refobjid IN (SELECT oid FROM tables)
+ORDER BY 5 DESC`
+
+// executeWithDependents executes the statements of a migration. When a
+// definitions directory is configured, the views and functions depending
+// on columns the script drops or changes the type of are dropped first and
+// recreated from their managed definitions afterwards, then queried to
+// verify they still compile against the new columns. Parallel groups run on
+// sessions opened with open, if given.
+func executeWithDependents(ctx context.Context, conn queryExecer, script string, statements []Statement, definitionsDir string, open sessionOpener) error {
+	if definitionsDir == "" {
+		return executeStatementGroups(ctx, conn, statements, open)
+	}
+	tables := alteredTables(script)
+	if len(tables) == 0 {
+		return executeStatementGroups(ctx, conn, statements, open)
+	}
+
+	dependents, err := findDependents(ctx, conn, tables)
+	if err != nil {
+		return fmt.Errorf("find dependent views and functions: %w", err)
+	}
+	paths := make([]string, len(dependents))
+	definitions := make(map[string]string)
+	for i, dep := range dependents {
+		paths[i], err = definitionPath(definitionsDir, dep)
+		if err != nil {
+			return err
+		}
+		if _, ok := definitions[paths[i]]; !ok {
+			data, err := os.ReadFile(paths[i])
+			if err != nil {
+				return err
+			}
+			definitions[paths[i]] = string(data)
+		}
+	}
+
+	for _, dep := range dependents {
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("DROP %s %s", dep.kind, dep.identity)); err != nil {
+			return fmt.Errorf("drop %s %s: %w", dep.kind, dep.identity, err)
+		}
+	}
+	if err := executeStatementGroups(ctx, conn, statements, open); err != nil {
+		return err
+	}
+	recreated := make(map[string]bool)
+	for i := len(dependents) - 1; i >= 0; i-- {
+		dep := dependents[i]
+		if !recreated[paths[i]] {
+			// The definition is sent in one piece rather than split, so
+			// that the body of a function, such as a BEGIN ATOMIC one,
+			// reaches the server whole.
+			if _, err := conn.ExecContext(ctx, definitions[paths[i]]); err != nil {
+				return fmt.Errorf("recreate %s %s: %w", dep.kind, dep.identity, err)
+			}
+			recreated[paths[i]] = true
+		}
+		if dep.kind != "FUNCTION" {
+			if _, err := conn.ExecContext(ctx, fmt.Sprintf("SELECT * FROM %s LIMIT 0", dep.identity)); err != nil {
+				return fmt.Errorf("verify %s %s: %w", dep.kind, dep.identity, err)
+			}
+		}
+	}
+	return nil
+}
+
+// alteredTables returns the tables, as named in the script, of which the
+// script drops columns or changes their type.
+func alteredTables(script string) []string {
+	tree, err := parseSQL(script)
+	if err != nil {
+		return nil
+	}
+	var tables []string
+	for _, raw := range tree.Stmts {
+		alter := raw.Stmt.GetAlterTableStmt()
+		if alter == nil || alter.Relation == nil {
+			continue
+		}
+		for _, cmd := range alter.Cmds {
+			switch cmd.GetAlterTableCmd().GetSubtype() {
+			case pg_query.AlterTableType_AT_DropColumn, pg_query.AlterTableType_AT_AlterColumnType:
+				tables = append(tables, quoteRelation(alter.Relation))
+			}
+		}
+	}
+	return tables
+}
+
+func findDependents(ctx context.Context, conn queryExecer, tables []string) ([]dependent, error) {
+	rows, err := conn.QueryContext(ctx, dependentsQuery, pq.Array(tables))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var dependents []dependent
+	for rows.Next() {
+		var dep dependent
+		if err := rows.Scan(&dep.schema, &dep.name, &dep.kind, &dep.identity, &dep.depth); err != nil {
+			return nil, err
+		}
+		dependents = append(dependents, dep)
+	}
+	return dependents, rows.Err()
+}
+
+// definitionPath returns the file holding the managed definition of a
+// dependent, named <schema>.<name>.sql, or <name>.sql for objects in the
+// public schema. A function's file recreates all of its overloads, and any
+// file may also restore grants and comments.
+func definitionPath(dir string, dep dependent) (string, error) {
+	names := []string{dep.schema + "." + dep.name + ".sql"}
+	if dep.schema == "public" {
+		names = append(names, dep.name+".sql")
+	}
+	for _, name := range names {
+		path := filepath.Join(dir, name)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%s %s depends on an altered table but has no definition %s in %s", dep.kind, dep.identity, names[0], dir)
+}
@@ -0,0 +1,141 @@
+package pgmigrate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dependsOnDirective declares the migrations a migration depends on, e.g.
+//
+//	-- pgmigrate:depends-on 0007, 0009
+//
+// A migration with dependencies runs once they have been applied rather
+// than after every migration of a lower version, so migrations written on
+// parallel branches need not be renumbered to apply in a working order. It
+// is skipped while any of its dependencies is pending. Migrations without
+// the directive keep running in version order among themselves, each after
+// the one before it, so a migration may depend on one of a higher version.
+const dependsOnDirective = "-- pgmigrate:depends-on"
+
+// scriptDependencies returns the versions of the depends-on directives of a
+// script.
+func scriptDependencies(script string) ([]string, error) {
+	var versions []string
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, dependsOnDirective) {
+			continue
+		}
+		list := splitList(strings.TrimPrefix(line, dependsOnDirective))
+		if len(list) == 0 {
+			return nil, errors.New("depends-on: version is required")
+		}
+		for _, version := range list {
+			version, err := parseVersion(version)
+			if err != nil {
+				return nil, fmt.Errorf("depends-on: %w", err)
+			}
+			versions = append(versions, version)
+		}
+	}
+	return versions, nil
+}
+
+// validateDependencies checks that the dependencies of every migration
+// exist and that they form no cycle.
+func validateDependencies(migrations []Migration) error {
+	versions := make(map[string]bool, len(migrations))
+	for _, migration := range migrations {
+		versions[migration.Version] = true
+	}
+	for _, migration := range migrations {
+		for _, version := range migration.DependsOn {
+			if !versions[version] {
+				return fmt.Errorf("migration %s depends on unknown migration %s", migration.Version, version)
+			}
+			if version == migration.Version {
+				return fmt.Errorf("migration %s depends on itself", migration.Version)
+			}
+		}
+	}
+	if ordered := orderByDependencies(migrations); len(ordered) < len(migrations) {
+		var cycle []string
+		done := make(map[string]bool, len(ordered))
+		for _, migration := range ordered {
+			done[migration.Version] = true
+		}
+		for _, migration := range migrations {
+			if !done[migration.Version] {
+				cycle = append(cycle, migration.Version)
+			}
+		}
+		return fmt.Errorf("dependency cycle among or before migrations %s", strings.Join(cycle, ", "))
+	}
+	return nil
+}
+
+// orderByDependencies orders migrations, sorted by version, so that each
+// comes after its dependencies: those it declares, or the closest migration
+// of a lower version that declares none when it declares none itself. Of
+// the migrations ready to run, the lowest version goes first, so migrations
+// without dependencies keep their version order. Migrations caught in a
+// cycle are left out.
+func orderByDependencies(migrations []Migration) []Migration {
+	index := make(map[string]int, len(migrations))
+	for i, migration := range migrations {
+		index[migration.Version] = i
+	}
+	dependents := make([][]int, len(migrations))
+	waiting := make([]int, len(migrations))
+	previous := -1
+	for i, migration := range migrations {
+		if len(migration.DependsOn) == 0 {
+			if previous >= 0 {
+				dependents[previous] = append(dependents[previous], i)
+				waiting[i] = 1
+			}
+			previous = i
+			continue
+		}
+		for _, version := range migration.DependsOn {
+			// Dependencies outside the list are already applied.
+			if j, ok := index[version]; ok {
+				dependents[j] = append(dependents[j], i)
+				waiting[i]++
+			}
+		}
+	}
+
+	var ready []int
+	for i := range migrations {
+		if waiting[i] == 0 {
+			ready = append(ready, i)
+		}
+	}
+	ordered := make([]Migration, 0, len(migrations))
+	for len(ready) > 0 {
+		sort.Ints(ready)
+		i := ready[0]
+		ready = ready[1:]
+		ordered = append(ordered, migrations[i])
+		for _, j := range dependents[i] {
+			if waiting[j]--; waiting[j] == 0 {
+				ready = append(ready, j)
+			}
+		}
+	}
+	return ordered
+}
+
+// dependenciesApplied reports whether every dependency a migration
+// declares is in the history or was applied by this run.
+func dependenciesApplied(migration Migration, applied map[string]AppliedMigration, done []string) bool {
+	for _, version := range migration.DependsOn {
+		if _, ok := applied[version]; !ok && !contains(done, version) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,80 @@
+package pgmigrate
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// dependencyMigrations returns migrations of the versions, each followed by
+// the versions it depends on after a colon, e.g. "3:1,2".
+func dependencyMigrations(specs ...string) []Migration {
+	var migrations []Migration
+	for _, spec := range specs {
+		version, deps, _ := strings.Cut(spec, ":")
+		migration := Migration{Version: version}
+		if deps != "" {
+			migration.DependsOn = strings.Split(deps, ",")
+		}
+		migrations = append(migrations, migration)
+	}
+	return migrations
+}
+
+func TestOrderByDependencies(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		migrations []string
+		want       string
+	}{
+		{name: "version order", migrations: []string{"1", "2", "3"}, want: "1 2 3"},
+		{name: "backward dependency", migrations: []string{"1", "2", "3:1"}, want: "1 2 3"},
+		{name: "forward dependency", migrations: []string{"1", "2:3", "3"}, want: "1 3 2"},
+		{name: "dependency on a later branch", migrations: []string{"1", "2:4", "3", "4:3"}, want: "1 3 4 2"},
+		{name: "applied dependency", migrations: []string{"2:1", "3"}, want: "2 3"},
+		{name: "cycle", migrations: []string{"1", "2:3", "3:2", "4"}, want: "1 4"},
+	} {
+		var got []string
+		for _, migration := range orderByDependencies(dependencyMigrations(test.migrations...)) {
+			got = append(got, migration.Version)
+		}
+		if strings.Join(got, " ") != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, strings.Join(got, " "), test.want)
+		}
+	}
+}
+
+func TestValidateDependencies(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		migrations []string
+		err        string
+	}{
+		{name: "none", migrations: []string{"1", "2", "3"}},
+		{name: "forward dependency", migrations: []string{"1", "2:3", "3"}},
+		{name: "missing dependency", migrations: []string{"1", "2:7"}, err: "migration 2 depends on unknown migration 7"},
+		{name: "self", migrations: []string{"1:1"}, err: "migration 1 depends on itself"},
+		{name: "cycle", migrations: []string{"1", "2:3", "3:2", "4"}, err: "dependency cycle among or before migrations 2, 3"},
+		{name: "cycle through three", migrations: []string{"1:3", "2:1", "3:2"}, err: "dependency cycle among or before migrations 1, 2, 3"},
+	} {
+		err := validateDependencies(dependencyMigrations(test.migrations...))
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && (err == nil || err.Error() != test.err):
+			t.Errorf("%s: got error %v, want %s", test.name, err, test.err)
+		}
+	}
+}
+
+func TestOrderByDependenciesLinear(t *testing.T) {
+	// Migrations without dependencies each wait on the one before them
+	// only, rather than on every lower version.
+	migrations := make([]Migration, 20000)
+	for i := range migrations {
+		migrations[i].Version = fmt.Sprintf("%05d", i)
+	}
+	if ordered := orderByDependencies(migrations); len(ordered) != len(migrations) {
+		t.Fatalf("ordered %d of %d migrations", len(ordered), len(migrations))
+	}
+}
@@ -0,0 +1,136 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Ways of disposing of a deprovisioned tenant database.
+const (
+	ArchiveDrop   = "drop"
+	ArchiveRename = "rename"
+)
+
+// DeprovisionResult reports the deprovisioning of a tenant database.
+type DeprovisionResult struct {
+	Cluster  string
+	Database string
+	// Backup is the file the final pg_dump was written to, if any.
+	Backup string
+	// Archived is the name the database was renamed to, or empty when it
+	// was dropped.
+	Archived string
+}
+
+func validateArchive(archive string) error {
+	switch archive {
+	case "", ArchiveDrop, ArchiveRename:
+		return nil
+	}
+	return fmt.Errorf("unknown archive %q (want %s or %s)", archive, ArchiveDrop, ArchiveRename)
+}
+
+// Deprovision retires the tenant database name on the named cluster, or the
+// only configured one: it takes a final pg_dump to the backup directory,
+// stops new connections and ends the open ones, drops the database or
+// renames it out of the way and removes it from the registry. Nothing is
+// dropped unless the backup succeeded.
+func (m *Migrator) Deprovision(ctx context.Context, clusterName, name string) (DeprovisionResult, error) {
+	result := DeprovisionResult{Database: name}
+	config := m.config.Provisioning
+	if config == nil {
+		config = &ProvisioningConfig{}
+	}
+	cluster, err := m.cluster(clusterName)
+	if err != nil {
+		return result, err
+	}
+	result.Cluster = cluster.Name
+
+	if config.BackupDir != "" {
+		result.Backup, err = dumpDatabase(ctx, cluster, m.config.PgDump, name, config.BackupDir)
+		if err != nil {
+			return result, fmt.Errorf("backup: %w", err)
+		}
+	}
+
+	admin, err := connectToDatabase(ctx, cluster, "")
+	if err != nil {
+		return result, err
+	}
+	defer admin.Close()
+
+	quoted := pq.QuoteIdentifier(name)
+	for _, stmt := range []string{
+		"REVOKE CONNECT ON DATABASE " + quoted + " FROM PUBLIC",
+		"ALTER DATABASE " + quoted + " ALLOW_CONNECTIONS false",
+	} {
+		if _, err := admin.ExecContext(ctx, stmt); err != nil {
+			return result, fmt.Errorf("revoke connections: %w", err)
+		}
+	}
+	if _, err := admin.ExecContext(ctx, `SELECT pg_terminate_backend(pid) FROM pg_stat_activity
+WHERE datname = $1 AND pid <> pg_backend_pid()`, name); err != nil {
+		return result, fmt.Errorf("terminate connections: %w", err)
+	}
+
+	if config.Archive == ArchiveRename {
+		result.Archived = fmt.Sprintf("%s_archived_%s", name, time.Now().UTC().Format("20060102150405"))
+		if _, err := admin.ExecContext(ctx, "ALTER DATABASE "+quoted+" RENAME TO "+pq.QuoteIdentifier(result.Archived)); err != nil {
+			return result, fmt.Errorf("rename database: %w", err)
+		}
+	} else if _, err := admin.ExecContext(ctx, "DROP DATABASE "+quoted); err != nil {
+		return result, fmt.Errorf("drop database: %w", err)
+	}
+
+	if config.Registry != nil && config.Registry.Deregister != "" {
+		target := Target{Cluster: cluster, Database: name}
+		if err := m.updateRegistry(ctx, target, config.Registry, config.Registry.Deregister); err != nil {
+			return result, fmt.Errorf("deregister tenant: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// dumpDatabase writes a pg_dump of the database in custom format to a
+// timestamped file of dir and returns its path.
+func dumpDatabase(ctx context.Context, cluster ClusterConfig, command, dbName, dir string) (string, error) {
+	env, err := clientEnv(ctx, cluster, dbName)
+	if err != nil {
+		return "", fmt.Errorf("pg_dump: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("%s-%s.dump", safeFileName(dbName), time.Now().UTC().Format("20060102T150405Z"))
+	if cluster.Name != "" {
+		name = safeFileName(cluster.Name) + "-" + name
+	}
+	path := filepath.Join(dir, name)
+
+	if command == "" {
+		command = "pg_dump"
+	}
+	cmd := exec.CommandContext(ctx, command, "--format=custom", "--file", path, "--dbname", dbName)
+	cmd.Env = env
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		os.Remove(path)
+		return "", fmt.Errorf("pg_dump: %w: %s", err, strings.TrimSpace(output.String()))
+	}
+	if info, err := os.Stat(path); err != nil || info.Size() == 0 {
+		return "", errors.New("pg_dump wrote no backup")
+	}
+	return path, nil
+}
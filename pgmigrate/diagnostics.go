@@ -0,0 +1,225 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// defaultLogLines is the number of lines of the server log collected when
+// none is configured.
+const defaultLogLines = 200
+
+// logExcerptBytes bounds how much of the end of the server log is read.
+const logExcerptBytes = 256 << 10
+
+// DiagnosticsConfig collects a bundle of diagnostics for each database that
+// fails, so that postmortems do not depend on reproducing the failure. The
+// bundle is a directory under Dir, named after the target and the time,
+// holding diagnostics.json with the error, the sessions of the database and
+// the statement each last ran, the locks they hold or wait for and, when the
+// role may read it, the end of the server log. The directory is given to the
+// reporter and to the hooks as PGMIGRATE_DIAGNOSTICS.
+type DiagnosticsConfig struct {
+	Dir string `json:"dir"`
+
+	// LogLines is the number of lines of the server log collected.
+	// Defaults to 200; -1 skips the log.
+	LogLines int `json:"log_lines"`
+
+	// Command is a shell command run once diagnostics.json is written, to
+	// add to the bundle what the database does not expose, such as logs
+	// kept by a cloud provider. It gets the environment of hooks.
+	Command *HookCommand `json:"command"`
+}
+
+func (c *DiagnosticsConfig) validate() error {
+	if c.Dir == "" {
+		return errors.New("dir is required")
+	}
+	if c.LogLines < -1 {
+		return fmt.Errorf("invalid log_lines %d", c.LogLines)
+	}
+	if c.Command != nil {
+		hooks := HooksConfig{OnFailure: []HookCommand{*c.Command}}
+		if err := hooks.validate(); err != nil {
+			return fmt.Errorf("command: %w", err)
+		}
+	}
+	return nil
+}
+
+// DiagnosticBundle is the content of diagnostics.json.
+type DiagnosticBundle struct {
+	Cluster     string    `json:"cluster"`
+	Database    string    `json:"database"`
+	Schema      string    `json:"schema,omitempty"`
+	CollectedAt time.Time `json:"collected_at"`
+	Error       string    `json:"error"`
+	ErrorClass  string    `json:"error_class,omitempty"`
+	// Version, File, Statement, Line and SQLState locate a failed
+	// migration's error.
+	Version   string `json:"version,omitempty"`
+	File      string `json:"file,omitempty"`
+	Statement int    `json:"statement,omitempty"`
+	Line      int    `json:"line,omitempty"`
+	SQLState  string `json:"sqlstate,omitempty"`
+	// Activity lists the other sessions of the database, from
+	// pg_stat_activity, with the backends blocking them.
+	Activity []json.RawMessage `json:"activity"`
+	// Locks lists the locks held or awaited by the sessions of the
+	// database, from pg_locks.
+	Locks []json.RawMessage `json:"locks"`
+	// ServerLog is the end of the server's current log file.
+	ServerLog []string `json:"server_log,omitempty"`
+	// Problems lists the diagnostics that could not be collected.
+	Problems []string `json:"problems,omitempty"`
+}
+
+// collectDiagnostics writes the diagnostic bundle of a failed database and
+// returns its directory, or "" when it could not be written.
+func collectDiagnostics(ctx context.Context, config *DiagnosticsConfig, target Target, result MigrationResult) string {
+	bundle := DiagnosticBundle{
+		Cluster:     result.Cluster,
+		Database:    result.Database,
+		Schema:      result.Schema,
+		CollectedAt: time.Now().UTC(),
+		ErrorClass:  result.ErrorClass,
+	}
+	if result.Error != nil {
+		bundle.Error = result.Error.Error()
+	}
+	var migrationErr *MigrationError
+	if errors.As(result.Error, &migrationErr) {
+		bundle.Version, bundle.File = migrationErr.Version, migrationErr.File
+		bundle.Statement, bundle.Line, bundle.SQLState = migrationErr.Statement, migrationErr.Line, migrationErr.SQLState
+	}
+	if err := readDiagnostics(ctx, config, target, &bundle); err != nil {
+		bundle.Problems = append(bundle.Problems, err.Error())
+	}
+
+	dir := filepath.Join(snapshotDir(config.Dir, target), bundle.CollectedAt.Format("20060102T150405.000Z"))
+	name := resultName(result)
+	if err := writeDiagnostics(dir, bundle); err != nil {
+		log.Printf("WARNING: diagnostics for %s: %v", name, err)
+		return ""
+	}
+	if config.Command != nil {
+		result.Diagnostics = dir
+		if err := runHook(ctx, *config.Command, hookEnv(result), name); err != nil {
+			log.Printf("WARNING: diagnostics command for %s: %v", name, err)
+		}
+	}
+	return dir
+}
+
+// readDiagnostics reads the diagnostics of the database into bundle. A
+// diagnostic that cannot be read is listed in the bundle's problems;
+// readDiagnostics only fails when the database cannot be reached.
+func readDiagnostics(ctx context.Context, config *DiagnosticsConfig, target Target, bundle *DiagnosticBundle) error {
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	bundle.Activity, err = jsonRows(ctx, db, `SELECT to_jsonb(a) FROM (
+		SELECT pid, usename, application_name, host(client_addr) AS client_addr, state,
+			wait_event_type, wait_event, backend_start, xact_start, query_start, state_change,
+			pg_blocking_pids(pid) AS blocked_by, query
+		FROM pg_stat_activity
+		WHERE datname = current_database() AND pid <> pg_backend_pid()
+		ORDER BY pid) a`)
+	if err != nil {
+		bundle.Problems = append(bundle.Problems, fmt.Sprintf("activity: %v", err))
+	}
+	bundle.Locks, err = jsonRows(ctx, db, `SELECT to_jsonb(l) FROM (
+		SELECT pid, locktype, mode, granted, relation::regclass::text AS relation,
+			transactionid::text AS transactionid, virtualxid, classid, objid
+		FROM pg_locks
+		WHERE pid IN (SELECT pid FROM pg_stat_activity WHERE datname = current_database())
+		ORDER BY granted, pid) l`)
+	if err != nil {
+		bundle.Problems = append(bundle.Problems, fmt.Sprintf("locks: %v", err))
+	}
+
+	lines := config.LogLines
+	if lines == 0 {
+		lines = defaultLogLines
+	}
+	if lines > 0 {
+		bundle.ServerLog, err = serverLogExcerpt(ctx, db, lines)
+		if err != nil {
+			bundle.Problems = append(bundle.Problems, fmt.Sprintf("server log: %v", err))
+		}
+	}
+	return nil
+}
+
+// jsonRows returns the single JSON column of the rows of a query.
+func jsonRows(ctx context.Context, db *sql.DB, query string) ([]json.RawMessage, error) {
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []json.RawMessage
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+	return result, rows.Err()
+}
+
+// serverLogExcerpt returns the last lines of the server's current log file,
+// which needs the logging collector and a role allowed to read server files,
+// such as a member of pg_read_server_files.
+func serverLogExcerpt(ctx context.Context, db *sql.DB, lines int) ([]string, error) {
+	var path sql.NullString
+	if err := db.QueryRowContext(ctx, `SELECT pg_current_logfile()`).Scan(&path); err != nil {
+		return nil, err
+	}
+	if !path.Valid {
+		return nil, errors.New("the logging collector is not running")
+	}
+	// The file is read as bytes, since the excerpt may start in the middle
+	// of a character, and the partial line it may start with is dropped.
+	var excerpt []byte
+	var truncated bool
+	err := db.QueryRowContext(ctx, `SELECT pg_read_binary_file($1::text, greatest(s.size - $2::bigint, 0), $2::bigint), s.size > $2::bigint
+		FROM pg_stat_file($1::text) s`, path.String, logExcerptBytes).Scan(&excerpt, &truncated)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", path.String, err)
+	}
+	all := strings.Split(strings.TrimRight(strings.ToValidUTF8(string(excerpt), "\uFFFD"), "\n"), "\n")
+	if truncated {
+		all = all[1:]
+	}
+	if len(all) > lines {
+		all = all[len(all)-lines:]
+	}
+	return all, nil
+}
+
+// writeDiagnostics creates the bundle directory and writes diagnostics.json
+// to it.
+func writeDiagnostics(dir string, bundle DiagnosticBundle) error {
+	data, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "diagnostics.json"), append(data, '\n'), 0o644)
+}
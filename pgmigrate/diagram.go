@@ -0,0 +1,232 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DiagramConfig emits an entity-relationship diagram of the tables and
+// foreign keys of each database after its migrations, for documentation
+// that follows every release.
+type DiagramConfig struct {
+	// Format is "mermaid" (the default) or "dot".
+	Format string `json:"format"`
+
+	// Dir receives the diagrams as <cluster>/<database>.mmd or .dot.
+	Dir string `json:"dir"`
+
+	// Database restricts the diagrams to the databases matching this name
+	// or pattern, typically a single representative one of a fleet of
+	// identical tenants.
+	Database string `json:"database"`
+}
+
+func (c *DiagramConfig) validate() error {
+	switch c.Format {
+	case "", "mermaid", "dot":
+	default:
+		return fmt.Errorf("format: expected mermaid or dot, got %q", c.Format)
+	}
+	if c.Dir == "" {
+		return errors.New("dir is required")
+	}
+	return nil
+}
+
+// diagramTable is a table of a diagram.
+type diagramTable struct {
+	name    string
+	columns []diagramColumn
+}
+
+type diagramColumn struct {
+	name, typ           string
+	primaryKey, foreign bool
+}
+
+// diagramForeignKey is a foreign key between two tables of a diagram.
+type diagramForeignKey struct {
+	table, references, name string
+	optional                bool // one of the referencing columns is nullable
+}
+
+// diagramColumnsQuery lists the columns of the user tables, leaving out
+// partitions, which share the columns of their parent.
+const diagramColumnsQuery = `
+SELECT c.oid::regclass::text, a.attname, format_type(a.atttypid, a.atttypmod),
+	EXISTS (SELECT 1 FROM pg_constraint p WHERE p.conrelid = c.oid AND p.contype = 'p' AND a.attnum = ANY (p.conkey)),
+	EXISTS (SELECT 1 FROM pg_constraint f WHERE f.conrelid = c.oid AND f.contype = 'f' AND a.attnum = ANY (f.conkey))
+FROM pg_class c
+JOIN pg_namespace n ON n.oid = c.relnamespace
+JOIN pg_attribute a ON a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped
+WHERE c.relkind IN ('r', 'p') AND NOT c.relispartition AND ` + userNamespaces + `
+	AND NOT EXISTS (SELECT 1 FROM pg_depend e WHERE e.objid = c.oid AND e.deptype = 'e')
+ORDER BY 1, a.attnum`
+
+// diagramForeignKeysQuery lists the foreign keys between user tables.
+const diagramForeignKeysQuery = `
+SELECT con.conrelid::regclass::text, con.confrelid::regclass::text, con.conname,
+	EXISTS (SELECT 1 FROM pg_attribute a WHERE a.attrelid = con.conrelid AND a.attnum = ANY (con.conkey) AND NOT a.attnotnull)
+FROM pg_constraint con
+JOIN pg_class c ON c.oid = con.conrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE con.contype = 'f' AND NOT c.relispartition AND ` + userNamespaces + `
+ORDER BY 1, 3`
+
+// introspectDiagram reads the tables and foreign keys of the database.
+func introspectDiagram(ctx context.Context, conn queryExecer) ([]diagramTable, []diagramForeignKey, error) {
+	rows, err := conn.QueryContext(ctx, diagramColumnsQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+	var tables []diagramTable
+	for rows.Next() {
+		var table string
+		var column diagramColumn
+		if err := rows.Scan(&table, &column.name, &column.typ, &column.primaryKey, &column.foreign); err != nil {
+			rows.Close()
+			return nil, nil, err
+		}
+		if len(tables) == 0 || tables[len(tables)-1].name != table {
+			tables = append(tables, diagramTable{name: table})
+		}
+		tables[len(tables)-1].columns = append(tables[len(tables)-1].columns, column)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = conn.QueryContext(ctx, diagramForeignKeysQuery)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+	var keys []diagramForeignKey
+	for rows.Next() {
+		var key diagramForeignKey
+		if err := rows.Scan(&key.table, &key.references, &key.name, &key.optional); err != nil {
+			return nil, nil, err
+		}
+		keys = append(keys, key)
+	}
+	return tables, keys, rows.Err()
+}
+
+// renderMermaid renders an erDiagram for Mermaid.
+func renderMermaid(tables []diagramTable, keys []diagramForeignKey) string {
+	var b strings.Builder
+	b.WriteString("erDiagram\n")
+	for _, table := range tables {
+		fmt.Fprintf(&b, "    %s {\n", mermaidName(table.name))
+		for _, column := range table.columns {
+			fmt.Fprintf(&b, "        %s %s", mermaidName(column.typ), mermaidName(column.name))
+			switch {
+			case column.primaryKey && column.foreign:
+				b.WriteString(" PK, FK")
+			case column.primaryKey:
+				b.WriteString(" PK")
+			case column.foreign:
+				b.WriteString(" FK")
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString("    }\n")
+	}
+	for _, key := range keys {
+		cardinality := "||--o{"
+		if key.optional {
+			cardinality = "|o--o{"
+		}
+		fmt.Fprintf(&b, "    %s %s %s : %q\n", mermaidName(key.references), cardinality, mermaidName(key.table), key.name)
+	}
+	return b.String()
+}
+
+// mermaidName replaces the characters Mermaid does not accept in entity,
+// attribute and type names.
+func mermaidName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-',
+			r == '(', r == ')', r == '[', r == ']':
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// renderDot renders a Graphviz digraph with a record node per table.
+func renderDot(tables []diagramTable, keys []diagramForeignKey) string {
+	var b strings.Builder
+	b.WriteString("digraph schema {\n    rankdir=LR;\n    node [shape=record];\n")
+	for _, table := range tables {
+		var fields []string
+		for _, column := range table.columns {
+			field := column.name + " : " + column.typ
+			if column.primaryKey {
+				field += " (PK)"
+			}
+			if column.foreign {
+				field += " (FK)"
+			}
+			fields = append(fields, dotRecordEscape(field)+`\l`)
+		}
+		label := "{" + dotRecordEscape(table.name) + "|" + strings.Join(fields, "") + "}"
+		fmt.Fprintf(&b, "    %s [label=\"%s\"];\n", dotQuote(table.name), label)
+	}
+	for _, key := range keys {
+		style := ""
+		if key.optional {
+			style = ", style=dashed"
+		}
+		fmt.Fprintf(&b, "    %s -> %s [label=%s%s];\n", dotQuote(key.table), dotQuote(key.references), dotQuote(key.name), style)
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// dotQuote quotes a Graphviz identifier.
+func dotQuote(s string) string {
+	return `"` + strings.ReplaceAll(strings.ReplaceAll(s, `\`, `\\`), `"`, `\"`) + `"`
+}
+
+// dotRecordEscape escapes the characters with a meaning in record labels,
+// and the quotes of the label string itself.
+func dotRecordEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch r {
+		case '{', '}', '|', '<', '>', '"', '\\', ' ':
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// writeDiagram writes the diagram of the database to the configured
+// directory.
+func writeDiagram(ctx context.Context, conn queryExecer, config *DiagramConfig, target Target) error {
+	tables, keys, err := introspectDiagram(ctx, conn)
+	if err != nil {
+		return err
+	}
+	diagram, ext := renderMermaid(tables, keys), ".mmd"
+	if config.Format == "dot" {
+		diagram, ext = renderDot(tables, keys), ".dot"
+	}
+	name := safeFileName(target.Database)
+	if target.Schema != "" {
+		name += "." + safeFileName(target.Schema)
+	}
+	path := filepath.Join(config.Dir, safeFileName(target.Cluster.Name), name+ext)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(diagram), 0o644)
+}
@@ -0,0 +1,57 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"net"
+	"time"
+)
+
+// Dialer opens network connections to database servers, for networking the
+// built-in SSH and proxy support does not cover (service meshes, SPIFFE
+// identities and the like). *net.Dialer satisfies it. The network is "unix"
+// when the cluster host is a socket directory.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// dialFunc adapts a context-aware dial function to the dialer interfaces of
+// lib/pq.
+type dialFunc func(ctx context.Context, network, address string) (net.Conn, error)
+
+func (f dialFunc) Dial(network, address string) (net.Conn, error) {
+	return f(context.Background(), network, address)
+}
+
+func (f dialFunc) DialTimeout(network, address string, timeout time.Duration) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return f(ctx, network, address)
+}
+
+func (f dialFunc) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	return f(ctx, network, address)
+}
+
+// clusterDialer returns the dialer used to reach the cluster, or nil when
+// connections are made directly.
+func clusterDialer(cluster ClusterConfig) (dialFunc, error) {
+	configured := 0
+	for _, set := range []bool{cluster.Dialer != nil, cluster.SSH != nil, cluster.Proxy != ""} {
+		if set {
+			configured++
+		}
+	}
+
+	switch {
+	case configured > 1:
+		return nil, errors.New("only one of dialer, ssh and proxy can be used")
+	case cluster.Dialer != nil:
+		return cluster.Dialer.DialContext, nil
+	case cluster.SSH != nil:
+		return sshDialer(*cluster.SSH), nil
+	case cluster.Proxy != "":
+		return proxyDialer(cluster.Proxy)
+	}
+	return nil, nil
+}
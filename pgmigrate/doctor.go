@@ -0,0 +1,302 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minServerVersion is the oldest PostgreSQL server the tool supports, as
+// server_version_num: the history table is upgraded with ADD COLUMN IF NOT
+// EXISTS, which PostgreSQL 9.6 introduced.
+const minServerVersion = 90600
+
+// maxClockSkew is the largest difference between the clocks of the runner
+// and a server that Diagnose accepts. Skewed clocks make the applied_at
+// times of the history misleading and time-based schedules fire early or
+// late.
+const maxClockSkew = 5 * time.Second
+
+// DiagnosticCheck is the outcome of one check of Diagnose. Err is nil when
+// the check passed.
+type DiagnosticCheck struct {
+	Name   string
+	Detail string
+	Err    error
+}
+
+// DiagnosticResult holds the checks of a cluster, when Database is empty,
+// or of one of its databases.
+type DiagnosticResult struct {
+	Cluster  string
+	Database string
+	Checks   []DiagnosticCheck
+}
+
+// Failed reports whether any check of the result failed.
+func (r DiagnosticResult) Failed() bool {
+	for _, check := range r.Checks {
+		if check.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Diagnose checks that the environment is ready for a run without changing
+// anything: for every cluster, that it is reachable, that its server and
+// the client programs the configuration uses are of supported versions,
+// that the role has the privileges the configuration needs and that the
+// clocks agree; and for every target database, that the history table, if
+// there is one, has the expected shape, that the role may create or write
+// it and that the migration lock is free.
+func (m *Migrator) Diagnose(ctx context.Context, targets []Target) []DiagnosticResult {
+	var results []DiagnosticResult
+	for _, cluster := range m.config.Clusters {
+		results = append(results, m.diagnoseCluster(ctx, cluster))
+	}
+
+	// Tenant schemas share their database's connection and lock checks.
+	seen := make(map[string]bool)
+	var databases []Target
+	for _, target := range targets {
+		key := target.Cluster.Name + "\x00" + target.Database
+		if !seen[key] {
+			seen[key] = true
+			databases = append(databases, Target{Cluster: target.Cluster, Database: target.Database})
+		}
+	}
+	return append(results, forEachTarget(ctx, m.config, databases, func(ctx context.Context, target Target) DiagnosticResult {
+		return diagnoseDatabase(ctx, target)
+	}, func(target Target, err error) DiagnosticResult {
+		return DiagnosticResult{Cluster: target.Cluster.Name, Database: target.Database, Checks: []DiagnosticCheck{{Name: "connectivity", Err: err}}}
+	})...)
+}
+
+// diagnoseCluster runs the checks of a cluster on its default database.
+func (m *Migrator) diagnoseCluster(ctx context.Context, cluster ClusterConfig) DiagnosticResult {
+	result := DiagnosticResult{Cluster: cluster.Name}
+	check := func(name, detail string, err error) {
+		result.Checks = append(result.Checks, DiagnosticCheck{Name: name, Detail: detail, Err: err})
+	}
+
+	db, err := connectToDatabase(ctx, cluster, "")
+	if err != nil {
+		check("connectivity", "", err)
+		return result
+	}
+	defer db.Close()
+	check("connectivity", cluster.address(), nil)
+
+	var version int
+	var versionName string
+	err = db.QueryRowContext(ctx, `SELECT current_setting('server_version_num')::int, current_setting('server_version')`).Scan(&version, &versionName)
+	if err == nil && version < minServerVersion {
+		err = fmt.Errorf("PostgreSQL %s is older than the oldest supported, 9.6", versionName)
+	}
+	check("server version", versionName, err)
+	if err != nil {
+		return result
+	}
+	if lint := m.config.Lint.ServerVersion; lint != 0 && version/10000 < lint {
+		check("lint.server_version", "", fmt.Errorf("lint assumes PostgreSQL %d but the server runs %s", lint, versionName))
+	}
+	if cluster.PgRepack != "" {
+		detail, err := clientVersion(cluster.PgRepack, version)
+		check("pg_repack version", detail, err)
+	}
+	if m.config.Provisioning != nil && m.config.Provisioning.BackupDir != "" {
+		pgDump := m.config.PgDump
+		if pgDump == "" {
+			pgDump = "pg_dump"
+		}
+		detail, err := clientVersion(pgDump, version)
+		check("pg_dump version", detail, err)
+	}
+
+	var user string
+	var superuser, createDB bool
+	err = db.QueryRowContext(ctx, `SELECT current_user, rolsuper, rolcreatedb FROM pg_roles WHERE rolname = current_user`).Scan(&user, &superuser, &createDB)
+	switch {
+	case err != nil:
+	case superuser:
+		check("privileges", user+" is a superuser", nil)
+	case m.config.Provisioning != nil && !createDB:
+		err = fmt.Errorf("%s cannot create the databases of new tenants: grant it CREATEDB", user)
+	default:
+		check("privileges", user, nil)
+	}
+	if err != nil {
+		check("privileges", "", err)
+	}
+
+	started := time.Now()
+	var serverTime time.Time
+	if err := db.QueryRowContext(ctx, `SELECT clock_timestamp()`).Scan(&serverTime); err != nil {
+		check("clock skew", "", err)
+		return result
+	}
+	// The server read its clock about halfway through the round trip.
+	skew := serverTime.Sub(started.Add(time.Since(started) / 2))
+	if skew < 0 {
+		skew = -skew
+	}
+	err = nil
+	if skew > maxClockSkew {
+		err = fmt.Errorf("server clock is %s off the local clock", skew.Round(time.Millisecond))
+	}
+	check("clock skew", skew.Round(time.Millisecond).String(), err)
+	return result
+}
+
+// clientVersionPattern matches the version a PostgreSQL client program
+// prints, such as "pg_dump (PostgreSQL) 16.2".
+var clientVersionPattern = regexp.MustCompile(`\(PostgreSQL\) (\d+)\.(\d+)`)
+
+// clientVersion checks that a client program runs and, for the PostgreSQL
+// client programs, that it is not older than the server, which they
+// refuse.
+func clientVersion(program string, serverVersion int) (string, error) {
+	out, err := exec.Command(program, "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", program, err)
+	}
+	line := strings.TrimSpace(string(out))
+	match := clientVersionPattern.FindStringSubmatch(line)
+	if match == nil {
+		return line, nil
+	}
+	// Compare major versions, which are two numbers before PostgreSQL 10.
+	major, _ := strconv.Atoi(match[1])
+	minor, _ := strconv.Atoi(match[2])
+	client := major * 10000
+	if major < 10 {
+		client += minor * 100
+	}
+	server := serverVersion / 10000 * 10000
+	if serverVersion < 100000 {
+		server = serverVersion / 100 * 100
+	}
+	if client < server {
+		return line, fmt.Errorf("%s is older than the server", line)
+	}
+	return line, nil
+}
+
+// historyColumns are the columns of the history table and their types.
+var historyColumns = map[string]string{
+	"version":      "text",
+	"description":  "text",
+	"checksum":     "text",
+	"applied_at":   "timestamp with time zone",
+	"execution_ms": "bigint",
+	"release":      "text",
+	"tool_version": "text",
+	"deprecated":   "boolean",
+}
+
+// diagnoseDatabase runs the checks of a target database.
+func diagnoseDatabase(ctx context.Context, target Target) DiagnosticResult {
+	result := DiagnosticResult{Cluster: target.Cluster.Name, Database: target.Database}
+	check := func(name, detail string, err error) {
+		result.Checks = append(result.Checks, DiagnosticCheck{Name: name, Detail: detail, Err: err})
+	}
+
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		check("connectivity", "", err)
+		return result
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		check("connectivity", "", err)
+		return result
+	}
+	defer conn.Close()
+	check("connectivity", "", nil)
+
+	detail, err := historyShape(ctx, conn)
+	check("history table", detail, err)
+
+	var canCreate, historyExists, canWrite bool
+	err = conn.QueryRowContext(ctx, `SELECT has_schema_privilege(current_schema(), 'CREATE'),
+		to_regclass($1) IS NOT NULL,
+		to_regclass($1) IS NOT NULL AND has_table_privilege($1, 'SELECT, INSERT, DELETE')`, historyTable).Scan(&canCreate, &historyExists, &canWrite)
+	switch {
+	case err != nil:
+	case !historyExists && !canCreate:
+		err = errors.New("cannot create the history table in the current schema")
+	case historyExists && !canWrite:
+		err = errors.New("cannot read and write the history table")
+	case !canCreate:
+		err = errors.New("cannot create objects in the current schema")
+	}
+	check("privileges", "", err)
+
+	var holder sql.NullInt64
+	if err := lockDatabase(ctx, conn); err != nil {
+		if errors.Is(err, ErrDatabaseLocked) {
+			conn.QueryRowContext(ctx, `SELECT pid FROM pg_locks
+				WHERE locktype = 'advisory' AND granted AND database = (SELECT oid FROM pg_database WHERE datname = current_database())
+				AND ((classid::bigint << 32) | objid::bigint) = $1`, advisoryLockKey).Scan(&holder)
+			if holder.Valid {
+				err = fmt.Errorf("%w by backend %d", err, holder.Int64)
+			}
+		}
+		check("migration lock", "", err)
+		return result
+	}
+	unlockDatabase(conn)
+	check("migration lock", "free", nil)
+	return result
+}
+
+// historyShape checks the columns of the history table, which is fine
+// missing as the first run creates it.
+func historyShape(ctx context.Context, conn *sql.Conn) (string, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT column_name, data_type FROM information_schema.columns
+		WHERE table_schema = current_schema() AND table_name = $1`, historyTable)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+	columns := make(map[string]string)
+	for rows.Next() {
+		var name, dataType string
+		if err := rows.Scan(&name, &dataType); err != nil {
+			return "", err
+		}
+		columns[name] = dataType
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if len(columns) == 0 {
+		return "not created yet", nil
+	}
+
+	var problems []string
+	for name, dataType := range historyColumns {
+		switch actual, ok := columns[name]; {
+		case !ok && (name == "release" || name == "tool_version" || name == "deprecated"):
+			// Added to older tables by the next run.
+		case !ok:
+			problems = append(problems, "missing column "+name)
+		case actual != dataType:
+			problems = append(problems, fmt.Sprintf("column %s is %s, expected %s", name, actual, dataType))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return "", errors.New(strings.Join(problems, "; "))
+	}
+	return "", nil
+}
@@ -0,0 +1,159 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ErrLossyDown is reported when a down migration does not restore the
+// schema the migration started from, or the migration reapplied after it
+// does not produce the same schema as its first application.
+var ErrLossyDown = errors.New("schema differs")
+
+// DownResult reports the verification of the down migration of a
+// migration.
+type DownResult struct {
+	Version     string
+	Description string
+	// Missing is set when the migration has no down migration.
+	Missing bool
+	// Error is how the down migration or the reapplied migration failed.
+	Error error
+	// Differences are the objects left changed when Error wraps
+	// ErrLossyDown.
+	Differences []SchemaChange
+}
+
+// VerifyDown applies every migration to an empty database on the cluster of
+// target, then its down migration and the migration again, checking that
+// the down migration restores the previous schema and that the reapplied
+// migration produces the same schema as a single pass. Verification stops
+// at the first failure, since the following migrations would run against a
+// broken schema. Scripts are rendered as for target, which is otherwise not
+// touched; the scratch database is dropped afterwards.
+func (m *Migrator) VerifyDown(ctx context.Context, target Target) ([]DownResult, error) {
+	scratch, drop, err := createScratchDatabase(ctx, target.Cluster, "down", "")
+	if err != nil {
+		return nil, err
+	}
+	defer drop()
+
+	settings := m.config.settingsFor(target)
+	data := templateData{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema, Vars: settings.Variables}
+	scratchTarget := Target{Cluster: target.Cluster, Database: scratch}
+
+	db, err := connectToDatabase(ctx, target.Cluster, scratch)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if target.Schema != "" {
+		if _, err := conn.ExecContext(ctx, "CREATE SCHEMA "+pq.QuoteIdentifier(target.Schema)); err != nil {
+			return nil, fmt.Errorf("create schema: %w", err)
+		}
+	}
+	if err := applySessionSettings(ctx, conn, settings); err != nil {
+		return nil, err
+	}
+	if err := ensureHistoryTable(ctx, conn); err != nil {
+		return nil, fmt.Errorf("create history table: %w", err)
+	}
+
+	before, err := introspectSchema(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("read schema: %w", err)
+	}
+	var results []DownResult
+	for _, migration := range pendingMigrations(m.migrations, nil, settings.TargetVersion) {
+		if err := applyMigration(ctx, conn, scratchTarget, migration, data, settings); err != nil {
+			return results, fmt.Errorf("migration %s (%s) fails: %w", migration.Version, migration.Description, err)
+		}
+		after, err := introspectSchema(ctx, conn)
+		if err != nil {
+			return results, fmt.Errorf("read schema: %w", err)
+		}
+
+		result := DownResult{Version: migration.Version, Description: migration.Description}
+		if migration.DownScript == "" {
+			result.Missing = true
+			results = append(results, result)
+			before = after
+			continue
+		}
+		result.Differences, result.Error = verifyDownMigration(ctx, conn, scratchTarget, migration, data, settings, before, after)
+		results = append(results, result)
+		if result.Error != nil {
+			return results, nil
+		}
+		before = after
+	}
+	return results, nil
+}
+
+// verifyDownMigration reverts an applied migration, compares the schema to
+// before, applies the migration again and compares the schema to after.
+func verifyDownMigration(ctx context.Context, conn *sql.Conn, target Target, migration Migration, data templateData, settings DatabaseSettings, before, after Schema) ([]SchemaChange, error) {
+	if err := revertMigration(ctx, conn, migration, data, settings); err != nil {
+		return nil, fmt.Errorf("down migration: %w", err)
+	}
+	reverted, err := introspectSchema(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("read schema: %w", err)
+	}
+	if changes := DiffSchemas(before, reverted); len(changes) > 0 {
+		return changes, fmt.Errorf("after down migration: %w", ErrLossyDown)
+	}
+
+	if err := applyMigration(ctx, conn, target, migration, data, settings); err != nil {
+		return nil, fmt.Errorf("reapplied migration: %w", err)
+	}
+	reapplied, err := introspectSchema(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("read schema: %w", err)
+	}
+	if changes := DiffSchemas(after, reapplied); len(changes) > 0 {
+		return changes, fmt.Errorf("after reapplied migration: %w", ErrLossyDown)
+	}
+	return nil, nil
+}
+
+// revertMigration renders and executes the down migration of a migration
+// and removes its history rows, together unless the database runs without
+// transactions.
+func revertMigration(ctx context.Context, conn *sql.Conn, migration Migration, data templateData, settings DatabaseSettings) error {
+	down := Migration{Version: migration.Version, Path: migration.DownPath, Script: migration.DownScript}
+	script, err := renderScript(down, data)
+	if err != nil {
+		return fmt.Errorf("render: %w", err)
+	}
+	statements := splitStatements(script)
+	execute := func(conn queryExecer) error {
+		if err := executeWithDependents(ctx, conn, script, statements, settings.DefinitionsDir, nil); err != nil {
+			return err
+		}
+		return removeApplied(ctx, conn, migration.Version, settings)
+	}
+
+	if settings.NoTransaction || migration.NoTransaction {
+		return execute(conn)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := execute(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
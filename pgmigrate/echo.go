@@ -0,0 +1,73 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// echoExecer logs every statement of a migration sent to the server, with
+// its parameters, duration and outcome, for debugging.
+type echoExecer struct {
+	queryExecer
+	prefix string // cluster/database
+}
+
+func (e echoExecer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	started := time.Now()
+	result, err := e.queryExecer.ExecContext(ctx, query, args...)
+	if err != nil {
+		e.log(query, args, started, "failed: "+err.Error())
+		return result, err
+	}
+	outcome := "done"
+	if rows, err := result.RowsAffected(); err == nil {
+		outcome = fmt.Sprintf("%d rows affected", rows)
+	}
+	e.log(query, args, started, outcome)
+	return result, nil
+}
+
+func (e echoExecer) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	started := time.Now()
+	rows, err := e.queryExecer.QueryContext(ctx, query, args...)
+	if err != nil {
+		e.log(query, args, started, "failed: "+err.Error())
+	} else {
+		e.log(query, args, started, "query")
+	}
+	return rows, err
+}
+
+func (e echoExecer) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	e.log(query, args, time.Now(), "query")
+	return e.queryExecer.QueryRowContext(ctx, query, args...)
+}
+
+func (e echoExecer) log(query string, args []interface{}, started time.Time, outcome string) {
+	line := "SQL [" + e.prefix + "] " + strings.TrimSpace(query)
+	if len(args) > 0 {
+		line += fmt.Sprintf(" -- args: %v", args)
+	}
+	log.Printf("%s (%s, %v)", line, outcome, time.Since(started).Round(time.Microsecond))
+}
+
+// logNotices returns a notice handler logging the NOTICE and WARNING
+// messages the server sends, which Exec otherwise swallows.
+func logNotices(cluster, database string) func(*pq.Error) {
+	return func(notice *pq.Error) {
+		line := notice.Severity + " [" + cluster + "/" + database + "]: " + notice.Message
+		if notice.Detail != "" {
+			line += " DETAIL: " + notice.Detail
+		}
+		if notice.Hint != "" {
+			line += " HINT: " + notice.Hint
+		}
+		log.Print(line)
+	}
+}
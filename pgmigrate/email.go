@@ -0,0 +1,185 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// EmailConfig sends a summary of each run by SMTP, with the run manifest
+// attached as JSON.
+type EmailConfig struct {
+	Host string `json:"host"`
+	// Port defaults to 587.
+	Port int `json:"port"`
+	// Username and Password authenticate with PLAIN auth when set. Either
+	// may be a secret reference.
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+
+	// Subject and Body are text/templates executed with the RunSummary, as
+	// described for WebhookConfig. They default to a one-line outcome and a
+	// list of the failures.
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+
+	// OnFailure only sends email for runs in which a database failed.
+	OnFailure bool `json:"on_failure"`
+}
+
+const defaultEmailSubject = `Migration run {{.ID}}: {{.Succeeded}} succeeded, {{.Failed}} failed`
+
+const defaultEmailBody = `Migration run {{.ID}} started {{.Started.Format "2006-01-02 15:04:05 MST"}} and finished {{.Finished.Format "2006-01-02 15:04:05 MST"}}.
+
+{{.Succeeded}} databases succeeded and {{.Failed}} failed.
+{{range .Failures}}
+{{.Cluster}}/{{.Database}}{{if .Schema}}/{{.Schema}}{{end}}: {{.Error}}{{end}}
+
+The run manifest is attached.
+`
+
+func (c *EmailConfig) validate() error {
+	if c.Host == "" {
+		return errors.New("host is required")
+	}
+	if c.From == "" {
+		return errors.New("from is required")
+	}
+	if len(c.To) == 0 {
+		return errors.New("to is required")
+	}
+	for name, text := range map[string]string{"subject": c.Subject, "body": c.Body} {
+		if _, err := template.New(name).Funcs(summaryFuncs).Parse(text); err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// sendEmail mails the summary of a run, upgrading the connection with
+// STARTTLS when the server offers it.
+func sendEmail(ctx context.Context, config *EmailConfig, summary RunSummary) error {
+	if config.OnFailure && summary.Failed == 0 {
+		return nil
+	}
+	subject, err := executeTemplate(config.Subject, defaultEmailSubject, summary)
+	if err != nil {
+		return fmt.Errorf("subject: %w", err)
+	}
+	body, err := executeTemplate(config.Body, defaultEmailBody, summary)
+	if err != nil {
+		return fmt.Errorf("body: %w", err)
+	}
+	manifest, err := summary.Manifest()
+	if err != nil {
+		return err
+	}
+	message, err := emailMessage(config.From, config.To, strings.TrimSpace(subject), body, "manifest-"+summary.ID+".json", manifest)
+	if err != nil {
+		return err
+	}
+
+	port := config.Port
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if config.Username != "" {
+		username, err := resolveSecret(ctx, config.Username)
+		if err != nil {
+			return err
+		}
+		password, err := resolveSecret(ctx, config.Password)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", username, password, config.Host)
+	}
+	return smtp.SendMail(net.JoinHostPort(config.Host, strconv.Itoa(port)), auth, config.From, config.To, message)
+}
+
+// summaryFuncs are the functions of the templates of notifications.
+var summaryFuncs = template.FuncMap{
+	// json encodes a value as JSON, such as a string in a JSON payload.
+	"json": func(v interface{}) (string, error) {
+		data, err := json.Marshal(v)
+		return string(data), err
+	},
+	// duration formats a number of milliseconds, e.g. 1m2.5s.
+	"duration": func(ms int64) string {
+		return (time.Duration(ms) * time.Millisecond).String()
+	},
+}
+
+// executeTemplate executes text, or fallback when text is empty, with data.
+func executeTemplate(text, fallback string, data interface{}) (string, error) {
+	if text == "" {
+		text = fallback
+	}
+	tmpl, err := template.New("").Funcs(summaryFuncs).Parse(text)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// emailMessage builds a multipart message of a plain text body and a JSON
+// attachment.
+func emailMessage(from string, to []string, subject, body, attachmentName string, attachment []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	fmt.Fprintf(&buf, "From: %s\r\nTo: %s\r\nSubject: %s\r\nMIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		from, strings.Join(to, ", "), mimeHeader(subject), w.Boundary())
+
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n")))
+
+	part, err = w.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"application/json"},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", attachmentName)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	encoded := base64.StdEncoding.EncodeToString(attachment)
+	for len(encoded) > 76 {
+		part.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	part.Write([]byte(encoded + "\r\n"))
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// mimeHeader encodes a header value that is not plain ASCII.
+func mimeHeader(value string) string {
+	for _, r := range value {
+		if r > 126 {
+			return "=?utf-8?b?" + base64.StdEncoding.EncodeToString([]byte(value)) + "?="
+		}
+	}
+	return value
+}
@@ -0,0 +1,204 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+)
+
+// Encrypted configuration values are strings of the form
+// enc:<scheme>:<base64 ciphertext>, where scheme is "age" or "aws-kms".
+const encryptedPrefix = "enc:"
+
+// EncryptOptions selects how EncryptValue encrypts.
+type EncryptOptions struct {
+	// AgeRecipients are age public keys (age1...) the value is encrypted to.
+	AgeRecipients []string
+	// KMSKeyID is an AWS KMS key ID, ARN or alias used instead of age.
+	KMSKeyID string
+	Region   string
+}
+
+// EncryptValue encrypts a configuration value for use in the config file.
+func EncryptValue(ctx context.Context, plaintext string, opts EncryptOptions) (string, error) {
+	switch {
+	case opts.KMSKeyID != "" && len(opts.AgeRecipients) > 0:
+		return "", errors.New("choose either age recipients or a KMS key, not both")
+	case opts.KMSKeyID != "":
+		cfg, err := loadAWSConfig(ctx, opts.Region)
+		if err != nil {
+			return "", err
+		}
+		out, err := kms.NewFromConfig(cfg).Encrypt(ctx, &kms.EncryptInput{
+			KeyId:     &opts.KMSKeyID,
+			Plaintext: []byte(plaintext),
+		})
+		if err != nil {
+			return "", err
+		}
+		return encryptedPrefix + "aws-kms:" + base64.StdEncoding.EncodeToString(out.CiphertextBlob), nil
+	case len(opts.AgeRecipients) > 0:
+		var recipients []age.Recipient
+		for _, key := range opts.AgeRecipients {
+			recipient, err := age.ParseX25519Recipient(key)
+			if err != nil {
+				return "", err
+			}
+			recipients = append(recipients, recipient)
+		}
+		var buf bytes.Buffer
+		w, err := age.Encrypt(&buf, recipients...)
+		if err != nil {
+			return "", err
+		}
+		if _, err := io.WriteString(w, plaintext); err != nil {
+			return "", err
+		}
+		if err := w.Close(); err != nil {
+			return "", err
+		}
+		return encryptedPrefix + "age:" + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+	}
+	return "", errors.New("no age recipient or KMS key given")
+}
+
+// Decrypter decrypts encrypted configuration values, loading the age
+// identities only once they are needed.
+type Decrypter struct {
+	// IdentityFile is the age identity file for enc:age: values.
+	IdentityFile string
+	// Region is the AWS region used for enc:aws-kms: values.
+	Region string
+
+	identities []age.Identity
+}
+
+// AgeIdentityFile returns the age identity file to decrypt with:
+// $PGMIGRATE_AGE_IDENTITY_FILE, else the configured one.
+func AgeIdentityFile(configured string) string {
+	if path := os.Getenv("PGMIGRATE_AGE_IDENTITY_FILE"); path != "" {
+		return path
+	}
+	return configured
+}
+
+// Decrypt returns the plaintext of an encrypted value, or value itself when
+// it is not encrypted.
+func (d *Decrypter) Decrypt(ctx context.Context, value string) (string, error) {
+	if !strings.HasPrefix(value, encryptedPrefix) {
+		return value, nil
+	}
+	scheme, encoded, ok := strings.Cut(strings.TrimPrefix(value, encryptedPrefix), ":")
+	if !ok {
+		return "", errors.New("malformed encrypted value")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", fmt.Errorf("malformed encrypted value: %w", err)
+	}
+
+	switch scheme {
+	case "age":
+		if d.identities == nil {
+			if d.IdentityFile == "" {
+				return "", errors.New("age-encrypted value found but no identity file configured (set age_identity_file or PGMIGRATE_AGE_IDENTITY_FILE)")
+			}
+			file, err := os.Open(d.IdentityFile)
+			if err != nil {
+				return "", err
+			}
+			d.identities, err = age.ParseIdentities(file)
+			file.Close()
+			if err != nil {
+				return "", fmt.Errorf("%s: %w", d.IdentityFile, err)
+			}
+		}
+		r, err := age.Decrypt(bytes.NewReader(ciphertext), d.identities...)
+		if err != nil {
+			return "", err
+		}
+		plaintext, err := io.ReadAll(r)
+		return string(plaintext), err
+	case "aws-kms":
+		cfg, err := loadAWSConfig(ctx, d.Region)
+		if err != nil {
+			return "", err
+		}
+		out, err := kms.NewFromConfig(cfg).Decrypt(ctx, &kms.DecryptInput{CiphertextBlob: ciphertext})
+		if err != nil {
+			return "", err
+		}
+		return string(out.Plaintext), nil
+	}
+	return "", fmt.Errorf("unknown encryption scheme %q", scheme)
+}
+
+// decryptConfigValues replaces every encrypted string in the JSON
+// configuration document with its plaintext.
+func decryptConfigValues(ctx context.Context, data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte(`"`+encryptedPrefix)) {
+		return data, nil
+	}
+
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	d := &Decrypter{}
+	if root, ok := doc.(map[string]interface{}); ok {
+		identityFile, _ := root["age_identity_file"].(string)
+		d.IdentityFile = AgeIdentityFile(identityFile)
+		d.Region, _ = root["kms_region"].(string)
+	}
+
+	var walk func(node interface{}, path string) (interface{}, error)
+	walk = func(node interface{}, path string) (interface{}, error) {
+		switch node := node.(type) {
+		case string:
+			plaintext, err := d.Decrypt(ctx, node)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", path, err)
+			}
+			return plaintext, nil
+		case map[string]interface{}:
+			for key, value := range node {
+				decrypted, err := walk(value, joinPath(path, key))
+				if err != nil {
+					return nil, err
+				}
+				node[key] = decrypted
+			}
+		case []interface{}:
+			for i, value := range node {
+				decrypted, err := walk(value, fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return nil, err
+				}
+				node[i] = decrypted
+			}
+		}
+		return node, nil
+	}
+
+	doc, err := walk(doc, "")
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
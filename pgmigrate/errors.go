@@ -0,0 +1,161 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+// ErrConnectionFailed is returned, wrapping the driver's error, when a
+// database cannot be connected to.
+var ErrConnectionFailed = errors.New("connection failed")
+
+// ErrMigrationFailed matches every *MigrationError with errors.Is.
+var ErrMigrationFailed = errors.New("migration failed")
+
+// StatementError is a statement of a migration script that failed.
+type StatementError struct {
+	// Index is the 1-based number of the statement in the script. Line and
+	// Column locate the error in the script when the server reported its
+	// position; otherwise Line is the line the statement starts on and
+	// Column is zero.
+	Index  int
+	Line   int
+	Column int
+	// SQLState is the server's error code, if the server reported one.
+	SQLState string
+	Err      error
+}
+
+// newStatementError locates the failure of a statement of a script.
+func newStatementError(index int, statement Statement, err error) *StatementError {
+	e := &StatementError{Index: index, Line: statement.Line, Err: err}
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return e
+	}
+	e.SQLState = string(pqErr.Code)
+	// Position counts characters from 1 in the statement as sent.
+	position, convErr := strconv.Atoi(pqErr.Position)
+	if convErr != nil || position < 1 {
+		return e
+	}
+	text := []rune(statement.SQL)
+	if position > len(text) {
+		position = len(text)
+	}
+	before := string(text[:position-1])
+	e.Line += strings.Count(before, "\n")
+	e.Column = utf8.RuneCountInString(before[strings.LastIndexByte(before, '\n')+1:]) + 1
+	return e
+}
+
+func (e *StatementError) Error() string {
+	location := fmt.Sprintf("line %d", e.Line)
+	if e.Column > 0 {
+		location += fmt.Sprintf(", column %d", e.Column)
+	}
+	if e.SQLState != "" {
+		return fmt.Sprintf("statement %d (%s): %v (SQLSTATE %s)", e.Index, location, e.Err, e.SQLState)
+	}
+	return fmt.Sprintf("statement %d (%s): %v", e.Index, location, e.Err)
+}
+
+func (e *StatementError) Unwrap() error {
+	return e.Err
+}
+
+// MigrationError is a migration that failed to apply. When the failure was
+// a statement's, Statement, Line, Column and SQLState locate it in File as
+// a StatementError does.
+type MigrationError struct {
+	Version     string
+	Description string
+	File        string
+	Statement   int
+	Line        int
+	Column      int
+	SQLState    string
+	Err         error
+}
+
+func newMigrationError(migration Migration, err error) *MigrationError {
+	e := &MigrationError{Version: migration.Version, Description: migration.Description, File: migration.Path, Err: err}
+	var statement *StatementError
+	if errors.As(err, &statement) {
+		e.Statement, e.Line, e.Column, e.SQLState = statement.Index, statement.Line, statement.Column, statement.SQLState
+	}
+	return e
+}
+
+func (e *MigrationError) Error() string {
+	if e.File != "" {
+		return fmt.Sprintf("migration %s (%s) in %s: %v", e.Version, e.Description, filepath.Base(e.File), e.Err)
+	}
+	return fmt.Sprintf("migration %s (%s): %v", e.Version, e.Description, e.Err)
+}
+
+func (e *MigrationError) Unwrap() error {
+	return e.Err
+}
+
+func (e *MigrationError) Is(target error) bool {
+	return target == ErrMigrationFailed
+}
+
+// Classes of failures. Transient failures, such as a lost connection, a
+// lock held by another run or a deadlock, may succeed when retried;
+// permanent ones, such as a syntax error or a violated constraint, will
+// not.
+const (
+	ErrorTransient = "transient"
+	ErrorPermanent = "permanent"
+)
+
+// transientSQLStates are the SQLSTATE codes and classes of errors that may
+// go away by themselves.
+var transientSQLStates = []string{
+	"08",    // connection exception
+	"40001", // serialization_failure
+	"40P01", // deadlock_detected
+	"53",    // insufficient resources
+	"55P03", // lock_not_available
+	"57014", // query_canceled, as by statement_timeout
+	"57P01", // admin_shutdown
+	"57P02", // crash_shutdown
+	"57P03", // cannot_connect_now
+	"58",    // system error
+}
+
+// ClassifyError returns ErrorTransient or ErrorPermanent for a failure, or
+// "" for nil.
+func ClassifyError(err error) string {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, ErrConnectionFailed) || errors.Is(err, ErrDatabaseLocked) || errors.Is(err, ErrRunLocked) ||
+		errors.Is(err, context.DeadlineExceeded) || errors.Is(err, driver.ErrBadConn) {
+		return ErrorTransient
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return ErrorTransient
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		for _, state := range transientSQLStates {
+			if strings.HasPrefix(string(pqErr.Code), state) {
+				return ErrorTransient
+			}
+		}
+	}
+	return ErrorPermanent
+}
@@ -0,0 +1,449 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// migrateDatabase applies the pending migrations of target to database,
+// which is the target's own database except when rehearsing on a clone of
+// it.
+func migrateDatabase(ctx context.Context, config Configuration, migrations []Migration, target Target, database string) MigrationResult {
+	result := MigrationResult{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema}
+	settings := config.settingsFor(target)
+
+	// Connect to the database
+	db, err := connectToDatabase(ctx, target.Cluster, database)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	defer db.Close()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	defer conn.Close()
+
+	if target.Schema != "" {
+		err = lockSchema(ctx, conn, target.Schema)
+	} else {
+		err = lockDatabase(ctx, conn)
+	}
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	if target.Schema != "" {
+		defer unlockSchema(conn, target.Schema)
+	} else {
+		defer unlockDatabase(conn)
+	}
+
+	if err := applySessionSettings(ctx, conn, settings); err != nil {
+		result.Error = err
+		return result
+	}
+	if err := lookupVariables(ctx, config, target, conn, &settings); err != nil {
+		result.Error = err
+		return result
+	}
+
+	if err := ensureHistoryTable(ctx, conn); err != nil {
+		result.Error = fmt.Errorf("create history table: %w", err)
+		return result
+	}
+	applied, err := appliedMigrations(ctx, conn, migrations)
+	if err != nil {
+		result.Error = fmt.Errorf("read history: %w", err)
+		return result
+	}
+	if err := verifyChecksums(migrations, applied, config.Checksum); err != nil {
+		result.Error = err
+		return result
+	}
+
+	var before Schema
+	if config.SchemaDiff {
+		if before, err = introspectSchema(ctx, conn); err != nil {
+			result.Error = fmt.Errorf("read schema: %w", err)
+			return result
+		}
+		result.SchemaBefore = before.Fingerprint()
+	}
+
+	result.Extensions, err = ensureExtensions(ctx, conn, config.Extensions)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+
+	data := templateData{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema, Vars: settings.Variables}
+	sessionTarget := Target{Cluster: target.Cluster, Database: database}
+	if config.SessionScripts != nil {
+		if err := runSessionScript(ctx, conn, sessionTarget, config.SessionScripts.Before, data, settings); err != nil {
+			result.Error = err
+			return result
+		}
+	}
+	bootstrapped, err := bootstrapDatabase(ctx, conn, config.Bootstrap, migrations, applied, data)
+	if err != nil {
+		result.Error = fmt.Errorf("bootstrap from snapshot: %w", err)
+		return result
+	}
+	if bootstrapped {
+		result.Bootstrapped = config.Bootstrap.Version
+	}
+	refreshes := append([]RefreshConfig(nil), config.RefreshMaterializedViews...)
+	pending := pendingMigrations(migrations, applied, settings.TargetVersion)
+	features, err := enabledFeatures(ctx, config, target, conn, pending)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	selected := func(migration Migration, done []string) (bool, error) {
+		if !config.contextSelected(migration.Contexts) || !config.labelsSelected(migration.Labels) ||
+			!featuresEnabled(migration, features) || !dependenciesApplied(migration, applied, done) {
+			return false, nil
+		}
+		skip, err := checkPreconditions(ctx, conn, target, migration, data)
+		return !skip, err
+	}
+	record := func(migration Migration) {
+		result.Applied = append(result.Applied, migration.Version)
+		// Directives were validated when the migrations were loaded.
+		viewRefreshes, _ := scriptRefreshes(migration.Script)
+		refreshes = append(refreshes, viewRefreshes...)
+	}
+	apply := func(migration Migration) error {
+		transactional := !settings.NoTransaction && !migration.NoTransaction
+		err := applyWithPolicies(ctx, settings.ErrorPolicies, []Migration{migration}, transactional, func() error {
+			return applyMigration(ctx, conn, Target{Cluster: target.Cluster, Database: database}, migration, data, settings)
+		})
+		if err != nil {
+			return newMigrationError(migration, err)
+		}
+		record(migration)
+		return nil
+	}
+	for i := 0; i < len(pending); i++ {
+		migration := pending[i]
+		ok, err := selected(migration, result.Applied)
+		if err != nil {
+			result.Error = newMigrationError(migration, err)
+			return result
+		}
+		if !ok {
+			result.Skipped = append(result.Skipped, migration.Version)
+			continue
+		}
+		if migration.Release == "" {
+			if err := apply(migration); err != nil {
+				result.Error = err
+				return result
+			}
+			continue
+		}
+
+		// The following migrations of the release join it.
+		release := []Migration{migration}
+		done := append([]string{migration.Version}, result.Applied...)
+		for i+1 < len(pending) && pending[i+1].Release == migration.Release {
+			i++
+			ok, err := selected(pending[i], done)
+			if err != nil {
+				result.Error = newMigrationError(pending[i], err)
+				return result
+			}
+			if !ok {
+				result.Skipped = append(result.Skipped, pending[i].Version)
+				continue
+			}
+			release = append(release, pending[i])
+			done = append(done, pending[i].Version)
+		}
+		if len(release) > 1 && transactionalRelease(release, settings) {
+			err := applyWithPolicies(ctx, settings.ErrorPolicies, release, true, func() error {
+				return applyRelease(ctx, conn, Target{Cluster: target.Cluster, Database: database}, release, data, settings)
+			})
+			if err != nil {
+				result.Error = fmt.Errorf("release %s: %w", migration.Release, err)
+				return result
+			}
+			for _, migration := range release {
+				record(migration)
+			}
+			continue
+		}
+		if len(release) > 1 {
+			log.Printf("WARNING: release %s has migrations running outside a transaction; applying them one at a time", migration.Release)
+		}
+		for _, migration := range release {
+			if err := apply(migration); err != nil {
+				result.Error = err
+				return result
+			}
+		}
+	}
+
+	result.Repeated, err = applyRepeatables(ctx, conn, config.Flyway, data)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	if config.SessionScripts != nil {
+		if err := runSessionScript(ctx, conn, sessionTarget, config.SessionScripts.After, data, settings); err != nil {
+			result.Error = err
+			return result
+		}
+	}
+
+	// Materialized views are only refreshed when the schema changed.
+	if len(result.Applied) > 0 || len(result.Repeated) > 0 {
+		result.Refreshed, err = refreshViews(ctx, conn, refreshes)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+	}
+
+	if config.Sequences != "" {
+		result.Sequences, err = checkSequences(ctx, conn, config.Sequences == "fix")
+		if err != nil {
+			result.Error = fmt.Errorf("check sequences: %w", err)
+			return result
+		}
+	}
+
+	if err := applyAccess(ctx, conn, config.Access); err != nil {
+		result.Error = err
+		return result
+	}
+
+	if config.HistoryExport != "" {
+		if _, err := exportHistory(ctx, conn, config.HistoryExport); err != nil {
+			result.Error = fmt.Errorf("export history: %w", err)
+			return result
+		}
+	}
+
+	if config.SchemaDiff || config.SnapshotDir != "" {
+		after, err := introspectSchema(ctx, conn)
+		if err != nil {
+			result.Error = fmt.Errorf("read schema: %w", err)
+			return result
+		}
+		if config.SchemaDiff {
+			result.SchemaAfter = after.Fingerprint()
+			result.SchemaChanges = DiffSchemas(before, after)
+		}
+		if config.SnapshotDir != "" {
+			if err := writeSnapshot(snapshotDir(config.SnapshotDir, target), after); err != nil {
+				result.Error = fmt.Errorf("write schema snapshot: %w", err)
+				return result
+			}
+		}
+	}
+
+	if config.Diagram != nil && (config.Diagram.Database == "" || MatchDatabase(config.Diagram.Database, target.Database)) {
+		if err := writeDiagram(ctx, conn, config.Diagram, target); err != nil {
+			result.Error = fmt.Errorf("write diagram: %w", err)
+			return result
+		}
+	}
+
+	// If migration succeeded
+	result.Success = true
+	return result
+}
+
+// pendingMigrations returns the migrations not yet applied, up to and
+// including targetVersion when it is set, in the order of their
+// dependencies.
+func pendingMigrations(migrations []Migration, applied map[string]AppliedMigration, targetVersion string) []Migration {
+	var pending []Migration
+	for _, migration := range migrations {
+		if targetVersion != "" && compareVersions(migration.Version, targetVersion) > 0 {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			pending = append(pending, migration)
+		}
+	}
+	return orderByDependencies(pending)
+}
+
+// applySessionSettings sets the session timeouts for a migration connection
+// and, in schema-per-tenant mode, its search path.
+func applySessionSettings(ctx context.Context, conn *sql.Conn, settings DatabaseSettings) error {
+	if settings.Schema != "" {
+		if err := setSearchPath(ctx, conn, settings.Schema); err != nil {
+			return fmt.Errorf("set search_path: %w", err)
+		}
+	}
+	if settings.StatementTimeout != "" {
+		if _, err := conn.ExecContext(ctx, "SET statement_timeout = "+pq.QuoteLiteral(settings.StatementTimeout)); err != nil {
+			return fmt.Errorf("set statement_timeout: %w", err)
+		}
+	}
+	if settings.LockTimeout != "" {
+		if _, err := conn.ExecContext(ctx, "SET lock_timeout = "+pq.QuoteLiteral(settings.LockTimeout)); err != nil {
+			return fmt.Errorf("set lock_timeout: %w", err)
+		}
+	}
+	return nil
+}
+
+// applyMigration renders and executes a single migration and records it in
+// the history table. Unless the database runs without transactions, the
+// statements and the history row are committed together.
+func applyMigration(ctx context.Context, conn *sql.Conn, target Target, migration Migration, data templateData, settings DatabaseSettings) error {
+	script, err := renderScript(migration, data)
+	if err != nil {
+		return fmt.Errorf("render: %w", err)
+	}
+	repacks, err := scriptRepacks(script)
+	if err != nil {
+		return err
+	}
+
+	started := time.Now()
+	if settings.NoTransaction || migration.NoTransaction {
+		if err := runMigrationHooks(ctx, conn, settings.MigrationHooks, false, migration, data); err != nil {
+			return err
+		}
+		if err := executeScript(ctx, conn, target, migration, script, settings); err != nil {
+			return err
+		}
+		for _, r := range repacks {
+			if err := runRepack(ctx, target.Cluster, target.Database, target.Schema, r); err != nil {
+				return err
+			}
+		}
+		if err := runMigrationHooks(ctx, conn, settings.MigrationHooks, true, migration, data); err != nil {
+			return err
+		}
+		return recordApplied(ctx, conn, migration, time.Since(started).Milliseconds(), settings)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := runMigrationHooks(ctx, tx, settings.MigrationHooks, false, migration, data); err != nil {
+		return err
+	}
+	if err := executeScript(ctx, tx, target, migration, script, settings); err != nil {
+		return err
+	}
+	if err := runMigrationHooks(ctx, tx, settings.MigrationHooks, true, migration, data); err != nil {
+		return err
+	}
+	if err := recordApplied(ctx, tx, migration, time.Since(started).Milliseconds(), settings); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// recordApplied records an applied migration in the history table, and in
+// flyway_schema_history when it is kept up to date, on conn, so that both
+// rows are written in the migration's transaction when it has one.
+func recordApplied(ctx context.Context, conn execer, migration Migration, executionMs int64, settings DatabaseSettings) error {
+	if err := recordMigration(ctx, conn, migration, executionMs); err != nil {
+		return err
+	}
+	if settings.FlywayHistory {
+		return recordFlywayHistory(ctx, conn, migration, executionMs)
+	}
+	return nil
+}
+
+// removeApplied removes a reverted migration from the history table, and
+// from flyway_schema_history when it is kept up to date, on conn, so that
+// both rows go in the revert's transaction when it has one.
+func removeApplied(ctx context.Context, conn queryExecer, version string, settings DatabaseSettings) error {
+	if _, err := conn.ExecContext(ctx, `DELETE FROM `+historyTable+` WHERE version = $1`, version); err != nil {
+		return err
+	}
+	if settings.FlywayHistory {
+		return removeFlywayHistory(ctx, conn, version)
+	}
+	return nil
+}
+
+// executeScript executes the rendered script of a migration on conn, which
+// is a transaction unless the migration runs outside one, and maintains the
+// partitions its directives ask for.
+func executeScript(ctx context.Context, conn queryExecer, target Target, migration Migration, script string, settings DatabaseSettings) error {
+	partitions, err := scriptPartitions(script)
+	if err != nil {
+		return err
+	}
+	parallel, err := scriptParallel(script)
+	if err != nil {
+		return err
+	}
+	session := func(conn queryExecer, tx bool) queryExecer {
+		conn = withIgnorePolicies(conn, settings.ErrorPolicies, migration, tx)
+		if target.Cluster.EchoSQL {
+			conn = echoExecer{queryExecer: conn, prefix: target.Cluster.Name + "/" + target.Database}
+		}
+		return conn
+	}
+	_, tx := conn.(*sql.Tx)
+	var open sessionOpener
+	if parallel && !tx {
+		pool := &sessionPool{target: target, settings: settings}
+		defer pool.close()
+		open = func(ctx context.Context) (queryExecer, func(), error) {
+			conn, err := pool.conn(ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+			return session(conn, false), func() { conn.Close() }, nil
+		}
+	}
+	if err := executeWithDependents(ctx, session(conn, tx), script, splitStatements(script), settings.DefinitionsDir, open); err != nil {
+		return err
+	}
+	for _, p := range partitions {
+		if _, err := maintainPartitions(ctx, conn, p, time.Now()); err != nil {
+			return fmt.Errorf("partitions of %s: %w", p.Table, err)
+		}
+	}
+	return nil
+}
+
+// executeStatements executes the statements of a migration one by one,
+// running those marked with a backfill directive in batches.
+func executeStatements(ctx context.Context, conn execer, statements []Statement) error {
+	for i, statement := range statements {
+		if err := executeStatement(ctx, conn, statement); err != nil {
+			return newStatementError(i+1, statement, err)
+		}
+	}
+	return nil
+}
+
+// executeStatement executes a single statement, in batches when it is
+// marked with a backfill directive.
+func executeStatement(ctx context.Context, conn execer, statement Statement) error {
+	backfill, err := statementBackfill(statement.SQL)
+	if err != nil {
+		return err
+	}
+	if backfill != nil {
+		return runBackfill(ctx, conn, statement, backfill)
+	}
+	_, err = conn.ExecContext(ctx, statement.SQL)
+	return err
+}
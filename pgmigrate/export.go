@@ -0,0 +1,100 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// exportHistory writes the history of the database in the format of
+// another migration tool.
+func exportHistory(ctx context.Context, conn *sql.Conn, format string) (string, error) {
+	switch format {
+	case FormatGolangMigrate:
+		return exportGolangMigrate(ctx, conn)
+	}
+	return "", fmt.Errorf("unknown export format %q", format)
+}
+
+// exportGolangMigrate records the latest applied version in golang-migrate's
+// schema_migrations table, creating it if needed, so that tooling reading
+// that table keeps working. It returns the version written, or "" when no
+// migration was applied.
+func exportGolangMigrate(ctx context.Context, conn *sql.Conn) (string, error) {
+	applied, err := appliedMigrations(ctx, conn, nil)
+	if err != nil {
+		return "", fmt.Errorf("read history: %w", err)
+	}
+	var latest string
+	for version := range applied {
+		if latest == "" || compareVersions(version, latest) > 0 {
+			latest = version
+		}
+	}
+	if latest == "" {
+		return "", nil
+	}
+	version, err := strconv.ParseInt(latest, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("version %s cannot be written to schema_migrations, which holds integer versions", latest)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+	for _, stmt := range []string{
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)",
+		"DELETE FROM schema_migrations",
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return "", err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", version); err != nil {
+		return "", err
+	}
+	return latest, tx.Commit()
+}
+
+// ExportHistory writes the history of each database in the format of
+// another migration tool; only golang-migrate's schema_migrations is
+// supported. Set HistoryExport to keep it up to date on every run instead.
+func (m *Migrator) ExportHistory(ctx context.Context, targets []Target, format string) []MaintenanceResult {
+	return forEachTarget(ctx, m.config, targets, func(ctx context.Context, target Target) MaintenanceResult {
+		result := MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database}
+		db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		defer db.Close()
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		defer conn.Close()
+		if err := applySessionSettings(ctx, conn, m.config.settingsFor(target)); err != nil {
+			result.Error = err
+			return result
+		}
+		if err := ensureHistoryTable(ctx, conn); err != nil {
+			result.Error = fmt.Errorf("create history table: %w", err)
+			return result
+		}
+		version, err := exportHistory(ctx, conn, format)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		if version != "" {
+			result.Changes = append(result.Changes, "exported version "+version)
+		}
+		return result
+	}, func(target Target, err error) MaintenanceResult {
+		return MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database, Error: err}
+	})
+}
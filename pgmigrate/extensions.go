@@ -0,0 +1,101 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// ExtensionConfig declares an extension every migrated database must have.
+type ExtensionConfig struct {
+	Name string `json:"name"`
+
+	// Version is the version the extension is created with or updated to.
+	// Empty means the server's default version, and an installed extension
+	// is left as it is.
+	Version string `json:"version"`
+
+	// Schema is the schema the extension is created in. It does not move
+	// an installed extension.
+	Schema string `json:"schema"`
+}
+
+func (e ExtensionConfig) validate() error {
+	if e.Name == "" {
+		return errors.New("name is required")
+	}
+	return nil
+}
+
+// ensureExtensions creates the declared extensions that are missing and
+// updates those installed at another version than declared. It returns a
+// description of each change made.
+func ensureExtensions(ctx context.Context, conn *sql.Conn, extensions []ExtensionConfig) ([]string, error) {
+	var changes []string
+	for _, extension := range extensions {
+		change, err := ensureExtension(ctx, conn, extension)
+		if err != nil {
+			return changes, fmt.Errorf("extension %s: %w", extension.Name, err)
+		}
+		if change != "" {
+			changes = append(changes, change)
+		}
+	}
+	return changes, nil
+}
+
+func ensureExtension(ctx context.Context, conn *sql.Conn, extension ExtensionConfig) (string, error) {
+	var available bool
+	err := conn.QueryRowContext(ctx,
+		`SELECT EXISTS (SELECT 1 FROM pg_available_extension_versions WHERE name = $1 AND ($2 = '' OR version = $2))`,
+		extension.Name, extension.Version).Scan(&available)
+	if err != nil {
+		return "", err
+	}
+
+	var installed string
+	err = conn.QueryRowContext(ctx, `SELECT extversion FROM pg_extension WHERE extname = $1`, extension.Name).Scan(&installed)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if !available {
+			return "", notAvailable(extension)
+		}
+		query := "CREATE EXTENSION IF NOT EXISTS " + pq.QuoteIdentifier(extension.Name)
+		if extension.Schema != "" {
+			query += " SCHEMA " + pq.QuoteIdentifier(extension.Schema)
+		}
+		if extension.Version != "" {
+			query += " VERSION " + pq.QuoteLiteral(extension.Version)
+		}
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return "", err
+		}
+		if extension.Version == "" {
+			return fmt.Sprintf("created %s", extension.Name), nil
+		}
+		return fmt.Sprintf("created %s %s", extension.Name, extension.Version), nil
+	case err != nil:
+		return "", err
+	case extension.Version == "" || installed == extension.Version:
+		return "", nil
+	}
+
+	if !available {
+		return "", notAvailable(extension)
+	}
+	query := "ALTER EXTENSION " + pq.QuoteIdentifier(extension.Name) + " UPDATE TO " + pq.QuoteLiteral(extension.Version)
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("updated %s %s -> %s", extension.Name, installed, extension.Version), nil
+}
+
+func notAvailable(extension ExtensionConfig) error {
+	if extension.Version == "" {
+		return errors.New("not available on the server; install its package on the database host")
+	}
+	return fmt.Errorf("version %s is not available on the server; install its package on the database host", extension.Version)
+}
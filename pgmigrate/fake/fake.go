@@ -0,0 +1,97 @@
+// Package fake provides in-memory implementations of the pgmigrate
+// interfaces, for unit testing programs that embed the Migrator without a
+// PostgreSQL server:
+//
+//	executor := &fake.Executor{}
+//	migrator, err := pgmigrate.New(pgmigrate.Configuration{
+//		Clusters: []pgmigrate.ClusterConfig{{Name: "main"}},
+//		Lister:   fake.Lister{"main": {"tenant_a", "tenant_b"}},
+//		Source:   fake.Source{{Version: "1", Description: "create users", Script: "CREATE TABLE users ();"}},
+//		Executor: executor,
+//	})
+package fake
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// Lister lists the databases of each cluster by cluster name. Clusters
+// missing from the map fail to be listed.
+type Lister map[string][]string
+
+// ListDatabases implements pgmigrate.DatabaseLister.
+func (l Lister) ListDatabases(ctx context.Context, cluster pgmigrate.ClusterConfig) ([]string, error) {
+	databases, ok := l[cluster.Name]
+	if !ok {
+		return nil, fmt.Errorf("unknown cluster %q", cluster.Name)
+	}
+	return databases, nil
+}
+
+// Source provides a fixed set of migrations.
+type Source []pgmigrate.Migration
+
+// LoadMigrations implements pgmigrate.ScriptSource.
+func (s Source) LoadMigrations() ([]pgmigrate.Migration, error) {
+	return append([]pgmigrate.Migration(nil), s...), nil
+}
+
+// Executor records the databases it is asked to migrate and reports every
+// migration as applied, except on the databases given an error.
+type Executor struct {
+	// Errors fails the databases named in it, keyed by database name.
+	Errors map[string]error
+
+	mu      sync.Mutex
+	targets []pgmigrate.Target
+}
+
+// Execute implements pgmigrate.Executor.
+func (e *Executor) Execute(ctx context.Context, target pgmigrate.Target, migrations []pgmigrate.Migration) pgmigrate.MigrationResult {
+	e.mu.Lock()
+	e.targets = append(e.targets, target)
+	e.mu.Unlock()
+
+	result := pgmigrate.MigrationResult{Cluster: target.Cluster.Name, Database: target.Database}
+	if err := e.Errors[target.Database]; err != nil {
+		result.Error = err
+		return result
+	}
+	for _, migration := range migrations {
+		result.Applied = append(result.Applied, migration.Version)
+	}
+	result.Success = true
+	return result
+}
+
+// Targets returns the databases migrated so far.
+func (e *Executor) Targets() []pgmigrate.Target {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]pgmigrate.Target(nil), e.targets...)
+}
+
+// Reporter collects the results reported to it.
+type Reporter struct {
+	mu      sync.Mutex
+	results []pgmigrate.MigrationResult
+}
+
+// Report implements pgmigrate.Reporter.
+func (r *Reporter) Report(result pgmigrate.MigrationResult) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, result)
+}
+
+// Results returns the results reported so far, in the order they were
+// reported.
+func (r *Reporter) Results() []pgmigrate.MigrationResult {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]pgmigrate.MigrationResult(nil), r.results...)
+}
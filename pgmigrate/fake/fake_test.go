@@ -0,0 +1,80 @@
+package fake
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"testing"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+func TestRun(t *testing.T) {
+	executor := &Executor{Errors: map[string]error{"tenant_b": errors.New("connection refused")}}
+	reporter := &Reporter{}
+	migrator, err := pgmigrate.New(pgmigrate.Configuration{
+		Clusters: []pgmigrate.ClusterConfig{{Name: "main"}},
+		Lister:   Lister{"main": {"tenant_a", "tenant_b"}},
+		Source: Source{
+			{Version: "2", Description: "add email", Script: "ALTER TABLE users ADD COLUMN email text;"},
+			{Version: "1", Description: "create users", Script: "CREATE TABLE users (id bigint);"},
+		},
+		Executor: executor,
+		Reporter: reporter,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrator.Close()
+
+	results, err := migrator.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(results) != 2 {
+		t.Fatalf("got %d results, want 2", len(results))
+	}
+	for _, result := range results {
+		switch result.Database {
+		case "tenant_a":
+			if !result.Success || len(result.Applied) != 2 || result.Applied[0] != "1" || result.Applied[1] != "2" {
+				t.Errorf("tenant_a: success %v, applied %v, want 1 and 2 applied", result.Success, result.Applied)
+			}
+		case "tenant_b":
+			if result.Success || result.Error == nil {
+				t.Errorf("tenant_b: success %v, error %v, want the executor's error", result.Success, result.Error)
+			}
+		default:
+			t.Errorf("unexpected result for %s", result.Database)
+		}
+	}
+
+	var migrated []string
+	for _, target := range executor.Targets() {
+		migrated = append(migrated, target.Cluster.Name+"/"+target.Database)
+	}
+	sort.Strings(migrated)
+	if len(migrated) != 2 || migrated[0] != "main/tenant_a" || migrated[1] != "main/tenant_b" {
+		t.Errorf("executor migrated %v, want main/tenant_a and main/tenant_b", migrated)
+	}
+	if reported := reporter.Results(); len(reported) != 2 {
+		t.Errorf("reported %d results, want 2", len(reported))
+	}
+}
+
+func TestListerUnknownCluster(t *testing.T) {
+	migrator, err := pgmigrate.New(pgmigrate.Configuration{
+		Clusters: []pgmigrate.ClusterConfig{{Name: "other"}},
+		Lister:   Lister{"main": {"tenant_a"}},
+		Source:   Source{},
+		Executor: &Executor{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer migrator.Close()
+	if _, err := migrator.Targets(context.Background()); err == nil {
+		t.Error("listed the databases of a cluster missing from the Lister")
+	}
+}
@@ -0,0 +1,183 @@
+package pgmigrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// featureDirective gates a migration behind feature flags, e.g.
+//
+//	-- pgmigrate:feature new_billing
+//
+// The migration only applies to the databases for which every flag it names
+// is enabled. Elsewhere it is left unapplied and unrecorded, so that it
+// applies on a later run once the flag is enabled for the database.
+const featureDirective = "-- pgmigrate:feature"
+
+// FeatureConfig decides which feature flags are enabled for a database. A
+// flag is enabled when any of the sources below enables it.
+type FeatureConfig struct {
+	// Enabled lists the flags enabled for every database.
+	Enabled []string `json:"enabled"`
+
+	// Query returns the names of the flags enabled for a database, one per
+	// row, e.g. SELECT name FROM feature_flags WHERE enabled. It runs in
+	// the database itself unless Database names a control database, where
+	// it gets the name of the migrated database as $1.
+	Query    string `json:"query"`
+	Database string `json:"database"`
+	Cluster  string `json:"cluster"`
+
+	// URL is a flag service queried with the cluster and database
+	// parameters, e.g. https://flags.example.com/enabled, which returns
+	// the names of the flags enabled for the database as a JSON array.
+	// Services such as LaunchDarkly are reached through such an adapter.
+	URL string `json:"url"`
+
+	// Token is sent as a bearer token to URL. It may be a secret
+	// reference.
+	Token string `json:"token"`
+}
+
+func (c *FeatureConfig) validate() error {
+	if c.Database != "" && c.Query == "" {
+		return errors.New("database requires query")
+	}
+	if c.Cluster != "" && c.Database == "" {
+		return errors.New("cluster requires database")
+	}
+	if c.URL != "" {
+		if _, err := url.Parse(c.URL); err != nil {
+			return fmt.Errorf("url: %w", err)
+		}
+	}
+	return nil
+}
+
+// scriptFeatures returns the flags named by the feature directives of a
+// script.
+func scriptFeatures(script string) ([]string, error) {
+	var features []string
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, featureDirective) {
+			continue
+		}
+		names := strings.Fields(strings.TrimPrefix(line, featureDirective))
+		if len(names) == 0 {
+			return nil, errors.New("feature: flag name is required")
+		}
+		features = append(features, names...)
+	}
+	return features, nil
+}
+
+// enabledFeatures returns the feature flags enabled for target. It returns
+// nil without looking anything up when no migration is gated.
+func enabledFeatures(ctx context.Context, config Configuration, target Target, conn queryExecer, migrations []Migration) (map[string]bool, error) {
+	gated := false
+	for _, migration := range migrations {
+		if strings.Contains(migration.Script, featureDirective) {
+			gated = true
+			break
+		}
+	}
+	if !gated {
+		return nil, nil
+	}
+
+	enabled := make(map[string]bool)
+	features := config.Features
+	if features == nil {
+		return enabled, nil
+	}
+	for _, name := range features.Enabled {
+		enabled[name] = true
+	}
+
+	if features.Query != "" {
+		rows, done, err := lookupQuery(ctx, config, target, conn, features.Query, features.Database, features.Cluster)
+		if err != nil {
+			return nil, fmt.Errorf("features: %w", err)
+		}
+		defer done()
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				return nil, fmt.Errorf("features: %w", err)
+			}
+			enabled[name] = true
+		}
+		if err := rows.Err(); err != nil {
+			return nil, fmt.Errorf("features: %w", err)
+		}
+	}
+
+	if features.URL != "" {
+		names, err := fetchFeatures(ctx, features, target)
+		if err != nil {
+			return nil, fmt.Errorf("features: %w", err)
+		}
+		for _, name := range names {
+			enabled[name] = true
+		}
+	}
+	return enabled, nil
+}
+
+// fetchFeatures asks the flag service for the flags enabled for target.
+func fetchFeatures(ctx context.Context, config *FeatureConfig, target Target) ([]string, error) {
+	endpoint, err := url.Parse(config.URL)
+	if err != nil {
+		return nil, err
+	}
+	query := endpoint.Query()
+	query.Set("cluster", target.Cluster.Name)
+	query.Set("database", target.Database)
+	endpoint.RawQuery = query.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	token, err := resolveSecret(ctx, config.Token)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, fmt.Errorf("%s: %s %s", endpoint.Redacted(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	var names []string
+	if err := json.NewDecoder(resp.Body).Decode(&names); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// featuresEnabled reports whether every flag gating the migration is
+// enabled.
+func featuresEnabled(migration Migration, enabled map[string]bool) bool {
+	features, _ := scriptFeatures(migration.Script)
+	for _, name := range features {
+		if !enabled[name] {
+			return false
+		}
+	}
+	return true
+}
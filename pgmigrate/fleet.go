@@ -0,0 +1,128 @@
+package pgmigrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// discoveryBatch is the number of databases fetched per query when listing
+// the databases of a cluster.
+const discoveryBatch = 1000
+
+// DiscoveryCacheConfig caches the database list of each cluster on disk,
+// so that repeated runs against servers with tens of thousands of
+// databases skip listing them again.
+type DiscoveryCacheConfig struct {
+	// Dir holds one file per cluster.
+	Dir string `json:"dir"`
+	// MaxAge is how long a cached list is used, e.g. "15m". Defaults to
+	// one hour.
+	MaxAge string `json:"max_age"`
+}
+
+func (c *DiscoveryCacheConfig) validate() error {
+	if c.Dir == "" {
+		return errors.New("dir is required")
+	}
+	if c.MaxAge != "" {
+		if _, err := time.ParseDuration(c.MaxAge); err != nil {
+			return fmt.Errorf("invalid max_age %q", c.MaxAge)
+		}
+	}
+	return nil
+}
+
+func (c *DiscoveryCacheConfig) maxAge() time.Duration {
+	if c.MaxAge == "" {
+		return time.Hour
+	}
+	// Validated when the configuration was loaded.
+	d, _ := time.ParseDuration(c.MaxAge)
+	return d
+}
+
+// cachedDatabases lists the databases of a cluster with list, reading the
+// list from the cache while it is fresh and writing it there otherwise.
+// Failing to write the cache only logs a warning.
+func cachedDatabases(ctx context.Context, cache *DiscoveryCacheConfig, cluster ClusterConfig, list func(context.Context, ClusterConfig) ([]string, error)) ([]string, error) {
+	if cache == nil {
+		return list(ctx, cluster)
+	}
+	path := filepath.Join(cache.Dir, url.PathEscape(cluster.Name)+".json")
+	if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) < cache.maxAge() {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		var databases []string
+		if err := json.Unmarshal(data, &databases); err != nil {
+			return nil, fmt.Errorf("discovery cache %s: %w", path, err)
+		}
+		return databases, nil
+	}
+
+	databases, err := list(ctx, cluster)
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(databases)
+	if err == nil {
+		if err = os.MkdirAll(cache.Dir, 0o755); err == nil {
+			err = os.WriteFile(path, data, 0o644)
+		}
+	}
+	if err != nil {
+		log.Printf("WARNING: failed to cache the databases of %s: %v", cluster.Name, err)
+	}
+	return databases, nil
+}
+
+// parseShard parses a shard given as "i/n", the i-th of n slices of the
+// fleet counting from 1. An empty shard is the whole fleet.
+func parseShard(shard string) (index, count int, err error) {
+	if shard == "" {
+		return 0, 0, nil
+	}
+	i, n, ok := strings.Cut(shard, "/")
+	if ok {
+		index, err = strconv.Atoi(i)
+		if err == nil {
+			count, err = strconv.Atoi(n)
+		}
+	}
+	if !ok || err != nil || count < 1 || index < 1 || index > count {
+		return 0, 0, fmt.Errorf("invalid shard %q: expected i/n with 1 <= i <= n", shard)
+	}
+	return index, count, nil
+}
+
+// ShardTargets returns the targets of the i-th of n slices of the fleet,
+// shard being "i/n". Databases are assigned to slices by a hash of their
+// cluster and name, so that instances given the same fleet and different
+// shards split it between them without coordinating, and the tenant
+// schemas of a database stay together.
+func ShardTargets(targets []Target, shard string) ([]Target, error) {
+	index, count, err := parseShard(shard)
+	if err != nil || count <= 1 {
+		return targets, err
+	}
+	var selected []Target
+	for _, target := range targets {
+		h := fnv.New32a()
+		h.Write([]byte(target.Cluster.Name + "\x00" + target.Database))
+		if int(h.Sum32()%uint32(count)) == index-1 {
+			selected = append(selected, target)
+		}
+	}
+	return selected, nil
+}
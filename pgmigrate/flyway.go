@@ -0,0 +1,266 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// flywayFilePattern matches Flyway file names: versioned migrations such as
+// V1_1__create_users.sql, undo migrations such as U1_1__create_users.sql and
+// repeatable migrations such as R__refresh_views.sql.
+var flywayFilePattern = regexp.MustCompile(`^(?:([VU])(\d+(?:[._]\d+)*)|R)__(.+)\.sql$`)
+
+// flywayHistoryTable is Flyway's history table.
+const flywayHistoryTable = "flyway_schema_history"
+
+// repeatableTable records the checksum each repeatable migration was last
+// applied with.
+const repeatableTable = "pgmigrate_repeatable"
+
+// FlywayConfig switches the migration directory to Flyway's naming
+// conventions, for teams cutting over from Flyway gradually. Versioned
+// migrations are named V<version>__<description>.sql, with their undo
+// migration U<version>__<description>.sql, and repeatable migrations
+// R__<description>.sql are applied after the versioned ones whenever their
+// content changes. Flyway's history is imported with "import -format
+// flyway".
+type FlywayConfig struct {
+	// WriteHistory keeps flyway_schema_history up to date with the
+	// migrations this tool applies, so that Flyway and tooling reading its
+	// table keep working during the cut-over. The row is written in the
+	// migration's transaction, so both histories always agree.
+	WriteHistory bool `json:"write_history"`
+
+	// Repeatables are the repeatable migrations, loaded with the versioned
+	// ones.
+	Repeatables []Migration `json:"-"`
+}
+
+// LoadFlywayMigrations reads the Flyway-named migration scripts of the
+// migration directory: the versioned migrations ordered by version, and the
+// repeatable migrations ordered by description.
+func LoadFlywayMigrations(migrationDir string) (migrations, repeatables []Migration, err error) {
+	entries, err := os.ReadDir(migrationDir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seen := make(map[string]string)
+	undos := make(map[string]string)
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".sql" || isSessionScript(entry.Name()) {
+			continue
+		}
+		match := flywayFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, nil, fmt.Errorf("migration file %s does not match V<version>__<description>.sql or R__<description>.sql", entry.Name())
+		}
+		path := filepath.Join(migrationDir, entry.Name())
+		description := strings.ReplaceAll(match[3], "_", " ")
+		if match[1] == "" {
+			migration, err := loadMigration(path, "", description)
+			if err != nil {
+				return nil, nil, err
+			}
+			repeatables = append(repeatables, migration)
+			continue
+		}
+		version := canonicalVersion(strings.ReplaceAll(match[2], "_", "."))
+		if match[1] == "U" {
+			undos[version] = path
+			continue
+		}
+		if other, ok := seen[version]; ok {
+			return nil, nil, fmt.Errorf("migration files %s and %s share version %s", other, entry.Name(), version)
+		}
+		seen[version] = entry.Name()
+		migration, err := loadMigration(path, version, description)
+		if err != nil {
+			return nil, nil, err
+		}
+		migrations = append(migrations, migration)
+	}
+
+	for i, migration := range migrations {
+		path, ok := undos[migration.Version]
+		if !ok {
+			continue
+		}
+		delete(undos, migration.Version)
+		script, err := os.ReadFile(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		migrations[i].DownPath = path
+		migrations[i].DownScript = string(script)
+	}
+	for _, path := range undos {
+		return nil, nil, fmt.Errorf("undo migration %s has no migration of the same version", filepath.Base(path))
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return compareVersions(migrations[i].Version, migrations[j].Version) < 0
+	})
+	sort.Slice(repeatables, func(i, j int) bool {
+		return repeatables[i].Description < repeatables[j].Description
+	})
+	return migrations, repeatables, nil
+}
+
+// applyRepeatables applies the repeatable migrations that are new or whose
+// content changed since they were last applied, returning their
+// descriptions.
+func applyRepeatables(ctx context.Context, conn *sql.Conn, config *FlywayConfig, data templateData) ([]string, error) {
+	if config == nil || len(config.Repeatables) == 0 {
+		return nil, nil
+	}
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+repeatableTable+` (
+		description text PRIMARY KEY,
+		checksum    text NOT NULL,
+		applied_at  timestamptz NOT NULL DEFAULT now()
+	)`); err != nil {
+		return nil, fmt.Errorf("create repeatable table: %w", err)
+	}
+	rows, err := conn.QueryContext(ctx, `SELECT description, checksum FROM `+repeatableTable)
+	if err != nil {
+		return nil, err
+	}
+	checksums := make(map[string]string)
+	for rows.Next() {
+		var description, checksum string
+		if err := rows.Scan(&description, &checksum); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		checksums[description] = checksum
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var applied []string
+	for _, migration := range config.Repeatables {
+		if checksums[migration.Description] == migration.Checksum {
+			continue
+		}
+		if err := applyRepeatable(ctx, conn, config, migration, data); err != nil {
+			return applied, fmt.Errorf("repeatable migration %s: %w", migration.Description, err)
+		}
+		applied = append(applied, migration.Description)
+	}
+	return applied, nil
+}
+
+// applyRepeatable runs a repeatable migration and records its checksum in a
+// single transaction.
+func applyRepeatable(ctx context.Context, conn *sql.Conn, config *FlywayConfig, migration Migration, data templateData) error {
+	script, err := renderScript(migration, data)
+	if err != nil {
+		return fmt.Errorf("render: %w", err)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := executeStatements(ctx, tx, splitStatements(script)); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `INSERT INTO `+repeatableTable+` (description, checksum) VALUES ($1, $2)
+ON CONFLICT (description) DO UPDATE SET checksum = excluded.checksum, applied_at = now()`,
+		migration.Description, migration.Checksum); err != nil {
+		return err
+	}
+	if config.WriteHistory {
+		if err := recordFlywayHistory(ctx, tx, migration, 0); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// recordFlywayHistory appends a row for an applied migration to
+// flyway_schema_history, creating the table as Flyway would if needed.
+func recordFlywayHistory(ctx context.Context, conn execer, migration Migration, executionMs int64) error {
+	if _, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+flywayHistoryTable+` (
+		installed_rank integer PRIMARY KEY,
+		version        varchar(50),
+		description    varchar(200) NOT NULL,
+		type           varchar(20) NOT NULL,
+		script         varchar(1000) NOT NULL,
+		checksum       integer,
+		installed_by   varchar(100) NOT NULL,
+		installed_on   timestamp NOT NULL DEFAULT now(),
+		execution_time integer NOT NULL,
+		success        boolean NOT NULL
+	)`); err != nil {
+		return fmt.Errorf("create %s: %w", flywayHistoryTable, err)
+	}
+	var version interface{}
+	if migration.Version != "" {
+		version = migration.Version
+	}
+	_, err := conn.ExecContext(ctx, `INSERT INTO `+flywayHistoryTable+`
+	(installed_rank, version, description, type, script, checksum, installed_by, execution_time, success)
+SELECT COALESCE(max(installed_rank), 0) + 1, $1, $2, 'SQL', $3, $4, current_user, $5, true FROM `+flywayHistoryTable,
+		version, migration.Description, filepath.Base(migration.Path), flywayCRC32(migration.Script), executionMs)
+	if err != nil {
+		return fmt.Errorf("record in %s: %w", flywayHistoryTable, err)
+	}
+	return nil
+}
+
+// removeFlywayHistory deletes the rows of a reverted migration from
+// flyway_schema_history, if the table exists, so Flyway no longer counts
+// the migration as applied.
+func removeFlywayHistory(ctx context.Context, conn queryExecer, version string) error {
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, flywayHistoryTable).Scan(&exists); err != nil {
+		return fmt.Errorf("read %s: %w", flywayHistoryTable, err)
+	}
+	if !exists {
+		return nil
+	}
+	if _, err := conn.ExecContext(ctx, `DELETE FROM `+flywayHistoryTable+` WHERE version = $1`, version); err != nil {
+		return fmt.Errorf("remove from %s: %w", flywayHistoryTable, err)
+	}
+	return nil
+}
+
+// flywayVersion reads the highest version Flyway applied successfully,
+// refusing a history with failed migrations, which must be repaired first.
+func flywayVersion(ctx context.Context, conn *sql.Conn) (string, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, success FROM `+flywayHistoryTable+` WHERE version IS NOT NULL`)
+	if err != nil {
+		return "", fmt.Errorf("read %s: %w", flywayHistoryTable, err)
+	}
+	defer rows.Close()
+	var latest string
+	for rows.Next() {
+		var version string
+		var success bool
+		if err := rows.Scan(&version, &success); err != nil {
+			return "", err
+		}
+		if !success {
+			return "", fmt.Errorf("%s records a failed migration %s; run flyway repair first", flywayHistoryTable, version)
+		}
+		if latest == "" || compareVersions(version, latest) > 0 {
+			latest = version
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+	if latest == "" {
+		return "", nil
+	}
+	return canonicalVersion(latest), nil
+}
@@ -0,0 +1,147 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/oauth2/google"
+)
+
+// gcsAPI is the base URL of the Cloud Storage JSON API.
+const gcsAPI = "https://storage.googleapis.com/storage/v1"
+
+// gcsObject is an object of a Cloud Storage listing.
+type gcsObject struct {
+	Name       string `json:"name"`
+	Generation string `json:"generation"`
+	MD5Hash    string `json:"md5Hash"`
+}
+
+// fetchGCS downloads the objects under the prefix of a gs://bucket/prefix
+// URL to dir, keeping their paths below the prefix. Credentials come from
+// Application Default Credentials, such as the workload identity of the
+// runner. Objects are verified against their MD5 hash and cached by it in
+// the user cache directory, so that runs against an unchanged bundle
+// download nothing.
+func fetchGCS(ctx context.Context, u *url.URL, dir string) error {
+	client, err := google.DefaultClient(ctx, "https://www.googleapis.com/auth/devstorage.read_only")
+	if err != nil {
+		return err
+	}
+	bucket := u.Host
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	cache := ""
+	if base, err := os.UserCacheDir(); err == nil {
+		cache = filepath.Join(base, "pgmigrate", "gcs", bucket)
+	}
+
+	pageToken := ""
+	for {
+		query := url.Values{"prefix": {prefix}}
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		var page struct {
+			Items         []gcsObject `json:"items"`
+			NextPageToken string      `json:"nextPageToken"`
+		}
+		if err := gcsGet(ctx, client, gcsAPI+"/b/"+url.PathEscape(bucket)+"/o?"+query.Encode(), func(r io.Reader) error {
+			return json.NewDecoder(r).Decode(&page)
+		}); err != nil {
+			return err
+		}
+		for _, object := range page.Items {
+			if strings.HasSuffix(object.Name, "/") {
+				continue
+			}
+			if err := fetchGCSObject(ctx, client, bucket, object, cache, dir, strings.TrimPrefix(object.Name, prefix)); err != nil {
+				return fmt.Errorf("%s: %w", object.Name, err)
+			}
+		}
+		if page.NextPageToken == "" {
+			return nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// fetchGCSObject writes an object to name under dir, from the cache when it
+// holds the object's content and from the bucket otherwise.
+func fetchGCSObject(ctx context.Context, client *http.Client, bucket string, object gcsObject, cache, dir, name string) error {
+	sum, err := base64.StdEncoding.DecodeString(object.MD5Hash)
+	if err != nil || len(sum) != md5.Size {
+		// Composite objects have no MD5 hash and are neither verified nor
+		// cached.
+		sum = nil
+	}
+	cached := ""
+	if cache != "" && sum != nil {
+		cached = filepath.Join(cache, hex.EncodeToString(sum))
+		if f, err := os.Open(cached); err == nil {
+			defer f.Close()
+			return writeFetchedFile(dir, name, f)
+		}
+	}
+
+	query := url.Values{"alt": {"media"}, "generation": {object.Generation}}
+	endpoint := gcsAPI + "/b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(object.Name) + "?" + query.Encode()
+	if err := gcsGet(ctx, client, endpoint, func(r io.Reader) error {
+		h := md5.New()
+		if err := writeFetchedFile(dir, name, io.TeeReader(r, h)); err != nil {
+			return err
+		}
+		if sum != nil && !bytes.Equal(h.Sum(nil), sum) {
+			return errors.New("MD5 mismatch")
+		}
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if cached != "" {
+		// The cache only saves downloads, so failing to fill it is ignored.
+		if err := os.MkdirAll(cache, 0o755); err == nil {
+			if data, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name))); err == nil {
+				tmp := cached + ".tmp"
+				if os.WriteFile(tmp, data, 0o644) == nil {
+					os.Rename(tmp, cached)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// gcsGet issues a GET request to the Cloud Storage JSON API and reads the
+// body of a successful response with read.
+func gcsGet(ctx context.Context, client *http.Client, endpoint string, read func(io.Reader) error) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return read(resp.Body)
+}
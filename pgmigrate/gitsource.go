@@ -0,0 +1,109 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// fetchGit checks out a git repository to dir at the ref and commit of the
+// fragment of a git+https, git+ssh or git+file URL, e.g.
+//
+//	git+https://github.com/acme/schema.git#ref=v1.4.0&commit=3f2c1e...&dir=migrations
+//
+// ref is a branch or tag, fetched with a shallow clone. commit is the full
+// hash of the reviewed commit: the checkout is refused unless ref resolves
+// to it, and it is fetched directly when ref is omitted. One of them is
+// required. The git command runs with the credentials of the environment.
+func fetchGit(ctx context.Context, u *url.URL, dir string) error {
+	options, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return fmt.Errorf("invalid fragment: %w", err)
+	}
+	ref, commit := options.Get("ref"), strings.ToLower(options.Get("commit"))
+	if ref == "" && commit == "" {
+		return errors.New("git source requires a ref or commit in the URL fragment")
+	}
+	if commit != "" && !isCommitHash(commit) {
+		return fmt.Errorf("invalid commit %q: expected a full commit hash", commit)
+	}
+	if ref != "" {
+		if err := checkGitRef(ctx, ref); err != nil {
+			return err
+		}
+	}
+	remote := *u
+	remote.Scheme = strings.TrimPrefix(u.Scheme, "git+")
+	remote.Fragment = ""
+	if ref == "" {
+		ref = commit
+	}
+
+	git := func(args ...string) (string, error) {
+		cmd := exec.CommandContext(ctx, "git", append([]string{"-C", dir}, args...)...)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+	if _, err := git("init", "--quiet"); err != nil {
+		return err
+	}
+	if _, err := git("fetch", "--quiet", "--depth", "1", "--", remote.String(), ref); err != nil {
+		return err
+	}
+	if _, err := git("checkout", "--quiet", "--detach", "FETCH_HEAD"); err != nil {
+		return err
+	}
+	head, err := git("rev-parse", "HEAD")
+	if err != nil {
+		return err
+	}
+	if commit != "" && head != commit {
+		return fmt.Errorf("%s is at commit %s, expected %s", ref, head, commit)
+	}
+	log.Printf("Fetched migrations from %s at %s (%s)", remote.Redacted(), ref, head)
+	// The repository metadata is no migration, and must not be loaded as
+	// one by migration_glob.
+	return os.RemoveAll(filepath.Join(dir, ".git"))
+}
+
+// checkGitRef checks that ref is a branch or tag name git accepts, so that
+// a ref from the URL is never taken for an option of git fetch.
+func checkGitRef(ctx context.Context, ref string) error {
+	if strings.HasPrefix(ref, "-") {
+		return fmt.Errorf("invalid ref %q", ref)
+	}
+	if err := exec.CommandContext(ctx, "git", "check-ref-format", "--allow-onelevel", ref).Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("invalid ref %q", ref)
+		}
+		return fmt.Errorf("git check-ref-format: %w", err)
+	}
+	return nil
+}
+
+// isCommitHash reports whether s is a full SHA-1 or SHA-256 commit hash in
+// lower case.
+func isCommitHash(s string) bool {
+	if len(s) != 40 && len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,57 @@
+package pgmigrate
+
+import (
+	"context"
+	"net/url"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestFetchGitRejectsRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	for _, fragment := range []string{
+		"ref=--upload-pack=touch%20/tmp/pwned",
+		"ref=-b",
+		"ref=main..other",
+		"ref=v1%20main",
+		"commit=--upload-pack=x",
+		"commit=3f2c1e",
+		"ref=main&commit=" + "G000000000000000000000000000000000000000",
+	} {
+		u := &url.URL{Scheme: "git+file", Path: "/nonexistent/repository.git", Fragment: fragment}
+		// The repository does not exist, so any error from running git
+		// means the fragment got past validation.
+		if err := fetchGit(context.Background(), u, t.TempDir()); err == nil || strings.HasPrefix(err.Error(), "git ") {
+			t.Errorf("%s: got %v, want the fragment rejected", fragment, err)
+		}
+	}
+}
+
+func TestCheckGitRef(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	for _, test := range []struct {
+		ref string
+		ok  bool
+	}{
+		{"main", true},
+		{"v1.4.0", true},
+		{"release/2024.06", true},
+		{"refs/tags/v1", true},
+		{"-b", false},
+		{"--upload-pack=evil", false},
+		{"a..b", false},
+		{"a b", false},
+		{"a~1", false},
+		{"a:b", false},
+		{"", false},
+	} {
+		if err := checkGitRef(context.Background(), test.ref); (err == nil) != test.ok {
+			t.Errorf("checkGitRef(%q) = %v, want ok %v", test.ref, err, test.ok)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package pgmigrate
+
+import (
+	"strings"
+)
+
+// goose annotations, which let goose migration trees be used unmodified:
+//
+//	-- +goose Up
+//	-- +goose StatementBegin
+//	CREATE FUNCTION ...;
+//	-- +goose StatementEnd
+//	-- +goose Down
+//	DROP FUNCTION ...;
+//
+// The Down section becomes the migration's down migration, statements
+// between StatementBegin and StatementEnd are run as one and NO TRANSACTION
+// runs the migration outside a transaction.
+const (
+	gooseUp             = "-- +goose Up"
+	gooseDown           = "-- +goose Down"
+	gooseStatementBegin = "-- +goose StatementBegin"
+	gooseStatementEnd   = "-- +goose StatementEnd"
+	gooseNoTransaction  = "-- +goose NO TRANSACTION"
+)
+
+// gooseSections splits a script with goose annotations into its Up and
+// Down sections. The Up section keeps everything before the Down
+// annotation, so that line numbers still match the file. ok is false for
+// scripts without an Up annotation.
+func gooseSections(script string) (up, down string, ok bool) {
+	if !hasAnnotation(script, gooseUp) {
+		return script, "", false
+	}
+	offset := 0
+	for _, line := range strings.SplitAfter(script, "\n") {
+		if strings.TrimSpace(line) == gooseDown {
+			return script[:offset], script[offset+len(line):], true
+		}
+		offset += len(line)
+	}
+	return script, "", true
+}
+
+// hasAnnotation reports whether the script has the annotation on a line of
+// its own.
+func hasAnnotation(script, annotation string) bool {
+	for _, line := range strings.Split(script, "\n") {
+		if strings.TrimSpace(line) == annotation {
+			return true
+		}
+	}
+	return false
+}
+
+// gooseStatementEndAt returns the index just past the StatementEnd
+// annotation closing a statement block that starts at i, or the end of the
+// script when it is not closed.
+func gooseStatementEndAt(script string, i int) (end, markerStart int) {
+	rest := script[i:]
+	for offset := 0; offset < len(rest); {
+		n := strings.IndexByte(rest[offset:], '\n')
+		lineEnd := len(rest)
+		if n >= 0 {
+			lineEnd = offset + n
+		}
+		if strings.TrimSpace(rest[offset:lineEnd]) == gooseStatementEnd {
+			return i + lineEnd, i + offset
+		}
+		offset = lineEnd + 1
+	}
+	return len(script), len(script)
+}
@@ -0,0 +1,69 @@
+package pgmigrate
+
+import "testing"
+
+func TestGooseSections(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		script   string
+		up, down string
+		ok       bool
+	}{
+		{
+			name:   "not goose",
+			script: "CREATE TABLE a ();\n",
+			up:     "CREATE TABLE a ();\n",
+		},
+		{
+			name:   "up and down",
+			script: "-- +goose Up\nCREATE TABLE a ();\n-- +goose Down\nDROP TABLE a;\n",
+			up:     "-- +goose Up\nCREATE TABLE a ();\n",
+			down:   "DROP TABLE a;\n",
+			ok:     true,
+		},
+		{
+			name:   "up only",
+			script: "-- +goose Up\nCREATE TABLE a ();\n",
+			up:     "-- +goose Up\nCREATE TABLE a ();\n",
+			ok:     true,
+		},
+		{
+			name:   "indented annotations",
+			script: "  -- +goose Up\nCREATE TABLE a ();\n\t-- +goose Down  \r\nDROP TABLE a;",
+			up:     "  -- +goose Up\nCREATE TABLE a ();\n",
+			down:   "DROP TABLE a;",
+			ok:     true,
+		},
+		{
+			name: "statement blocks",
+			script: "-- +goose Up\n-- +goose StatementBegin\nCREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;\n-- +goose StatementEnd\n" +
+				"-- +goose Down\n-- +goose StatementBegin\nDROP FUNCTION f();\n-- +goose StatementEnd\n",
+			up:   "-- +goose Up\n-- +goose StatementBegin\nCREATE FUNCTION f() RETURNS int AS $$ SELECT 1; $$ LANGUAGE sql;\n-- +goose StatementEnd\n",
+			down: "-- +goose StatementBegin\nDROP FUNCTION f();\n-- +goose StatementEnd\n",
+			ok:   true,
+		},
+		{
+			name:   "annotation inside a comment line",
+			script: "-- +goose Up\nSELECT 1; -- +goose Down\n",
+			up:     "-- +goose Up\nSELECT 1; -- +goose Down\n",
+			ok:     true,
+		},
+	} {
+		up, down, ok := gooseSections(test.script)
+		if up != test.up || down != test.down || ok != test.ok {
+			t.Errorf("%s: got %q, %q, %v, want %q, %q, %v", test.name, up, down, ok, test.up, test.down, test.ok)
+		}
+	}
+}
+
+func TestGooseStatementBlocks(t *testing.T) {
+	up, _, _ := gooseSections("-- +goose Up\n-- +goose StatementBegin\nCREATE FUNCTION f() RETURNS void AS $$\nBEGIN\n  PERFORM 1;\nEND;\n$$ LANGUAGE plpgsql;\n" +
+		"CREATE FUNCTION g() RETURNS void AS 'SELECT 1; SELECT 2' LANGUAGE sql;\n-- +goose StatementEnd\nSELECT 3;\n-- +goose Down\nDROP FUNCTION f();\n")
+	statements := splitStatements(up)
+	if len(statements) != 2 {
+		t.Fatalf("got %d statements, want the block and SELECT 3: %q", len(statements), statements)
+	}
+	if statements[1].SQL != "SELECT 3" || statements[1].Line != 10 {
+		t.Errorf("second statement %q at line %d, want SELECT 3 at line 10", statements[1].SQL, statements[1].Line)
+	}
+}
@@ -0,0 +1,124 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// historyTable is the table recording which migrations have been applied to
+// a database.
+const historyTable = "pgmigrate_history"
+
+// execer is implemented by *sql.Conn and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// ensureHistoryTable creates the history table if it does not exist yet,
+// and adds the columns added since to tables created before them.
+func ensureHistoryTable(ctx context.Context, conn execer) error {
+	_, err := conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+historyTable+` (
+		version      text PRIMARY KEY,
+		description  text NOT NULL,
+		checksum     text NOT NULL,
+		applied_at   timestamptz NOT NULL DEFAULT now(),
+		execution_ms bigint NOT NULL,
+		release      text,
+		tool_version text,
+		deprecated   boolean NOT NULL DEFAULT false
+	)`)
+	if err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, `ALTER TABLE `+historyTable+` ADD COLUMN IF NOT EXISTS release text,
+		ADD COLUMN IF NOT EXISTS tool_version text,
+		ADD COLUMN IF NOT EXISTS deprecated boolean NOT NULL DEFAULT false`)
+	return err
+}
+
+// AppliedMigration is a row of the history table.
+type AppliedMigration struct {
+	Version     string
+	Description string
+	Checksum    string
+	// Release is the release the migration was applied with, if any.
+	Release string
+	// Deprecated is set when the migration's file was deliberately removed,
+	// see RepairHistory.
+	Deprecated bool
+}
+
+// appliedMigrations returns the migrations recorded in the history table,
+// keyed by version. The migrations at or below the version the history was
+// pruned through are applied too, recorded with their current checksum.
+func appliedMigrations(ctx context.Context, conn *sql.Conn, migrations []Migration) (map[string]AppliedMigration, error) {
+	rows, err := conn.QueryContext(ctx, `SELECT version, description, checksum, COALESCE(release, ''), deprecated FROM `+historyTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[string]AppliedMigration)
+	for rows.Next() {
+		var m AppliedMigration
+		if err := rows.Scan(&m.Version, &m.Description, &m.Checksum, &m.Release, &m.Deprecated); err != nil {
+			return nil, err
+		}
+		applied[m.Version] = m
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pruned, err := prunedThrough(ctx, conn)
+	if err != nil {
+		return nil, fmt.Errorf("read pruned version: %w", err)
+	}
+	for _, migration := range migrations {
+		if pruned == "" || compareVersions(migration.Version, pruned) > 0 {
+			break
+		}
+		if _, ok := applied[migration.Version]; !ok {
+			applied[migration.Version] = AppliedMigration{Version: migration.Version, Description: migration.Description, Checksum: migration.Checksum}
+		}
+	}
+	return applied, nil
+}
+
+// recordMigration inserts a history row for an applied migration.
+func recordMigration(ctx context.Context, conn execer, migration Migration, executionMs int64) error {
+	_, err := conn.ExecContext(ctx,
+		`INSERT INTO `+historyTable+` (version, description, checksum, execution_ms, release, tool_version) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6)`,
+		migration.Version, migration.Description, migration.Checksum, executionMs, migration.Release, Version)
+	return err
+}
+
+// CreateHistoryTables creates the history table on every target that does
+// not have one yet, as the first run would.
+func (m *Migrator) CreateHistoryTables(ctx context.Context, targets []Target) []MaintenanceResult {
+	return forEachTarget(ctx, m.config, targets, func(ctx context.Context, target Target) MaintenanceResult {
+		result := MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database}
+		result.Error = createHistoryTable(ctx, target, m.config.settingsFor(target))
+		return result
+	}, func(target Target, err error) MaintenanceResult {
+		return MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database, Error: err}
+	})
+}
+
+func createHistoryTable(ctx context.Context, target Target, settings DatabaseSettings) error {
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if err := applySessionSettings(ctx, conn, settings); err != nil {
+		return err
+	}
+	return ensureHistoryTable(ctx, conn)
+}
@@ -0,0 +1,240 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// HistoryDump is the migration history of one database or tenant schema,
+// written by DumpHistory and read by RestoreHistory to move it along with
+// the database to another cluster.
+type HistoryDump struct {
+	// SchemaVersion is the HistorySchemaVersion of the tool that wrote the
+	// dump.
+	SchemaVersion int       `json:"history_schema_version"`
+	Cluster       string    `json:"cluster"`
+	Database      string    `json:"database"`
+	Schema        string    `json:"schema,omitempty"`
+	DumpedAt      time.Time `json:"dumped_at"`
+	// PrunedThrough is the version the history was pruned through, see
+	// PruneHistory.
+	PrunedThrough string `json:"pruned_through,omitempty"`
+
+	Migrations  []HistoryRecord    `json:"migrations"`
+	Repeatables []RepeatableRecord `json:"repeatables,omitempty"`
+}
+
+// HistoryRecord is a row of the history table with its audit fields.
+type HistoryRecord struct {
+	Version     string    `json:"version"`
+	Description string    `json:"description"`
+	Checksum    string    `json:"checksum"`
+	AppliedAt   time.Time `json:"applied_at"`
+	ExecutionMs int64     `json:"execution_ms"`
+	Release     string    `json:"release,omitempty"`
+	ToolVersion string    `json:"tool_version,omitempty"`
+	Deprecated  bool      `json:"deprecated,omitempty"`
+}
+
+// RepeatableRecord is a row of the table of Flyway repeatable migrations.
+type RepeatableRecord struct {
+	Description string    `json:"description"`
+	Checksum    string    `json:"checksum"`
+	AppliedAt   time.Time `json:"applied_at"`
+}
+
+// DumpHistory reads the history of a target, in the order it was applied.
+func (m *Migrator) DumpHistory(ctx context.Context, target Target) (HistoryDump, error) {
+	dump := HistoryDump{
+		SchemaVersion: HistorySchemaVersion,
+		Cluster:       target.Cluster.Name,
+		Database:      target.Database,
+		Schema:        target.Schema,
+		DumpedAt:      time.Now().UTC(),
+	}
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		return dump, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return dump, err
+	}
+	defer conn.Close()
+	if err := applySessionSettings(ctx, conn, m.config.settingsFor(target)); err != nil {
+		return dump, err
+	}
+
+	var exists, repeatables bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL, to_regclass($2) IS NOT NULL`, historyTable, repeatableTable).Scan(&exists, &repeatables); err != nil {
+		return dump, err
+	}
+	if !exists {
+		return dump, fmt.Errorf("%s has no history table", target.Database)
+	}
+	// Tables created by older versions lack the later columns, which the
+	// next run would add anyway.
+	if err := ensureHistoryTable(ctx, conn); err != nil {
+		return dump, fmt.Errorf("upgrade history table: %w", err)
+	}
+	if dump.PrunedThrough, err = prunedThrough(ctx, conn); err != nil {
+		return dump, fmt.Errorf("read pruned version: %w", err)
+	}
+	rows, err := conn.QueryContext(ctx, `SELECT version, description, checksum, applied_at, execution_ms, COALESCE(release, ''), COALESCE(tool_version, ''), deprecated
+		FROM `+historyTable+` ORDER BY applied_at, version`)
+	if err != nil {
+		return dump, fmt.Errorf("read history: %w", err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r HistoryRecord
+		if err := rows.Scan(&r.Version, &r.Description, &r.Checksum, &r.AppliedAt, &r.ExecutionMs, &r.Release, &r.ToolVersion, &r.Deprecated); err != nil {
+			return dump, err
+		}
+		r.AppliedAt = r.AppliedAt.UTC()
+		dump.Migrations = append(dump.Migrations, r)
+	}
+	if err := rows.Err(); err != nil {
+		return dump, err
+	}
+
+	if repeatables {
+		rows, err := conn.QueryContext(ctx, `SELECT description, checksum, applied_at FROM `+repeatableTable+` ORDER BY description`)
+		if err != nil {
+			return dump, fmt.Errorf("read repeatable history: %w", err)
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var r RepeatableRecord
+			if err := rows.Scan(&r.Description, &r.Checksum, &r.AppliedAt); err != nil {
+				return dump, err
+			}
+			r.AppliedAt = r.AppliedAt.UTC()
+			dump.Repeatables = append(dump.Repeatables, r)
+		}
+		if err := rows.Err(); err != nil {
+			return dump, err
+		}
+	}
+	return dump, nil
+}
+
+// RestoreHistory records the history of a dump in a target, typically the
+// same database restored onto another cluster, so that the next run applies
+// exactly the migrations the original had not. Rows the target already has
+// are kept when they match the dump; a row recording a version with another
+// checksum fails the restore, which then changes nothing.
+func (m *Migrator) RestoreHistory(ctx context.Context, target Target, dump HistoryDump) MaintenanceResult {
+	result := MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database}
+	if dump.SchemaVersion > HistorySchemaVersion {
+		result.Error = fmt.Errorf("dump has history schema version %d, newer than the %d of this build; upgrade the tool", dump.SchemaVersion, HistorySchemaVersion)
+		return result
+	}
+	result.Changes, result.Error = m.restoreHistory(ctx, target, dump)
+	return result
+}
+
+func (m *Migrator) restoreHistory(ctx context.Context, target Target, dump HistoryDump) ([]string, error) {
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if target.Schema != "" {
+		err = lockSchema(ctx, conn, target.Schema)
+	} else {
+		err = lockDatabase(ctx, conn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if target.Schema != "" {
+		defer unlockSchema(conn, target.Schema)
+	} else {
+		defer unlockDatabase(conn)
+	}
+	if err := applySessionSettings(ctx, conn, m.config.settingsFor(target)); err != nil {
+		return nil, err
+	}
+
+	if err := ensureHistoryTable(ctx, conn); err != nil {
+		return nil, fmt.Errorf("create history table: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn, nil)
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	restored, present := 0, 0
+	for _, r := range dump.Migrations {
+		if existing, ok := applied[r.Version]; ok {
+			if existing.Checksum != r.Checksum {
+				return nil, fmt.Errorf("version %s is already recorded with checksum %s, the dump has %s", r.Version, existing.Checksum, r.Checksum)
+			}
+			present++
+			continue
+		}
+		_, err := tx.ExecContext(ctx, `INSERT INTO `+historyTable+` (version, description, checksum, applied_at, execution_ms, release, tool_version, deprecated)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), $8)`,
+			r.Version, r.Description, r.Checksum, r.AppliedAt, r.ExecutionMs, r.Release, r.ToolVersion, r.Deprecated)
+		if err != nil {
+			return nil, fmt.Errorf("record %s: %w", r.Version, err)
+		}
+		restored++
+	}
+	if dump.PrunedThrough != "" {
+		pruned, err := prunedThrough(ctx, conn)
+		if err != nil {
+			return nil, fmt.Errorf("read pruned version: %w", err)
+		}
+		if compareVersions(dump.PrunedThrough, pruned) > 0 {
+			if err := setPrunedThrough(ctx, tx, dump.PrunedThrough); err != nil {
+				return nil, fmt.Errorf("record pruned version: %w", err)
+			}
+		}
+	}
+	if len(dump.Repeatables) > 0 {
+		if _, err := tx.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+repeatableTable+` (
+			description text PRIMARY KEY,
+			checksum    text NOT NULL,
+			applied_at  timestamptz NOT NULL DEFAULT now()
+		)`); err != nil {
+			return nil, fmt.Errorf("create repeatable table: %w", err)
+		}
+		// The dump reflects the last run on the original database, which
+		// supersedes whatever the target recorded.
+		for _, r := range dump.Repeatables {
+			_, err := tx.ExecContext(ctx, `INSERT INTO `+repeatableTable+` (description, checksum, applied_at) VALUES ($1, $2, $3)
+				ON CONFLICT (description) DO UPDATE SET checksum = excluded.checksum, applied_at = excluded.applied_at`,
+				r.Description, r.Checksum, r.AppliedAt)
+			if err != nil {
+				return nil, fmt.Errorf("record repeatable %s: %w", r.Description, err)
+			}
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	changes := []string{fmt.Sprintf("restored %d migrations", restored)}
+	if present > 0 {
+		changes = append(changes, fmt.Sprintf("%d already recorded", present))
+	}
+	if len(dump.Repeatables) > 0 {
+		changes = append(changes, fmt.Sprintf("restored %d repeatable migrations", len(dump.Repeatables)))
+	}
+	return changes, nil
+}
@@ -0,0 +1,145 @@
+package pgmigrate
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultHookTimeout bounds hook commands that set no timeout.
+const defaultHookTimeout = time.Minute
+
+// HooksConfig lists shell commands run after each database is migrated,
+// such as resuming a tenant's job queue once it succeeded or paging its
+// owner when it failed. Commands are run by sh -c with the outcome in
+// environment variables:
+//
+//	PGMIGRATE_CLUSTER, PGMIGRATE_DATABASE, PGMIGRATE_SCHEMA
+//	PGMIGRATE_RESULT       success or failure
+//	PGMIGRATE_ERROR        why the database failed
+//	PGMIGRATE_APPLIED      the versions applied, comma-separated
+//	PGMIGRATE_DURATION_MS  how long the database took
+//	PGMIGRATE_DIAGNOSTICS  the diagnostic bundle of a failed database
+//
+// Their output is written to the log line by line. A hook that fails or
+// times out is logged as a warning and does not change the result of the
+// database.
+type HooksConfig struct {
+	OnSuccess []HookCommand `json:"on_success"`
+	OnFailure []HookCommand `json:"on_failure"`
+}
+
+// HookCommand is a shell command run by a hook.
+type HookCommand struct {
+	Command string `json:"command"`
+	// Timeout after which the command is killed, e.g. "30s". Defaults to
+	// one minute.
+	Timeout string `json:"timeout"`
+}
+
+func (c *HooksConfig) validate() error {
+	for name, commands := range map[string][]HookCommand{"on_success": c.OnSuccess, "on_failure": c.OnFailure} {
+		for i, command := range commands {
+			if strings.TrimSpace(command.Command) == "" {
+				return fmt.Errorf("%s[%d]: command is required", name, i)
+			}
+			if command.Timeout != "" {
+				if d, err := time.ParseDuration(command.Timeout); err != nil || d <= 0 {
+					return fmt.Errorf("%s[%d]: invalid timeout %q", name, i, command.Timeout)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// runHooks runs the hook commands for the result of a database, one after
+// the other.
+func runHooks(ctx context.Context, config *HooksConfig, result MigrationResult) {
+	if config == nil {
+		return
+	}
+	commands, outcome := config.OnSuccess, "success"
+	if !result.Success {
+		commands, outcome = config.OnFailure, "failure"
+	}
+	if len(commands) == 0 {
+		return
+	}
+	env := hookEnv(result)
+	name := resultName(result)
+	for _, command := range commands {
+		if err := runHook(ctx, command, env, name); err != nil {
+			log.Printf("WARNING: %s hook for %s: %v", outcome, name, err)
+		}
+	}
+}
+
+// hookEnv returns the environment of hook commands run for a result.
+func hookEnv(result MigrationResult) []string {
+	outcome, errorText := "success", ""
+	if !result.Success {
+		outcome = "failure"
+	}
+	if result.Error != nil {
+		errorText = result.Error.Error()
+	}
+	return append(os.Environ(),
+		"PGMIGRATE_CLUSTER="+result.Cluster,
+		"PGMIGRATE_DATABASE="+result.Database,
+		"PGMIGRATE_SCHEMA="+result.Schema,
+		"PGMIGRATE_RESULT="+outcome,
+		"PGMIGRATE_ERROR="+errorText,
+		"PGMIGRATE_APPLIED="+strings.Join(result.Applied, ","),
+		"PGMIGRATE_DURATION_MS="+strconv.FormatInt(result.Duration.Milliseconds(), 10),
+		"PGMIGRATE_DIAGNOSTICS="+result.Diagnostics,
+	)
+}
+
+// resultName names the database of a result in the log.
+func resultName(result MigrationResult) string {
+	if result.Schema != "" {
+		return result.Database + "/" + result.Schema
+	}
+	return result.Database
+}
+
+// runHook runs a hook command, writing its output to the log prefixed with
+// the database's name.
+func runHook(ctx context.Context, command HookCommand, env []string, name string) error {
+	timeout := defaultHookTimeout
+	if command.Timeout != "" {
+		timeout, _ = time.ParseDuration(command.Timeout)
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command.Command)
+	cmd.Env = env
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	// Commands that leave a background process holding the output open
+	// are not waited for past the timeout.
+	cmd.WaitDelay = time.Second
+	err := cmd.Run()
+	scanner := bufio.NewScanner(&output)
+	for scanner.Scan() {
+		log.Printf("hook %s: %s", name, scanner.Text())
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%q timed out after %s", command.Command, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("%q: %w", command.Command, err)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// IdempotencyResult reports whether applying a migration a second time
+// succeeds.
+type IdempotencyResult struct {
+	Version     string
+	Description string
+	// Error is the error the second application failed with, or nil when
+	// the migration is idempotent.
+	Error error
+}
+
+// VerifyIdempotent applies the migrations pending on target to a disposable
+// copy of it, then applies each of them again, catching SQL that only works
+// once or depends on the state it runs against. With fromScratch, the copy
+// is an empty database and every migration is verified. The copy is dropped
+// afterwards and target itself is never modified.
+func (m *Migrator) VerifyIdempotent(ctx context.Context, target Target, fromScratch bool) ([]IdempotencyResult, error) {
+	template := target.Database
+	if fromScratch {
+		template = ""
+	}
+	scratch, drop, err := createScratchDatabase(ctx, target.Cluster, "verify", template)
+	if err != nil {
+		return nil, err
+	}
+	defer drop()
+
+	// Render with the original target so templates see its name.
+	settings := m.config.settingsFor(target)
+	data := templateData{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema, Vars: settings.Variables}
+
+	db, err := connectToDatabase(ctx, target.Cluster, scratch)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if err := applySessionSettings(ctx, conn, settings); err != nil {
+		return nil, err
+	}
+	if err := ensureHistoryTable(ctx, conn); err != nil {
+		return nil, fmt.Errorf("create history table: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn, m.migrations)
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	pending := pendingMigrations(m.migrations, applied, settings.TargetVersion)
+
+	for _, migration := range pending {
+		if err := applyMigration(ctx, conn, Target{Cluster: target.Cluster, Database: scratch}, migration, data, settings); err != nil {
+			return nil, fmt.Errorf("migration %s (%s) fails on first application: %w", migration.Version, migration.Description, err)
+		}
+	}
+
+	var results []IdempotencyResult
+	for _, migration := range pending {
+		result := IdempotencyResult{Version: migration.Version, Description: migration.Description}
+		result.Error = reapplyMigration(ctx, conn, migration, data, settings)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// reapplyMigration executes a migration's statements again without
+// recording it. Transactional migrations are rolled back afterwards so that
+// each re-application sees the state left by the first pass.
+func reapplyMigration(ctx context.Context, conn *sql.Conn, migration Migration, data templateData, settings DatabaseSettings) error {
+	script, err := renderScript(migration, data)
+	if err != nil {
+		return fmt.Errorf("render: %w", err)
+	}
+	statements := splitStatements(script)
+	if settings.NoTransaction || migration.NoTransaction {
+		return executeWithDependents(ctx, conn, script, statements, settings.DefinitionsDir, nil)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return executeWithDependents(ctx, tx, script, statements, settings.DefinitionsDir, nil)
+}
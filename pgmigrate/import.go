@@ -0,0 +1,208 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Formats of other migration tools whose history can be imported.
+const (
+	FormatGolangMigrate = "golang-migrate"
+	FormatFlyway        = "flyway"
+	FormatLiquibase     = "liquibase"
+)
+
+// golangMigrateFilePattern matches golang-migrate file names such as
+// 1_create_users.up.sql or 20230102150405_add_index.down.sql.
+var golangMigrateFilePattern = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// ConvertGolangMigrateFiles copies the golang-migrate migrations of src to
+// dst under this tool's names: 1_create_users.up.sql becomes
+// 1_create_users.sql and 1_create_users.down.sql keeps its name. dst must
+// be a local directory without migrations, which is created if needed. It
+// returns the names of the files written.
+func ConvertGolangMigrateFiles(src, dst string) ([]string, error) {
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return nil, err
+	}
+	if err := prepareConversionDir(dst); err != nil {
+		return nil, err
+	}
+	var written []string
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		match := golangMigrateFilePattern.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		name := match[1] + "_" + match[2] + ".sql"
+		if match[3] == "down" {
+			name = match[1] + "_" + match[2] + downFileSuffix
+		}
+		script, err := os.ReadFile(filepath.Join(src, entry.Name()))
+		if err != nil {
+			return written, err
+		}
+		if err := os.WriteFile(filepath.Join(dst, name), script, 0o644); err != nil {
+			return written, err
+		}
+		written = append(written, name)
+	}
+	if len(written) == 0 {
+		return nil, fmt.Errorf("no golang-migrate migrations in %s", src)
+	}
+	return written, nil
+}
+
+// prepareConversionDir creates the directory converted migrations are
+// written to, refusing the URL of a remote source or an archive, which
+// cannot be written to, and a directory already holding migrations, whose
+// files would be overwritten.
+func prepareConversionDir(dst string) error {
+	switch {
+	case strings.Contains(dst, "://"):
+		return fmt.Errorf("%s is a remote migration source: convert into a local directory", dst)
+	case hasArchiveExtension(dst):
+		return fmt.Errorf("%s is a migration archive: convert into a local directory", dst)
+	}
+	if err := os.MkdirAll(dst, 0o755); err != nil {
+		return err
+	}
+	if existing, _ := filepath.Glob(filepath.Join(dst, "*.sql")); len(existing) > 0 {
+		return fmt.Errorf("%s already holds migrations", dst)
+	}
+	return nil
+}
+
+// ImportHistory converts the history another migration tool recorded in
+// each database into this tool's history: every loaded migration up to and
+// including the version the other tool reached is recorded as applied.
+// Databases that already have a history are left alone.
+func (m *Migrator) ImportHistory(ctx context.Context, targets []Target, format string) []MaintenanceResult {
+	var read func(ctx context.Context, conn *sql.Conn) (string, error)
+	switch format {
+	case FormatGolangMigrate:
+		read = golangMigrateVersion
+	case FormatFlyway:
+		read = flywayVersion
+	case FormatLiquibase:
+		read = liquibaseVersion(m.migrations)
+	default:
+		err := fmt.Errorf("unknown import format %q", format)
+		results := make([]MaintenanceResult, len(targets))
+		for i, target := range targets {
+			results[i] = MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database, Error: err}
+		}
+		return results
+	}
+
+	return forEachTarget(ctx, m.config, targets, func(ctx context.Context, target Target) MaintenanceResult {
+		result := MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database}
+		result.Changes, result.Error = m.importHistory(ctx, target, read)
+		return result
+	}, func(target Target, err error) MaintenanceResult {
+		return MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database, Error: err}
+	})
+}
+
+// importHistory imports the history of one database, given the function
+// reading the last version the other tool applied.
+func (m *Migrator) importHistory(ctx context.Context, target Target, read func(context.Context, *sql.Conn) (string, error)) ([]string, error) {
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if target.Schema != "" {
+		err = lockSchema(ctx, conn, target.Schema)
+	} else {
+		err = lockDatabase(ctx, conn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if target.Schema != "" {
+		defer unlockSchema(conn, target.Schema)
+	} else {
+		defer unlockDatabase(conn)
+	}
+	if err := applySessionSettings(ctx, conn, m.config.settingsFor(target)); err != nil {
+		return nil, err
+	}
+
+	if err := ensureHistoryTable(ctx, conn); err != nil {
+		return nil, fmt.Errorf("create history table: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn, m.migrations)
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	if len(applied) > 0 {
+		return []string{"history already present"}, nil
+	}
+	version, err := read(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if version == "" {
+		return []string{"no migrations applied"}, nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	var changes []string
+	found := false
+	for _, migration := range m.migrations {
+		c := compareVersions(migration.Version, version)
+		if c > 0 {
+			break
+		}
+		found = found || c == 0
+		if err := recordMigration(ctx, tx, migration, 0); err != nil {
+			return nil, err
+		}
+		changes = append(changes, "imported "+migration.Version)
+	}
+	if !found {
+		return nil, fmt.Errorf("applied version %s has no migration", version)
+	}
+	return changes, tx.Commit()
+}
+
+// golangMigrateVersion reads the version recorded in golang-migrate's
+// schema_migrations table, refusing a dirty one: a migration that failed
+// halfway must be repaired before its history is imported.
+func golangMigrateVersion(ctx context.Context, conn *sql.Conn) (string, error) {
+	var version int64
+	var dirty bool
+	err := conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&version, &dirty)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read schema_migrations: %w", err)
+	}
+	if dirty {
+		return "", fmt.Errorf("schema_migrations is dirty at version %d; fix the database and force a clean version first", version)
+	}
+	return canonicalVersion(strconv.FormatInt(version, 10)), nil
+}
@@ -0,0 +1,53 @@
+package pgmigrate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConvertGolangMigrateFiles(t *testing.T) {
+	src := t.TempDir()
+	for name, script := range map[string]string{
+		"1_create_users.up.sql":   "CREATE TABLE users ();",
+		"1_create_users.down.sql": "DROP TABLE users;",
+		"README.md":               "not a migration",
+	} {
+		if err := os.WriteFile(filepath.Join(src, name), []byte(script), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dst := filepath.Join(t.TempDir(), "migrations")
+	written, err := ConvertGolangMigrateFiles(src, dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1_create_users" + downFileSuffix, "1_create_users.sql"}; !reflect.DeepEqual(written, want) {
+		t.Errorf("written = %v, want %v", written, want)
+	}
+
+	// Converting again would overwrite the files, which may have been
+	// edited since.
+	if err := os.WriteFile(filepath.Join(dst, "1_create_users.sql"), []byte("edited"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ConvertGolangMigrateFiles(src, dst); err == nil {
+		t.Error("converted into a directory holding migrations")
+	}
+	if data, _ := os.ReadFile(filepath.Join(dst, "1_create_users.sql")); string(data) != "edited" {
+		t.Errorf("existing migration overwritten with %q", data)
+	}
+
+	for _, dst := range []string{
+		"s3://bucket/migrations",
+		"git+https://github.com/acme/schema.git#ref=main",
+		filepath.Join(t.TempDir(), "migrations.zip"),
+		filepath.Join(t.TempDir(), "migrations.tar.gz"),
+	} {
+		if _, err := ConvertGolangMigrateFiles(src, dst); err == nil {
+			t.Errorf("converted into %s", dst)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package pgmigrate
+
+import "context"
+
+// The interfaces below replace parts of the migrator for programs embedding
+// it, typically with the fakes of package fake to unit test orchestration
+// built on the Migrator without a PostgreSQL server. Each is only available
+// to programs using the package and defaults to the built-in behavior when
+// unset in the configuration.
+
+// DatabaseLister lists the databases of a cluster, excluded ones included.
+// The built-in lister queries pg_database.
+type DatabaseLister interface {
+	ListDatabases(ctx context.Context, cluster ClusterConfig) ([]string, error)
+}
+
+// ScriptSource provides the migrations, in any order. The built-in source
+// reads the migration directory with LoadMigrations.
+type ScriptSource interface {
+	LoadMigrations() ([]Migration, error)
+}
+
+// Executor applies the pending migrations to a single database. It is
+// called concurrently for different databases. The built-in executor
+// connects to the database and applies the migrations recorded as missing
+// in its history table.
+type Executor interface {
+	Execute(ctx context.Context, target Target, migrations []Migration) MigrationResult
+}
+
+// Reporter is told the result of each database as soon as it is done,
+// before Migrate or Run returns all of them, from a single goroutine in the
+// order databases finish. It is how results are streamed: Migrate and Run
+// still return every result, for the run summary, notifications and
+// reports that need them all. Results wait for a slow reporter in a buffer of
+// Configuration.ReportBuffer results, beyond which databases wait too.
+type Reporter interface {
+	Report(result MigrationResult)
+}
+
+// StartReporter is a Reporter also told when each database starts, such as
+// to show the databases in flight. ReportStart is called concurrently, from
+// the goroutine of each database, and is not queued: it must not block.
+type StartReporter interface {
+	Reporter
+	ReportStart(target Target)
+}
@@ -0,0 +1,56 @@
+package pgmigrate
+
+import (
+	"errors"
+	"strings"
+)
+
+// labelsDirective labels a migration for selection at run time, e.g.
+//
+//	-- pgmigrate:labels billing,heavy
+//
+// See Configuration.Labels.
+const labelsDirective = "-- pgmigrate:labels"
+
+// scriptLabels returns the labels of the labels directives of a script.
+func scriptLabels(script string) ([]string, error) {
+	var labels []string
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, labelsDirective) {
+			continue
+		}
+		names := splitList(strings.TrimPrefix(line, labelsDirective))
+		if len(names) == 0 {
+			return nil, errors.New("labels: label is required")
+		}
+		labels = append(labels, names...)
+	}
+	return labels, nil
+}
+
+// splitList splits a list separated by commas or spaces.
+func splitList(s string) []string {
+	return strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == ' ' || r == '\t'
+	})
+}
+
+// labelsSelected reports whether a migration with the given labels is
+// selected by the configured labels and excluded labels.
+func (c Configuration) labelsSelected(labels []string) bool {
+	for _, label := range labels {
+		if contains(c.ExcludeLabels, label) {
+			return false
+		}
+	}
+	if len(c.Labels) == 0 {
+		return true
+	}
+	for _, label := range labels {
+		if contains(c.Labels, label) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,138 @@
+package pgmigrate
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// applyLibpqDefaults fills the connection parameters the cluster leaves
+// unset, first from its connection service and then from the PGHOST,
+// PGPORT, PGUSER, PGPASSWORD and PGSSLMODE environment variables, matching
+// the precedence psql uses.
+func applyLibpqDefaults(cluster ClusterConfig) (ClusterConfig, error) {
+	service := cluster.Service
+	if service == "" {
+		service = os.Getenv("PGSERVICE")
+	}
+	if service != "" {
+		params, err := lookupService(service)
+		if err != nil {
+			return cluster, err
+		}
+		if err := fillCluster(&cluster, params); err != nil {
+			return cluster, fmt.Errorf("service %s: %w", service, err)
+		}
+	}
+
+	env := map[string]string{
+		"host":     os.Getenv("PGHOST"),
+		"port":     os.Getenv("PGPORT"),
+		"user":     os.Getenv("PGUSER"),
+		"password": os.Getenv("PGPASSWORD"),
+		"sslmode":  os.Getenv("PGSSLMODE"),
+	}
+	if err := fillCluster(&cluster, env); err != nil {
+		return cluster, fmt.Errorf("environment: %w", err)
+	}
+	return cluster, nil
+}
+
+// fillCluster sets the cluster fields that are still empty from libpq
+// connection parameters.
+func fillCluster(cluster *ClusterConfig, params map[string]string) error {
+	if cluster.Host == "" {
+		cluster.Host = params["host"]
+	}
+	if cluster.Port == 0 && params["port"] != "" {
+		port, err := strconv.Atoi(params["port"])
+		if err != nil {
+			return fmt.Errorf("invalid port %q", params["port"])
+		}
+		cluster.Port = port
+	}
+	if cluster.Username == "" {
+		cluster.Username = params["user"]
+	}
+	if cluster.Password == "" {
+		cluster.Password = params["password"]
+	}
+	if cluster.SSLMode == "" {
+		cluster.SSLMode = params["sslmode"]
+	}
+	return nil
+}
+
+// serviceFiles returns the connection service files to search, in order:
+// $PGSERVICEFILE or ~/.pg_service.conf, then the system-wide
+// pg_service.conf in $PGSYSCONFDIR.
+func serviceFiles() []string {
+	var files []string
+	if path := os.Getenv("PGSERVICEFILE"); path != "" {
+		files = append(files, path)
+	} else if home, err := os.UserHomeDir(); err == nil {
+		files = append(files, filepath.Join(home, ".pg_service.conf"))
+	}
+	if dir := os.Getenv("PGSYSCONFDIR"); dir != "" {
+		files = append(files, filepath.Join(dir, "pg_service.conf"))
+	}
+	return files
+}
+
+// lookupService returns the parameters of the named service from the first
+// service file that defines it.
+func lookupService(name string) (map[string]string, error) {
+	for _, path := range serviceFiles() {
+		params, found, err := readServiceFile(path, name)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			return params, nil
+		}
+	}
+	return nil, fmt.Errorf("definition of service %q not found", name)
+}
+
+// readServiceFile reads the parameters of one service from an INI-style
+// service file. A missing file is not an error.
+func readServiceFile(path, name string) (map[string]string, bool, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	defer file.Close()
+
+	var params map[string]string
+	scanner := bufio.NewScanner(file)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "["):
+			if params != nil {
+				return params, true, nil
+			}
+			if strings.TrimSuffix(strings.TrimPrefix(line, "["), "]") == name {
+				params = make(map[string]string)
+			}
+		case params != nil:
+			key, value, ok := strings.Cut(line, "=")
+			if !ok {
+				return nil, false, fmt.Errorf("%s:%d: syntax error in service file", path, lineNo)
+			}
+			params[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, err
+	}
+	return params, params != nil, nil
+}
@@ -0,0 +1,85 @@
+package pgmigrate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadServiceFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pg_service.conf")
+	content := `# services
+[prod]
+host = db.example.com
+port=5432
+dbname = app
+
+[empty]
+
+[staging]
+host=staging.example.com
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		params map[string]string
+		found  bool
+	}{
+		{name: "prod", params: map[string]string{"host": "db.example.com", "port": "5432", "dbname": "app"}, found: true},
+		{name: "empty", params: map[string]string{}, found: true},
+		{name: "staging", params: map[string]string{"host": "staging.example.com"}, found: true},
+		{name: "missing"},
+	} {
+		params, found, err := readServiceFile(path, test.name)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if found != test.found || !reflect.DeepEqual(params, test.params) {
+			t.Errorf("%s: got %v, %v, want %v, %v", test.name, params, found, test.params, test.found)
+		}
+	}
+
+	if _, found, err := readServiceFile(filepath.Join(t.TempDir(), "missing.conf"), "prod"); err != nil || found {
+		t.Errorf("missing file: found = %v, err = %v", found, err)
+	}
+}
+
+func TestReadServiceFileSyntaxError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pg_service.conf")
+	if err := os.WriteFile(path, []byte("[prod]\nhost\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := readServiceFile(path, "prod"); err == nil {
+		t.Error("no error for a line without =")
+	}
+}
+
+func TestLookupServiceFirstFileWins(t *testing.T) {
+	dir := t.TempDir()
+	user := filepath.Join(dir, "user.conf")
+	if err := os.WriteFile(user, []byte("[prod]\nhost=user.example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "pg_service.conf"), []byte("[prod]\nhost=system.example.com\n[other]\nhost=other.example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PGSERVICEFILE", user)
+	t.Setenv("PGSYSCONFDIR", dir)
+
+	params, err := lookupService("prod")
+	if err != nil || params["host"] != "user.example.com" {
+		t.Errorf("prod: %v, %v, want the user file's", params, err)
+	}
+	params, err = lookupService("other")
+	if err != nil || params["host"] != "other.example.com" {
+		t.Errorf("other: %v, %v, want the system file's", params, err)
+	}
+	if _, err := lookupService("missing"); err == nil {
+		t.Error("missing: no error")
+	}
+}
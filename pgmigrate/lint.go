@@ -0,0 +1,455 @@
+package pgmigrate
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/lib/pq"
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+// ErrUnsafeMigration is returned when a migration contains an operation
+// whose lint rule is configured with the error severity.
+var ErrUnsafeMigration = errors.New("unsafe migration")
+
+// Severity is the level a lint rule is reported at.
+type Severity string
+
+const (
+	SeverityOff     Severity = "off"
+	SeverityWarning Severity = "warning"
+	SeverityError   Severity = "error"
+)
+
+// Lint rules for operations that hold long locks or rewrite tables.
+const (
+	RuleAddColumnDefault = "add_column_default"
+	RuleAlterColumnType  = "alter_column_type"
+	RuleCreateIndex      = "create_index"
+	RuleSetNotNull       = "set_not_null"
+	RuleAddConstraint    = "add_constraint"
+)
+
+// LintConfig adjusts the checks for operations known to cause downtime on
+// large tables. Every rule is reported as a warning unless configured
+// otherwise, so that stricter environments can turn them into errors.
+type LintConfig struct {
+	// Rules sets the severity of individual rules, e.g.
+	// {"create_index": "error", "alter_column_type": "off"}.
+	Rules map[string]Severity `json:"rules"`
+
+	// ServerVersion is the oldest PostgreSQL major version migrated. Before
+	// version 11 adding a column with any default rewrites the table.
+	// Zero assumes a current version.
+	ServerVersion int `json:"server_version"`
+
+	// Baseline skips migrations up to and including this version, which
+	// have already been deployed everywhere.
+	Baseline string `json:"baseline"`
+
+	// Rewrite replaces non-concurrent index builds and validating
+	// constraints with their safe equivalents (CREATE INDEX CONCURRENTLY,
+	// ADD CONSTRAINT ... NOT VALID followed by VALIDATE CONSTRAINT) instead
+	// of only suggesting them. Rewritten migrations run outside a
+	// transaction, as the safe forms require, so only migrations of a
+	// single statement, or already running outside a transaction, are
+	// rewritten; in other migrations the statement is an error.
+	Rewrite bool `json:"rewrite"`
+}
+
+// LintFinding is a potentially unsafe operation found in a migration.
+type LintFinding struct {
+	Path     string
+	Line     int
+	Rule     string
+	Severity Severity
+	Message  string
+
+	// Suggestion is the safe SQL equivalent of the statement or, where
+	// none can be derived, advice on how to write one.
+	Suggestion string
+
+	// Rewritten is set when the statement was replaced by Suggestion.
+	Rewritten bool
+
+	automatic bool
+}
+
+func (f LintFinding) String() string {
+	return fmt.Sprintf("%s:%d: %s (%s)", f.Path, f.Line, f.Message, f.Rule)
+}
+
+// report formats the finding for the log, with its suggestion.
+func (f LintFinding) report() string {
+	switch {
+	case f.Rewritten:
+		return fmt.Sprintf("%s; rewritten as: %s", f, f.Suggestion)
+	case f.Suggestion != "":
+		return fmt.Sprintf("%s; instead: %s", f, f.Suggestion)
+	}
+	return f.String()
+}
+
+func (c LintConfig) validate() error {
+	for rule, severity := range c.Rules {
+		switch rule {
+		case RuleAddColumnDefault, RuleAlterColumnType, RuleCreateIndex, RuleSetNotNull, RuleAddConstraint:
+		default:
+			return fmt.Errorf("lint.rules: unknown rule %q", rule)
+		}
+		switch severity {
+		case SeverityOff, SeverityWarning, SeverityError:
+		default:
+			return fmt.Errorf("lint.rules.%s: unknown severity %q", rule, severity)
+		}
+	}
+	if c.Baseline != "" {
+		if _, err := parseVersion(c.Baseline); err != nil {
+			return fmt.Errorf("lint.baseline: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c LintConfig) severity(rule string) Severity {
+	if severity, ok := c.Rules[rule]; ok {
+		return severity
+	}
+	return SeverityWarning
+}
+
+// volatileFunctions are common functions whose use as a column default
+// forces a table rewrite even on PostgreSQL 11 and later.
+var volatileFunctions = map[string]bool{
+	"random":             true,
+	"clock_timestamp":    true,
+	"timeofday":          true,
+	"gen_random_uuid":    true,
+	"uuid_generate_v1":   true,
+	"uuid_generate_v1mc": true,
+	"uuid_generate_v4":   true,
+	"nextval":            true,
+}
+
+// lintMigrations checks the migrations after the baseline for operations
+// that lock or rewrite existing tables. Migrations that fail to parse or
+// cannot be rendered without a target are skipped. When rewriting is
+// enabled, statements with a safe equivalent are replaced in the scripts of
+// the migrations of a single statement, which are then applied outside a
+// transaction.
+func lintMigrations(migrations []Migration, config Configuration) []LintFinding {
+	var findings []LintFinding
+	for i, migration := range migrations {
+		if config.Lint.Baseline != "" && compareVersions(migration.Version, canonicalVersion(config.Lint.Baseline)) <= 0 {
+			continue
+		}
+		script, ok := analysisScript(migration, config)
+		if !ok {
+			continue
+		}
+		tree, err := parseSQL(script)
+		if err != nil {
+			continue
+		}
+		linter := &linter{config: config.Lint, migration: migration, script: script, created: make(map[string]bool)}
+		for _, stmt := range tree.Stmts {
+			linter.statement(stmt)
+		}
+		// Templated scripts are only rendered when applied, so they
+		// cannot be rewritten. Neither are migrations whose other
+		// statements would lose their transaction: a failure part way
+		// would leave them applied without a history row.
+		switch {
+		case !config.Lint.Rewrite || len(linter.rewrites) == 0 || script != migration.Script:
+		case len(tree.Stmts) > 1 && !migration.NoTransaction:
+			for j := range linter.findings {
+				if linter.findings[j].automatic {
+					linter.findings[j].Severity = SeverityError
+					linter.findings[j].Message += "; not rewritten, as the migration's other statements would run outside its transaction: move the statement to a migration of its own"
+				}
+			}
+		default:
+			migrations[i].Script = linter.rewrite()
+			migrations[i].NoTransaction = true
+			for j := range linter.findings {
+				linter.findings[j].Rewritten = linter.findings[j].Suggestion != "" && linter.findings[j].automatic
+			}
+		}
+		findings = append(findings, linter.findings...)
+	}
+	return findings
+}
+
+// checkLint logs the warnings among the findings and returns the errors.
+// Findings that were rewritten into their safe equivalent are only logged.
+func checkLint(findings []LintFinding) error {
+	var errs []error
+	for _, finding := range findings {
+		switch {
+		case finding.Rewritten:
+			log.Printf("NOTE: %s", finding.report())
+		case finding.Severity == SeverityWarning:
+			log.Printf("WARNING: %s", finding.report())
+		case finding.Severity == SeverityError:
+			errs = append(errs, fmt.Errorf("%w: %s", ErrUnsafeMigration, finding.report()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+type linter struct {
+	config    LintConfig
+	migration Migration
+	script    string
+	stmt      *pg_query.RawStmt
+	line      int
+
+	// created holds the tables created earlier in the script, which are
+	// still empty and can be changed freely.
+	created  map[string]bool
+	findings []LintFinding
+	rewrites []rewrite
+}
+
+// rewrite replaces the statement at script[start:end] with sql.
+type rewrite struct {
+	start, end int
+	sql        string
+}
+
+// report records a finding. The suggestion is either the safe SQL that can
+// replace the current statement, when automatic is set, or advice on how to
+// write it by hand.
+func (l *linter) report(rule, message, suggestion string, automatic bool) {
+	severity := l.config.severity(rule)
+	if severity == SeverityOff {
+		return
+	}
+	l.findings = append(l.findings, LintFinding{
+		Path:       l.migration.Path,
+		Line:       l.line,
+		Rule:       rule,
+		Severity:   severity,
+		Message:    message,
+		Suggestion: suggestion,
+		automatic:  automatic,
+	})
+	if automatic {
+		end := len(l.script)
+		if l.stmt.StmtLen > 0 {
+			end = int(l.stmt.StmtLocation + l.stmt.StmtLen)
+		}
+		start := end - len(skipComments(l.script[l.stmt.StmtLocation:end]))
+		l.rewrites = append(l.rewrites, rewrite{start: start, end: end, sql: suggestion})
+	}
+}
+
+// rewrite returns the script with the safe equivalents substituted.
+func (l *linter) rewrite() string {
+	var b strings.Builder
+	last := 0
+	for _, r := range l.rewrites {
+		b.WriteString(l.script[last:r.start])
+		b.WriteString(strings.TrimSuffix(r.sql, ";"))
+		last = r.end
+	}
+	b.WriteString(l.script[last:])
+	return b.String()
+}
+
+func (l *linter) statement(raw *pg_query.RawStmt) {
+	l.stmt = raw
+	start := len(l.script) - len(skipComments(l.script[raw.StmtLocation:]))
+	l.line = 1 + strings.Count(l.script[:start], "\n")
+
+	switch stmt := raw.Stmt.Node.(type) {
+	case *pg_query.Node_CreateStmt:
+		l.created[relationName(stmt.CreateStmt.Relation)] = true
+	case *pg_query.Node_IndexStmt:
+		index := stmt.IndexStmt
+		if !index.Concurrent && !l.created[relationName(index.Relation)] {
+			safe := proto.Clone(index).(*pg_query.IndexStmt)
+			safe.Concurrent = true
+			suggestion, err := deparse(&pg_query.Node{Node: &pg_query.Node_IndexStmt{IndexStmt: safe}})
+			l.report(RuleCreateIndex, fmt.Sprintf("creating an index on %s without CONCURRENTLY blocks writes to the table until it is built", index.Relation.Relname), suggestion, err == nil)
+		}
+	case *pg_query.Node_AlterTableStmt:
+		alter := stmt.AlterTableStmt
+		if alter.Objtype != pg_query.ObjectType_OBJECT_TABLE || l.created[relationName(alter.Relation)] {
+			return
+		}
+		for _, cmd := range alter.Cmds {
+			if cmd, ok := cmd.Node.(*pg_query.Node_AlterTableCmd); ok {
+				l.alterTableCmd(alter, cmd.AlterTableCmd)
+			}
+		}
+	}
+}
+
+func (l *linter) alterTableCmd(alter *pg_query.AlterTableStmt, cmd *pg_query.AlterTableCmd) {
+	table := alter.Relation.Relname
+	switch cmd.Subtype {
+	case pg_query.AlterTableType_AT_AddColumn:
+		column := cmd.Def.GetColumnDef()
+		if column == nil {
+			return
+		}
+		for _, node := range column.Constraints {
+			constraint := node.GetConstraint()
+			if constraint == nil || constraint.Contype != pg_query.ConstrType_CONSTR_DEFAULT {
+				continue
+			}
+			suggestion := fmt.Sprintf("add %s without a default, then SET DEFAULT and backfill existing rows in batches", column.Colname)
+			if l.config.ServerVersion != 0 && l.config.ServerVersion < 11 {
+				l.report(RuleAddColumnDefault, fmt.Sprintf("adding column %s with a default rewrites %s before PostgreSQL 11", column.Colname, table), suggestion, false)
+			} else if name := volatileCall(constraint.RawExpr); name != "" {
+				l.report(RuleAddColumnDefault, fmt.Sprintf("adding column %s with the volatile default %s() rewrites %s", column.Colname, name, table), suggestion, false)
+			}
+		}
+	case pg_query.AlterTableType_AT_AlterColumnType:
+		l.report(RuleAlterColumnType, fmt.Sprintf("changing the type of %s.%s rewrites the table under an exclusive lock", table, cmd.Name),
+			fmt.Sprintf("add a column of the new type, backfill it in batches, then swap it with %s", cmd.Name), false)
+	case pg_query.AlterTableType_AT_SetNotNull:
+		relation := quoteRelation(alter.Relation)
+		check := pq.QuoteIdentifier(cmd.Name + "_not_null")
+		column := pq.QuoteIdentifier(cmd.Name)
+		l.report(RuleSetNotNull, fmt.Sprintf("setting %s.%s NOT NULL scans the table under an exclusive lock unless a valid CHECK (%s IS NOT NULL) constraint exists", table, cmd.Name, cmd.Name),
+			fmt.Sprintf("ALTER TABLE %[1]s ADD CONSTRAINT %[2]s CHECK (%[3]s IS NOT NULL) NOT VALID; ALTER TABLE %[1]s VALIDATE CONSTRAINT %[2]s; ALTER TABLE %[1]s ALTER COLUMN %[3]s SET NOT NULL; ALTER TABLE %[1]s DROP CONSTRAINT %[2]s;", relation, check, column), false)
+	case pg_query.AlterTableType_AT_AddConstraint:
+		constraint := cmd.Def.GetConstraint()
+		if constraint == nil || constraint.SkipValidation {
+			return
+		}
+		switch constraint.Contype {
+		case pg_query.ConstrType_CONSTR_CHECK, pg_query.ConstrType_CONSTR_FOREIGN:
+		default:
+			return
+		}
+		message := fmt.Sprintf("adding constraint %s to %s without NOT VALID scans the table while holding a lock", constraintName(constraint), table)
+		if constraint.Conname == "" || len(alter.Cmds) != 1 {
+			l.report(RuleAddConstraint, message, "add the constraint NOT VALID in its own statement, then VALIDATE CONSTRAINT it in a separate transaction", false)
+			return
+		}
+		safe := proto.Clone(alter).(*pg_query.AlterTableStmt)
+		safeConstraint := safe.Cmds[0].GetAlterTableCmd().Def.GetConstraint()
+		safeConstraint.SkipValidation = true
+		safeConstraint.InitiallyValid = false
+		add, err := deparse(&pg_query.Node{Node: &pg_query.Node_AlterTableStmt{AlterTableStmt: safe}})
+		// The statements of the rewrite run outside a transaction, so a
+		// VALIDATE failing on existing rows leaves the NOT VALID constraint
+		// behind without a history row. Dropping it first lets the fixed
+		// migration run again; the original statement would have failed
+		// on an existing constraint of that name anyway.
+		relation, name := quoteRelation(alter.Relation), pq.QuoteIdentifier(constraint.Conname)
+		if alter.MissingOk {
+			relation = "IF EXISTS " + relation
+		}
+		suggestion := fmt.Sprintf("ALTER TABLE %[1]s DROP CONSTRAINT IF EXISTS %[2]s; %[3]s; ALTER TABLE %[1]s VALIDATE CONSTRAINT %[2]s;", relation, name, add)
+		l.report(RuleAddConstraint, message, suggestion, err == nil)
+	}
+}
+
+// deparse turns a statement back into SQL.
+func deparse(stmt *pg_query.Node) (string, error) {
+	return deparseSQL(&pg_query.ParseResult{Stmts: []*pg_query.RawStmt{{Stmt: stmt}}})
+}
+
+func quoteRelation(relation *pg_query.RangeVar) string {
+	if relation.Schemaname == "" {
+		return pq.QuoteIdentifier(relation.Relname)
+	}
+	return pq.QuoteIdentifier(relation.Schemaname) + "." + pq.QuoteIdentifier(relation.Relname)
+}
+
+// skipComments strips the whitespace and comments preceding a statement.
+func skipComments(s string) string {
+	for {
+		s = strings.TrimLeftFunc(s, unicode.IsSpace)
+		switch {
+		case strings.HasPrefix(s, "--"):
+			end := strings.IndexByte(s, '\n')
+			if end < 0 {
+				return ""
+			}
+			s = s[end:]
+		case strings.HasPrefix(s, "/*"):
+			end := strings.Index(s, "*/")
+			if end < 0 {
+				return ""
+			}
+			s = s[end+2:]
+		default:
+			return s
+		}
+	}
+}
+
+// relationName returns the lower-case, schema-qualified name of a relation
+// as written in the script.
+func relationName(relation *pg_query.RangeVar) string {
+	if relation == nil {
+		return ""
+	}
+	if relation.Schemaname == "" {
+		return relation.Relname
+	}
+	return relation.Schemaname + "." + relation.Relname
+}
+
+func constraintName(constraint *pg_query.Constraint) string {
+	if constraint.Conname != "" {
+		return constraint.Conname
+	}
+	return strings.ToLower(strings.TrimPrefix(constraint.Contype.String(), "CONSTR_"))
+}
+
+// volatileCall returns the name of the first known volatile function called
+// in the expression, or "" if there is none.
+func volatileCall(node *pg_query.Node) string {
+	if node == nil {
+		return ""
+	}
+	var name string
+	walkMessage(node.ProtoReflect(), func(m protoreflect.Message) bool {
+		call, ok := m.Interface().(*pg_query.FuncCall)
+		if !ok || len(call.Funcname) == 0 {
+			return true
+		}
+		last := call.Funcname[len(call.Funcname)-1].GetString_().GetSval()
+		if volatileFunctions[last] {
+			name = last
+			return false
+		}
+		return true
+	})
+	return name
+}
+
+// walkMessage calls fn for m and every message nested in it until fn returns
+// false.
+func walkMessage(m protoreflect.Message, fn func(protoreflect.Message) bool) bool {
+	if !fn(m) {
+		return false
+	}
+	more := true
+	m.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		if field.Kind() != protoreflect.MessageKind {
+			return true
+		}
+		if field.IsList() {
+			list := value.List()
+			for i := 0; i < list.Len() && more; i++ {
+				more = walkMessage(list.Get(i).Message(), fn)
+			}
+		} else if !field.IsMap() {
+			more = walkMessage(value.Message(), fn)
+		}
+		return more
+	})
+	return more
+}
@@ -0,0 +1,88 @@
+package pgmigrate
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLintRewrite(t *testing.T) {
+	if !sqlParserAvailable {
+		t.Skip("built without cgo")
+	}
+	config := Configuration{Lint: LintConfig{Rewrite: true}}
+	for _, test := range []struct {
+		name          string
+		migration     Migration
+		wantRewritten bool
+		wantSeverity  Severity
+	}{
+		{
+			name:          "single statement",
+			migration:     Migration{Path: "1_index.sql", Script: "CREATE INDEX users_email ON users (email);"},
+			wantRewritten: true,
+			wantSeverity:  SeverityWarning,
+		},
+		{
+			name:         "other statements in the transaction",
+			migration:    Migration{Path: "2_index.sql", Script: "ALTER TABLE users ADD COLUMN age int;\nCREATE INDEX users_email ON users (email);"},
+			wantSeverity: SeverityError,
+		},
+		{
+			name:          "already outside a transaction",
+			migration:     Migration{Path: "3_index.sql", Script: "ALTER TABLE users ADD COLUMN age int;\nCREATE INDEX users_email ON users (email);", NoTransaction: true},
+			wantRewritten: true,
+			wantSeverity:  SeverityWarning,
+		},
+	} {
+		migrations := []Migration{test.migration}
+		findings := lintMigrations(migrations, config)
+		if len(findings) != 1 {
+			t.Errorf("%s: findings = %v, want one", test.name, findings)
+			continue
+		}
+		finding := findings[0]
+		if finding.Rewritten != test.wantRewritten || finding.Severity != test.wantSeverity {
+			t.Errorf("%s: rewritten = %v, severity = %s, want %v, %s", test.name, finding.Rewritten, finding.Severity, test.wantRewritten, test.wantSeverity)
+		}
+		rewritten := strings.Contains(migrations[0].Script, "CONCURRENTLY")
+		if rewritten != test.wantRewritten {
+			t.Errorf("%s: script = %q", test.name, migrations[0].Script)
+		}
+		if !test.wantRewritten && migrations[0].NoTransaction != test.migration.NoTransaction {
+			t.Errorf("%s: transaction changed", test.name)
+		}
+	}
+}
+
+func TestLintRewriteAddConstraint(t *testing.T) {
+	if !sqlParserAvailable {
+		t.Skip("built without cgo")
+	}
+	config := Configuration{Lint: LintConfig{Rewrite: true}}
+	migrations := []Migration{{
+		Path:   "1_constraint.sql",
+		Script: "ALTER TABLE orders ADD CONSTRAINT orders_user_fk FOREIGN KEY (user_id) REFERENCES users (id);",
+	}}
+	findings := lintMigrations(migrations, config)
+	if len(findings) != 1 || !findings[0].Rewritten {
+		t.Fatalf("findings = %v, want one rewritten", findings)
+	}
+	if !migrations[0].NoTransaction {
+		t.Error("rewritten migration runs in a transaction")
+	}
+
+	// A VALIDATE failing outside a transaction leaves the NOT VALID
+	// constraint behind, so the rewrite drops it before adding it again.
+	var statements []string
+	for _, statement := range splitStatements(migrations[0].Script) {
+		statements = append(statements, statement.SQL)
+	}
+	want := []string{
+		`ALTER TABLE "orders" DROP CONSTRAINT IF EXISTS "orders_user_fk"`,
+		"ALTER TABLE orders ADD CONSTRAINT orders_user_fk FOREIGN KEY (user_id) REFERENCES users (id) NOT VALID",
+		`ALTER TABLE "orders" VALIDATE CONSTRAINT "orders_user_fk"`,
+	}
+	if strings.Join(statements, "\n") != strings.Join(want, "\n") {
+		t.Errorf("statements = %q, want %q", statements, want)
+	}
+}
@@ -0,0 +1,430 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// liquibaseChangeSet is a changeset of a Liquibase changelog made of SQL.
+type liquibaseChangeSet struct {
+	id, author string
+	contexts   string
+	labels     string
+	sql        []string
+	rollback   []string
+}
+
+// ConvertLiquibaseChangelog converts the changesets of a Liquibase XML,
+// YAML or JSON changelog, and of the changelogs it includes, into
+// numbered migration files of dst, with rollbacks as down migrations.
+// Only sql and sqlFile changes are supported. Each file records its
+// changeset in a "changeset: author:id" header, which "import -format
+// liquibase" uses to map Liquibase's history onto the migrations. It
+// returns the names of the files written.
+func ConvertLiquibaseChangelog(changelog, dst string) ([]string, error) {
+	changeSets, err := readLiquibaseChangelog(changelog, filepath.Dir(changelog), make(map[string]bool))
+	if err != nil {
+		return nil, err
+	}
+	if len(changeSets) == 0 {
+		return nil, fmt.Errorf("no changesets in %s", changelog)
+	}
+	if err := prepareConversionDir(dst); err != nil {
+		return nil, err
+	}
+
+	var written []string
+	for i, cs := range changeSets {
+		var header strings.Builder
+		fmt.Fprintf(&header, "-- changeset: %s:%s\n-- author: %s\n", cs.author, cs.id, cs.author)
+		if cs.contexts != "" {
+			contexts, err := liquibaseContexts(cs.contexts)
+			if err != nil {
+				return written, fmt.Errorf("changeset %s:%s: %w", cs.author, cs.id, err)
+			}
+			fmt.Fprintf(&header, "%s %s\n", contextDirective, contexts)
+		}
+		if cs.labels != "" {
+			fmt.Fprintf(&header, "%s %s\n", labelsDirective, strings.Join(splitList(cs.labels), ","))
+		}
+		name := fmt.Sprintf("%04d_%s", i+1, liquibaseFileName(cs.id))
+		if err := os.WriteFile(filepath.Join(dst, name+".sql"), []byte(header.String()+"\n"+joinSQL(cs.sql)), 0o644); err != nil {
+			return written, err
+		}
+		written = append(written, name+".sql")
+		if len(cs.rollback) > 0 {
+			if err := os.WriteFile(filepath.Join(dst, name+downFileSuffix), []byte(joinSQL(cs.rollback)), 0o644); err != nil {
+				return written, err
+			}
+			written = append(written, name+downFileSuffix)
+		}
+	}
+	return written, nil
+}
+
+// readLiquibaseChangelog reads the changesets of a changelog in order,
+// following includes. Paths not relative to their changelog are resolved
+// against root, the directory of the top-level changelog.
+func readLiquibaseChangelog(path, root string, seen map[string]bool) ([]liquibaseChangeSet, error) {
+	if seen[path] {
+		return nil, fmt.Errorf("%s is included twice", path)
+	}
+	seen[path] = true
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []liquibaseEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".xml":
+		entries, err = parseLiquibaseXML(data)
+	case ".yaml", ".yml", ".json":
+		entries, err = parseLiquibaseYAML(data)
+	default:
+		return nil, fmt.Errorf("%s: unsupported changelog format", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	resolve := func(file string, relative bool) string {
+		if filepath.IsAbs(file) {
+			return file
+		}
+		if relative {
+			return filepath.Join(filepath.Dir(path), file)
+		}
+		return filepath.Join(root, file)
+	}
+	texts := func(list []liquibaseSQL) ([]string, error) {
+		var texts []string
+		for _, s := range list {
+			if s.file == "" {
+				texts = append(texts, s.text)
+				continue
+			}
+			script, err := os.ReadFile(resolve(s.file, s.relative))
+			if err != nil {
+				return nil, err
+			}
+			texts = append(texts, string(script))
+		}
+		return texts, nil
+	}
+	var changeSets []liquibaseChangeSet
+	for _, entry := range entries {
+		if entry.include != "" {
+			included, err := readLiquibaseChangelog(resolve(entry.include, entry.relative), root, seen)
+			if err != nil {
+				return nil, err
+			}
+			changeSets = append(changeSets, included...)
+			continue
+		}
+		cs := entry.changeSet
+		if cs.sql, err = texts(entry.sql); err != nil {
+			return nil, fmt.Errorf("changeset %s:%s: %w", cs.author, cs.id, err)
+		}
+		if cs.rollback, err = texts(entry.rollback); err != nil {
+			return nil, fmt.Errorf("changeset %s:%s: %w", cs.author, cs.id, err)
+		}
+		if len(cs.sql) == 0 {
+			return nil, fmt.Errorf("%s: changeset %s:%s has no SQL", path, cs.author, cs.id)
+		}
+		changeSets = append(changeSets, cs)
+	}
+	return changeSets, nil
+}
+
+// liquibaseEntry is a changeset or an include of a changelog.
+type liquibaseEntry struct {
+	changeSet liquibaseChangeSet
+	sql       []liquibaseSQL
+	rollback  []liquibaseSQL
+	include   string
+	relative  bool
+}
+
+// liquibaseSQL is an sql change, or an sqlFile change naming its file.
+type liquibaseSQL struct {
+	text     string
+	file     string
+	relative bool
+}
+
+// xmlNode is any element of an XML changelog.
+type xmlNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Text     string     `xml:",chardata"`
+	Children []xmlNode  `xml:",any"`
+}
+
+func (n xmlNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func parseLiquibaseXML(data []byte) ([]liquibaseEntry, error) {
+	var root xmlNode
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if root.XMLName.Local != "databaseChangeLog" {
+		return nil, errors.New("root element is not databaseChangeLog")
+	}
+	var entries []liquibaseEntry
+	for _, node := range root.Children {
+		switch node.XMLName.Local {
+		case "changeSet":
+			entry := liquibaseEntry{changeSet: liquibaseChangeSet{
+				id:       node.attr("id"),
+				author:   node.attr("author"),
+				contexts: joinContexts(node.attr("context"), node.attr("contexts")),
+				labels:   node.attr("labels"),
+			}}
+			if node.attr("runInTransaction") == "false" {
+				return nil, fmt.Errorf("changeset %s:%s: runInTransaction=false is not supported", entry.changeSet.author, entry.changeSet.id)
+			}
+			for _, change := range node.Children {
+				switch change.XMLName.Local {
+				case "comment", "validCheckSum":
+				case "rollback":
+					if strings.TrimSpace(change.Text) != "" {
+						entry.rollback = append(entry.rollback, liquibaseSQL{text: change.Text})
+					}
+					for _, c := range change.Children {
+						s, err := xmlSQL(c)
+						if err != nil {
+							return nil, fmt.Errorf("changeset %s:%s rollback: %w", entry.changeSet.author, entry.changeSet.id, err)
+						}
+						entry.rollback = append(entry.rollback, s)
+					}
+				default:
+					s, err := xmlSQL(change)
+					if err != nil {
+						return nil, fmt.Errorf("changeset %s:%s: %w", entry.changeSet.author, entry.changeSet.id, err)
+					}
+					entry.sql = append(entry.sql, s)
+				}
+			}
+			entries = append(entries, entry)
+		case "include":
+			entries = append(entries, liquibaseEntry{include: node.attr("file"), relative: node.attr("relativeToChangelogFile") == "true"})
+		case "property", "preConditions":
+		default:
+			return nil, fmt.Errorf("unsupported element %s", node.XMLName.Local)
+		}
+	}
+	return entries, nil
+}
+
+func xmlSQL(node xmlNode) (liquibaseSQL, error) {
+	switch node.XMLName.Local {
+	case "sql":
+		return liquibaseSQL{text: node.Text}, nil
+	case "sqlFile":
+		return liquibaseSQL{file: node.attr("path"), relative: node.attr("relativeToChangelogFile") == "true"}, nil
+	}
+	return liquibaseSQL{}, fmt.Errorf("unsupported change type %s", node.XMLName.Local)
+}
+
+type yamlChangelog struct {
+	DatabaseChangeLog []struct {
+		ChangeSet *struct {
+			ID               string      `yaml:"id"`
+			Author           string      `yaml:"author"`
+			Context          string      `yaml:"context"`
+			Contexts         string      `yaml:"contexts"`
+			Labels           string      `yaml:"labels"`
+			RunInTransaction *bool       `yaml:"runInTransaction"`
+			Changes          []yaml.Node `yaml:"changes"`
+			Rollback         yaml.Node   `yaml:"rollback"`
+		} `yaml:"changeSet"`
+		Include *struct {
+			File                    string `yaml:"file"`
+			RelativeToChangelogFile bool   `yaml:"relativeToChangelogFile"`
+		} `yaml:"include"`
+		IncludeAll *yaml.Node `yaml:"includeAll"`
+	} `yaml:"databaseChangeLog"`
+}
+
+func parseLiquibaseYAML(data []byte) ([]liquibaseEntry, error) {
+	var changelog yamlChangelog
+	if err := yaml.Unmarshal(data, &changelog); err != nil {
+		return nil, err
+	}
+	var entries []liquibaseEntry
+	for _, item := range changelog.DatabaseChangeLog {
+		switch {
+		case item.ChangeSet != nil:
+			c := item.ChangeSet
+			entry := liquibaseEntry{changeSet: liquibaseChangeSet{
+				id:       c.ID,
+				author:   c.Author,
+				contexts: joinContexts(c.Context, c.Contexts),
+				labels:   c.Labels,
+			}}
+			if c.RunInTransaction != nil && !*c.RunInTransaction {
+				return nil, fmt.Errorf("changeset %s:%s: runInTransaction=false is not supported", c.Author, c.ID)
+			}
+			for i := range c.Changes {
+				s, err := yamlSQL(&c.Changes[i])
+				if err != nil {
+					return nil, fmt.Errorf("changeset %s:%s: %w", c.Author, c.ID, err)
+				}
+				entry.sql = append(entry.sql, s)
+			}
+			rollback, err := yamlRollback(&c.Rollback)
+			if err != nil {
+				return nil, fmt.Errorf("changeset %s:%s rollback: %w", c.Author, c.ID, err)
+			}
+			entry.rollback = rollback
+			entries = append(entries, entry)
+		case item.Include != nil:
+			entries = append(entries, liquibaseEntry{include: item.Include.File, relative: item.Include.RelativeToChangelogFile})
+		case item.IncludeAll != nil:
+			return nil, errors.New("includeAll is not supported")
+		}
+	}
+	return entries, nil
+}
+
+// yamlSQL decodes a change, a mapping from its type to its attributes.
+func yamlSQL(node *yaml.Node) (liquibaseSQL, error) {
+	var change map[string]struct {
+		SQL                     string `yaml:"sql"`
+		Path                    string `yaml:"path"`
+		RelativeToChangelogFile bool   `yaml:"relativeToChangelogFile"`
+	}
+	if err := node.Decode(&change); err != nil {
+		return liquibaseSQL{}, err
+	}
+	for kind, attrs := range change {
+		switch kind {
+		case "sql":
+			return liquibaseSQL{text: attrs.SQL}, nil
+		case "sqlFile":
+			return liquibaseSQL{file: attrs.Path, relative: attrs.RelativeToChangelogFile}, nil
+		}
+		return liquibaseSQL{}, fmt.Errorf("unsupported change type %s", kind)
+	}
+	return liquibaseSQL{}, errors.New("empty change")
+}
+
+// yamlRollback decodes a rollback: raw SQL, a change or a list of changes.
+func yamlRollback(node *yaml.Node) ([]liquibaseSQL, error) {
+	switch node.Kind {
+	case 0:
+		return nil, nil
+	case yaml.ScalarNode:
+		return []liquibaseSQL{{text: node.Value}}, nil
+	case yaml.MappingNode:
+		s, err := yamlSQL(node)
+		return []liquibaseSQL{s}, err
+	}
+	var rollback []liquibaseSQL
+	for _, child := range node.Content {
+		s, err := yamlSQL(child)
+		if err != nil {
+			return nil, err
+		}
+		rollback = append(rollback, s)
+	}
+	return rollback, nil
+}
+
+// joinContexts joins the context and contexts attributes of a changeset,
+// either of which may be empty, as alternatives.
+func joinContexts(expressions ...string) string {
+	var nonEmpty []string
+	for _, expression := range expressions {
+		if strings.TrimSpace(expression) != "" {
+			nonEmpty = append(nonEmpty, expression)
+		}
+	}
+	return strings.Join(nonEmpty, ",")
+}
+
+// liquibaseContexts converts a Liquibase context expression into the list
+// of a context directive. Only alternatives ("a, b" or "a or b") and
+// negations are supported.
+func liquibaseContexts(expression string) (string, error) {
+	expression = strings.ReplaceAll(expression, " or ", ",")
+	if strings.Contains(expression, " and ") || strings.ContainsAny(expression, "()") {
+		return "", fmt.Errorf("context expression %q is not supported", expression)
+	}
+	return strings.Join(splitList(expression), ","), nil
+}
+
+var fileNameUnsafe = regexp.MustCompile(`[^a-z0-9]+`)
+
+// liquibaseFileName turns a changeset id into the description part of a
+// migration file name.
+func liquibaseFileName(id string) string {
+	name := strings.Trim(fileNameUnsafe.ReplaceAllString(strings.ToLower(id), "_"), "_")
+	if name == "" || isDigits(name) {
+		name = "changeset_" + name
+	}
+	return strings.TrimSuffix(name, "_")
+}
+
+// joinSQL joins SQL texts into a script, terminating each with a semicolon.
+func joinSQL(texts []string) string {
+	var b strings.Builder
+	for _, text := range texts {
+		text = strings.TrimSpace(text)
+		if text == "" {
+			continue
+		}
+		b.WriteString(text)
+		if !strings.HasSuffix(text, ";") {
+			b.WriteString(";")
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// liquibaseVersion returns the function reading the last of migrations
+// whose changeset Liquibase recorded in databasechangelog.
+func liquibaseVersion(migrations []Migration) func(context.Context, *sql.Conn) (string, error) {
+	return func(ctx context.Context, conn *sql.Conn) (string, error) {
+		rows, err := conn.QueryContext(ctx, "SELECT id, author FROM databasechangelog")
+		if err != nil {
+			return "", fmt.Errorf("read databasechangelog: %w", err)
+		}
+		defer rows.Close()
+		applied := make(map[string]bool)
+		for rows.Next() {
+			var id, author string
+			if err := rows.Scan(&id, &author); err != nil {
+				return "", err
+			}
+			applied[author+":"+id] = true
+		}
+		if err := rows.Err(); err != nil {
+			return "", err
+		}
+		var version string
+		for _, migration := range migrations {
+			if applied[migration.Headers["changeset"]] {
+				version = migration.Version
+			}
+		}
+		return version, nil
+	}
+}
@@ -0,0 +1,76 @@
+package pgmigrate
+
+import "testing"
+
+func TestLiquibaseChangeSetContexts(t *testing.T) {
+	for _, test := range []struct {
+		name  string
+		parse func([]byte) ([]liquibaseEntry, error)
+		data  string
+		// contexts is the joined attribute and directive the list of the
+		// context directive it converts into.
+		contexts  string
+		directive string
+	}{
+		{
+			name:  "xml context and contexts",
+			parse: parseLiquibaseXML,
+			data: `<databaseChangeLog><changeSet id="1" author="a" context="a" contexts="b">
+<sql>SELECT 1;</sql></changeSet></databaseChangeLog>`,
+			contexts:  "a,b",
+			directive: "a,b",
+		},
+		{
+			name:  "xml contexts only",
+			parse: parseLiquibaseXML,
+			data: `<databaseChangeLog><changeSet id="1" author="a" contexts="b or c">
+<sql>SELECT 1;</sql></changeSet></databaseChangeLog>`,
+			contexts:  "b or c",
+			directive: "b,c",
+		},
+		{
+			name:  "yaml context and contexts",
+			parse: parseLiquibaseYAML,
+			data: `databaseChangeLog:
+  - changeSet:
+      id: "1"
+      author: a
+      context: a
+      contexts: b
+      changes:
+        - sql:
+            sql: SELECT 1;
+`,
+			contexts:  "a,b",
+			directive: "a,b",
+		},
+		{
+			name:  "yaml context only",
+			parse: parseLiquibaseYAML,
+			data: `databaseChangeLog:
+  - changeSet:
+      id: "1"
+      author: a
+      context: a
+      changes:
+        - sql:
+            sql: SELECT 1;
+`,
+			contexts:  "a",
+			directive: "a",
+		},
+	} {
+		entries, err := test.parse([]byte(test.data))
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if len(entries) != 1 || entries[0].changeSet.contexts != test.contexts {
+			t.Errorf("%s: got %+v, want contexts %q", test.name, entries, test.contexts)
+			continue
+		}
+		if directive, err := liquibaseContexts(entries[0].changeSet.contexts); err != nil || directive != test.directive {
+			t.Errorf("%s: directive %q, %v, want %q", test.name, directive, err, test.directive)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// advisoryLockKey is the session-level advisory lock a migration run holds
+// on each database ("pgmigrat" in ASCII), so two runs never migrate the same
+// database concurrently.
+const advisoryLockKey int64 = 0x70676d6967726174
+
+// ErrDatabaseLocked is returned when another run holds a database's
+// advisory lock.
+var ErrDatabaseLocked = errors.New("database is locked by another migration run")
+
+// lockDatabase takes the advisory lock on the connection's database without
+// waiting.
+func lockDatabase(ctx context.Context, conn *sql.Conn) error {
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1)", advisoryLockKey).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return ErrDatabaseLocked
+	}
+	return nil
+}
+
+// unlockDatabase releases the advisory lock. Closing the connection would
+// release it too; unlocking explicitly keeps pooled connections clean.
+func unlockDatabase(conn *sql.Conn) {
+	conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", advisoryLockKey)
+}
+
+// lockSchema takes the advisory lock of a tenant schema without waiting, so
+// that the schemas of a database can be migrated concurrently but none by
+// two runs at once.
+func lockSchema(ctx context.Context, conn *sql.Conn, schema string) error {
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock($1 # hashtext($2)::bigint)", advisoryLockKey, schema).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return ErrDatabaseLocked
+	}
+	return nil
+}
+
+// unlockSchema releases the advisory lock of a tenant schema.
+func unlockSchema(conn *sql.Conn, schema string) {
+	conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1 # hashtext($2)::bigint)", advisoryLockKey, schema)
+}
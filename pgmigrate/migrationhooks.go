@@ -0,0 +1,99 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"text/template"
+
+	"github.com/lib/pq"
+)
+
+// MigrationHook holds SQL run around every migration, such as inserting
+// into an application's audit table or recording pg_stat snapshots. Its
+// scripts run in the migration's transaction, when it has one, so that
+// they commit or roll back with it; AfterEach only runs once the migration
+// succeeded. The scripts are templates like migrations, with the
+// migration's version and description as .Version and .Description in
+// addition to the fields of the target, and the literal function quoting a
+// value as an SQL string:
+//
+//	INSERT INTO audit (event, detail) VALUES ('migration', {{literal .Description}})
+type MigrationHook struct {
+	// Contexts restricts the hook to runs in some contexts, listed as for
+	// context directives, e.g. ["prod"] or ["!dev"].
+	Contexts []string `json:"contexts"`
+
+	BeforeEach string `json:"before_each"`
+	AfterEach  string `json:"after_each"`
+}
+
+// migrationHookData is the data of the templates of migration hooks.
+type migrationHookData struct {
+	templateData
+	Version     string
+	Description string
+}
+
+var migrationHookFuncs = template.FuncMap{"literal": pq.QuoteLiteral}
+
+func (h MigrationHook) validate() error {
+	if h.BeforeEach == "" && h.AfterEach == "" {
+		return errors.New("before_each or after_each is required")
+	}
+	for _, name := range h.Contexts {
+		if name == "" || name == "!" {
+			return errors.New("contexts: empty context")
+		}
+	}
+	if _, err := template.New("before_each").Funcs(migrationHookFuncs).Parse(h.BeforeEach); err != nil {
+		return fmt.Errorf("before_each: %w", err)
+	}
+	if _, err := template.New("after_each").Funcs(migrationHookFuncs).Parse(h.AfterEach); err != nil {
+		return fmt.Errorf("after_each: %w", err)
+	}
+	return nil
+}
+
+// migrationHooks returns the migration hooks of the configured context.
+func (c Configuration) migrationHooks() []MigrationHook {
+	var hooks []MigrationHook
+	for _, hook := range c.MigrationHooks {
+		if c.contextSelected(hook.Contexts) {
+			hooks = append(hooks, hook)
+		}
+	}
+	return hooks
+}
+
+// runMigrationHooks runs the before_each scripts of hooks, or with after
+// their after_each scripts, for a migration on conn.
+func runMigrationHooks(ctx context.Context, conn execer, hooks []MigrationHook, after bool, migration Migration, data templateData) error {
+	name := "before_each"
+	if after {
+		name = "after_each"
+	}
+	for _, hook := range hooks {
+		script := hook.BeforeEach
+		if after {
+			script = hook.AfterEach
+		}
+		if script == "" {
+			continue
+		}
+		tmpl, err := template.New(name).Funcs(migrationHookFuncs).Option("missingkey=error").Parse(script)
+		if err != nil {
+			return fmt.Errorf("%s hook: %w", name, err)
+		}
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, migrationHookData{templateData: data, Version: migration.Version, Description: migration.Description})
+		if err != nil {
+			return fmt.Errorf("%s hook: %w", name, err)
+		}
+		if _, err := conn.ExecContext(ctx, buf.String()); err != nil {
+			return fmt.Errorf("%s hook: %w", name, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,429 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// migrationFilePattern matches versioned migration file names such as
+// 0001_create_users.sql or 2.1_add_index.sql, without their template
+// extension.
+var migrationFilePattern = regexp.MustCompile(`^(\d+(?:\.\d+)*)_(.+)\.sql$`)
+
+// downFileSuffix marks the down migration reverting the migration of the
+// same version, such as 0001_create_users.down.sql.
+const downFileSuffix = ".down.sql"
+
+// legacyScriptName is the single migration script read by the first
+// versions of the tool, which is loaded as version 1.
+const legacyScriptName = "migration_script.sql"
+
+// templateExtension marks scripts rendered as templates, such as
+// 0002_grant.sql.tmpl; scripts may also opt in with the template directive.
+const templateExtension = ".tmpl"
+
+// templateDirective opts a script into rendering as a template.
+const templateDirective = "-- pgmigrate:template"
+
+// isScriptFile reports whether a file is a SQL script, templated or not.
+func isScriptFile(name string) bool {
+	return strings.HasSuffix(name, ".sql") || strings.HasSuffix(name, ".sql"+templateExtension)
+}
+
+// Migration is a single versioned migration script.
+type Migration struct {
+	Version     string
+	Description string
+	Path        string
+	Script      string
+	// Source is the name of the migration source the script comes from
+	// when several are configured.
+	Source string
+	// Checksum is the SHA-256 of the script unless the migrator was
+	// configured with another checksum algorithm.
+	Checksum string
+	// NoTransaction runs the migration outside a transaction regardless
+	// of the database settings.
+	NoTransaction bool
+	// Headers are the "key: value" lines of the comment block opening the
+	// script, such as author or description, keyed in lower case.
+	Headers map[string]string
+	// Labels are the labels of the script's labels directives.
+	Labels []string
+	// Contexts are the contexts of the script's context directives.
+	Contexts []string
+	// DependsOn are the versions of the script's depends-on directives.
+	DependsOn []string
+	// Release is the release of the script's release directive, if any.
+	Release string
+	// DownPath and DownScript are the down migration reverting this one,
+	// if there is one.
+	DownPath   string
+	DownScript string
+}
+
+// LoadMigrations reads the versioned migration scripts from the migration
+// directory, ordered by version. The directory may be a .zip, .tar.gz or
+// .tgz archive, whose top-level members are read without extracting it.
+// A migration_script.sql left from the single-script layout is version 1.
+// Scripts named .sql.tmpl, or with a template directive, are rendered as
+// text/templates with the target and its variables before they run.
+func LoadMigrations(migrationDir string) ([]Migration, error) {
+	if isArchive(migrationDir) {
+		return loadArchive(migrationDir, "")
+	}
+	entries, err := os.ReadDir(migrationDir)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for _, entry := range entries {
+		if !entry.IsDir() && isScriptFile(entry.Name()) {
+			paths = append(paths, filepath.Join(migrationDir, entry.Name()))
+		}
+	}
+	return loadMigrationFiles(paths, os.ReadFile)
+}
+
+// LoadMigrationsGlob reads the versioned migration scripts under the
+// migration directory whose paths relative to it match pattern, ordered
+// by version whatever directory they are in. In the pattern, "**" matches
+// any number of directories, e.g. "**/[0-9]*_*.sql" or "modules/*/*.sql",
+// and the other elements match as in path.Match. Migrations of different
+// directories must not share a version. The pattern matches the members of
+// an archive as it does paths.
+func LoadMigrationsGlob(migrationDir, pattern string) ([]Migration, error) {
+	if isArchive(migrationDir) {
+		return loadArchive(migrationDir, pattern)
+	}
+	var paths []string
+	err := filepath.WalkDir(migrationDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(migrationDir, path)
+		if err != nil {
+			return err
+		}
+		if isScriptFile(path) && matchGlob(pattern, filepath.ToSlash(rel)) {
+			paths = append(paths, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return loadMigrationFiles(paths, os.ReadFile)
+}
+
+// matchGlob reports whether a slash-separated path matches a pattern in
+// which "**" matches any number of path elements.
+func matchGlob(pattern, name string) bool {
+	return matchGlobElements(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchGlobElements(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchGlobElements(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+// loadMigrationFiles reads the migration scripts and down migrations at
+// paths with readFile, ordered by version.
+func loadMigrationFiles(paths []string, readFile func(string) ([]byte, error)) ([]Migration, error) {
+	var migrations []Migration
+	seen := make(map[string]string)
+	downs := make(map[string]string)
+	for _, path := range paths {
+		if isSessionScript(filepath.Base(path)) {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), templateExtension)
+		if name == legacyScriptName {
+			name = "1_migration_script.sql"
+		}
+		if strings.HasSuffix(name, downFileSuffix) {
+			match := migrationFilePattern.FindStringSubmatch(name)
+			if match == nil {
+				return nil, fmt.Errorf("migration file %s does not match <version>_<description>.down.sql", path)
+			}
+			version := canonicalVersion(match[1])
+			if other, ok := downs[version]; ok {
+				return nil, fmt.Errorf("down migrations %s and %s share version %s", other, path, version)
+			}
+			downs[version] = path
+			continue
+		}
+		match := migrationFilePattern.FindStringSubmatch(name)
+		if match == nil {
+			return nil, fmt.Errorf("migration file %s does not match <version>_<description>.sql", path)
+		}
+		version := canonicalVersion(match[1])
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migration files %s and %s share version %s", other, path, version)
+		}
+		seen[version] = path
+
+		file, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		migration, err := parseMigration(path, file, version, strings.ReplaceAll(match[2], "_", " "))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration)
+	}
+
+	for i, migration := range migrations {
+		path, ok := downs[migration.Version]
+		if !ok {
+			continue
+		}
+		delete(downs, migration.Version)
+		if migration.DownScript != "" {
+			return nil, fmt.Errorf("%s has a goose Down section and down migration %s", filepath.Base(migration.Path), filepath.Base(path))
+		}
+		script, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		migrations[i].DownPath = path
+		migrations[i].DownScript = string(script)
+	}
+	for _, path := range downs {
+		return nil, fmt.Errorf("down migration %s has no migration of the same version", filepath.Base(path))
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return compareVersions(migrations[i].Version, migrations[j].Version) < 0
+	})
+	return migrations, nil
+}
+
+// loadMigration reads a migration script and validates its directives.
+func loadMigration(path, version, description string) (Migration, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return Migration{}, err
+	}
+	return parseMigration(path, file, version, description)
+}
+
+// parseMigration parses the content of a migration script and validates
+// its directives.
+func parseMigration(path string, file []byte, version, description string) (Migration, error) {
+	script, down, goose := gooseSections(string(file))
+	backfills, err := scriptBackfills(script)
+	if err != nil {
+		return Migration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if backfills && !sqlParserAvailable {
+		return Migration{}, fmt.Errorf("%s: backfill directives need the SQL parser, unavailable in builds without cgo", path)
+	}
+	repacks, err := scriptRepacks(script)
+	if err != nil {
+		return Migration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := scriptPartitions(script); err != nil {
+		return Migration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := scriptRefreshes(script); err != nil {
+		return Migration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := scriptPreconditions(script); err != nil {
+		return Migration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if _, err := scriptFeatures(script); err != nil {
+		return Migration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	parallel, err := scriptParallel(script)
+	if err != nil {
+		return Migration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	labels, err := scriptLabels(script)
+	if err != nil {
+		return Migration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	contexts, err := scriptContexts(script)
+	if err != nil {
+		return Migration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	dependsOn, err := scriptDependencies(script)
+	if err != nil {
+		return Migration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	release, err := scriptRelease(script)
+	if err != nil {
+		return Migration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	migration := Migration{
+		Version:       version,
+		Description:   description,
+		Path:          path,
+		Script:        script,
+		Checksum:      ChecksumConfig{}.Sum(script),
+		NoTransaction: backfills || parallel || len(repacks) > 0 || hasAnnotation(script, gooseNoTransaction),
+		Headers:       scriptHeaders(script),
+		Labels:        labels,
+		Contexts:      contexts,
+		DependsOn:     dependsOn,
+		Release:       release,
+	}
+	if goose && strings.TrimSpace(down) != "" {
+		migration.DownPath = path
+		migration.DownScript = down
+	}
+	return migration, nil
+}
+
+// headerPattern matches a "key: value" line of a script header.
+var headerPattern = regexp.MustCompile(`^--\s*([A-Za-z][A-Za-z0-9_-]*):\s+(.*?)\s*$`)
+
+// scriptHeaders returns the "key: value" lines of the comment block at the
+// top of a script. Directives are not headers.
+func scriptHeaders(script string) map[string]string {
+	headers := make(map[string]string)
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "--") {
+			if line == "" {
+				continue
+			}
+			break
+		}
+		if match := headerPattern.FindStringSubmatch(line); match != nil {
+			headers[strings.ToLower(match[1])] = match[2]
+		}
+	}
+	return headers
+}
+
+// canonicalVersion strips leading zeros from each part of a version so that
+// 0001 and 1 refer to the same migration.
+func canonicalVersion(version string) string {
+	parts := strings.Split(version, ".")
+	for i, part := range parts {
+		trimmed := strings.TrimLeft(part, "0")
+		if trimmed == "" {
+			trimmed = "0"
+		}
+		parts[i] = trimmed
+	}
+	return strings.Join(parts, ".")
+}
+
+// compareVersions compares two dotted numeric versions part by part,
+// returning -1, 0 or 1.
+func compareVersions(a, b string) int {
+	aParts := strings.Split(canonicalVersion(a), ".")
+	bParts := strings.Split(canonicalVersion(b), ".")
+	for i := 0; i < len(aParts) || i < len(bParts); i++ {
+		var x, y string
+		if i < len(aParts) {
+			x = aParts[i]
+		}
+		if i < len(bParts) {
+			y = bParts[i]
+		}
+		if c := compareNumeric(x, y); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareNumeric compares two non-negative decimal numbers of any length.
+// A missing part sorts before any present one.
+func compareNumeric(x, y string) int {
+	switch {
+	case len(x) != len(y):
+		if len(x) < len(y) {
+			return -1
+		}
+		return 1
+	case x < y:
+		return -1
+	case x > y:
+		return 1
+	}
+	return 0
+}
+
+// templateData is the data migration scripts are rendered with.
+type templateData struct {
+	Cluster  string
+	Database string
+	Schema   string // the tenant schema in schema-per-tenant mode
+	Vars     map[string]string
+}
+
+// isTemplate reports whether a script is rendered as a template, because of
+// its extension or its template directive. Other scripts are run as they
+// are, so that SQL such as '{{1,2},{3,4}}' needs no escaping.
+func isTemplate(path, script string) bool {
+	return strings.HasSuffix(path, templateExtension) || hasAnnotation(script, templateDirective)
+}
+
+// renderScript executes the migration script as a text/template, giving it
+// access to the target and its template variables, if it is a template.
+func renderScript(migration Migration, data templateData) (string, error) {
+	if !isTemplate(migration.Path, migration.Script) {
+		return migration.Script, nil
+	}
+	tmpl, err := template.New(filepath.Base(migration.Path)).Option("missingkey=error").Parse(migration.Script)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// parseVersion validates a version given in configuration or on the command
+// line.
+func parseVersion(version string) (string, error) {
+	for _, part := range strings.Split(version, ".") {
+		if !isDigits(part) {
+			return "", fmt.Errorf("invalid version %q", version)
+		}
+	}
+	return canonicalVersion(version), nil
+}
+
+func isDigits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,138 @@
+package pgmigrate
+
+import (
+	"io/fs"
+	"strings"
+	"testing"
+)
+
+// readFiles returns a readFile function reading from files.
+func readFiles(files map[string]string) func(string) ([]byte, error) {
+	return func(path string) ([]byte, error) {
+		content, ok := files[path]
+		if !ok {
+			return nil, fs.ErrNotExist
+		}
+		return []byte(content), nil
+	}
+}
+
+func TestLoadMigrationFiles(t *testing.T) {
+	files := map[string]string{
+		"m/migration_script.sql":   "CREATE TABLE a (id int);",
+		"m/2_add_b.sql":            "CREATE TABLE b (id int);",
+		"m/3_grant.sql.tmpl":       "GRANT SELECT ON b TO {{.Vars.role}};",
+		"m/3_grant.down.sql.tmpl":  "REVOKE SELECT ON b FROM {{.Vars.role}};",
+		"m/_before.sql":            "SET search_path = public;",
+		"m/10.1_array_literal.sql": "INSERT INTO t VALUES ('{{1,2},{3,4}}');",
+	}
+	var paths []string
+	for path := range files {
+		paths = append(paths, path)
+	}
+	migrations, err := loadMigrationFiles(paths, readFiles(files))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, migration := range migrations {
+		got = append(got, migration.Version+" "+migration.Description)
+	}
+	want := []string{"1 migration script", "2 add b", "3 grant", "10.1 array literal"}
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Fatalf("migrations = %q, want %q", got, want)
+	}
+	if migrations[2].DownPath != "m/3_grant.down.sql.tmpl" {
+		t.Errorf("down path = %q, want m/3_grant.down.sql.tmpl", migrations[2].DownPath)
+	}
+}
+
+func TestLoadMigrationFilesRejects(t *testing.T) {
+	for name, files := range map[string]map[string]string{
+		"unversioned":       {"m/create.sql": ""},
+		"legacy duplicate":  {"m/migration_script.sql": "", "m/1_init.sql": ""},
+		"shared version":    {"m/1_a.sql": "", "m/1_b.sql.tmpl": ""},
+		"orphan down":       {"m/1_a.sql": "", "m/2_b.down.sql": ""},
+		"templated session": {"m/_before.sql.tmpl": ""},
+	} {
+		var paths []string
+		for path := range files {
+			paths = append(paths, path)
+		}
+		if _, err := loadMigrationFiles(paths, readFiles(files)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestRenderScript(t *testing.T) {
+	data := templateData{Cluster: "main", Database: "app", Vars: map[string]string{"role": "reader"}}
+	for _, test := range []struct {
+		name      string
+		migration Migration
+		want      string
+		wantErr   bool
+	}{
+		{
+			name:      "plain SQL is not a template",
+			migration: Migration{Path: "1_a.sql", Script: "SELECT '{{1,2},{3,4}}'::int[][];"},
+			want:      "SELECT '{{1,2},{3,4}}'::int[][];",
+		},
+		{
+			name:      "template extension",
+			migration: Migration{Path: "1_a.sql.tmpl", Script: "GRANT SELECT ON t TO {{.Vars.role}};"},
+			want:      "GRANT SELECT ON t TO reader;",
+		},
+		{
+			name:      "template directive",
+			migration: Migration{Path: "1_a.sql", Script: "-- pgmigrate:template\nCOMMENT ON DATABASE {{.Database}} IS 'x';"},
+			want:      "-- pgmigrate:template\nCOMMENT ON DATABASE app IS 'x';",
+		},
+		{
+			name:      "missing variable",
+			migration: Migration{Path: "1_a.sql.tmpl", Script: "{{.Vars.missing}}"},
+			wantErr:   true,
+		},
+	} {
+		got, err := renderScript(test.migration, data)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("%s: no error", test.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
+
+func TestMatchGlob(t *testing.T) {
+	for _, test := range []struct {
+		pattern, name string
+		want          bool
+	}{
+		{"*.sql", "1_a.sql", true},
+		{"*.sql", "sub/1_a.sql", false},
+		{"sub/*.sql", "sub/1_a.sql", true},
+		{"**/*.sql", "1_a.sql", true},
+		{"**/*.sql", "a/b/c/1_a.sql", true},
+		{"**", "a/b/1_a.sql", true},
+		{"a/**/1_*.sql", "a/1_a.sql", true},
+		{"a/**/1_*.sql", "a/b/c/1_a.sql", true},
+		{"a/**/1_*.sql", "b/c/1_a.sql", false},
+		{"a/**/b/*.sql", "a/x/b/y/1_a.sql", false},
+		{"tenant_[ab]/*.sql", "tenant_b/1_a.sql", true},
+		{"tenant_[ab]/*.sql", "tenant_c/1_a.sql", false},
+		{"sub", "sub/1_a.sql", false},
+	} {
+		if got := matchGlob(test.pattern, test.name); got != test.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", test.pattern, test.name, got, test.want)
+		}
+	}
+}
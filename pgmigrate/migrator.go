@@ -0,0 +1,483 @@
+// Package pgmigrate applies versioned SQL migrations to every database of one
+// or more PostgreSQL clusters.
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Target identifies a single database on a cluster, or a tenant schema of
+// it in schema-per-tenant mode.
+type Target struct {
+	Cluster  ClusterConfig
+	Database string
+	Schema   string
+	// Size is the size of the database in bytes, measured during discovery
+	// when ordering by size and zero otherwise.
+	Size int64
+}
+
+// MigrationResult holds information about the result of a migration.
+type MigrationResult struct {
+	Cluster  string
+	Database string
+	Schema   string
+	Success  bool
+
+	// Error is why the database failed. Use errors.Is and errors.As to
+	// tell ErrConnectionFailed, ErrDatabaseLocked, ErrChecksumMismatch and
+	// a *MigrationError, which locates the failing statement, apart.
+	Error error
+
+	// ErrorClass is ErrorTransient or ErrorPermanent for a failed database.
+	ErrorClass string
+
+	// Attempts is the number of times the database was tried, retries of
+	// transient failures included.
+	Attempts int
+
+	// Duration is how long the database took, waiting for its lock
+	// included.
+	Duration time.Duration
+
+	// Applied lists the versions applied to the database during this run.
+	Applied []string
+
+	// Repeated lists the repeatable migrations applied because they are
+	// new or changed, in Flyway mode.
+	Repeated []string
+
+	// Bootstrapped is the version of the schema snapshot a brand-new
+	// database was initialized from, if it was.
+	Bootstrapped string
+
+	// Skipped lists the versions left unapplied because their context or
+	// labels are not selected, a feature flag gating them is disabled for the
+	// database or a precondition with on_fail=skip did not hold.
+	Skipped []string
+
+	// Extensions describes the extensions created or updated.
+	Extensions []string
+
+	// Refreshed lists the materialized views refreshed after the
+	// migrations, with the time each refresh took.
+	Refreshed []RefreshResult
+
+	// Sequences lists the sequences found behind their column, and
+	// whether they were fixed.
+	Sequences []SequenceIssue
+
+	// SchemaBefore and SchemaAfter are fingerprints of the schema before
+	// and after the run, and SchemaChanges the objects that changed, when
+	// schema diffs are enabled.
+	SchemaBefore  string
+	SchemaAfter   string
+	SchemaChanges []SchemaChange
+
+	// Validated lists the constraints validated after every database was
+	// migrated, when constraint validation runs after migrations.
+	Validated []string
+
+	// Diagnostics is the directory of the diagnostic bundle collected
+	// when the database failed, if diagnostics are enabled.
+	Diagnostics string
+}
+
+// Migrator applies a set of migrations to the databases of the configured
+// clusters.
+//
+// Execution order is deterministic. Migrations are loaded in version order
+// and applied to each database one after the other in that order. The
+// databases of each cluster are started in the order of their targets,
+// which Targets sorts by the configured OrderBy, each one as soon as the
+// concurrency limits allow: while a database waits for a slot of its
+// cluster or host, the later databases of its cluster wait too, but those of
+// other clusters do not. Results are returned in the order of the targets,
+// whatever order the databases finish in.
+type Migrator struct {
+	config     Configuration
+	migrations []Migration
+}
+
+// New resolves the clusters of the configuration and loads its migrations.
+func New(config Configuration) (*Migrator, error) {
+	clusters, err := config.ResolveClusters()
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve clusters: %w", err)
+	}
+	config.Clusters = clusters
+	if _, _, err := parseShard(config.Shard); err != nil {
+		return nil, err
+	}
+
+	if config.AccessFile != "" {
+		config.Access, err = LoadAccess(config.AccessFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load access file: %w", err)
+		}
+	}
+
+	if config.Bootstrap != nil {
+		bootstrap := *config.Bootstrap
+		if err := bootstrap.load(); err != nil {
+			return nil, fmt.Errorf("failed to load bootstrap snapshot: %w", err)
+		}
+		config.Bootstrap = &bootstrap
+	}
+
+	var migrations []Migration
+	// Remote sources are loaded from local copies, removed once the
+	// migrations are read and verified.
+	local := config
+	if config.Source == nil {
+		var cleanup func()
+		local, cleanup, err = fetchSources(context.Background(), config)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch migrations: %w", err)
+		}
+		defer cleanup()
+	}
+	if config.Source != nil {
+		migrations, err = config.Source.LoadMigrations()
+		sort.SliceStable(migrations, func(i, j int) bool {
+			return compareVersions(migrations[i].Version, migrations[j].Version) < 0
+		})
+	} else if config.Flyway != nil {
+		flyway := *config.Flyway
+		migrations, flyway.Repeatables, err = LoadFlywayMigrations(local.MigrationDir)
+		for i := range flyway.Repeatables {
+			flyway.Repeatables[i].Checksum = config.Checksum.Sum(flyway.Repeatables[i].Script)
+		}
+		config.Flyway = &flyway
+	} else if len(config.Sources) > 0 {
+		migrations, err = loadSources(local.Sources)
+	} else if config.MigrationGlob != "" {
+		migrations, err = LoadMigrationsGlob(local.MigrationDir, config.MigrationGlob)
+	} else {
+		migrations, err = LoadMigrations(local.MigrationDir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load migrations: %w", err)
+	}
+	if err := validateDependencies(migrations); err != nil {
+		return nil, err
+	}
+	if config.Source == nil && local.MigrationDir != "" {
+		readFile, err := migrationFileReader(local.MigrationDir)
+		if err != nil {
+			return nil, err
+		}
+		if config.SessionScripts, err = loadSessionScripts(local.MigrationDir, readFile); err != nil {
+			return nil, fmt.Errorf("failed to load session scripts: %w", err)
+		}
+	}
+	if config.Signatures != nil {
+		dirs := []string{local.MigrationDir}
+		for _, source := range local.Sources {
+			dirs = append(dirs, source.Dir)
+		}
+		readFile, err := migrationFileReader(dirs...)
+		if err != nil {
+			return nil, err
+		}
+		signed := migrations
+		if scripts := config.SessionScripts; scripts != nil && config.Source == nil {
+			for _, script := range []*Migration{scripts.Before, scripts.After} {
+				if script != nil {
+					signed = append(signed[:len(signed):len(signed)], *script)
+				}
+			}
+		}
+		if err := verifySignatures(signed, config.Signatures, readFile); err != nil {
+			return nil, err
+		}
+	}
+	// Checksums cover the files as written, before any lint rewrite.
+	for i := range migrations {
+		migrations[i].Checksum = config.Checksum.Sum(migrations[i].Script)
+	}
+	if !config.SkipSyntaxCheck && !sqlParserAvailable {
+		log.Printf("WARNING: syntax check unavailable: pgmigrate was built without cgo; migrations are neither checked nor linted")
+	}
+	if !config.SkipSyntaxCheck && sqlParserAvailable {
+		if err := validateSyntax(migrations, config); err != nil {
+			return nil, err
+		}
+		if err := checkLint(lintMigrations(migrations, config)); err != nil {
+			return nil, err
+		}
+	}
+	return &Migrator{config: config, migrations: migrations}, nil
+}
+
+// Config returns the configuration the migrator runs with, with its clusters
+// resolved.
+func (m *Migrator) Config() Configuration {
+	return m.config
+}
+
+// Migrations returns the migrations the migrator applies, ordered by
+// version.
+func (m *Migrator) Migrations() []Migration {
+	return m.migrations
+}
+
+// Targets lists the databases to migrate on every cluster, or those of the
+// configured shard, in the configured order and from the highest priority
+// to the lowest.
+func (m *Migrator) Targets(ctx context.Context) ([]Target, error) {
+	targets, err := discoverTargets(ctx, m.config)
+	if err != nil {
+		return nil, err
+	}
+	if targets, err = ShardTargets(targets, m.config.Shard); err != nil {
+		return nil, err
+	}
+	if err := OrderTargets(targets, m.config.OrderBy); err != nil {
+		return nil, err
+	}
+	PrioritizeTargets(targets, m.config.Priorities)
+	return targets, nil
+}
+
+// Migrate applies the pending migrations to the given databases, starting
+// them in the order given. With priority tiers configured, each run of
+// targets of the same priority finishes before the next starts.
+func (m *Migrator) Migrate(ctx context.Context, targets []Target) []MigrationResult {
+	config, closeQueue := withReportQueue(m.config)
+	defer closeQueue()
+	if !config.PriorityTiers {
+		return migrateDatabases(ctx, config, m.migrations, targets)
+	}
+	var results []MigrationResult
+	migrateByPriority(config, targets, func(tier []Target) int {
+		tierResults := migrateDatabases(ctx, config, m.migrations, tier)
+		results = append(results, tierResults...)
+		failed := 0
+		for _, result := range tierResults {
+			if !result.Success {
+				failed++
+			}
+		}
+		return failed
+	}, func(target Target, err error) {
+		results = append(results, notStarted(config, target, err))
+	})
+	return results
+}
+
+// Run discovers the databases of every cluster and migrates them while
+// holding the configured run lock, followed by the maintained template
+// database of each cluster, if any, and sends the configured notifications.
+// If the lock is lost mid-run, its context is canceled: databases not yet
+// started are skipped, and migrations in flight are canceled, stopping their
+// statements partway.
+func (m *Migrator) Run(ctx context.Context) ([]MigrationResult, error) {
+	ctx, release, err := acquireRunLock(ctx, m.config.RunLock)
+	if err != nil {
+		return nil, fmt.Errorf("failed to acquire run lock: %w", err)
+	}
+	defer release()
+
+	id, started := m.config.newRunID(), time.Now()
+	notifyStart(ctx, m.config, RunSummary{ID: id, Started: started, Build: Build()})
+	targets, err := m.Targets(ctx)
+	if err != nil {
+		return nil, err
+	}
+	results := m.Migrate(ctx, targets)
+	if m.config.ConstraintValidation != nil && m.config.ConstraintValidation.AfterMigrate {
+		m.validateMigrated(ctx, targets, results)
+	}
+	results = append(results, m.migrateTemplates(ctx, targets)...)
+	notify(ctx, m.config, NewRunSummary(id, started, results))
+	return results, nil
+}
+
+// validateMigrated runs the constraint validation phase on the databases
+// migrated successfully and adds its outcome to their results.
+func (m *Migrator) validateMigrated(ctx context.Context, targets []Target, results []MigrationResult) {
+	// Constraints are validated once per database, whatever the number of
+	// its tenant schemas, and reported on the result of its first target.
+	index := make(map[[2]string]int)
+	for i, result := range results {
+		key := [2]string{result.Cluster, result.Database}
+		if _, ok := index[key]; !ok && result.Success {
+			index[key] = i
+		}
+	}
+	var migrated []Target
+	for _, target := range targets {
+		key := [2]string{target.Cluster.Name, target.Database}
+		if i, ok := index[key]; ok && results[i].Schema == target.Schema {
+			migrated = append(migrated, Target{Cluster: target.Cluster, Database: target.Database})
+		}
+	}
+	for _, validation := range m.ValidateConstraints(ctx, migrated) {
+		i := index[[2]string{validation.Cluster, validation.Database}]
+		results[i].Validated = validation.Changes
+		if validation.Error != nil {
+			results[i].Success = false
+			results[i].Error = validation.Error
+		}
+	}
+}
+
+// Close releases the SSH tunnels opened to reach the clusters.
+func (m *Migrator) Close() error {
+	closeSSHTunnels()
+	return nil
+}
+
+// discoverTargets lists the databases to migrate on every cluster, leaving
+// out excluded ones. Clusters registered in Consul are added to the
+// configured ones, and clusters located through SRV records are resolved
+// first, so the targets carry the current primary's address.
+func discoverTargets(ctx context.Context, config Configuration) ([]Target, error) {
+	discovered, err := discoverConsulClusters(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+
+	var targets []Target
+	for _, cluster := range append(config.Clusters[:len(config.Clusters):len(config.Clusters)], discovered...) {
+		cluster, err := resolveSRV(ctx, cluster)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", cluster.Name, err)
+		}
+		list := fetchDatabases
+		if config.Lister != nil {
+			list = config.Lister.ListDatabases
+		}
+		databases, err := cachedDatabases(ctx, config.DiscoveryCache, cluster, list)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch databases from %s: %w", cluster.Name, err)
+		}
+		var sizes map[string]int64
+		if config.OrderBy == OrderBySize && config.Lister == nil {
+			sizes, err = fetchDatabaseSizes(ctx, cluster)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch database sizes from %s: %w", cluster.Name, err)
+			}
+		}
+		for _, dbName := range databases {
+			if config.excluded(dbName) {
+				continue
+			}
+			if config.TenantSchemas == nil {
+				targets = append(targets, Target{Cluster: cluster, Database: dbName, Size: sizes[dbName]})
+				continue
+			}
+			schemas, err := fetchTenantSchemas(ctx, cluster, dbName, config.TenantSchemas.Query)
+			if err != nil {
+				return nil, fmt.Errorf("failed to fetch tenant schemas from %s/%s: %w", cluster.Name, dbName, err)
+			}
+			for _, schema := range schemas {
+				targets = append(targets, Target{Cluster: cluster, Database: dbName, Schema: schema, Size: sizes[dbName]})
+			}
+		}
+	}
+	return targets, nil
+}
+
+// migrateDatabases performs schema migrations for multiple databases.
+func migrateDatabases(ctx context.Context, config Configuration, migrations []Migration, targets []Target) []MigrationResult {
+	return forEachTarget(ctx, config, targets, func(ctx context.Context, target Target) MigrationResult {
+		return migrateTarget(ctx, config, migrations, target)
+	}, func(target Target, err error) MigrationResult {
+		return notStarted(config, target, err)
+	})
+}
+
+// migrateTarget migrates a single database, retrying as configured,
+// collects diagnostics if it failed, runs its hooks and reports its result.
+func migrateTarget(ctx context.Context, config Configuration, migrations []Migration, target Target) MigrationResult {
+	started := time.Now()
+	if starter, ok := startReporter(config.Reporter); ok {
+		starter.ReportStart(target)
+	}
+	result := withRetries(ctx, config.Retry, config.classifyError, func() MigrationResult {
+		if config.Executor != nil {
+			return config.Executor.Execute(ctx, target, migrations)
+		}
+		return migrateDatabase(ctx, config, migrations, target, target.Database)
+	})
+	if result.Duration == 0 {
+		result.Duration = time.Since(started)
+	}
+	result.ErrorClass = config.classifyError(result.Error)
+	if !result.Success && config.Diagnostics != nil {
+		result.Diagnostics = collectDiagnostics(ctx, config.Diagnostics, target, result)
+	}
+	runHooks(ctx, config.Hooks, result)
+	if config.Reporter != nil {
+		config.Reporter.Report(result)
+	}
+	return result
+}
+
+// notStarted reports and returns the result of a database that was not
+// started because of err.
+func notStarted(config Configuration, target Target, err error) MigrationResult {
+	result := MigrationResult{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema, Error: err, ErrorClass: config.classifyError(err)}
+	if config.Reporter != nil {
+		config.Reporter.Report(result)
+	}
+	return result
+}
+
+// forEachTarget runs fn for every target concurrently, within the
+// configured concurrency limits, and returns the results in the order of
+// targets. The targets of each cluster are queued in order and started one
+// after the other as their slots free up; clusters are dispatched
+// independently, so a cluster out of slots does not hold up the others.
+// Targets not yet started when ctx is done get the result of canceled
+// instead.
+func forEachTarget[R any](ctx context.Context, config Configuration, targets []Target, fn func(context.Context, Target) R, canceled func(Target, error) R) []R {
+	var wg sync.WaitGroup
+	results := make([]R, len(targets))
+	budget := newConcurrencyBudget(config)
+
+	var clusters []string
+	queues := make(map[string][]int)
+	for i, target := range targets {
+		name := target.Cluster.Name
+		if _, ok := queues[name]; !ok {
+			clusters = append(clusters, name)
+		}
+		queues[name] = append(queues[name], i)
+	}
+
+	var dispatchers sync.WaitGroup
+	for _, name := range clusters {
+		dispatchers.Add(1)
+		go func(queue []int) {
+			defer dispatchers.Done()
+			for _, i := range queue {
+				target := targets[i]
+				budget.acquire(target.Cluster)
+				if err := ctx.Err(); err != nil {
+					budget.release(target.Cluster)
+					results[i] = canceled(target, err)
+					continue
+				}
+
+				wg.Add(1)
+				go func(i int, target Target) {
+					defer wg.Done()
+					defer budget.release(target.Cluster)
+					results[i] = fn(ctx, target)
+				}(i, target)
+			}
+		}(queues[name])
+	}
+	dispatchers.Wait()
+	wg.Wait()
+
+	return results
+}
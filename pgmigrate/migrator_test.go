@@ -0,0 +1,85 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestForEachTargetClusterDoesNotBlockOthers(t *testing.T) {
+	busy := ClusterConfig{Name: "busy", MaxConcurrency: 1}
+	idle := ClusterConfig{Name: "idle"}
+	targets := []Target{
+		{Cluster: busy, Database: "a"},
+		{Cluster: busy, Database: "b"},
+		{Cluster: idle, Database: "c"},
+	}
+
+	// The first database of the capped cluster only finishes once the
+	// database of the other cluster ran, which it cannot if that one waits
+	// behind the capped cluster's second database.
+	ran := make(chan struct{})
+	results := forEachTarget(context.Background(), Configuration{}, targets, func(ctx context.Context, target Target) string {
+		switch target.Database {
+		case "a":
+			select {
+			case <-ran:
+			case <-time.After(5 * time.Second):
+				return "timed out"
+			}
+		case "c":
+			close(ran)
+		}
+		return target.Database
+	}, func(target Target, err error) string {
+		return "canceled"
+	})
+
+	want := []string{"a", "b", "c"}
+	for i := range want {
+		if results[i] != want[i] {
+			t.Fatalf("results = %v, want %v", results, want)
+		}
+	}
+}
+
+func TestForEachTargetClusterOrder(t *testing.T) {
+	cluster := ClusterConfig{Name: "main", MaxConcurrency: 1}
+	var targets []Target
+	for _, name := range []string{"a", "b", "c", "d"} {
+		targets = append(targets, Target{Cluster: cluster, Database: name})
+	}
+
+	// With one slot, the databases of a cluster run in the order of the
+	// targets.
+	var order []string
+	forEachTarget(context.Background(), Configuration{}, targets, func(ctx context.Context, target Target) struct{} {
+		order = append(order, target.Database)
+		return struct{}{}
+	}, func(target Target, err error) struct{} {
+		return struct{}{}
+	})
+
+	want := []string{"a", "b", "c", "d"}
+	for i := range want {
+		if len(order) != len(want) || order[i] != want[i] {
+			t.Fatalf("order = %v, want %v", order, want)
+		}
+	}
+}
+
+func TestForEachTargetCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	targets := []Target{{Cluster: ClusterConfig{Name: "main"}, Database: "a"}}
+
+	results := forEachTarget(ctx, Configuration{}, targets, func(ctx context.Context, target Target) error {
+		return nil
+	}, func(target Target, err error) error {
+		return err
+	})
+	if !errors.Is(results[0], context.Canceled) {
+		t.Fatalf("result = %v, want context.Canceled", results[0])
+	}
+}
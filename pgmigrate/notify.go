@@ -0,0 +1,243 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NotificationConfig lists where the outcome of each run is sent.
+// Notifications are sent once a run is over; a notification that cannot be
+// sent is logged and does not fail the run.
+type NotificationConfig struct {
+	// Email sends a summary of each run by SMTP.
+	Email *EmailConfig `json:"email"`
+
+	// Alert opens a PagerDuty or Opsgenie incident when a run fails.
+	Alert *AlertConfig `json:"alert"`
+
+	// CommitStatus reports each run as a GitHub or GitLab commit status.
+	CommitStatus *CommitStatusConfig `json:"commit_status"`
+
+	// Datadog sends an event per run and DogStatsD metrics.
+	Datadog *DatadogConfig `json:"datadog"`
+
+	// StatsD sends the metrics of each run to a StatsD server.
+	StatsD *StatsDConfig `json:"statsd"`
+
+	// Webhooks post a templated message about each run, e.g. to Slack.
+	Webhooks []WebhookConfig `json:"webhooks"`
+}
+
+func (c *NotificationConfig) validate() error {
+	if c.Email != nil {
+		if err := c.Email.validate(); err != nil {
+			return fmt.Errorf("email: %w", err)
+		}
+	}
+	if c.Alert != nil {
+		if err := c.Alert.validate(); err != nil {
+			return fmt.Errorf("alert: %w", err)
+		}
+	}
+	if c.CommitStatus != nil {
+		if err := c.CommitStatus.validate(); err != nil {
+			return fmt.Errorf("commit_status: %w", err)
+		}
+	}
+	for i, webhook := range c.Webhooks {
+		if err := webhook.validate(); err != nil {
+			return fmt.Errorf("webhooks[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// RunSummary describes a completed run. Its JSON form is the run manifest
+// attached to notifications.
+type RunSummary struct {
+	ID        string            `json:"id"`
+	Started   time.Time         `json:"started"`
+	Finished  time.Time         `json:"finished"`
+	Succeeded int               `json:"succeeded"`
+	Failed    int               `json:"failed"`
+	Databases []DatabaseSummary `json:"databases"`
+	// Build is the build of the tool that ran.
+	Build BuildInfo `json:"build"`
+}
+
+// DatabaseSummary is the outcome of a run for a single database.
+type DatabaseSummary struct {
+	Cluster    string `json:"cluster"`
+	Database   string `json:"database"`
+	Schema     string `json:"schema,omitempty"`
+	Success    bool   `json:"success"`
+	Error      string `json:"error,omitempty"`
+	ErrorClass string `json:"error_class,omitempty"`
+	// File, Statement, Line, Column and SQLState locate a failed
+	// migration's error.
+	File       string   `json:"file,omitempty"`
+	Statement  int      `json:"statement,omitempty"`
+	Line       int      `json:"line,omitempty"`
+	Column     int      `json:"column,omitempty"`
+	SQLState   string   `json:"sqlstate,omitempty"`
+	Applied    []string `json:"applied,omitempty"`
+	Skipped    []string `json:"skipped,omitempty"`
+	DurationMs int64    `json:"duration_ms"`
+	// Diagnostics is the diagnostic bundle of a failed database.
+	Diagnostics string `json:"diagnostics,omitempty"`
+}
+
+// NewRunSummary summarizes the results of a run.
+func NewRunSummary(id string, started time.Time, results []MigrationResult) RunSummary {
+	summary := RunSummary{ID: id, Started: started, Finished: time.Now(), Build: Build()}
+	for _, result := range results {
+		database := DatabaseSummary{
+			Cluster:     result.Cluster,
+			Database:    result.Database,
+			Schema:      result.Schema,
+			Success:     result.Success,
+			Applied:     result.Applied,
+			Skipped:     result.Skipped,
+			ErrorClass:  result.ErrorClass,
+			DurationMs:  result.Duration.Milliseconds(),
+			Diagnostics: result.Diagnostics,
+		}
+		if result.Success {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+		if result.Error != nil {
+			database.Error = result.Error.Error()
+			var migrationErr *MigrationError
+			if errors.As(result.Error, &migrationErr) {
+				database.File = migrationErr.File
+				database.Statement = migrationErr.Statement
+				database.Line = migrationErr.Line
+				database.Column = migrationErr.Column
+				database.SQLState = migrationErr.SQLState
+			}
+		}
+		summary.Databases = append(summary.Databases, database)
+	}
+	return summary
+}
+
+// Failures returns the databases that failed.
+func (s RunSummary) Failures() []DatabaseSummary {
+	var failures []DatabaseSummary
+	for _, database := range s.Databases {
+		if !database.Success {
+			failures = append(failures, database)
+		}
+	}
+	return failures
+}
+
+// Manifest returns the summary as indented JSON.
+func (s RunSummary) Manifest() ([]byte, error) {
+	return json.MarshalIndent(s, "", "  ")
+}
+
+// newRunID returns the configured run ID, or a random one.
+func (c Configuration) newRunID() string {
+	if c.RunID != "" {
+		return c.RunID
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// notifyStart tells the destinations that follow runs as they happen that
+// a run started.
+func notifyStart(ctx context.Context, config Configuration, summary RunSummary) {
+	notifications := config.Notifications
+	if notifications == nil {
+		return
+	}
+	if notifications.CommitStatus != nil {
+		if err := postCommitStatus(ctx, notifications.CommitStatus, config.MigrationDir, "pending", "Migration run "+summary.ID+" in progress", summary); err != nil {
+			log.Printf("WARNING: %s commit status failed: %v", notifications.CommitStatus.Provider, err)
+		}
+	}
+}
+
+// notify sends the summary of a run to every configured destination.
+func notify(ctx context.Context, config Configuration, summary RunSummary) {
+	notifications := config.Notifications
+	if notifications == nil {
+		return
+	}
+	if notifications.CommitStatus != nil {
+		state, description := "success", fmt.Sprintf("%d databases migrated", summary.Succeeded)
+		if summary.Failed > 0 {
+			state, description = "failure", fmt.Sprintf("%d of %d databases failed", summary.Failed, summary.Failed+summary.Succeeded)
+		}
+		if err := postCommitStatus(ctx, notifications.CommitStatus, config.MigrationDir, state, description, summary); err != nil {
+			log.Printf("WARNING: %s commit status failed: %v", notifications.CommitStatus.Provider, err)
+		}
+	}
+	if notifications.Email != nil {
+		if err := sendEmail(ctx, notifications.Email, summary); err != nil {
+			log.Printf("WARNING: email notification failed: %v", err)
+		}
+	}
+	if notifications.Alert != nil {
+		if err := sendAlert(ctx, notifications.Alert, summary); err != nil {
+			log.Printf("WARNING: %s alert failed: %v", notifications.Alert.Provider, err)
+		}
+	}
+	if notifications.Datadog != nil {
+		if err := sendDatadog(ctx, notifications.Datadog, summary); err != nil {
+			log.Printf("WARNING: datadog notification failed: %v", err)
+		}
+	}
+	if notifications.StatsD != nil {
+		if err := sendStatsD(notifications.StatsD, summary); err != nil {
+			log.Printf("WARNING: statsd metrics failed: %v", err)
+		}
+	}
+	for i := range notifications.Webhooks {
+		if err := sendWebhook(ctx, &notifications.Webhooks[i], summary); err != nil {
+			log.Printf("WARNING: webhook notification failed: %v", err)
+		}
+	}
+}
+
+// postJSON posts body as JSON to url with the given extra headers and
+// fails unless the response status is 2xx.
+func postJSON(ctx context.Context, url string, headers map[string]string, body interface{}) error {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s", req.URL.Redacted(), resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
@@ -0,0 +1,334 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	ociManifestType = "application/vnd.oci.image.manifest.v1+json"
+	// ociTitleAnnotation names the file a layer holds.
+	ociTitleAnnotation = "org.opencontainers.image.title"
+	// orasUnpackAnnotation marks a layer holding a directory pushed by ORAS
+	// as a gzipped tar archive.
+	orasUnpackAnnotation = "io.deis.oras.content.unpack"
+	// cosignSignatureAnnotation holds the signature of a cosign payload.
+	cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+)
+
+// ociManifest is an OCI image manifest, as pushed by ORAS.
+type ociManifest struct {
+	Layers []ociDescriptor `json:"layers"`
+}
+
+type ociDescriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Annotations map[string]string `json:"annotations"`
+}
+
+// ociRegistry reads the manifests and blobs of one repository of a
+// registry.
+type ociRegistry struct {
+	host       string
+	repository string
+	token      string
+}
+
+// fetchOCI pulls the files of an OCI artifact pushed with ORAS to dir. The
+// artifact is given by tag, by digest or by both, the digest pinning the
+// artifact the tag must resolve to:
+//
+//	oci://ghcr.io/acme/schema:1.4@sha256:3f2c1e...
+//
+// A digest is required unless the allow-tag option of the URL fragment is
+// set. With the cosign-key option, naming a PEM public key file, the
+// artifact must carry a cosign signature by that key. Registry
+// credentials come from the auths of the Docker configuration file.
+func fetchOCI(ctx context.Context, u *url.URL, dir string) error {
+	options, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return fmt.Errorf("invalid fragment: %w", err)
+	}
+	repository, digest, _ := strings.Cut(strings.TrimPrefix(u.Path, "/"), "@")
+	reference := digest
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository, reference = repository[:i], repository[i+1:]
+	}
+	switch {
+	case digest == "" && !options.Has("allow-tag"):
+		return errors.New("artifact must be pinned by digest, e.g. oci://registry/repo:tag@sha256:...")
+	case digest != "" && !strings.HasPrefix(digest, "sha256:"):
+		return fmt.Errorf("unsupported digest %q: expected sha256", digest)
+	case reference == "":
+		return errors.New("artifact tag or digest is required")
+	}
+	registry := &ociRegistry{host: u.Host, repository: repository}
+
+	data, resolved, err := registry.manifest(ctx, reference)
+	if err != nil {
+		return err
+	}
+	if digest != "" && resolved != digest {
+		return fmt.Errorf("%s resolves to %s, expected %s", reference, resolved, digest)
+	}
+	if key := options.Get("cosign-key"); key != "" {
+		if err := registry.verifyCosign(ctx, resolved, key); err != nil {
+			return fmt.Errorf("cosign: %w", err)
+		}
+	}
+
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("manifest: %w", err)
+	}
+	for _, layer := range manifest.Layers {
+		title := layer.Annotations[ociTitleAnnotation]
+		if title == "" {
+			continue
+		}
+		blob, err := registry.blob(ctx, layer.Digest)
+		if err != nil {
+			return fmt.Errorf("%s: %w", title, err)
+		}
+		if layer.Annotations[orasUnpackAnnotation] == "true" {
+			sub := filepath.Join(dir, filepath.FromSlash(title))
+			if !filepath.IsLocal(filepath.FromSlash(title)) {
+				return fmt.Errorf("refusing directory %q outside the migration directory", title)
+			}
+			// ORAS archives a directory with the directory itself at the
+			// top, so it unpacks into dir.
+			err = extractTarGz(blob, filepath.Dir(sub))
+		} else {
+			err = writeFetchedFile(dir, title, bytes.NewReader(blob))
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", title, err)
+		}
+	}
+	return nil
+}
+
+// manifest returns the manifest of a tag or digest, and its digest.
+func (r *ociRegistry) manifest(ctx context.Context, reference string) ([]byte, string, error) {
+	data, err := r.get(ctx, "manifests/"+reference, ociManifestType)
+	if err != nil {
+		return nil, "", fmt.Errorf("manifest %s: %w", reference, err)
+	}
+	return data, fmt.Sprintf("sha256:%x", sha256.Sum256(data)), nil
+}
+
+// blob returns the blob of a digest, verified against it.
+func (r *ociRegistry) blob(ctx context.Context, digest string) ([]byte, error) {
+	data, err := r.get(ctx, "blobs/"+digest, "")
+	if err != nil {
+		return nil, err
+	}
+	if actual := fmt.Sprintf("sha256:%x", sha256.Sum256(data)); actual != digest {
+		return nil, fmt.Errorf("blob is %s, expected %s", actual, digest)
+	}
+	return data, nil
+}
+
+// get issues a GET request to the registry API of the repository,
+// requesting a bearer token when the registry asks for one.
+func (r *ociRegistry) get(ctx context.Context, path, accept string) ([]byte, error) {
+	endpoint := "https://" + r.host + "/v2/" + r.repository + "/" + path
+	for attempt := 0; ; attempt++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+		if err != nil {
+			return nil, err
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if r.token != "" {
+			req.Header.Set("Authorization", "Bearer "+r.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && attempt == 0 {
+			challenge := resp.Header.Get("WWW-Authenticate")
+			resp.Body.Close()
+			if r.token, err = r.authenticate(ctx, challenge); err != nil {
+				return nil, fmt.Errorf("authenticate: %w", err)
+			}
+			continue
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, &httpStatusError{resp.StatusCode, resp.Status}
+		}
+		return io.ReadAll(resp.Body)
+	}
+}
+
+// authenticate obtains a pull token for the repository from the token
+// service named in a Bearer challenge.
+func (r *ociRegistry) authenticate(ctx context.Context, challenge string) (string, error) {
+	scheme, params, _ := strings.Cut(challenge, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return "", fmt.Errorf("unsupported challenge %q", challenge)
+	}
+	values := make(map[string]string)
+	for _, param := range strings.Split(params, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+		values[key] = strings.Trim(value, `"`)
+	}
+	query := url.Values{"scope": {"repository:" + r.repository + ":pull"}}
+	if values["service"] != "" {
+		query.Set("service", values["service"])
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, values["realm"]+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	if auth := dockerAuth(r.host); auth != "" {
+		req.Header.Set("Authorization", "Basic "+auth)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &httpStatusError{resp.StatusCode, resp.Status}
+	}
+	var token struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.Token == "" {
+		return token.AccessToken, nil
+	}
+	return token.Token, nil
+}
+
+// dockerAuth returns the base64 encoded credentials for a registry from the
+// Docker configuration file, or "" if it has none.
+func dockerAuth(host string) string {
+	path := os.Getenv("DOCKER_CONFIG")
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		path = filepath.Join(home, ".docker")
+	}
+	data, err := os.ReadFile(filepath.Join(path, "config.json"))
+	if err != nil {
+		return ""
+	}
+	var config struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if json.Unmarshal(data, &config) != nil {
+		return ""
+	}
+	for _, key := range []string{host, "https://" + host} {
+		if auth, ok := config.Auths[key]; ok {
+			return auth.Auth
+		}
+	}
+	return ""
+}
+
+// verifyCosign checks that the artifact of a manifest digest carries a
+// cosign signature, stored under the sha256-<hex>.sig tag, by the public
+// key in keyFile.
+func (r *ociRegistry) verifyCosign(ctx context.Context, digest, keyFile string) error {
+	key, err := readPublicKey(keyFile)
+	if err != nil {
+		return fmt.Errorf("cosign-key: %w", err)
+	}
+	data, _, err := r.manifest(ctx, strings.Replace(digest, ":", "-", 1)+".sig")
+	var status *httpStatusError
+	if errors.As(err, &status) && status.code == http.StatusNotFound {
+		return errors.New("artifact is not signed")
+	}
+	if err != nil {
+		return err
+	}
+	var manifest ociManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return fmt.Errorf("signature manifest: %w", err)
+	}
+	for _, layer := range manifest.Layers {
+		signature, err := base64.StdEncoding.DecodeString(layer.Annotations[cosignSignatureAnnotation])
+		if err != nil || len(signature) == 0 {
+			continue
+		}
+		payload, err := r.blob(ctx, layer.Digest)
+		if err != nil {
+			return err
+		}
+		if !verifyPayload(key, payload, signature) {
+			continue
+		}
+		var simpleSigning struct {
+			Critical struct {
+				Image struct {
+					Digest string `json:"docker-manifest-digest"`
+				} `json:"image"`
+			} `json:"critical"`
+		}
+		if err := json.Unmarshal(payload, &simpleSigning); err != nil {
+			return fmt.Errorf("signature payload: %w", err)
+		}
+		if simpleSigning.Critical.Image.Digest == digest {
+			return nil
+		}
+	}
+	return errors.New("no signature of the artifact by the key")
+}
+
+// readPublicKey reads a PEM encoded public key.
+func readPublicKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM public key found")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// verifyPayload reports whether signature is a signature of payload by key.
+func verifyPayload(key crypto.PublicKey, payload, signature []byte) bool {
+	digest := sha256.Sum256(payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, signature)
+	}
+	return false
+}
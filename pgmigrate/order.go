@@ -0,0 +1,130 @@
+package pgmigrate
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+)
+
+// Orders of Configuration.OrderBy.
+const (
+	// OrderByName orders databases by cluster name, then database name,
+	// then tenant schema.
+	OrderByName = "name"
+	// OrderByCreated keeps clusters in the order they are configured, and
+	// discovered after those, and orders their databases from the oldest
+	// to the newest.
+	OrderByCreated = "created"
+	// OrderBySize starts the largest databases first, by the size of the
+	// database measured during discovery, so that the run does not end
+	// waiting on a huge database started last.
+	OrderBySize = "size"
+)
+
+func validateOrderBy(orderBy string) error {
+	switch orderBy {
+	case "", OrderByName, OrderByCreated, OrderBySize:
+		return nil
+	}
+	return fmt.Errorf("expected %s, %s or %s, got %q", OrderByName, OrderByCreated, OrderBySize, orderBy)
+}
+
+// OrderTargets sorts targets in place by the given order, "" meaning
+// OrderByName. Targets equal under the order keep their relative order.
+func OrderTargets(targets []Target, orderBy string) error {
+	if err := validateOrderBy(orderBy); err != nil {
+		return err
+	}
+	switch orderBy {
+	case OrderByCreated:
+		// Discovery already lists targets in this order.
+		return nil
+	case OrderBySize:
+		sort.SliceStable(targets, func(i, j int) bool {
+			return targets[i].Size > targets[j].Size
+		})
+		return nil
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		if targets[i].Cluster.Name != targets[j].Cluster.Name {
+			return targets[i].Cluster.Name < targets[j].Cluster.Name
+		}
+		if targets[i].Database != targets[j].Database {
+			return targets[i].Database < targets[j].Database
+		}
+		return targets[i].Schema < targets[j].Schema
+	})
+	return nil
+}
+
+// PriorityConfig assigns a priority to the databases it matches. Databases
+// of a higher priority are started before those of a lower one, whatever
+// the order_by; databases matched by no priority have priority 0.
+type PriorityConfig struct {
+	// Cluster restricts the priority to a cluster. Empty matches every
+	// cluster.
+	Cluster string `json:"cluster"`
+	// Database is a database name or pattern. Empty matches every
+	// database of the cluster.
+	Database string `json:"database"`
+	Priority int    `json:"priority"`
+}
+
+func (p PriorityConfig) validate() error {
+	if p.Cluster == "" && p.Database == "" {
+		return errors.New("cluster or database is required")
+	}
+	if _, err := path.Match(p.Database, ""); err != nil {
+		return fmt.Errorf("invalid database pattern %q: %w", p.Database, err)
+	}
+	return nil
+}
+
+// targetPriority returns the priority of the first of priorities matching
+// target, or 0.
+func targetPriority(priorities []PriorityConfig, target Target) int {
+	for _, p := range priorities {
+		if (p.Cluster == "" || p.Cluster == target.Cluster.Name) &&
+			(p.Database == "" || MatchDatabase(p.Database, target.Database)) {
+			return p.Priority
+		}
+	}
+	return 0
+}
+
+// PrioritizeTargets sorts targets in place from the highest priority to the
+// lowest. Targets of the same priority keep their relative order.
+func PrioritizeTargets(targets []Target, priorities []PriorityConfig) {
+	if len(priorities) == 0 {
+		return
+	}
+	sort.SliceStable(targets, func(i, j int) bool {
+		return targetPriority(priorities, targets[i]) > targetPriority(priorities, targets[j])
+	})
+}
+
+// migrateByPriority migrates targets one priority tier at a time with
+// migrate, which returns the number of databases of the tier that failed.
+// The targets of a tier, which follow each other in targets, all finish
+// before the next tier starts, and once a tier has a failed database the
+// targets of the following tiers are passed to skip instead.
+func migrateByPriority(config Configuration, targets []Target, migrate func(tier []Target) int, skip func(Target, error)) {
+	for start := 0; start < len(targets); {
+		priority := targetPriority(config.Priorities, targets[start])
+		end := start + 1
+		for end < len(targets) && targetPriority(config.Priorities, targets[end]) == priority {
+			end++
+		}
+		failed := migrate(targets[start:end])
+		start = end
+		if failed == 0 {
+			continue
+		}
+		err := fmt.Errorf("not started: %d databases of priority %d failed", failed, priority)
+		for _, target := range targets[start:] {
+			skip(target, err)
+		}
+		return
+	}
+}
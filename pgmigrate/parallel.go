@@ -0,0 +1,218 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// parallelDirective marks the statement following it as a member of a
+// group of independent statements run concurrently, each on its own
+// session to the database, e.g.
+//
+//	-- pgmigrate:parallel group=indexes jobs=2
+//	CREATE INDEX CONCURRENTLY orders_customer_idx ON orders (customer_id);
+//	-- pgmigrate:parallel group=indexes
+//	CREATE INDEX CONCURRENTLY invoices_due_idx ON invoices (due_at);
+//
+// Consecutive statements of the same group form the group, which runs once
+// the statements before it have and completes before the statements after
+// it start. jobs caps the statements of the group running at once and
+// defaults to all of them. Statements of a group must not depend on each
+// other. Migrations with parallel groups run outside a transaction.
+const parallelDirective = "-- pgmigrate:parallel"
+
+// parallelGroup holds the parameters of a parallel directive.
+type parallelGroup struct {
+	name string
+	jobs int
+}
+
+// parseParallel parses the arguments of a parallel directive line.
+func parseParallel(line string) (parallelGroup, error) {
+	var g parallelGroup
+	args, err := splitDirectiveArgs(strings.TrimPrefix(strings.TrimSpace(line), parallelDirective))
+	if err != nil {
+		return g, err
+	}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return g, fmt.Errorf("parallel: expected key=value, got %q", arg)
+		}
+		switch key {
+		case "group":
+			g.name = value
+		case "jobs":
+			g.jobs, err = strconv.Atoi(value)
+			if err != nil || g.jobs <= 0 {
+				return g, fmt.Errorf("parallel: invalid jobs %q", value)
+			}
+		default:
+			return g, fmt.Errorf("parallel: unknown argument %q", key)
+		}
+	}
+	return g, nil
+}
+
+// scriptParallel validates the parallel directives of a script and reports
+// whether it has any.
+func scriptParallel(script string) (bool, error) {
+	found := false
+	for _, line := range strings.Split(script, "\n") {
+		if strings.HasPrefix(strings.TrimSpace(line), parallelDirective) {
+			if _, err := parseParallel(line); err != nil {
+				return false, err
+			}
+			found = true
+		}
+	}
+	return found, nil
+}
+
+// statementParallel returns the parallel directive among the comment lines
+// leading the statement, or nil if there is none.
+func statementParallel(sql string) (*parallelGroup, error) {
+	for _, line := range strings.Split(sql, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, parallelDirective) {
+			g, err := parseParallel(line)
+			if err != nil {
+				return nil, err
+			}
+			return &g, nil
+		}
+		if line != "" && !strings.HasPrefix(line, "--") {
+			break
+		}
+	}
+	return nil, nil
+}
+
+// sessionOpener opens another session to the database a migration runs on,
+// set up like the migration's own, and returns it with the function
+// closing it.
+type sessionOpener func(ctx context.Context) (queryExecer, func(), error)
+
+// sessionPool opens the sessions of a migration's parallel groups from a
+// single connection pool to its database, which is closed once the
+// migration is done, so that the groups reuse their connections.
+type sessionPool struct {
+	target   Target
+	settings DatabaseSettings
+
+	mu sync.Mutex
+	db *sql.DB
+}
+
+// conn returns a session with the session settings of the migration
+// connection, connecting to the database first if needed.
+func (p *sessionPool) conn(ctx context.Context) (*sql.Conn, error) {
+	p.mu.Lock()
+	if p.db == nil {
+		db, err := connectToDatabase(ctx, p.target.Cluster, p.target.Database)
+		if err != nil {
+			p.mu.Unlock()
+			return nil, err
+		}
+		p.db = db
+	}
+	db := p.db
+	p.mu.Unlock()
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err := applySessionSettings(ctx, conn, p.settings); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+func (p *sessionPool) close() {
+	if p.db != nil {
+		p.db.Close()
+	}
+}
+
+// executeStatementGroups executes the statements of a migration in order,
+// running the statements of each parallel group concurrently on sessions
+// opened with open. Without open, every statement runs on conn.
+func executeStatementGroups(ctx context.Context, conn queryExecer, statements []Statement, open sessionOpener) error {
+	if open == nil {
+		return executeStatements(ctx, conn, statements)
+	}
+	for i := 0; i < len(statements); {
+		// Directives were validated when the migrations were loaded.
+		group, _ := statementParallel(statements[i].SQL)
+		if group == nil {
+			if err := executeStatement(ctx, conn, statements[i]); err != nil {
+				return newStatementError(i+1, statements[i], err)
+			}
+			i++
+			continue
+		}
+		end := i + 1
+		for ; end < len(statements); end++ {
+			next, _ := statementParallel(statements[end].SQL)
+			if next == nil || next.name != group.name {
+				break
+			}
+		}
+		if err := executeParallel(ctx, statements, i, end, group.jobs, open); err != nil {
+			return err
+		}
+		i = end
+	}
+	return nil
+}
+
+// executeParallel executes statements[start:end] concurrently, at most
+// jobs at once when jobs is set, and returns the error of the first
+// statement to fail after cancelling the others.
+func executeParallel(ctx context.Context, statements []Statement, start, end, jobs int, open sessionOpener) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	sem := newSemaphore(jobs)
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		firstErr error
+	)
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if firstErr == nil {
+			firstErr = err
+			cancel()
+		}
+	}
+	for i := start; i < end; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem.acquire()
+			defer sem.release()
+			if ctx.Err() != nil {
+				return
+			}
+			session, closeSession, err := open(ctx)
+			if err != nil {
+				fail(fmt.Errorf("open session for statement %d: %w", i+1, err))
+				return
+			}
+			defer closeSession()
+			if err := executeStatement(ctx, session, statements[i]); err != nil {
+				fail(newStatementError(i+1, statements[i], err))
+			}
+		}(i)
+	}
+	wg.Wait()
+	return firstErr
+}
@@ -0,0 +1,369 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// partitionsDirective runs partition maintenance for a table after the
+// statements of the migration, e.g.
+//
+//	-- pgmigrate:partitions table=events interval=month premake=3 default=true
+//	CREATE TABLE events (...) PARTITION BY RANGE (created_at);
+//
+// It takes the same arguments as the fields of PartitionConfig.
+const partitionsDirective = "-- pgmigrate:partitions"
+
+const defaultPremake = 3
+
+// PartitionConfig declares a table partitioned by time ranges whose
+// partitions are maintained by the "partitions" command and by partition
+// directives.
+type PartitionConfig struct {
+	// Table is the partitioned table, optionally schema-qualified.
+	// Partitions are created in its schema as <table>_p<start>, with
+	// bounds in UTC.
+	Table string `json:"table"`
+
+	// Interval is the range covered by each partition: day, week, month or
+	// year.
+	Interval string `json:"interval"`
+
+	// Premake is the number of partitions created ahead of the current
+	// one. Defaults to 3.
+	Premake int `json:"premake"`
+
+	// Default creates a default partition, <table>_default, for rows
+	// outside every range.
+	Default bool `json:"default"`
+
+	// Retain detaches partitions ending more than this many intervals
+	// before the current one. The detached tables are kept. Zero keeps
+	// every partition attached.
+	Retain int `json:"retain"`
+}
+
+// MaintenanceResult reports the changes made to a single database by a
+// maintenance operation.
+type MaintenanceResult struct {
+	Cluster  string
+	Database string
+	Changes  []string
+	Error    error
+}
+
+func (p PartitionConfig) validate() error {
+	if p.Table == "" {
+		return errors.New("table is required")
+	}
+	switch p.Interval {
+	case "day", "week", "month", "year":
+	default:
+		return fmt.Errorf("invalid interval %q", p.Interval)
+	}
+	if p.Premake < 0 || p.Retain < 0 {
+		return errors.New("premake and retain must not be negative")
+	}
+	return nil
+}
+
+// parsePartitionsDirective parses the arguments of a partitions directive
+// line.
+func parsePartitionsDirective(line string) (PartitionConfig, error) {
+	var p PartitionConfig
+	args, err := splitDirectiveArgs(strings.TrimPrefix(strings.TrimSpace(line), partitionsDirective))
+	if err != nil {
+		return p, err
+	}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return p, fmt.Errorf("partitions: expected key=value, got %q", arg)
+		}
+		switch key {
+		case "table":
+			p.Table = value
+		case "interval":
+			p.Interval = value
+		case "premake":
+			p.Premake, err = strconv.Atoi(value)
+		case "retain":
+			p.Retain, err = strconv.Atoi(value)
+		case "default":
+			p.Default, err = strconv.ParseBool(value)
+		default:
+			return p, fmt.Errorf("partitions: unknown argument %q", key)
+		}
+		if err != nil {
+			return p, fmt.Errorf("partitions: invalid %s %q", key, value)
+		}
+	}
+	if err := p.validate(); err != nil {
+		return p, fmt.Errorf("partitions: %w", err)
+	}
+	return p, nil
+}
+
+// scriptPartitions returns the partitions directives of a script.
+func scriptPartitions(script string) ([]PartitionConfig, error) {
+	var partitions []PartitionConfig
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), partitionsDirective) {
+			continue
+		}
+		p, err := parsePartitionsDirective(line)
+		if err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, p)
+	}
+	return partitions, nil
+}
+
+// MaintainPartitions creates the upcoming and default partitions and
+// detaches the expired ones of the configured partitioned tables in each
+// database.
+func (m *Migrator) MaintainPartitions(ctx context.Context, targets []Target) []MaintenanceResult {
+	return maintainDatabases(ctx, m.config, targets, func(ctx context.Context, conn queryExecer) ([]string, error) {
+		var changes []string
+		for _, p := range m.config.Partitions {
+			tableChanges, err := maintainPartitions(ctx, conn, p, time.Now())
+			changes = append(changes, tableChanges...)
+			if err != nil {
+				return changes, fmt.Errorf("%s: %w", p.Table, err)
+			}
+		}
+		return changes, nil
+	})
+}
+
+// AttachPartition attaches an existing table as the partition of table
+// holding the values from from (inclusive) to to (exclusive), in each
+// database.
+func (m *Migrator) AttachPartition(ctx context.Context, targets []Target, table, partition, from, to string) []MaintenanceResult {
+	query := fmt.Sprintf("ALTER TABLE %s ATTACH PARTITION %s FOR VALUES FROM (%s) TO (%s)",
+		quoteQualified(table), quoteQualified(partition), pq.QuoteLiteral(from), pq.QuoteLiteral(to))
+	return maintainDatabases(ctx, m.config, targets, func(ctx context.Context, conn queryExecer) ([]string, error) {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return nil, err
+		}
+		return []string{"attached " + partition}, nil
+	})
+}
+
+// DetachPartition detaches a partition of table in each database, keeping
+// it as a standalone table.
+func (m *Migrator) DetachPartition(ctx context.Context, targets []Target, table, partition string) []MaintenanceResult {
+	query := fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", quoteQualified(table), quoteQualified(partition))
+	return maintainDatabases(ctx, m.config, targets, func(ctx context.Context, conn queryExecer) ([]string, error) {
+		if _, err := conn.ExecContext(ctx, query); err != nil {
+			return nil, err
+		}
+		return []string{"detached " + partition}, nil
+	})
+}
+
+// maintainDatabases runs a maintenance operation in a transaction on each
+// database, holding the same lock as migrations.
+func maintainDatabases(ctx context.Context, config Configuration, targets []Target, operation func(context.Context, queryExecer) ([]string, error)) []MaintenanceResult {
+	return forEachTarget(ctx, config, targets, func(ctx context.Context, target Target) MaintenanceResult {
+		result := MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database}
+		db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		defer db.Close()
+		conn, err := db.Conn(ctx)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		defer conn.Close()
+
+		if err := lockDatabase(ctx, conn); err != nil {
+			result.Error = err
+			return result
+		}
+		defer unlockDatabase(conn)
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		defer tx.Rollback()
+		changes, err := operation(ctx, tx)
+		if err == nil {
+			err = tx.Commit()
+		}
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		result.Changes = changes
+		return result
+	}, func(target Target, err error) MaintenanceResult {
+		return MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database, Error: err}
+	})
+}
+
+// maintainPartitions creates the current and upcoming partitions and the
+// default partition of a table, and detaches the expired partitions. It
+// returns a description of each change made.
+func maintainPartitions(ctx context.Context, conn queryExecer, p PartitionConfig, now time.Time) ([]string, error) {
+	schema, table := splitQualified(p.Table)
+	parent := quoteQualified(p.Table)
+	var changes []string
+
+	premake := p.Premake
+	if premake == 0 {
+		premake = defaultPremake
+	}
+	current := truncateInterval(now.UTC(), p.Interval)
+	start := current
+	for i := 0; i <= premake; i++ {
+		end := addInterval(start, p.Interval, 1)
+		name := table + "_p" + start.Format(partitionLayout(p.Interval))
+		created, err := createPartition(ctx, conn, schema, name, fmt.Sprintf("PARTITION OF %s FOR VALUES FROM (%s) TO (%s)",
+			parent, pq.QuoteLiteral(start.Format("2006-01-02")), pq.QuoteLiteral(end.Format("2006-01-02"))))
+		if err != nil {
+			return changes, err
+		}
+		if created {
+			changes = append(changes, "created "+name)
+		}
+		start = end
+	}
+
+	if p.Default {
+		name := table + "_default"
+		created, err := createPartition(ctx, conn, schema, name, "PARTITION OF "+parent+" DEFAULT")
+		if err != nil {
+			return changes, err
+		}
+		if created {
+			changes = append(changes, "created "+name)
+		}
+	}
+
+	if p.Retain == 0 {
+		return changes, nil
+	}
+	cutoff := addInterval(current, p.Interval, -p.Retain)
+	partitions, err := listPartitions(ctx, conn, p.Table)
+	if err != nil {
+		return changes, err
+	}
+	for _, name := range partitions {
+		if !strings.HasPrefix(name, table+"_p") {
+			continue
+		}
+		start, err := time.Parse(partitionLayout(p.Interval), strings.TrimPrefix(name, table+"_p"))
+		if err != nil {
+			continue
+		}
+		if addInterval(start, p.Interval, 1).After(cutoff) {
+			continue
+		}
+		if _, err := conn.ExecContext(ctx, fmt.Sprintf("ALTER TABLE %s DETACH PARTITION %s", parent, quoteName(schema, name))); err != nil {
+			return changes, err
+		}
+		changes = append(changes, "detached "+name)
+	}
+	return changes, nil
+}
+
+// createPartition creates a partition unless a table of that name exists,
+// and reports whether it did.
+func createPartition(ctx context.Context, conn queryExecer, schema, name, definition string) (bool, error) {
+	var exists bool
+	err := conn.QueryRowContext(ctx, "SELECT to_regclass($1) IS NOT NULL", quoteName(schema, name)).Scan(&exists)
+	if err != nil || exists {
+		return false, err
+	}
+	_, err = conn.ExecContext(ctx, "CREATE TABLE "+quoteName(schema, name)+" "+definition)
+	return err == nil, err
+}
+
+// listPartitions returns the names of the partitions attached to table.
+func listPartitions(ctx context.Context, conn queryExecer, table string) ([]string, error) {
+	rows, err := conn.QueryContext(ctx,
+		`SELECT c.relname FROM pg_inherits i JOIN pg_class c ON c.oid = i.inhrelid WHERE i.inhparent = $1::regclass`,
+		quoteQualified(table))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+func partitionLayout(interval string) string {
+	switch interval {
+	case "year":
+		return "2006"
+	case "month":
+		return "2006_01"
+	}
+	return "2006_01_02"
+}
+
+// truncateInterval returns the start of the interval containing t.
+func truncateInterval(t time.Time, interval string) time.Time {
+	year, month, day := t.Date()
+	switch interval {
+	case "year":
+		return time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	case "month":
+		return time.Date(year, month, 1, 0, 0, 0, 0, time.UTC)
+	case "week":
+		weekday := (int(t.Weekday()) + 6) % 7 // days since Monday
+		return time.Date(year, month, day-weekday, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func addInterval(t time.Time, interval string, n int) time.Time {
+	switch interval {
+	case "year":
+		return t.AddDate(n, 0, 0)
+	case "month":
+		return t.AddDate(0, n, 0)
+	case "week":
+		return t.AddDate(0, 0, 7*n)
+	}
+	return t.AddDate(0, 0, n)
+}
+
+// splitQualified splits an optionally schema-qualified name.
+func splitQualified(name string) (schema, relation string) {
+	if schema, relation, ok := strings.Cut(name, "."); ok {
+		return schema, relation
+	}
+	return "", name
+}
+
+func quoteQualified(name string) string {
+	return quoteName(splitQualified(name))
+}
+
+func quoteName(schema, name string) string {
+	if schema == "" {
+		return pq.QuoteIdentifier(name)
+	}
+	return pq.QuoteIdentifier(schema) + "." + pq.QuoteIdentifier(name)
+}
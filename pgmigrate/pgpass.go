@@ -0,0 +1,137 @@
+package pgmigrate
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// warnedPassFiles records the insecure password files already reported, so
+// the warning is logged once rather than for every database.
+var warnedPassFiles sync.Map
+
+// password returns the password to connect to dbName with: the configured
+// one, resolved if it is a secret reference, or the matching entry of the
+// password file.
+func (c ClusterConfig) password(ctx context.Context, dbName string) (string, bool, error) {
+	if c.Password != "" {
+		password, err := resolveSecret(ctx, c.Password)
+		return password, err == nil, err
+	}
+	if dbName == "" {
+		// libpq defaults the database to the user name.
+		dbName = c.Username
+	}
+	password, ok := lookupPassFile(passFilePath(c.PassFile), c.Host, c.Port, dbName, c.Username)
+	return password, ok, nil
+}
+
+// passFilePath returns the password file to consult: the configured one,
+// then $PGPASSFILE, then ~/.pgpass.
+func passFilePath(configured string) string {
+	if configured != "" {
+		return configured
+	}
+	if path := os.Getenv("PGPASSFILE"); path != "" {
+		return path
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(os.Getenv("APPDATA"), "postgresql", "pgpass.conf")
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".pgpass")
+}
+
+// lookupPassFile returns the password for the first line of the password
+// file matching host, port, database and user, following libpq's rules:
+// fields are separated by colons, "*" matches anything, backslash escapes
+// colons and backslashes, and "localhost" also matches Unix socket
+// connections. Files readable by group or others are ignored, as psql does.
+func lookupPassFile(path, host string, port int, dbName, user string) (string, bool) {
+	if path == "" {
+		return "", false
+	}
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		return "", false
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		if _, warned := warnedPassFiles.LoadOrStore(path, true); !warned {
+			log.Printf("WARNING: password file %s has group or world access; permissions should be u=rw (0600) or less", path)
+		}
+		return "", false
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer file.Close()
+
+	if host == "" || strings.HasPrefix(host, "/") {
+		host = "localhost"
+	}
+	if port == 0 {
+		port = 5432
+	}
+	want := []string{host, strconv.Itoa(port), dbName, user}
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		fields, wildcards := splitPassFileLine(line)
+		if len(fields) < 5 {
+			continue
+		}
+		matched := true
+		for i, value := range want {
+			if !wildcards[i] && fields[i] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			return fields[4], true
+		}
+	}
+	return "", false
+}
+
+// splitPassFileLine splits a password file line on unescaped colons and
+// removes the escaping backslashes, reporting which fields are a bare "*"
+// wildcard; an escaped \* is a literal asterisk. Like libpq, anything after
+// a fifth unescaped colon is ignored by the caller.
+func splitPassFileLine(line string) (fields []string, wildcards []bool) {
+	var field strings.Builder
+	escaped := false
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case c == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+			escaped = true
+		case c == ':':
+			fields = append(fields, field.String())
+			wildcards = append(wildcards, !escaped && field.String() == "*")
+			field.Reset()
+			escaped = false
+		default:
+			field.WriteByte(c)
+		}
+	}
+	fields = append(fields, field.String())
+	wildcards = append(wildcards, !escaped && field.String() == "*")
+	return fields, wildcards
+}
@@ -0,0 +1,107 @@
+package pgmigrate
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestSplitPassFileLine(t *testing.T) {
+	for _, test := range []struct {
+		line      string
+		fields    []string
+		wildcards []bool
+	}{
+		{
+			line:      "db.example.com:5432:app:admin:secret",
+			fields:    []string{"db.example.com", "5432", "app", "admin", "secret"},
+			wildcards: []bool{false, false, false, false, false},
+		},
+		{
+			line:      `*:*:app\:prod:admin:pa\:ss\\word`,
+			fields:    []string{"*", "*", "app:prod", "admin", `pa:ss\word`},
+			wildcards: []bool{true, true, false, false, false},
+		},
+		{
+			line:      `host:5432:\*:admin:secret`,
+			fields:    []string{"host", "5432", "*", "admin", "secret"},
+			wildcards: []bool{false, false, false, false, false},
+		},
+		{
+			line:      `host:5432:app:admin:secret:ignored`,
+			fields:    []string{"host", "5432", "app", "admin", "secret", "ignored"},
+			wildcards: []bool{false, false, false, false, false, false},
+		},
+		{
+			line:      `host:5432:app:admin:trailing\`,
+			fields:    []string{"host", "5432", "app", "admin", `trailing\`},
+			wildcards: []bool{false, false, false, false, false},
+		},
+	} {
+		fields, wildcards := splitPassFileLine(test.line)
+		if !reflect.DeepEqual(fields, test.fields) || !reflect.DeepEqual(wildcards, test.wildcards) {
+			t.Errorf("splitPassFileLine(%q) = %q, %v, want %q, %v", test.line, fields, wildcards, test.fields, test.wildcards)
+		}
+	}
+}
+
+func TestLookupPassFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "pgpass")
+	content := `# comment
+
+db.example.com:5432:app:admin:first
+db.example.com:5432:app:admin:second
+db.example.com:*:*:admin:any-database
+localhost:5432:app:admin:socket
+*:6432:\*:admin:literal-asterisk
+*:6432:*:admin:wildcard
+db\:colon.example.com:5432:app:admin:escaped-colon
+short:line
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name     string
+		host     string
+		port     int
+		database string
+		user     string
+		want     string
+		found    bool
+	}{
+		{name: "first match wins", host: "db.example.com", port: 5432, database: "app", user: "admin", want: "first", found: true},
+		{name: "wildcards", host: "db.example.com", port: 5433, database: "other", user: "admin", want: "any-database", found: true},
+		{name: "default port", host: "db.example.com", database: "app", user: "admin", want: "first", found: true},
+		{name: "socket is localhost", host: "/var/run/postgresql", port: 5432, database: "app", user: "admin", want: "socket", found: true},
+		{name: "no host is localhost", port: 5432, database: "app", user: "admin", want: "socket", found: true},
+		{name: "escaped asterisk is literal", host: "other", port: 6432, database: "*", user: "admin", want: "literal-asterisk", found: true},
+		{name: "escaped asterisk does not match anything", host: "other", port: 6432, database: "app", user: "admin", want: "wildcard", found: true},
+		{name: "escaped colon", host: "db:colon.example.com", port: 5432, database: "app", user: "admin", want: "escaped-colon", found: true},
+		{name: "no match", host: "db.example.com", port: 5432, database: "app", user: "reader"},
+	} {
+		got, found := lookupPassFile(path, test.host, test.port, test.database, test.user)
+		if got != test.want || found != test.found {
+			t.Errorf("%s: got %q, %v, want %q, %v", test.name, got, found, test.want, test.found)
+		}
+	}
+}
+
+func TestLookupPassFileInsecure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("permissions are not checked on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "pgpass")
+	if err := os.WriteFile(path, []byte("*:*:*:*:secret\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, found := lookupPassFile(path, "host", 5432, "app", "admin"); found {
+		t.Error("password read from a world-readable file")
+	}
+}
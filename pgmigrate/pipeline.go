@@ -0,0 +1,85 @@
+package pgmigrate
+
+import (
+	"log"
+	"sync/atomic"
+)
+
+// defaultReportBuffer is the number of results waiting for the reporter
+// when Configuration.ReportBuffer is unset.
+const defaultReportBuffer = 100
+
+// reportQueue is the reporting stage of a run. It hands results to the
+// configured Reporter from a goroutine of its own, in the order databases
+// finish, through a buffer of bounded size, so that a slow reporter such as
+// a webhook does not hold up the databases while it catches up. Once the
+// buffer is full, finishing databases wait for the reporter, and keep
+// their slots so that no new database starts, rather than results piling
+// up in memory; a warning says so.
+type reportQueue struct {
+	reporter Reporter
+	results  chan MigrationResult
+	done     chan struct{}
+	warned   atomic.Bool
+}
+
+// startReportQueue starts the reporting stage for reporter, holding up to
+// size results, or defaultReportBuffer if size is zero.
+func startReportQueue(reporter Reporter, size int) *reportQueue {
+	if size <= 0 {
+		size = defaultReportBuffer
+	}
+	q := &reportQueue{
+		reporter: reporter,
+		results:  make(chan MigrationResult, size),
+		done:     make(chan struct{}),
+	}
+	go func() {
+		defer close(q.done)
+		for result := range q.results {
+			q.reporter.Report(result)
+		}
+	}()
+	return q
+}
+
+// Report queues a result for the reporter, waiting while the buffer is
+// full.
+func (q *reportQueue) Report(result MigrationResult) {
+	select {
+	case q.results <- result:
+		return
+	default:
+	}
+	if !q.warned.Swap(true) {
+		log.Printf("WARNING: the reporter is falling behind with %d results waiting; databases wait for it", cap(q.results))
+	}
+	q.results <- result
+}
+
+// close waits for the reporter to take every queued result.
+func (q *reportQueue) close() {
+	close(q.results)
+	<-q.done
+}
+
+// startReporter returns the configured Reporter if it is also told when
+// databases start.
+func startReporter(reporter Reporter) (StartReporter, bool) {
+	if q, ok := reporter.(*reportQueue); ok {
+		reporter = q.reporter
+	}
+	starter, ok := reporter.(StartReporter)
+	return starter, ok
+}
+
+// withReportQueue returns config with its Reporter, if any, behind a
+// report queue, and the function closing the queue.
+func withReportQueue(config Configuration) (Configuration, func()) {
+	if config.Reporter == nil {
+		return config, func() {}
+	}
+	q := startReportQueue(config.Reporter, config.ReportBuffer)
+	config.Reporter = q
+	return config, q.close
+}
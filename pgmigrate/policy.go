@@ -0,0 +1,210 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Actions of error policies.
+const (
+	// PolicyIgnore skips a failing statement and carries on with the
+	// migration, e.g. 42710 duplicate_object in a migration known to
+	// re-create objects on some tenants.
+	PolicyIgnore = "ignore"
+	// PolicyRetry re-runs a transactional migration whose statement failed,
+	// e.g. on 40P01 deadlock_detected.
+	PolicyRetry = "retry"
+	// PolicyTransient and PolicyPermanent override the class of the
+	// failure, which decides whether the database is retried.
+	PolicyTransient = "transient"
+	PolicyPermanent = "permanent"
+)
+
+// ErrorPolicy decides how statement errors with a SQLSTATE are handled,
+// for tenants whose quirks call for exceptions.
+type ErrorPolicy struct {
+	// SQLState is a five-character code such as 42710 or a two-character
+	// class such as 40.
+	SQLState string `json:"sqlstate"`
+
+	// Action is "ignore", "retry", "transient" or "permanent".
+	Action string `json:"action"`
+
+	// Migrations restricts the policy to migrations by version or file
+	// name pattern. Empty means every migration.
+	Migrations []string `json:"migrations"`
+
+	// Attempts is the number of retries of the retry action. Defaults to
+	// 3.
+	Attempts int `json:"attempts"`
+
+	// Delay is the wait before each retry. Defaults to 1s.
+	Delay string `json:"delay"`
+}
+
+func (p ErrorPolicy) validate() error {
+	if len(p.SQLState) != 2 && len(p.SQLState) != 5 {
+		return fmt.Errorf("invalid sqlstate %q", p.SQLState)
+	}
+	switch p.Action {
+	case PolicyIgnore, PolicyRetry, PolicyTransient, PolicyPermanent:
+	default:
+		return fmt.Errorf("sqlstate %s: unknown action %q", p.SQLState, p.Action)
+	}
+	if p.Delay != "" {
+		if _, err := time.ParseDuration(p.Delay); err != nil {
+			return fmt.Errorf("sqlstate %s: delay: %w", p.SQLState, err)
+		}
+	}
+	return nil
+}
+
+// appliesTo reports whether the policy covers the migration.
+func (p ErrorPolicy) appliesTo(migration Migration) bool {
+	if len(p.Migrations) == 0 {
+		return true
+	}
+	for _, m := range p.Migrations {
+		if isDigits(strings.ReplaceAll(m, ".", "")) && canonicalVersion(m) == migration.Version {
+			return true
+		}
+		if MatchDatabase(m, filepath.Base(migration.Path)) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches reports whether the policy covers the error's SQLSTATE.
+func (p ErrorPolicy) matches(err error) bool {
+	var pqErr *pq.Error
+	return errors.As(err, &pqErr) && strings.HasPrefix(string(pqErr.Code), p.SQLState)
+}
+
+// errorPolicy returns the first policy with one of the actions covering the
+// migration's error.
+func errorPolicy(policies []ErrorPolicy, migration Migration, err error, actions ...string) (ErrorPolicy, bool) {
+	for _, p := range policies {
+		if contains(actions, p.Action) && p.appliesTo(migration) && p.matches(err) {
+			return p, true
+		}
+	}
+	return ErrorPolicy{}, false
+}
+
+// classifyError classifies a failure, as overridden by the transient and
+// permanent policies.
+func (c Configuration) classifyError(err error) string {
+	var migrationErr *MigrationError
+	if errors.As(err, &migrationErr) {
+		migration := Migration{Version: migrationErr.Version, Path: migrationErr.File}
+		if p, ok := errorPolicy(c.ErrorPolicies, migration, err, PolicyTransient, PolicyPermanent); ok {
+			return p.Action
+		}
+	}
+	return ClassifyError(err)
+}
+
+// applyWithPolicies applies a migration, or the migrations of a release in
+// a single transaction, retrying as the retry policies of the migration
+// that failed say. Only transactional migrations are retried, since a
+// migration that failed outside a transaction may have left part of its
+// changes behind.
+func applyWithPolicies(ctx context.Context, policies []ErrorPolicy, migrations []Migration, transactional bool, apply func() error) error {
+	err := apply()
+	if !transactional {
+		return err
+	}
+	for attempt := 1; err != nil; attempt++ {
+		migration := failedMigration(migrations, err)
+		p, ok := errorPolicy(policies, migration, err, PolicyRetry)
+		attempts := p.Attempts
+		if attempts == 0 {
+			attempts = 3
+		}
+		if !ok || attempt > attempts {
+			return err
+		}
+		delay := time.Second
+		if p.Delay != "" {
+			delay, _ = time.ParseDuration(p.Delay)
+		}
+		log.Printf("WARNING: migration %s: retrying (%d/%d) after %v", migration.Version, attempt, attempts, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		err = apply()
+	}
+	return nil
+}
+
+// failedMigration returns the migration of migrations that err is the
+// failure of, or the first when err does not name one.
+func failedMigration(migrations []Migration, err error) Migration {
+	var migrationErr *MigrationError
+	if errors.As(err, &migrationErr) {
+		for _, migration := range migrations {
+			if migration.Version == migrationErr.Version {
+				return migration
+			}
+		}
+	}
+	return migrations[0]
+}
+
+// ignoringExecer runs statements under a savepoint, when in a transaction,
+// so that errors covered by an ignore policy can be skipped without
+// aborting the migration.
+type ignoringExecer struct {
+	queryExecer
+	policies  []ErrorPolicy
+	migration Migration
+	tx        bool
+}
+
+func (e ignoringExecer) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if e.tx {
+		if _, err := e.queryExecer.ExecContext(ctx, "SAVEPOINT pgmigrate_policy"); err != nil {
+			return nil, err
+		}
+	}
+	result, err := e.queryExecer.ExecContext(ctx, query, args...)
+	if err == nil {
+		if e.tx {
+			_, err = e.queryExecer.ExecContext(ctx, "RELEASE SAVEPOINT pgmigrate_policy")
+		}
+		return result, err
+	}
+	if _, ok := errorPolicy(e.policies, e.migration, err, PolicyIgnore); !ok {
+		return nil, err
+	}
+	log.Printf("WARNING: migration %s: ignoring %v", e.migration.Version, err)
+	if e.tx {
+		if _, err := e.queryExecer.ExecContext(ctx, "ROLLBACK TO SAVEPOINT pgmigrate_policy; RELEASE SAVEPOINT pgmigrate_policy"); err != nil {
+			return nil, err
+		}
+	}
+	return driver.RowsAffected(0), nil
+}
+
+// withIgnorePolicies wraps conn so that it skips the errors the ignore
+// policies of the migration cover.
+func withIgnorePolicies(conn queryExecer, policies []ErrorPolicy, migration Migration, tx bool) queryExecer {
+	for _, p := range policies {
+		if p.Action == PolicyIgnore && p.appliesTo(migration) {
+			return ignoringExecer{queryExecer: conn, policies: policies, migration: migration, tx: tx}
+		}
+	}
+	return conn
+}
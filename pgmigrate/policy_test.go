@@ -0,0 +1,59 @@
+package pgmigrate
+
+import (
+	"context"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestApplyWithPoliciesRelease(t *testing.T) {
+	release := []Migration{
+		{Version: canonicalVersion("1"), Path: "1_create_users.sql"},
+		{Version: canonicalVersion("2"), Path: "2_backfill_users.sql"},
+	}
+	deadlock := func() error {
+		return newMigrationError(release[1], &pq.Error{Code: "40P01", Message: "deadlock detected"})
+	}
+
+	for _, test := range []struct {
+		name          string
+		policies      []ErrorPolicy
+		transactional bool
+		calls         int
+		fails         bool
+	}{
+		{
+			name:          "retry policy of the failed migration",
+			policies:      []ErrorPolicy{{SQLState: "40P01", Action: PolicyRetry, Migrations: []string{"2"}, Delay: "1ms"}},
+			transactional: true,
+			calls:         2,
+		},
+		{
+			name:          "retry policy of another migration",
+			policies:      []ErrorPolicy{{SQLState: "40P01", Action: PolicyRetry, Migrations: []string{"1"}, Delay: "1ms"}},
+			transactional: true,
+			calls:         1,
+			fails:         true,
+		},
+		{
+			name:          "outside a transaction",
+			policies:      []ErrorPolicy{{SQLState: "40", Action: PolicyRetry, Delay: "1ms"}},
+			transactional: false,
+			calls:         1,
+			fails:         true,
+		},
+	} {
+		calls := 0
+		err := applyWithPolicies(context.Background(), test.policies, release, test.transactional, func() error {
+			calls++
+			if calls == 1 {
+				return deadlock()
+			}
+			return nil
+		})
+		if calls != test.calls || (err != nil) != test.fails {
+			t.Errorf("%s: %d calls, error %v, want %d calls, failure %v", test.name, calls, err, test.calls, test.fails)
+		}
+	}
+}
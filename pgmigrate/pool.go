@@ -0,0 +1,136 @@
+package pgmigrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// semaphore limits concurrent access to a resource. A nil semaphore never
+// blocks.
+type semaphore chan struct{}
+
+func newSemaphore(limit int) semaphore {
+	if limit <= 0 {
+		return nil
+	}
+	return make(semaphore, limit)
+}
+
+func (s semaphore) acquire() {
+	if s != nil {
+		s <- struct{}{}
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// concurrencyBudget limits how many databases are migrated at once, globally,
+// per cluster and per host.
+type concurrencyBudget struct {
+	global semaphore
+	hosts  map[string]semaphore
+
+	mu       sync.Mutex
+	clusters map[string]semaphore
+}
+
+// newConcurrencyBudget builds the budget for the given configuration.
+// Cluster budgets are created as clusters are first seen, so clusters found
+// by discovery providers get theirs too.
+func newConcurrencyBudget(config Configuration) *concurrencyBudget {
+	budget := &concurrencyBudget{
+		global:   newSemaphore(config.MaxConcurrency),
+		hosts:    make(map[string]semaphore),
+		clusters: make(map[string]semaphore),
+	}
+	for host, limit := range config.HostConcurrency {
+		budget.hosts[host] = newSemaphore(limit)
+	}
+	return budget
+}
+
+// cluster returns the semaphore of the cluster's own budget.
+func (b *concurrencyBudget) cluster(cluster ClusterConfig) semaphore {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.clusters[cluster.Name]
+	if !ok {
+		s = newSemaphore(cluster.MaxConcurrency)
+		b.clusters[cluster.Name] = s
+	}
+	return s
+}
+
+// acquire blocks until the cluster has a free slot in every budget it falls
+// under. Slots are taken from the narrowest budget to the widest, so a
+// worker waiting on its own cluster never holds a global slot that a worker
+// of another cluster could use.
+func (b *concurrencyBudget) acquire(cluster ClusterConfig) {
+	b.cluster(cluster).acquire()
+	b.hosts[cluster.hostName()].acquire()
+	b.global.acquire()
+}
+
+// release returns the slots taken by acquire.
+func (b *concurrencyBudget) release(cluster ClusterConfig) {
+	b.global.release()
+	b.hosts[cluster.hostName()].release()
+	b.cluster(cluster).release()
+}
+
+// PoolConfig sizes the connection pool opened for each database, so that
+// the connections a run holds on a server stay predictable. Pools are
+// closed as soon as their database is done.
+type PoolConfig struct {
+	// MaxOpenConns and MaxIdleConns cap the open and idle connections of
+	// each pool. Zero leaves the database/sql default.
+	MaxOpenConns int `json:"max_open_conns"`
+	MaxIdleConns int `json:"max_idle_conns"`
+	// ConnMaxLifetime and ConnMaxIdleTime, e.g. "5m", close connections
+	// that are older or were idle for longer.
+	ConnMaxLifetime string `json:"conn_max_lifetime"`
+	ConnMaxIdleTime string `json:"conn_max_idle_time"`
+}
+
+func (p *PoolConfig) validate() error {
+	if p.MaxOpenConns < 0 || p.MaxIdleConns < 0 {
+		return errors.New("max_open_conns and max_idle_conns must not be negative")
+	}
+	for _, d := range []string{p.ConnMaxLifetime, p.ConnMaxIdleTime} {
+		if d == "" {
+			continue
+		}
+		if _, err := time.ParseDuration(d); err != nil {
+			return fmt.Errorf("invalid duration %q", d)
+		}
+	}
+	return nil
+}
+
+// apply sets the limits of the pool on db.
+func (p *PoolConfig) apply(db *sql.DB) {
+	if p == nil {
+		return
+	}
+	if p.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(p.MaxOpenConns)
+	}
+	if p.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	// Durations were validated when the configuration was loaded.
+	if d, err := time.ParseDuration(p.ConnMaxLifetime); err == nil {
+		db.SetConnMaxLifetime(d)
+	}
+	if d, err := time.ParseDuration(p.ConnMaxIdleTime); err == nil {
+		db.SetConnMaxIdleTime(d)
+	}
+}
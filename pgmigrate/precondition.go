@@ -0,0 +1,108 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// preconditionDirective guards a migration with a query that must return
+// true for the migration to apply to a database, e.g.
+//
+//	-- pgmigrate:precondition SELECT to_regclass('public.orders') IS NOT NULL
+//	-- pgmigrate:precondition on_fail=skip SELECT current_setting('server_version_num')::int >= 150000
+//
+// on_fail decides what happens when the query returns false: "error" (the
+// default) fails the database, "warn" logs a warning and applies the
+// migration anyway, and "skip" leaves the migration unapplied and
+// unrecorded, so that it is checked again on the next run. Preconditions are
+// checked in order and the first failing one decides.
+const preconditionDirective = "-- pgmigrate:precondition"
+
+// Actions of the on_fail argument of a precondition.
+const (
+	onFailError = "error"
+	onFailWarn  = "warn"
+	onFailSkip  = "skip"
+)
+
+// precondition holds a parsed precondition directive.
+type precondition struct {
+	query  string
+	onFail string
+}
+
+// parsePrecondition parses the arguments of a precondition directive line.
+func parsePrecondition(line string) (precondition, error) {
+	p := precondition{onFail: onFailError}
+	rest := strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(line), preconditionDirective))
+	if strings.HasPrefix(rest, "on_fail=") {
+		var action string
+		action, rest, _ = strings.Cut(strings.TrimPrefix(rest, "on_fail="), " ")
+		switch action {
+		case onFailError, onFailWarn, onFailSkip:
+			p.onFail = action
+		default:
+			return p, fmt.Errorf("precondition: on_fail must be error, warn or skip, got %q", action)
+		}
+		rest = strings.TrimSpace(rest)
+	}
+	if rest == "" {
+		return p, errors.New("precondition: query is required")
+	}
+	p.query = rest
+	return p, nil
+}
+
+// scriptPreconditions returns the precondition directives of a script.
+func scriptPreconditions(script string) ([]precondition, error) {
+	var preconditions []precondition
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), preconditionDirective) {
+			continue
+		}
+		p, err := parsePrecondition(line)
+		if err != nil {
+			return nil, err
+		}
+		preconditions = append(preconditions, p)
+	}
+	return preconditions, nil
+}
+
+// checkPreconditions evaluates the preconditions of a migration on the
+// database and reports whether the migration must be skipped.
+func checkPreconditions(ctx context.Context, conn queryExecer, target Target, migration Migration, data templateData) (bool, error) {
+	if !strings.Contains(migration.Script, preconditionDirective) {
+		return false, nil
+	}
+	script, err := renderScript(migration, data)
+	if err != nil {
+		return false, fmt.Errorf("render: %w", err)
+	}
+	preconditions, err := scriptPreconditions(script)
+	if err != nil {
+		return false, err
+	}
+	for _, p := range preconditions {
+		var ok bool
+		if err := conn.QueryRowContext(ctx, p.query).Scan(&ok); err != nil {
+			return false, fmt.Errorf("precondition %q: %w", p.query, err)
+		}
+		if ok {
+			continue
+		}
+		switch p.onFail {
+		case onFailSkip:
+			return true, nil
+		case onFailWarn:
+			log.Printf("WARNING: %s/%s: precondition of migration %s failed, applying it anyway: %s",
+				target.Cluster.Name, target.Database, migration.Version, p.query)
+		default:
+			return false, fmt.Errorf("precondition failed: %s", p.query)
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,248 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/lib/pq"
+)
+
+// ProvisioningConfig describes how tenant databases are created by the
+// "provision" command and retired by the "deprovision" command.
+type ProvisioningConfig struct {
+	// Template is the database new tenants are cloned from. Defaults to
+	// the server's default template.
+	Template string `json:"template"`
+
+	// MaintainTemplate keeps Template migrated to the latest version: it
+	// is created if missing and migrated before each provisioning and
+	// along with the other databases on every run, so new tenants are
+	// cloned at the latest version instead of replaying every migration.
+	MaintainTemplate bool `json:"maintain_template"`
+
+	// Owner is the role owning new tenant databases. Defaults to the
+	// connecting user.
+	Owner string `json:"owner"`
+
+	// SeedDir holds SQL scripts run in file name order once the
+	// migrations have been applied, rendered like migrations.
+	SeedDir string `json:"seed_dir"`
+
+	// BackupDir is where deprovisioning writes the final pg_dump of a
+	// tenant database. No backup is taken when empty.
+	BackupDir string `json:"backup_dir"`
+
+	// Archive is what deprovisioning does with a tenant database once it
+	// is backed up: drop it (the default) or rename it out of the way.
+	Archive string `json:"archive"`
+
+	// Registry records tenants in a control database.
+	Registry *RegistryConfig `json:"registry"`
+}
+
+// RegistryConfig is the tenant registry, a table in a control database
+// listing the tenant databases.
+type RegistryConfig struct {
+	// Database is the control database holding the registry.
+	Database string `json:"database"`
+
+	// Cluster names the configured cluster holding the control database.
+	// Defaults to the cluster of the tenant.
+	Cluster string `json:"cluster"`
+
+	// Register records a new tenant, with its database name as $1, e.g.
+	// INSERT INTO tenants (database) VALUES ($1).
+	Register string `json:"register"`
+
+	// Deregister removes a deprovisioned tenant, with its database name as
+	// $1, e.g. DELETE FROM tenants WHERE database = $1.
+	Deregister string `json:"deregister"`
+}
+
+func (c *ProvisioningConfig) validate() error {
+	if err := validateArchive(c.Archive); err != nil {
+		return err
+	}
+	if c.MaintainTemplate && c.Template == "" {
+		return errors.New("maintain_template requires a template")
+	}
+	if c.Registry != nil {
+		if err := c.Registry.validate(); err != nil {
+			return fmt.Errorf("registry: %w", err)
+		}
+	}
+	return nil
+}
+
+func (c *RegistryConfig) validate() error {
+	if c.Database == "" {
+		return errors.New("database is required")
+	}
+	return nil
+}
+
+// ProvisionResult reports the provisioning of a tenant database.
+type ProvisionResult struct {
+	MigrationResult
+	// Seeds lists the seed scripts run.
+	Seeds []string
+}
+
+// Provision creates the tenant database name on the named cluster, or the
+// only configured one, applies every migration and the seed scripts to it
+// and records it in the registry. A database that fails to migrate or seed
+// is dropped again so that provisioning can be retried.
+func (m *Migrator) Provision(ctx context.Context, clusterName, name string) (ProvisionResult, error) {
+	var result ProvisionResult
+	config := m.config.Provisioning
+	if config == nil {
+		config = &ProvisioningConfig{}
+	}
+	cluster, err := m.cluster(clusterName)
+	if err != nil {
+		return result, err
+	}
+	seeds, err := loadSeeds(config.SeedDir)
+	if err != nil {
+		return result, fmt.Errorf("load seeds: %w", err)
+	}
+	if config.MaintainTemplate {
+		if _, err := m.MigrateTemplate(ctx, clusterName); err != nil {
+			return result, fmt.Errorf("migrate template %s: %w", config.Template, err)
+		}
+	}
+
+	admin, err := connectToDatabase(ctx, cluster, "")
+	if err != nil {
+		return result, err
+	}
+	defer admin.Close()
+	stmt := "CREATE DATABASE " + pq.QuoteIdentifier(name)
+	if config.Template != "" {
+		stmt += " TEMPLATE " + pq.QuoteIdentifier(config.Template)
+	}
+	if config.Owner != "" {
+		stmt += " OWNER " + pq.QuoteIdentifier(config.Owner)
+	}
+	if _, err := admin.ExecContext(ctx, stmt); err != nil {
+		return result, fmt.Errorf("create database: %w", err)
+	}
+
+	target := Target{Cluster: cluster, Database: name}
+	result.MigrationResult = migrateDatabase(ctx, m.config, m.migrations, target, name)
+	if result.Error == nil {
+		result.Seeds, result.Error = m.runSeeds(ctx, target, seeds)
+	}
+	if result.Error != nil {
+		result.Success = false
+		if _, err := admin.ExecContext(context.Background(), "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(name)); err != nil {
+			return result, fmt.Errorf("%v; drop database: %w", result.Error, err)
+		}
+		return result, result.Error
+	}
+
+	if config.Registry != nil && config.Registry.Register != "" {
+		if err := m.updateRegistry(ctx, target, config.Registry, config.Registry.Register); err != nil {
+			return result, fmt.Errorf("register tenant: %w", err)
+		}
+	}
+	return result, nil
+}
+
+// cluster returns the configured cluster with the given name, or the only
+// configured cluster when name is empty.
+func (m *Migrator) cluster(name string) (ClusterConfig, error) {
+	if name == "" {
+		if len(m.config.Clusters) != 1 {
+			return ClusterConfig{}, errors.New("several clusters are configured; name one")
+		}
+		return m.config.Clusters[0], nil
+	}
+	for _, cluster := range m.config.Clusters {
+		if cluster.Name == name {
+			return cluster, nil
+		}
+	}
+	return ClusterConfig{}, fmt.Errorf("unknown cluster %q", name)
+}
+
+// loadSeeds reads the seed scripts of dir in file name order.
+func loadSeeds(dir string) ([]Migration, error) {
+	if dir == "" {
+		return nil, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.sql"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	var seeds []Migration
+	for _, path := range paths {
+		script, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		seeds = append(seeds, Migration{Description: filepath.Base(path), Path: path, Script: string(script)})
+	}
+	return seeds, nil
+}
+
+// runSeeds runs each seed script on the database in a transaction of its
+// own.
+func (m *Migrator) runSeeds(ctx context.Context, target Target, seeds []Migration) ([]string, error) {
+	if len(seeds) == 0 {
+		return nil, nil
+	}
+	settings := m.config.settingsFor(target)
+	data := templateData{Cluster: target.Cluster.Name, Database: target.Database, Vars: settings.Variables}
+
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var ran []string
+	for _, seed := range seeds {
+		script, err := renderScript(seed, data)
+		if err != nil {
+			return ran, fmt.Errorf("seed %s: render: %w", seed.Description, err)
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return ran, err
+		}
+		if err := executeStatements(ctx, tx, splitStatements(script)); err != nil {
+			tx.Rollback()
+			return ran, fmt.Errorf("seed %s: %w", seed.Description, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return ran, fmt.Errorf("seed %s: %w", seed.Description, err)
+		}
+		ran = append(ran, seed.Description)
+	}
+	return ran, nil
+}
+
+// updateRegistry runs a registry statement for the tenant in the control
+// database.
+func (m *Migrator) updateRegistry(ctx context.Context, target Target, registry *RegistryConfig, statement string) error {
+	cluster := target.Cluster
+	if registry.Cluster != "" {
+		var err error
+		if cluster, err = m.cluster(registry.Cluster); err != nil {
+			return err
+		}
+	}
+	db, err := connectToDatabase(ctx, cluster, registry.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	_, err = db.ExecContext(ctx, statement, target.Database)
+	return err
+}
@@ -0,0 +1,188 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// prunedTable records the version the history table was pruned through:
+// that migration and every older one are applied though their rows were
+// removed. A migration added later with an older version is considered
+// applied as well, so only history older than any unmerged branch should be
+// pruned.
+const prunedTable = "pgmigrate_history_pruned"
+
+// prunedThrough returns the version the history was pruned through, or ""
+// when it never was.
+func prunedThrough(ctx context.Context, conn *sql.Conn) (string, error) {
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, prunedTable).Scan(&exists); err != nil || !exists {
+		return "", err
+	}
+	var version string
+	err := conn.QueryRowContext(ctx, `SELECT version FROM `+prunedTable).Scan(&version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", nil
+	}
+	return version, err
+}
+
+// setPrunedThrough records the version the history was pruned through.
+func setPrunedThrough(ctx context.Context, tx *sql.Tx, version string) error {
+	for _, stmt := range []string{
+		`CREATE TABLE IF NOT EXISTS ` + prunedTable + ` (version text NOT NULL, pruned_at timestamptz NOT NULL DEFAULT now())`,
+		`DELETE FROM ` + prunedTable,
+	} {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO `+prunedTable+` (version) VALUES ($1)`, version)
+	return err
+}
+
+// PruneHistory removes all but the keepLast newest rows of the history
+// table of each target, after writing the removed rows to a JSON file in
+// archiveDir in the format of DumpHistory. The removed migrations remain
+// applied. Pruning is refused while a migration older than the newest
+// removed one is not applied, as it would then be considered applied; apply
+// it or record it with RepairHistory first.
+func (m *Migrator) PruneHistory(ctx context.Context, targets []Target, keepLast int, archiveDir string) []MaintenanceResult {
+	return forEachTarget(ctx, m.config, targets, func(ctx context.Context, target Target) MaintenanceResult {
+		result := MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database}
+		result.Changes, result.Error = m.pruneHistory(ctx, target, keepLast, archiveDir)
+		return result
+	}, func(target Target, err error) MaintenanceResult {
+		return MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database, Error: err}
+	})
+}
+
+func (m *Migrator) pruneHistory(ctx context.Context, target Target, keepLast int, archiveDir string) ([]string, error) {
+	if keepLast < 1 {
+		return nil, errors.New("at least the latest history row must be kept")
+	}
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if target.Schema != "" {
+		err = lockSchema(ctx, conn, target.Schema)
+	} else {
+		err = lockDatabase(ctx, conn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if target.Schema != "" {
+		defer unlockSchema(conn, target.Schema)
+	} else {
+		defer unlockDatabase(conn)
+	}
+	if err := applySessionSettings(ctx, conn, m.config.settingsFor(target)); err != nil {
+		return nil, err
+	}
+
+	// The lock is held, so no run changes the history read here.
+	dump, err := m.DumpHistory(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+	rows := dump.Migrations
+	sort.Slice(rows, func(i, j int) bool { return compareVersions(rows[i].Version, rows[j].Version) < 0 })
+	if len(rows) <= keepLast {
+		return []string{fmt.Sprintf("%d rows, nothing to prune", len(rows))}, nil
+	}
+	dump.Migrations, dump.Repeatables = rows[:len(rows)-keepLast], nil
+	through := dump.Migrations[len(dump.Migrations)-1].Version
+
+	recorded := make(map[string]bool)
+	for _, row := range rows {
+		recorded[row.Version] = true
+	}
+	for _, migration := range m.migrations {
+		if compareVersions(migration.Version, through) > 0 {
+			break
+		}
+		if !recorded[migration.Version] && compareVersions(migration.Version, dump.PrunedThrough) > 0 {
+			return nil, fmt.Errorf("migration %s is not applied; apply it or record it with history repair -gaps before pruning through %s", migration.Version, through)
+		}
+	}
+
+	archive, err := writeHistoryArchive(archiveDir, dump)
+	if err != nil {
+		return nil, fmt.Errorf("archive history: %w", err)
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	versions := make([]string, len(dump.Migrations))
+	for i, row := range dump.Migrations {
+		versions[i] = row.Version
+	}
+	if _, err := tx.ExecContext(ctx, `DELETE FROM `+historyTable+` WHERE version = ANY($1)`, pq.Array(versions)); err != nil {
+		return nil, err
+	}
+	if compareVersions(through, dump.PrunedThrough) > 0 {
+		if err := setPrunedThrough(ctx, tx, through); err != nil {
+			return nil, fmt.Errorf("record pruned version: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return []string{fmt.Sprintf("pruned %d rows through %s", len(versions), through), "archived to " + archive}, nil
+}
+
+// writeHistoryArchive writes the pruned rows of a database to a new file
+// in dir, named after the cluster, database, schema and time.
+func writeHistoryArchive(dir string, dump HistoryDump) (string, error) {
+	name := []string{dump.Cluster, dump.Database}
+	if dump.Schema != "" {
+		name = append(name, dump.Schema)
+	}
+	name = append(name, dump.DumpedAt.Format("20060102T150405Z"))
+	for i := range name {
+		name[i] = strings.NewReplacer("/", "_", string(filepath.Separator), "_").Replace(name[i])
+	}
+	path := filepath.Join(dir, strings.Join(name, "-")+".json")
+
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return "", err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return "", err
+	}
+	// The rows are deleted once the archive is on disk.
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return "", err
+	}
+	return path, file.Close()
+}
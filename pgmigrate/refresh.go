@@ -0,0 +1,112 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// refreshDirective declares a materialized view to refresh once the
+// migrations of a database have been applied, e.g.
+//
+//	-- pgmigrate:refresh view=reporting.daily_totals concurrently=true
+//
+// It takes the same arguments as the fields of RefreshConfig.
+const refreshDirective = "-- pgmigrate:refresh"
+
+// RefreshConfig is a materialized view refreshed after migrations.
+type RefreshConfig struct {
+	// View is the materialized view, optionally schema-qualified.
+	View string `json:"view"`
+
+	// Concurrently refreshes the view without blocking readers, which
+	// requires a unique index on it.
+	Concurrently bool `json:"concurrently"`
+}
+
+// RefreshResult reports the refresh of a materialized view.
+type RefreshResult struct {
+	View     string
+	Duration time.Duration
+}
+
+func (r RefreshConfig) validate() error {
+	if r.View == "" {
+		return errors.New("view is required")
+	}
+	return nil
+}
+
+// parseRefresh parses the arguments of a refresh directive line.
+func parseRefresh(line string) (RefreshConfig, error) {
+	var r RefreshConfig
+	args, err := splitDirectiveArgs(strings.TrimPrefix(strings.TrimSpace(line), refreshDirective))
+	if err != nil {
+		return r, err
+	}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return r, fmt.Errorf("refresh: expected key=value, got %q", arg)
+		}
+		switch key {
+		case "view":
+			r.View = value
+		case "concurrently":
+			r.Concurrently, err = strconv.ParseBool(value)
+			if err != nil {
+				return r, fmt.Errorf("refresh: invalid concurrently %q", value)
+			}
+		default:
+			return r, fmt.Errorf("refresh: unknown argument %q", key)
+		}
+	}
+	if err := r.validate(); err != nil {
+		return r, fmt.Errorf("refresh: %w", err)
+	}
+	return r, nil
+}
+
+// scriptRefreshes returns the refresh directives of a script.
+func scriptRefreshes(script string) ([]RefreshConfig, error) {
+	var refreshes []RefreshConfig
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), refreshDirective) {
+			continue
+		}
+		r, err := parseRefresh(line)
+		if err != nil {
+			return nil, err
+		}
+		refreshes = append(refreshes, r)
+	}
+	return refreshes, nil
+}
+
+// refreshViews refreshes each materialized view once, outside a transaction
+// so that concurrent refreshes are possible, and reports how long each
+// took.
+func refreshViews(ctx context.Context, conn execer, views []RefreshConfig) ([]RefreshResult, error) {
+	var results []RefreshResult
+	seen := make(map[string]bool)
+	for _, view := range views {
+		if seen[view.View] {
+			continue
+		}
+		seen[view.View] = true
+
+		query := "REFRESH MATERIALIZED VIEW "
+		if view.Concurrently {
+			query += "CONCURRENTLY "
+		}
+		started := time.Now()
+		if _, err := conn.ExecContext(ctx, query+quoteQualified(view.View)); err != nil {
+			return results, fmt.Errorf("refresh %s: %w", view.View, err)
+		}
+		results = append(results, RefreshResult{View: view.View, Duration: time.Since(started)})
+	}
+	return results, nil
+}
@@ -0,0 +1,51 @@
+package pgmigrate
+
+import (
+	"context"
+	"time"
+)
+
+// RehearsalResult reports the rehearsal of the pending migrations of a
+// database on a clone of it.
+type RehearsalResult struct {
+	Cluster  string
+	Database string
+	Schema   string
+	// Applied lists the versions applied to the clone.
+	Applied  []string
+	Duration time.Duration
+	Error    error
+}
+
+// Rehearse clones each target with CREATE DATABASE ... TEMPLATE, applies
+// its pending migrations to the clone and drops the clone again, reporting
+// how long the migrations took and how they failed, if they did. Cloning
+// requires that nobody is connected to the target, so rehearsals are
+// usually run against a representative database or a replica-fed copy.
+// Snapshots, diagrams and roles, which are written outside the clone, are
+// skipped.
+func (m *Migrator) Rehearse(ctx context.Context, targets []Target) []RehearsalResult {
+	config := m.config
+	config.SnapshotDir = ""
+	config.Diagram = nil
+	config.Access = nil
+
+	return forEachTarget(ctx, config, targets, func(ctx context.Context, target Target) RehearsalResult {
+		result := RehearsalResult{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema}
+		clone, drop, err := createScratchDatabase(ctx, target.Cluster, "rehearse", target.Database)
+		if err != nil {
+			result.Error = err
+			return result
+		}
+		defer drop()
+
+		started := time.Now()
+		migration := migrateDatabase(ctx, config, m.migrations, target, clone)
+		result.Duration = time.Since(started)
+		result.Applied = migration.Applied
+		result.Error = migration.Error
+		return result
+	}, func(target Target, err error) RehearsalResult {
+		return RehearsalResult{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema, Error: err}
+	})
+}
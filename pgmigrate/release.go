@@ -0,0 +1,194 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// releaseDirective assigns a migration to a named release, e.g.
+//
+//	-- pgmigrate:release 2024.06
+//
+// The pending migrations of a release that follow each other are applied
+// to each database in a single transaction and recorded with the release
+// in the history table, so that a release lands on a database completely
+// or not at all and can be rolled back as a unit with RollbackRelease.
+// Error policies apply inside a release as they do to a migration of its
+// own: ignore policies skip the statements they cover, and a retry policy
+// covering the migration that failed re-runs the whole release, since its
+// transaction rolled back every migration of it. Releases with a migration
+// running outside a transaction are applied one migration at a time.
+const releaseDirective = "-- pgmigrate:release"
+
+// scriptRelease returns the release of the release directive of a script,
+// or "" if there is none.
+func scriptRelease(script string) (string, error) {
+	release := ""
+	for _, line := range strings.Split(script, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, releaseDirective) {
+			continue
+		}
+		name := strings.TrimSpace(strings.TrimPrefix(line, releaseDirective))
+		switch {
+		case name == "" || strings.ContainsAny(name, " \t"):
+			return "", fmt.Errorf("release: expected a single name, got %q", name)
+		case release != "" && release != name:
+			return "", fmt.Errorf("release: migration is in both %s and %s", release, name)
+		}
+		release = name
+	}
+	return release, nil
+}
+
+// transactionalRelease reports whether the migrations of a release can be
+// applied in a single transaction.
+func transactionalRelease(migrations []Migration, settings DatabaseSettings) bool {
+	if settings.NoTransaction {
+		return false
+	}
+	for _, migration := range migrations {
+		if migration.NoTransaction {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRelease applies the migrations of a release in a single transaction
+// and records them with the release.
+func applyRelease(ctx context.Context, conn *sql.Conn, target Target, migrations []Migration, data templateData, settings DatabaseSettings) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, migration := range migrations {
+		started := time.Now()
+		script, err := renderScript(migration, data)
+		if err != nil {
+			return newMigrationError(migration, fmt.Errorf("render: %w", err))
+		}
+		if err := runMigrationHooks(ctx, tx, settings.MigrationHooks, false, migration, data); err != nil {
+			return newMigrationError(migration, err)
+		}
+		if err := executeScript(ctx, tx, target, migration, script, settings); err != nil {
+			return newMigrationError(migration, err)
+		}
+		if err := runMigrationHooks(ctx, tx, settings.MigrationHooks, true, migration, data); err != nil {
+			return newMigrationError(migration, err)
+		}
+		if err := recordApplied(ctx, tx, migration, time.Since(started).Milliseconds(), settings); err != nil {
+			return newMigrationError(migration, err)
+		}
+	}
+	return tx.Commit()
+}
+
+// RollbackRelease reverts the migrations applied with a release on every
+// target with their down migrations, newest first, and removes them from
+// the history. The migrations of a release are reverted in a single
+// transaction unless one of them runs outside a transaction.
+func (m *Migrator) RollbackRelease(ctx context.Context, targets []Target, release string) []MaintenanceResult {
+	return forEachTarget(ctx, m.config, targets, func(ctx context.Context, target Target) MaintenanceResult {
+		result := MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database}
+		result.Changes, result.Error = m.rollbackRelease(ctx, target, release)
+		return result
+	}, func(target Target, err error) MaintenanceResult {
+		return MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database, Error: err}
+	})
+}
+
+// rollbackRelease reverts the migrations of a release on one database.
+func (m *Migrator) rollbackRelease(ctx context.Context, target Target, release string) ([]string, error) {
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if target.Schema != "" {
+		err = lockSchema(ctx, conn, target.Schema)
+	} else {
+		err = lockDatabase(ctx, conn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if target.Schema != "" {
+		defer unlockSchema(conn, target.Schema)
+	} else {
+		defer unlockDatabase(conn)
+	}
+	settings := m.config.settingsFor(target)
+	if err := applySessionSettings(ctx, conn, settings); err != nil {
+		return nil, err
+	}
+	if err := lookupVariables(ctx, m.config, target, conn, &settings); err != nil {
+		return nil, err
+	}
+
+	if err := ensureHistoryTable(ctx, conn); err != nil {
+		return nil, fmt.Errorf("create history table: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn, m.migrations)
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+	var migrations []Migration
+	for _, migration := range m.migrations {
+		if a, ok := applied[migration.Version]; ok && a.Release == release {
+			if migration.DownScript == "" {
+				return nil, fmt.Errorf("migration %s of release %s has no down migration", migration.Version, release)
+			}
+			migrations = append(migrations, migration)
+		}
+	}
+	if len(migrations) == 0 {
+		return []string{"release " + release + " not applied"}, nil
+	}
+	migrations = orderByDependencies(migrations)
+
+	data := templateData{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema, Vars: settings.Variables}
+	var changes []string
+	if !transactionalRelease(migrations, settings) {
+		for i := len(migrations) - 1; i >= 0; i-- {
+			if err := revertMigration(ctx, conn, migrations[i], data, settings); err != nil {
+				return changes, newMigrationError(migrations[i], err)
+			}
+			changes = append(changes, "reverted "+migrations[i].Version)
+		}
+		return changes, nil
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	for i := len(migrations) - 1; i >= 0; i-- {
+		migration := migrations[i]
+		down := Migration{Version: migration.Version, Path: migration.DownPath, Script: migration.DownScript}
+		script, err := renderScript(down, data)
+		if err != nil {
+			return nil, newMigrationError(migration, fmt.Errorf("render: %w", err))
+		}
+		if err := executeWithDependents(ctx, tx, script, splitStatements(script), settings.DefinitionsDir, nil); err != nil {
+			return nil, newMigrationError(migration, err)
+		}
+		if err := removeApplied(ctx, tx, migration.Version, settings); err != nil {
+			return nil, err
+		}
+		changes = append(changes, "reverted "+migration.Version)
+	}
+	return changes, tx.Commit()
+}
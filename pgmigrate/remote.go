@@ -0,0 +1,132 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// fetchLocation returns a local directory holding the migration files at
+// location, and the function removing it once the migrations are loaded.
+// Location is a directory, or the URL of a remote source whose files are
+// downloaded to a temporary directory:
+//
+//	s3://bucket/prefix?region=eu-west-1
+//	gs://bucket/prefix
+//	https://artifacts.example.com/migrations.tar.gz#sha256=9f86d0...
+//	git+https://github.com/acme/schema.git#ref=v1.4.0&commit=3f2c1e...
+//	oci://ghcr.io/acme/schema:1.4@sha256:3f2c1e...
+//
+// The dir option of the URL fragment, e.g. #dir=db/migrations, loads a
+// subdirectory of the downloaded files. Bundles are checked against
+// signatures when set.
+func fetchLocation(ctx context.Context, location string, signatures *SignatureConfig) (string, func(), error) {
+	if !strings.Contains(location, "://") {
+		return location, func() {}, nil
+	}
+	u, err := url.Parse(location)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid migration location %q: %w", location, err)
+	}
+	options, err := url.ParseQuery(u.Fragment)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid migration location %q: %w", location, err)
+	}
+	sub := filepath.FromSlash(options.Get("dir"))
+	if sub != "" && !filepath.IsLocal(sub) {
+		return "", nil, fmt.Errorf("invalid migration location %q: dir must be a relative path inside the source", location)
+	}
+	var fetch func(ctx context.Context, u *url.URL, dir string) error
+	switch u.Scheme {
+	case "s3":
+		fetch = fetchS3
+	case "gs":
+		fetch = fetchGCS
+	case "git+https", "git+ssh", "git+file":
+		fetch = fetchGit
+	case "oci":
+		fetch = fetchOCI
+	case "https":
+		fetch = func(ctx context.Context, u *url.URL, dir string) error {
+			return fetchBundle(ctx, u, dir, signatures)
+		}
+	default:
+		return "", nil, fmt.Errorf("unsupported migration location %q", location)
+	}
+
+	dir, err := os.MkdirTemp("", "pgmigrate-")
+	if err != nil {
+		return "", nil, err
+	}
+	if err := fetch(ctx, u, dir); err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("fetch %s: %w", u.Redacted(), err)
+	}
+	if info, err := os.Stat(filepath.Join(dir, sub)); err != nil || !info.IsDir() {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("fetch %s: no directory %s in the source", u.Redacted(), options.Get("dir"))
+	}
+	return filepath.Join(dir, sub), func() { os.RemoveAll(dir) }, nil
+}
+
+// writeFetchedFile writes a file of a remote source to its path, relative
+// to dir and slash-separated, refusing paths that would leave dir.
+func writeFetchedFile(dir, name string, r io.Reader) error {
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		return fmt.Errorf("refusing file %q outside the migration directory", name)
+	}
+	path := filepath.Join(dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// fetchSources fetches the migration directory and the source directories
+// of config that are remote, returning config with their local copies in
+// their place and the function removing those copies.
+func fetchSources(ctx context.Context, config Configuration) (Configuration, func(), error) {
+	var cleanups []func()
+	cleanup := func() {
+		for _, cleanup := range cleanups {
+			cleanup()
+		}
+	}
+	fail := func(err error) (Configuration, func(), error) {
+		cleanup()
+		return config, nil, err
+	}
+
+	if len(config.Sources) == 0 {
+		dir, done, err := fetchLocation(ctx, config.MigrationDir, config.Signatures)
+		if err != nil {
+			return fail(err)
+		}
+		config.MigrationDir = dir
+		cleanups = append(cleanups, done)
+		return config, cleanup, nil
+	}
+	sources := append([]SourceConfig(nil), config.Sources...)
+	for i, source := range sources {
+		dir, done, err := fetchLocation(ctx, source.Dir, config.Signatures)
+		if err != nil {
+			return fail(fmt.Errorf("source %s: %w", source.Name, err))
+		}
+		sources[i].Dir = dir
+		cleanups = append(cleanups, done)
+	}
+	config.Sources = sources
+	return config, cleanup, nil
+}
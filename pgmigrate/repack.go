@@ -0,0 +1,169 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// repackDirective marks a migration whose tables are rewritten online with
+// pg_repack after its statements have run, e.g.
+//
+//	-- pgmigrate:repack table=orders order_by="created_at" tablespace=fast jobs=2
+//	ALTER TABLE orders DROP COLUMN legacy_payload;
+//
+// This pairs catalog-only changes with a rewrite that does not hold an
+// exclusive lock for its duration. The pg_repack extension must be
+// installed in the database. Migrations with a repack directive run outside
+// a transaction and are recorded once every repack has succeeded.
+const repackDirective = "-- pgmigrate:repack"
+
+// repack holds the parameters of a repack directive.
+type repack struct {
+	table      string
+	orderBy    string
+	tablespace string
+	jobs       int
+}
+
+// parseRepack parses the arguments of a repack directive line.
+func parseRepack(line string) (repack, error) {
+	var r repack
+	args, err := splitDirectiveArgs(strings.TrimPrefix(strings.TrimSpace(line), repackDirective))
+	if err != nil {
+		return r, err
+	}
+	for _, arg := range args {
+		key, value, ok := strings.Cut(arg, "=")
+		if !ok {
+			return r, fmt.Errorf("repack: expected key=value, got %q", arg)
+		}
+		switch key {
+		case "table":
+			r.table = value
+		case "order_by":
+			r.orderBy = value
+		case "tablespace":
+			r.tablespace = value
+		case "jobs":
+			r.jobs, err = strconv.Atoi(value)
+			if err != nil || r.jobs <= 0 {
+				return r, fmt.Errorf("repack: invalid jobs %q", value)
+			}
+		default:
+			return r, fmt.Errorf("repack: unknown argument %q", key)
+		}
+	}
+	if r.table == "" {
+		return r, errors.New("repack: table is required")
+	}
+	return r, nil
+}
+
+// scriptRepacks returns the repack directives of a script.
+func scriptRepacks(script string) ([]repack, error) {
+	var repacks []repack
+	for _, line := range strings.Split(script, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), repackDirective) {
+			continue
+		}
+		r, err := parseRepack(line)
+		if err != nil {
+			return nil, err
+		}
+		repacks = append(repacks, r)
+	}
+	return repacks, nil
+}
+
+// runRepack runs pg_repack on a table of the database. In schema-per-tenant
+// mode, schema is the tenant schema and qualifies a table named without one,
+// since pg_repack resolves names through its own search_path.
+func runRepack(ctx context.Context, cluster ClusterConfig, dbName, schema string, r repack) error {
+	env, err := clientEnv(ctx, cluster, dbName)
+	if err != nil {
+		return fmt.Errorf("pg_repack: %w", err)
+	}
+
+	args := []string{"--dbname", dbName, "--table", repackTable(schema, r.table)}
+	if r.orderBy != "" {
+		args = append(args, "--order-by", r.orderBy)
+	}
+	if r.tablespace != "" {
+		args = append(args, "--tablespace", r.tablespace)
+	}
+	if r.jobs > 0 {
+		args = append(args, "--jobs", strconv.Itoa(r.jobs))
+	}
+
+	command := cluster.PgRepack
+	if command == "" {
+		command = "pg_repack"
+	}
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = env
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("pg_repack %s: %w: %s", r.table, err, strings.TrimSpace(output.String()))
+	}
+	return nil
+}
+
+// repackTable returns table qualified with schema, unless schema is empty or
+// table already names a schema with a dot outside double quotes.
+func repackTable(schema, table string) string {
+	if schema == "" {
+		return table
+	}
+	quoted := false
+	for _, c := range table {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == '.' && !quoted:
+			return table
+		}
+	}
+	return pq.QuoteIdentifier(schema) + "." + table
+}
+
+// clientEnv returns the environment for a PostgreSQL client program such as
+// pg_repack or pg_dump connecting to the database. Client programs open
+// their own connections, so they cannot reach clusters behind an SSH tunnel,
+// proxy or custom dialer.
+func clientEnv(ctx context.Context, cluster ClusterConfig, dbName string) ([]string, error) {
+	if cluster.SSH != nil || cluster.Proxy != "" || cluster.Dialer != nil {
+		return nil, errors.New("cannot connect through an SSH tunnel, proxy or dialer")
+	}
+	username, err := resolveSecret(ctx, cluster.Username)
+	if err != nil {
+		return nil, err
+	}
+	password, hasPassword, err := cluster.password(ctx, dbName)
+	if err != nil {
+		return nil, err
+	}
+	env := append(os.Environ(), "PGSSLMODE="+sslMode(cluster))
+	if cluster.Host != "" {
+		env = append(env, "PGHOST="+cluster.Host)
+	}
+	if cluster.Port != 0 {
+		env = append(env, "PGPORT="+strconv.Itoa(cluster.Port))
+	}
+	if username != "" {
+		env = append(env, "PGUSER="+username)
+	}
+	if hasPassword {
+		env = append(env, "PGPASSWORD="+password)
+	}
+	return env, nil
+}
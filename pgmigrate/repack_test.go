@@ -0,0 +1,21 @@
+package pgmigrate
+
+import "testing"
+
+func TestRepackTable(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		schema string
+		table  string
+		want   string
+	}{
+		{name: "no schema", table: "orders", want: "orders"},
+		{name: "tenant schema", schema: "tenant_42", table: "orders", want: `"tenant_42".orders`},
+		{name: "qualified table", schema: "tenant_42", table: "audit.orders", want: "audit.orders"},
+		{name: "dot inside quotes", schema: "tenant_42", table: `"orders.v2"`, want: `"tenant_42"."orders.v2"`},
+	} {
+		if got := repackTable(test.schema, test.table); got != test.want {
+			t.Errorf("%s: repackTable = %q, want %q", test.name, got, test.want)
+		}
+	}
+}
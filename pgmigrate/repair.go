@@ -0,0 +1,161 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+)
+
+// RepairOptions selects the repairs of RepairHistory. Each must be asked
+// for explicitly, as each accepts a state a run would refuse or warn about.
+type RepairOptions struct {
+	// Checksums records the current checksum of applied migrations whose
+	// file was edited since, after the edit was reviewed and approved.
+	// Versions restricts it to the listed versions.
+	Checksums bool
+	Versions  []string
+
+	// Deprecate marks the applied migrations whose file no longer exists
+	// as deprecated, recording that the file was removed on purpose.
+	Deprecate bool
+
+	// Gaps records the migrations older than the latest applied one that
+	// were never applied, typically because their changes were made by
+	// hand, as applied without running them. Otherwise the next run would
+	// apply them out of order.
+	Gaps bool
+
+	// DryRun reports what would change without changing anything.
+	DryRun bool
+}
+
+// RepairHistory repairs the history table of each target as selected by
+// options. The changes of a database, or those it would make with DryRun,
+// are listed in its result as "checksum", "deprecate" and "record" lines.
+func (m *Migrator) RepairHistory(ctx context.Context, targets []Target, options RepairOptions) []MaintenanceResult {
+	return forEachTarget(ctx, m.config, targets, func(ctx context.Context, target Target) MaintenanceResult {
+		result := MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database}
+		result.Changes, result.Error = m.repairHistory(ctx, target, options)
+		return result
+	}, func(target Target, err error) MaintenanceResult {
+		return MaintenanceResult{Cluster: target.Cluster.Name, Database: target.Database, Error: err}
+	})
+}
+
+func (m *Migrator) repairHistory(ctx context.Context, target Target, options RepairOptions) ([]string, error) {
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if target.Schema != "" {
+		err = lockSchema(ctx, conn, target.Schema)
+	} else {
+		err = lockDatabase(ctx, conn)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if target.Schema != "" {
+		defer unlockSchema(conn, target.Schema)
+	} else {
+		defer unlockDatabase(conn)
+	}
+	if err := applySessionSettings(ctx, conn, m.config.settingsFor(target)); err != nil {
+		return nil, err
+	}
+
+	if err := ensureHistoryTable(ctx, conn); err != nil {
+		return nil, fmt.Errorf("create history table: %w", err)
+	}
+	applied, err := appliedMigrations(ctx, conn, m.migrations)
+	if err != nil {
+		return nil, fmt.Errorf("read history: %w", err)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+	changes, err := m.repairs(ctx, tx, applied, options)
+	if err != nil || options.DryRun {
+		return changes, err
+	}
+	return changes, tx.Commit()
+}
+
+// repairs makes the repairs of options in tx and returns them.
+func (m *Migrator) repairs(ctx context.Context, tx *sql.Tx, applied map[string]AppliedMigration, options RepairOptions) ([]string, error) {
+	var changes []string
+	latest := ""
+	for version := range applied {
+		if latest == "" || compareVersions(version, latest) > 0 {
+			latest = version
+		}
+	}
+
+	if options.Checksums {
+		selected := make(map[string]bool)
+		for _, version := range options.Versions {
+			if _, ok := applied[version]; !ok {
+				return nil, fmt.Errorf("version %s is not applied", version)
+			}
+			selected[version] = true
+		}
+		for _, migration := range m.migrations {
+			record, ok := applied[migration.Version]
+			if !ok || (len(selected) > 0 && !selected[migration.Version]) || m.config.Checksum.matches(record.Checksum, migration.Script) {
+				continue
+			}
+			checksum := m.config.Checksum.Sum(migration.Script)
+			if _, err := tx.ExecContext(ctx, `UPDATE `+historyTable+` SET checksum = $2 WHERE version = $1`, migration.Version, checksum); err != nil {
+				return nil, fmt.Errorf("update %s: %w", migration.Version, err)
+			}
+			changes = append(changes, fmt.Sprintf("checksum %s: %s -> %s", migration.Version, record.Checksum, checksum))
+		}
+	}
+
+	if options.Deprecate {
+		files := make(map[string]bool)
+		for _, migration := range m.migrations {
+			files[migration.Version] = true
+		}
+		var versions []string
+		for version, record := range applied {
+			if !files[version] && !record.Deprecated {
+				versions = append(versions, version)
+			}
+		}
+		sort.Slice(versions, func(i, j int) bool { return compareVersions(versions[i], versions[j]) < 0 })
+		for _, version := range versions {
+			if _, err := tx.ExecContext(ctx, `UPDATE `+historyTable+` SET deprecated = true WHERE version = $1`, version); err != nil {
+				return nil, fmt.Errorf("deprecate %s: %w", version, err)
+			}
+			changes = append(changes, fmt.Sprintf("deprecate %s %s: file removed", version, applied[version].Description))
+		}
+	}
+
+	if options.Gaps && latest != "" {
+		for _, migration := range m.migrations {
+			if compareVersions(migration.Version, latest) >= 0 {
+				break
+			}
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+			if err := recordMigration(ctx, tx, migration, 0); err != nil {
+				return nil, fmt.Errorf("record %s: %w", migration.Version, err)
+			}
+			changes = append(changes, fmt.Sprintf("record %s %s: gap before %s", migration.Version, migration.Description, latest))
+		}
+	}
+	return changes, nil
+}
@@ -0,0 +1,57 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// RetryConfig retries the databases that fail with a transient error, such
+// as a lost connection or a deadlock. Permanent failures are not retried.
+type RetryConfig struct {
+	// Attempts is the number of retries after the first failure.
+	Attempts int `json:"attempts"`
+
+	// Delay is the wait before the first retry, doubled before each of the
+	// next ones. Defaults to 5s.
+	Delay string `json:"delay"`
+}
+
+func (c *RetryConfig) validate() error {
+	if c.Attempts < 0 {
+		return errors.New("attempts must not be negative")
+	}
+	if c.Delay != "" {
+		if _, err := time.ParseDuration(c.Delay); err != nil {
+			return fmt.Errorf("delay: %w", err)
+		}
+	}
+	return nil
+}
+
+// withRetries runs migrate, again after a delay as long as it fails with a
+// transient error and retries are left. The result counts the attempts.
+func withRetries(ctx context.Context, config *RetryConfig, classify func(error) string, migrate func() MigrationResult) MigrationResult {
+	result := migrate()
+	result.Attempts = 1
+	if config == nil {
+		return result
+	}
+	delay := 5 * time.Second
+	if config.Delay != "" {
+		delay, _ = time.ParseDuration(config.Delay)
+	}
+	for retry := 0; retry < config.Attempts && classify(result.Error) == ErrorTransient; retry++ {
+		select {
+		case <-ctx.Done():
+			return result
+		case <-time.After(delay):
+		}
+		delay *= 2
+		attempts := result.Attempts
+		result = migrate()
+		result.Attempts = attempts + 1
+	}
+	return result
+}
@@ -0,0 +1,211 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+	"go.etcd.io/etcd/client/v3/concurrency"
+)
+
+// ErrRunLocked is returned when another instance holds the run lock.
+var ErrRunLocked = errors.New("another migration run holds the run lock")
+
+// RunLockConfig configures a lock held in etcd or Consul for the duration
+// of a run, so that only one of several replicas of the migrator runs at a
+// time. It complements the advisory lock each database takes.
+type RunLockConfig struct {
+	// Backend is "etcd" or "consul".
+	Backend string `json:"backend"`
+	// Key is the lock key. Defaults to "pgmigrate/run-lock".
+	Key string `json:"key"`
+	// TTL is how long the lock survives a crashed holder. Defaults to 30s.
+	TTL string `json:"ttl"`
+	// Wait is how long to wait for a lock held elsewhere before giving up.
+	// Zero fails immediately.
+	Wait string `json:"wait"`
+
+	// Endpoints, Username and Password configure the etcd client.
+	Endpoints []string `json:"endpoints"`
+	Username  string   `json:"username"`
+	Password  string   `json:"password"`
+
+	// Address and Token configure the Consul client. They default to
+	// $CONSUL_HTTP_ADDR and $CONSUL_HTTP_TOKEN.
+	Address string `json:"address"`
+	Token   string `json:"token"`
+}
+
+func (c RunLockConfig) key() string {
+	if c.Key == "" {
+		return "pgmigrate/run-lock"
+	}
+	return c.Key
+}
+
+func (c RunLockConfig) durations() (ttl, wait time.Duration, err error) {
+	ttl = 30 * time.Second
+	if c.TTL != "" {
+		if ttl, err = time.ParseDuration(c.TTL); err != nil {
+			return 0, 0, fmt.Errorf("run_lock.ttl: %w", err)
+		}
+	}
+	if c.Wait != "" {
+		if wait, err = time.ParseDuration(c.Wait); err != nil {
+			return 0, 0, fmt.Errorf("run_lock.wait: %w", err)
+		}
+	}
+	return ttl, wait, nil
+}
+
+// acquireRunLock takes the configured run lock. The returned context is
+// canceled if the lock is lost while the run is in progress, and release
+// must be called once the run is over. Without a configured lock it returns
+// ctx unchanged.
+func acquireRunLock(ctx context.Context, config *RunLockConfig) (context.Context, func(), error) {
+	if config == nil {
+		return ctx, func() {}, nil
+	}
+	ttl, wait, err := config.durations()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch config.Backend {
+	case "etcd":
+		return acquireEtcdLock(ctx, *config, ttl, wait)
+	case "consul":
+		return acquireConsulLock(ctx, *config, ttl, wait)
+	}
+	return nil, nil, fmt.Errorf("run_lock: unknown backend %q", config.Backend)
+}
+
+func acquireEtcdLock(ctx context.Context, config RunLockConfig, ttl, wait time.Duration) (context.Context, func(), error) {
+	client, err := clientv3.New(clientv3.Config{
+		Endpoints:   config.Endpoints,
+		Username:    config.Username,
+		Password:    config.Password,
+		DialTimeout: 10 * time.Second,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("etcd: %w", err)
+	}
+	session, err := concurrency.NewSession(client, concurrency.WithTTL(int(ttl.Seconds())))
+	if err != nil {
+		client.Close()
+		return nil, nil, fmt.Errorf("etcd: %w", err)
+	}
+	mutex := concurrency.NewMutex(session, config.key())
+
+	if wait > 0 {
+		lockCtx, cancel := context.WithTimeout(ctx, wait)
+		err = mutex.Lock(lockCtx)
+		cancel()
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = ErrRunLocked
+		}
+	} else {
+		err = mutex.TryLock(ctx)
+		if errors.Is(err, concurrency.ErrLocked) {
+			err = ErrRunLocked
+		}
+	}
+	if err != nil {
+		session.Close()
+		client.Close()
+		return nil, nil, err
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-session.Done():
+			cancel()
+		case <-runCtx.Done():
+		}
+	}()
+	release := func() {
+		cancel()
+		mutex.Unlock(context.Background())
+		session.Close()
+		client.Close()
+	}
+	return runCtx, release, nil
+}
+
+func acquireConsulLock(ctx context.Context, config RunLockConfig, ttl, wait time.Duration) (context.Context, func(), error) {
+	client := newConsulClient(config.Address, config.Token)
+	if ttl < 10*time.Second {
+		// Consul rejects session TTLs below ten seconds.
+		ttl = 10 * time.Second
+	}
+
+	var session struct{ ID string }
+	err := client.do(ctx, http.MethodPut, "/v1/session/create", nil, map[string]string{
+		"Name":      "pgmigrate run lock",
+		"TTL":       ttl.String(),
+		"Behavior":  "delete",
+		"LockDelay": "0s",
+	}, &session)
+	if err != nil {
+		return nil, nil, err
+	}
+	destroy := func() {
+		client.do(context.Background(), http.MethodPut, "/v1/session/destroy/"+session.ID, nil, nil, nil)
+	}
+
+	hostname, _ := os.Hostname()
+	holder := map[string]interface{}{"host": hostname, "pid": os.Getpid(), "since": time.Now().UTC()}
+	keyPath := "/v1/kv/" + config.key()
+	deadline := time.Now().Add(wait)
+	for {
+		var acquired bool
+		err := client.do(ctx, http.MethodPut, keyPath, url.Values{"acquire": {session.ID}}, holder, &acquired)
+		if err != nil {
+			destroy()
+			return nil, nil, err
+		}
+		if acquired {
+			break
+		}
+		if !time.Now().Before(deadline) {
+			destroy()
+			return nil, nil, ErrRunLocked
+		}
+		select {
+		case <-time.After(time.Second):
+		case <-ctx.Done():
+			destroy()
+			return nil, nil, ctx.Err()
+		}
+	}
+
+	// Renew the session at half its TTL; losing it means losing the lock.
+	runCtx, cancel := context.WithCancel(ctx)
+	go func() {
+		ticker := time.NewTicker(ttl / 2)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := client.do(runCtx, http.MethodPut, "/v1/session/renew/"+session.ID, nil, nil, nil); err != nil && runCtx.Err() == nil {
+					cancel()
+					return
+				}
+			case <-runCtx.Done():
+				return
+			}
+		}
+	}()
+	release := func() {
+		cancel()
+		client.do(context.Background(), http.MethodPut, keyPath, url.Values{"release": {session.ID}}, nil, nil)
+		destroy()
+	}
+	return runCtx, release, nil
+}
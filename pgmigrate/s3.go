@@ -0,0 +1,60 @@
+package pgmigrate
+
+import (
+	"context"
+	"net/url"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// fetchS3 downloads the objects under the prefix of an s3://bucket/prefix
+// URL to dir, keeping their paths below the prefix. Credentials come from
+// the default AWS chain, such as the IAM role of the runner, and the
+// region from the region query parameter or the environment. Objects
+// uploaded with a checksum are verified against it as they download.
+func fetchS3(ctx context.Context, u *url.URL, dir string) error {
+	cfg, err := loadAWSConfig(ctx, u.Query().Get("region"))
+	if err != nil {
+		return err
+	}
+	client := s3.NewFromConfig(cfg)
+	bucket := u.Host
+	prefix := strings.TrimPrefix(u.Path, "/")
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	pages := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+	for pages.HasMorePages() {
+		page, err := pages.NextPage(ctx)
+		if err != nil {
+			return err
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if strings.HasSuffix(key, "/") {
+				continue
+			}
+			out, err := client.GetObject(ctx, &s3.GetObjectInput{
+				Bucket:       aws.String(bucket),
+				Key:          aws.String(key),
+				ChecksumMode: types.ChecksumModeEnabled,
+			})
+			if err != nil {
+				return err
+			}
+			err = writeFetchedFile(dir, strings.TrimPrefix(key, prefix), out.Body)
+			out.Body.Close()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
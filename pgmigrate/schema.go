@@ -0,0 +1,181 @@
+package pgmigrate
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"sort"
+	"strings"
+)
+
+// SchemaObject is a single object of an introspected schema: a relation,
+// column, index, constraint, trigger, function, type or extension.
+type SchemaObject struct {
+	Kind       string
+	Name       string // qualified and quoted as needed
+	Definition string
+}
+
+// Schema is the introspected schema of a database, ordered by kind and
+// name. Objects belonging to extensions are left out.
+type Schema []SchemaObject
+
+// SchemaChange is an object added, removed or changed between two schemas.
+type SchemaChange struct {
+	Change string // added, removed or changed
+	Object SchemaObject
+	// Before is the previous definition of a changed object.
+	Before string
+}
+
+func (c SchemaChange) String() string {
+	s := c.Change + " " + c.Object.Kind + " " + c.Object.Name
+	switch {
+	case c.Change == "changed" && !strings.Contains(c.Before+c.Object.Definition, "\n"):
+		s += ": " + c.Before + " -> " + c.Object.Definition
+	case c.Change == "added" && c.Object.Definition != "" && !strings.Contains(c.Object.Definition, "\n"):
+		s += ": " + c.Object.Definition
+	}
+	return s
+}
+
+// userNamespaces restricts the introspection queries to the schemas
+// created by users.
+const userNamespaces = `n.nspname NOT IN ('pg_catalog', 'information_schema')
+	AND n.nspname NOT LIKE 'pg\_toast%' AND n.nspname NOT LIKE 'pg\_temp%'`
+
+// notExtensionMember leaves out the objects created by extensions.
+const notExtensionMember = `NOT EXISTS (SELECT 1 FROM pg_depend e WHERE e.objid = %s AND e.deptype = 'e')`
+
+// schemaQuery lists the objects of the database as (kind, name, definition).
+var schemaQuery = strings.Join([]string{`
+SELECT CASE c.relkind
+		WHEN 'r' THEN 'table' WHEN 'p' THEN 'partitioned table' WHEN 'v' THEN 'view'
+		WHEN 'm' THEN 'materialized view' WHEN 'S' THEN 'sequence' ELSE 'foreign table' END,
+	format('%I.%I', n.nspname, c.relname),
+	CASE WHEN c.relkind IN ('v', 'm') THEN pg_get_viewdef(c.oid)
+		WHEN c.relkind = 'p' THEN 'PARTITION BY ' || pg_get_partkeydef(c.oid)
+		WHEN c.relispartition THEN 'PARTITION OF ' || (SELECT i.inhparent::regclass::text FROM pg_inherits i WHERE i.inhrelid = c.oid) || ' ' || pg_get_expr(c.relpartbound, c.oid)
+		ELSE '' END
+FROM pg_class c JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE c.relkind IN ('r', 'p', 'v', 'm', 'S', 'f') AND ` + userNamespaces + ` AND ` + strings.Replace(notExtensionMember, "%s", "c.oid", 1),
+	`SELECT 'column', format('%I.%I.%I', n.nspname, c.relname, a.attname),
+	format_type(a.atttypid, a.atttypmod)
+		|| CASE WHEN a.attnotnull THEN ' NOT NULL' ELSE '' END
+		|| COALESCE(' DEFAULT ' || pg_get_expr(d.adbin, d.adrelid), '')
+FROM pg_attribute a
+JOIN pg_class c ON c.oid = a.attrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+LEFT JOIN pg_attrdef d ON d.adrelid = a.attrelid AND d.adnum = a.attnum
+WHERE a.attnum > 0 AND NOT a.attisdropped AND c.relkind IN ('r', 'p', 'v', 'm', 'f')
+	AND ` + userNamespaces + ` AND ` + strings.Replace(notExtensionMember, "%s", "c.oid", 1),
+	`SELECT 'index', format('%I.%I', n.nspname, c.relname), pg_get_indexdef(c.oid)
+FROM pg_index i
+JOIN pg_class c ON c.oid = i.indexrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE ` + userNamespaces + ` AND ` + strings.Replace(notExtensionMember, "%s", "i.indrelid", 1),
+	`SELECT 'constraint', format('%I.%I.%I', n.nspname, c.relname, con.conname), pg_get_constraintdef(con.oid)
+FROM pg_constraint con
+JOIN pg_class c ON c.oid = con.conrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE ` + userNamespaces + ` AND ` + strings.Replace(notExtensionMember, "%s", "c.oid", 1),
+	`SELECT 'trigger', format('%I.%I.%I', n.nspname, c.relname, t.tgname), pg_get_triggerdef(t.oid)
+FROM pg_trigger t
+JOIN pg_class c ON c.oid = t.tgrelid
+JOIN pg_namespace n ON n.oid = c.relnamespace
+WHERE NOT t.tgisinternal AND ` + userNamespaces,
+	`SELECT CASE p.prokind WHEN 'p' THEN 'procedure' ELSE 'function' END, p.oid::regprocedure::text, pg_get_functiondef(p.oid)
+FROM pg_proc p
+JOIN pg_namespace n ON n.oid = p.pronamespace
+WHERE p.prokind IN ('f', 'p') AND ` + userNamespaces + ` AND ` + strings.Replace(notExtensionMember, "%s", "p.oid", 1),
+	`SELECT 'type', format('%I.%I', n.nspname, t.typname),
+	CASE t.typtype
+		WHEN 'e' THEN 'ENUM (' || (SELECT string_agg(quote_literal(e.enumlabel), ', ' ORDER BY e.enumsortorder) FROM pg_enum e WHERE e.enumtypid = t.oid) || ')'
+		WHEN 'd' THEN 'DOMAIN ' || format_type(t.typbasetype, t.typtypmod)
+		ELSE 'COMPOSITE' END
+FROM pg_type t
+JOIN pg_namespace n ON n.oid = t.typnamespace
+WHERE (t.typtype IN ('e', 'd') OR (t.typtype = 'c' AND (SELECT relkind FROM pg_class WHERE oid = t.typrelid) = 'c'))
+	AND ` + userNamespaces + ` AND ` + strings.Replace(notExtensionMember, "%s", "t.oid", 1),
+	`SELECT 'extension', quote_ident(extname), extversion FROM pg_extension`,
+}, "\nUNION ALL\n")
+
+// introspectSchema reads the schema of the database.
+func introspectSchema(ctx context.Context, conn queryExecer) (Schema, error) {
+	rows, err := conn.QueryContext(ctx, schemaQuery)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var schema Schema
+	for rows.Next() {
+		var object SchemaObject
+		if err := rows.Scan(&object.Kind, &object.Name, &object.Definition); err != nil {
+			return nil, err
+		}
+		schema = append(schema, object)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Sort here rather than rely on the server's collation, so that
+	// fingerprints compare across servers.
+	sort.Slice(schema, func(i, j int) bool {
+		if schema[i].Kind != schema[j].Kind {
+			return schema[i].Kind < schema[j].Kind
+		}
+		return schema[i].Name < schema[j].Name
+	})
+	return schema, nil
+}
+
+// ReadSchema reads the schema of the database db is connected to.
+func ReadSchema(ctx context.Context, db *sql.DB) (Schema, error) {
+	return introspectSchema(ctx, db)
+}
+
+// Fingerprint returns a hash of the whole schema, equal for databases with
+// identical schemas.
+func (s Schema) Fingerprint() string {
+	h := sha256.New()
+	for _, object := range s {
+		h.Write([]byte(object.Kind + "\x00" + object.Name + "\x00" + object.Definition + "\x00"))
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// DiffSchemas lists the objects added, removed or changed from before to
+// after, ordered by kind and name.
+func DiffSchemas(before, after Schema) []SchemaChange {
+	type key struct{ kind, name string }
+	old := make(map[key]SchemaObject, len(before))
+	for _, object := range before {
+		old[key{object.Kind, object.Name}] = object
+	}
+
+	var changes []SchemaChange
+	for _, object := range after {
+		k := key{object.Kind, object.Name}
+		previous, ok := old[k]
+		delete(old, k)
+		switch {
+		case !ok:
+			changes = append(changes, SchemaChange{Change: "added", Object: object})
+		case previous.Definition != object.Definition:
+			changes = append(changes, SchemaChange{Change: "changed", Object: object, Before: previous.Definition})
+		}
+	}
+	for _, object := range old {
+		changes = append(changes, SchemaChange{Change: "removed", Object: object})
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		a, b := changes[i].Object, changes[j].Object
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		return a.Name < b.Name
+	})
+	return changes
+}
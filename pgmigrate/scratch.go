@@ -0,0 +1,45 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// scratchDatabasePrefix starts the names of scratch databases, which are
+// never migrated as targets: a run finding one that a rehearsal or check is
+// using would have its sessions terminated when it is dropped.
+const scratchDatabasePrefix = "pgmigrate_scratch_"
+
+// createScratchDatabase creates a disposable database on the cluster,
+// cloned from template when it is non-empty and empty otherwise. Cloning
+// requires that nobody else is connected to the template database. The
+// returned function drops the database again.
+func createScratchDatabase(ctx context.Context, cluster ClusterConfig, purpose, template string) (string, func() error, error) {
+	admin, err := connectToDatabase(ctx, cluster, "")
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := fmt.Sprintf("%s%s_%d", scratchDatabasePrefix, purpose, time.Now().UnixNano())
+	stmt := "CREATE DATABASE " + pq.QuoteIdentifier(name)
+	if template != "" {
+		stmt += " TEMPLATE " + pq.QuoteIdentifier(template)
+	}
+	if _, err := admin.ExecContext(ctx, stmt); err != nil {
+		admin.Close()
+		return "", nil, fmt.Errorf("create scratch database: %w", err)
+	}
+
+	drop := func() error {
+		defer admin.Close()
+		// Terminate leftover sessions so the drop cannot be blocked by them.
+		admin.ExecContext(context.Background(),
+			"SELECT pg_terminate_backend(pid) FROM pg_stat_activity WHERE datname = $1 AND pid <> pg_backend_pid()", name)
+		_, err := admin.ExecContext(context.Background(), "DROP DATABASE IF EXISTS "+pq.QuoteIdentifier(name))
+		return err
+	}
+	return name, drop, nil
+}
@@ -0,0 +1,249 @@
+package pgmigrate
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/secretsmanager"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/lib/pq"
+)
+
+// secretCacheTTL is how long a resolved secret is reused before it is
+// fetched again, so rotated credentials are picked up during long runs.
+const secretCacheTTL = 5 * time.Minute
+
+// secretRef is a reference to a value held in an external secret store,
+// written in configuration as scheme://id[?region=r][#json-key].
+type secretRef struct {
+	Scheme string
+	ID     string
+	Region string
+	// Key selects a field when the secret is a JSON object, such as the
+	// username and password pairs Secrets Manager stores for databases.
+	Key string
+}
+
+// secretProvider fetches secrets from one kind of store.
+type secretProvider func(ctx context.Context, ref secretRef) (string, error)
+
+// secretProviders maps reference schemes to their providers.
+var secretProviders = map[string]secretProvider{
+	"aws-secretsmanager": fetchSecretsManagerSecret,
+	"aws-ssm":            fetchSSMParameter,
+}
+
+// parseSecretRef parses a secret reference. ok is false when value is a
+// plain value rather than a reference.
+func parseSecretRef(value string) (ref secretRef, ok bool, err error) {
+	scheme, rest, found := strings.Cut(value, "://")
+	if !found {
+		return ref, false, nil
+	}
+	if _, known := secretProviders[scheme]; !known {
+		return ref, false, nil
+	}
+
+	ref.Scheme = scheme
+	rest, ref.Key, _ = strings.Cut(rest, "#")
+	rest, query, _ := strings.Cut(rest, "?")
+	for _, param := range strings.Split(query, "&") {
+		if param == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(param, "=")
+		if name != "region" {
+			return ref, true, fmt.Errorf("secret reference %s: unknown parameter %q", scheme, name)
+		}
+		ref.Region = value
+	}
+	if rest == "" {
+		return ref, true, fmt.Errorf("secret reference %s: missing secret name", scheme)
+	}
+	ref.ID = rest
+	return ref, true, nil
+}
+
+// cachedSecret is a secret being fetched or fetched already. Its fields
+// are set before done is closed.
+type cachedSecret struct {
+	done      chan struct{}
+	value     string
+	err       error
+	fetchedAt time.Time
+}
+
+// fresh reports whether the secret is being fetched, or was fetched within
+// the TTL.
+func (s *cachedSecret) fresh() bool {
+	select {
+	case <-s.done:
+		return s.err == nil && time.Since(s.fetchedAt) < secretCacheTTL
+	default:
+		return true
+	}
+}
+
+// secretCache caches resolved secrets across connections.
+var secretCache = struct {
+	sync.Mutex
+	entries map[string]*cachedSecret
+}{entries: make(map[string]*cachedSecret)}
+
+// resolveSecret returns value itself, or the secret it refers to. Callers
+// resolving a reference that is being fetched wait for that fetch rather
+// than start their own, so connecting to many databases at once fetches
+// each secret once.
+func resolveSecret(ctx context.Context, value string) (string, error) {
+	ref, ok, err := parseSecretRef(value)
+	if err != nil || !ok {
+		return value, err
+	}
+
+	secretCache.Lock()
+	entry, cached := secretCache.entries[value]
+	if cached && entry.fresh() {
+		secretCache.Unlock()
+		select {
+		case <-entry.done:
+			return entry.value, entry.err
+		case <-ctx.Done():
+			return "", ctx.Err()
+		}
+	}
+	entry = &cachedSecret{done: make(chan struct{})}
+	secretCache.entries[value] = entry
+	secretCache.Unlock()
+
+	entry.value, entry.err = fetchSecret(ctx, ref)
+	entry.fetchedAt = time.Now()
+	close(entry.done)
+	if entry.err != nil {
+		// Failures are not cached: the next caller tries again.
+		secretCache.Lock()
+		if secretCache.entries[value] == entry {
+			delete(secretCache.entries, value)
+		}
+		secretCache.Unlock()
+	}
+	return entry.value, entry.err
+}
+
+// fetchSecret fetches the secret a reference refers to from its store.
+func fetchSecret(ctx context.Context, ref secretRef) (string, error) {
+	secret, err := secretProviders[ref.Scheme](ctx, ref)
+	if err != nil {
+		return "", fmt.Errorf("resolve %s://%s: %w", ref.Scheme, ref.ID, err)
+	}
+	if ref.Key != "" {
+		var fields map[string]interface{}
+		if err := json.Unmarshal([]byte(secret), &fields); err != nil {
+			return "", fmt.Errorf("resolve %s://%s: secret is not a JSON object", ref.Scheme, ref.ID)
+		}
+		field, ok := fields[ref.Key]
+		if !ok {
+			return "", fmt.Errorf("resolve %s://%s: secret has no key %q", ref.Scheme, ref.ID, ref.Key)
+		}
+		secret = fmt.Sprint(field)
+	}
+	return secret, nil
+}
+
+// invalidateSecrets drops the cached values of the given references, so the
+// next resolution fetches them again. It reports whether any of the values
+// was a secret reference.
+func invalidateSecrets(values ...string) bool {
+	secretCache.Lock()
+	defer secretCache.Unlock()
+
+	invalidated := false
+	for _, value := range values {
+		if _, ok, _ := parseSecretRef(value); ok {
+			delete(secretCache.entries, value)
+			invalidated = true
+		}
+	}
+	return invalidated
+}
+
+// isAuthFailure reports whether err is the server rejecting credentials.
+func isAuthFailure(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "28P01" || pqErr.Code == "28000"
+	}
+	return false
+}
+
+// awsConfigs holds one AWS configuration per region, loaded on first use
+// from the default credential chain.
+var awsConfigs = struct {
+	sync.Mutex
+	byRegion map[string]aws.Config
+}{byRegion: make(map[string]aws.Config)}
+
+func loadAWSConfig(ctx context.Context, region string) (aws.Config, error) {
+	awsConfigs.Lock()
+	defer awsConfigs.Unlock()
+
+	if cfg, ok := awsConfigs.byRegion[region]; ok {
+		return cfg, nil
+	}
+	var opts []func(*awsconfig.LoadOptions) error
+	if region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return cfg, err
+	}
+	awsConfigs.byRegion[region] = cfg
+	return cfg, nil
+}
+
+// fetchSecretsManagerSecret reads the current value of an AWS Secrets
+// Manager secret, given by name or ARN.
+func fetchSecretsManagerSecret(ctx context.Context, ref secretRef) (string, error) {
+	cfg, err := loadAWSConfig(ctx, ref.Region)
+	if err != nil {
+		return "", err
+	}
+	out, err := secretsmanager.NewFromConfig(cfg).GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{
+		SecretId: aws.String(ref.ID),
+	})
+	if err != nil {
+		return "", err
+	}
+	if out.SecretString == nil {
+		return string(out.SecretBinary), nil
+	}
+	return *out.SecretString, nil
+}
+
+// fetchSSMParameter reads an AWS Systems Manager Parameter Store parameter,
+// decrypting SecureString parameters.
+func fetchSSMParameter(ctx context.Context, ref secretRef) (string, error) {
+	cfg, err := loadAWSConfig(ctx, ref.Region)
+	if err != nil {
+		return "", err
+	}
+	name := ref.ID
+	if !strings.HasPrefix(name, "/") && strings.Contains(name, "/") {
+		name = "/" + name
+	}
+	out, err := ssm.NewFromConfig(cfg).GetParameter(ctx, &ssm.GetParameterInput{
+		Name:           aws.String(name),
+		WithDecryption: aws.Bool(true),
+	})
+	if err != nil {
+		return "", err
+	}
+	return aws.ToString(out.Parameter.Value), nil
+}
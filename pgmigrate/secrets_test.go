@@ -0,0 +1,89 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// withSecretProvider registers a provider for the test scheme until the
+// test ends.
+func withSecretProvider(t *testing.T, provider secretProvider) {
+	secretProviders["test"] = provider
+	t.Cleanup(func() {
+		delete(secretProviders, "test")
+		secretCache.Lock()
+		secretCache.entries = make(map[string]*cachedSecret)
+		secretCache.Unlock()
+	})
+}
+
+func TestResolveSecretSingleFlight(t *testing.T) {
+	var fetches int32
+	release := make(chan struct{})
+	withSecretProvider(t, func(ctx context.Context, ref secretRef) (string, error) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		return `{"password": "secret"}`, nil
+	})
+
+	var wg sync.WaitGroup
+	values := make([]string, 20)
+	errs := make([]error, len(values))
+	for i := range values {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			values[i], errs[i] = resolveSecret(context.Background(), "test://db#password")
+		}(i)
+	}
+	// Wait for the fetch to start before letting it finish.
+	for atomic.LoadInt32(&fetches) == 0 {
+		runtime.Gosched()
+	}
+	close(release)
+	wg.Wait()
+
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Errorf("fetched %d times, want once", n)
+	}
+	for i := range values {
+		if values[i] != "secret" || errs[i] != nil {
+			t.Errorf("resolved %q, %v, want secret", values[i], errs[i])
+		}
+	}
+
+	if value, err := resolveSecret(context.Background(), "test://db#password"); value != "secret" || err != nil || atomic.LoadInt32(&fetches) != 1 {
+		t.Errorf("cached: %q, %v after %d fetches, want secret from the cache", value, err, atomic.LoadInt32(&fetches))
+	}
+	invalidateSecrets("test://db#password")
+	if _, err := resolveSecret(context.Background(), "test://db#password"); err != nil || atomic.LoadInt32(&fetches) != 2 {
+		t.Errorf("invalidated: %v after %d fetches, want a second fetch", err, atomic.LoadInt32(&fetches))
+	}
+}
+
+func TestResolveSecretFailureNotCached(t *testing.T) {
+	var fetches int32
+	withSecretProvider(t, func(ctx context.Context, ref secretRef) (string, error) {
+		if atomic.AddInt32(&fetches, 1) == 1 {
+			return "", errors.New("throttled")
+		}
+		return "secret", nil
+	})
+
+	if _, err := resolveSecret(context.Background(), "test://db"); err == nil {
+		t.Fatal("no error from a failed fetch")
+	}
+	if value, err := resolveSecret(context.Background(), "test://db"); value != "secret" || err != nil {
+		t.Errorf("after a failure: %q, %v, want secret", value, err)
+	}
+}
+
+func TestResolveSecretPlainValue(t *testing.T) {
+	if value, err := resolveSecret(context.Background(), "hunter2"); value != "hunter2" || err != nil {
+		t.Errorf("got %q, %v, want the value itself", value, err)
+	}
+}
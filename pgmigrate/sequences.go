@@ -0,0 +1,116 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SequenceIssue is a sequence whose next value is already used by the
+// column it feeds, typically after rows were copied in with explicit ids by
+// a data migration or restore. Inserting with the default would fail with a
+// duplicate key.
+type SequenceIssue struct {
+	Sequence  string
+	Table     string
+	Column    string
+	NextValue int64
+	MaxValue  int64
+
+	// Fixed is set when the sequence was advanced past MaxValue.
+	Fixed bool
+}
+
+func (s SequenceIssue) String() string {
+	if s.Fixed {
+		return fmt.Sprintf("advanced %s to %d (was at %d)", s.Sequence, s.MaxValue, s.NextValue)
+	}
+	return fmt.Sprintf("%s would return %d but max(%s.%s) is %d", s.Sequence, s.NextValue, s.Table, s.Column, s.MaxValue)
+}
+
+// ownedSequencesQuery lists the ascending sequences owned by serial and
+// identity columns.
+const ownedSequencesQuery = `
+SELECT s.oid::regclass::text, t.oid::regclass::text, quote_ident(a.attname)
+FROM pg_depend d
+JOIN pg_class s ON s.oid = d.objid AND s.relkind = 'S'
+JOIN pg_sequence seq ON seq.seqrelid = s.oid
+JOIN pg_class t ON t.oid = d.refobjid AND t.relkind IN ('r', 'p')
+JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = d.refobjsubid
+WHERE d.classid = 'pg_class'::regclass
+	AND d.refclassid = 'pg_class'::regclass
+	AND d.deptype IN ('a', 'i')
+	AND seq.seqincrement > 0
+ORDER BY 1`
+
+// checkSequences finds the sequences behind the maximum value of their
+// column and, with fix, advances them to it.
+func checkSequences(ctx context.Context, conn queryExecer, fix bool) ([]SequenceIssue, error) {
+	rows, err := conn.QueryContext(ctx, ownedSequencesQuery)
+	if err != nil {
+		return nil, err
+	}
+	var owned []SequenceIssue
+	for rows.Next() {
+		var s SequenceIssue
+		if err := rows.Scan(&s.Sequence, &s.Table, &s.Column); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		owned = append(owned, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var issues []SequenceIssue
+	for _, s := range owned {
+		var lastValue, increment int64
+		var isCalled bool
+		err := conn.QueryRowContext(ctx, `SELECT last_value, is_called, (SELECT seqincrement FROM pg_sequence WHERE seqrelid = $1::regclass) FROM `+s.Sequence, s.Sequence).
+			Scan(&lastValue, &isCalled, &increment)
+		if err != nil {
+			return issues, fmt.Errorf("read %s: %w", s.Sequence, err)
+		}
+		s.NextValue = lastValue
+		if isCalled {
+			s.NextValue += increment
+		}
+
+		var maxValue sql.NullInt64
+		if err := conn.QueryRowContext(ctx, fmt.Sprintf("SELECT max(%s)::bigint FROM %s", s.Column, s.Table)).Scan(&maxValue); err != nil {
+			return issues, fmt.Errorf("read max(%s.%s): %w", s.Table, s.Column, err)
+		}
+		if !maxValue.Valid || maxValue.Int64 < s.NextValue {
+			continue
+		}
+		s.MaxValue = maxValue.Int64
+
+		if fix {
+			if _, err := conn.ExecContext(ctx, "SELECT setval($1::regclass, $2, true)", s.Sequence, s.MaxValue); err != nil {
+				return issues, fmt.Errorf("advance %s: %w", s.Sequence, err)
+			}
+			s.Fixed = true
+		}
+		issues = append(issues, s)
+	}
+	return issues, nil
+}
+
+// CheckSequences reports the sequences behind their column in each
+// database and, with fix, advances them.
+func (m *Migrator) CheckSequences(ctx context.Context, targets []Target, fix bool) []MaintenanceResult {
+	return maintainDatabases(ctx, m.config, targets, func(ctx context.Context, conn queryExecer) ([]string, error) {
+		issues, err := checkSequences(ctx, conn, fix)
+		return issueStrings(issues), err
+	})
+}
+
+func issueStrings(issues []SequenceIssue) []string {
+	var descriptions []string
+	for _, issue := range issues {
+		descriptions = append(descriptions, issue.String())
+	}
+	return descriptions
+}
@@ -0,0 +1,90 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+)
+
+// Names of the session scripts of the migration directory.
+const (
+	beforeScriptName = "_before.sql"
+	afterScriptName  = "_after.sql"
+)
+
+// SessionScripts are scripts run on every database at the start and at the
+// end of its session, such as setting flags or refreshing grants. They are
+// not versioned: they run on every run, whether migrations are pending or
+// not, and are not recorded in the history. Each runs in a transaction
+// unless no_transaction is set for the database or the script would run
+// outside one as a migration, and may be templates and use directives like
+// migrations.
+type SessionScripts struct {
+	// Before runs before the pending migrations, and After once they and
+	// the repeatable migrations succeeded.
+	Before *Migration
+	After  *Migration
+}
+
+// loadSessionScripts reads the _before.sql and _after.sql scripts of the
+// migration directory, which may be missing, with readFile.
+func loadSessionScripts(dir string, readFile func(string) ([]byte, error)) (*SessionScripts, error) {
+	scripts := &SessionScripts{}
+	for _, s := range []struct {
+		name   string
+		script **Migration
+	}{{beforeScriptName, &scripts.Before}, {afterScriptName, &scripts.After}} {
+		path := filepath.Join(dir, s.name)
+		file, err := readFile(path)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		migration, err := parseMigration(path, file, "", s.name)
+		if err != nil {
+			return nil, err
+		}
+		*s.script = &migration
+	}
+	if scripts.Before == nil && scripts.After == nil {
+		return nil, nil
+	}
+	return scripts, nil
+}
+
+// isSessionScript reports whether a file of the migration directory is a
+// session script rather than a migration.
+func isSessionScript(name string) bool {
+	return name == beforeScriptName || name == afterScriptName
+}
+
+// runSessionScript runs a session script on conn, if there is one.
+func runSessionScript(ctx context.Context, conn *sql.Conn, target Target, script *Migration, data templateData, settings DatabaseSettings) error {
+	if script == nil {
+		return nil
+	}
+	rendered, err := renderScript(*script, data)
+	if err != nil {
+		return fmt.Errorf("%s: render: %w", script.Description, err)
+	}
+	if settings.NoTransaction || script.NoTransaction {
+		if err := executeScript(ctx, conn, target, *script, rendered, settings); err != nil {
+			return fmt.Errorf("%s: %w", script.Description, err)
+		}
+		return nil
+	}
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := executeScript(ctx, tx, target, *script, rendered, settings); err != nil {
+		return fmt.Errorf("%s: %w", script.Description, err)
+	}
+	return tx.Commit()
+}
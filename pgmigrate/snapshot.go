@@ -0,0 +1,112 @@
+package pgmigrate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// snapshotDirs names the directory of each kind of object in a snapshot.
+// Columns, constraints and triggers are written into the file of their
+// table.
+var snapshotDirs = map[string]string{
+	"table":             "tables",
+	"partitioned table": "tables",
+	"foreign table":     "tables",
+	"view":              "views",
+	"materialized view": "materialized_views",
+	"sequence":          "sequences",
+	"index":             "indexes",
+	"function":          "functions",
+	"procedure":         "procedures",
+	"type":              "types",
+	"extension":         "extensions",
+}
+
+// writeSnapshot replaces the contents of dir with one file per object of
+// schema, e.g. tables/public.users.sql, so that the directory can be
+// committed and reviewed as the current schema of a database.
+func writeSnapshot(dir string, schema Schema) error {
+	files := make(map[string]*strings.Builder)
+	var tables []string
+	for _, object := range schema {
+		subdir, ok := snapshotDirs[object.Kind]
+		if !ok {
+			continue
+		}
+		b := &strings.Builder{}
+		fmt.Fprintf(b, "-- %s %s\n", object.Kind, object.Name)
+		if object.Definition != "" {
+			b.WriteString(strings.TrimSpace(object.Definition) + "\n")
+		}
+		path := filepath.Join(subdir, safeFileName(object.Name)+".sql")
+		files[path] = b
+		if subdir == "tables" {
+			tables = append(tables, object.Name)
+		}
+	}
+
+	// Columns, constraints and triggers are named after their table.
+	for _, object := range schema {
+		if object.Kind != "column" && object.Kind != "constraint" && object.Kind != "trigger" {
+			continue
+		}
+		for _, table := range tables {
+			member, ok := strings.CutPrefix(object.Name, table+".")
+			if !ok {
+				continue
+			}
+			b := files[filepath.Join("tables", safeFileName(table)+".sql")]
+			switch object.Kind {
+			case "column":
+				fmt.Fprintf(b, "%s %s\n", member, object.Definition)
+			case "constraint":
+				fmt.Fprintf(b, "CONSTRAINT %s %s\n", member, object.Definition)
+			default:
+				fmt.Fprintf(b, "%s\n", object.Definition)
+			}
+			break
+		}
+	}
+
+	// Files of dropped objects must not linger.
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	for path, b := range files {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(path, []byte(b.String()), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotDir is the directory of the snapshot of a database, or of a
+// tenant schema of it.
+func snapshotDir(root string, target Target) string {
+	dir := filepath.Join(root, safeFileName(target.Cluster.Name), safeFileName(target.Database))
+	if target.Schema != "" {
+		dir = filepath.Join(dir, safeFileName(target.Schema))
+	}
+	return dir
+}
+
+// safeFileName turns a name into a file name, replacing the characters not
+// safe in paths.
+func safeFileName(name string) string {
+	if strings.Trim(name, ".") == "" {
+		return strings.Repeat("_", len(name))
+	}
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '.', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,74 @@
+package pgmigrate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// SourceConfig is one of several migration sources merged into a single
+// sequence, such as the core product's migrations, those of its plugins
+// and customer-specific overrides.
+type SourceConfig struct {
+	// Name identifies the source in errors and in Migration.Source.
+	Name string `json:"name"`
+	// Dir is the directory of the source's migrations, or the URL of a
+	// remote source such as s3://bucket/prefix or gs://bucket/prefix.
+	Dir string `json:"dir"`
+	// Glob loads the migrations under Dir matching it, as MigrationGlob
+	// does.
+	Glob string `json:"glob"`
+	// Override lets the source's migrations replace the migrations of
+	// the same version from the sources listed before it. Otherwise a
+	// version found in two sources is an error.
+	Override bool `json:"override"`
+}
+
+func (s SourceConfig) validate() error {
+	if s.Name == "" {
+		return errors.New("name is required")
+	}
+	if s.Dir == "" {
+		return errors.New("dir is required")
+	}
+	return nil
+}
+
+// load reads the migrations of the source.
+func (s SourceConfig) load() ([]Migration, error) {
+	if s.Glob != "" {
+		return LoadMigrationsGlob(s.Dir, s.Glob)
+	}
+	return LoadMigrations(s.Dir)
+}
+
+// loadSources reads the migrations of every source and merges them into a
+// single sequence ordered by version. Sources take precedence in the order
+// they are listed, the later ones replacing migrations of earlier ones only
+// when they are overrides.
+func loadSources(sources []SourceConfig) ([]Migration, error) {
+	byVersion := make(map[string]Migration)
+	for _, source := range sources {
+		migrations, err := source.load()
+		if err != nil {
+			return nil, fmt.Errorf("source %s: %w", source.Name, err)
+		}
+		for _, migration := range migrations {
+			migration.Source = source.Name
+			if other, ok := byVersion[migration.Version]; ok && !source.Override {
+				return nil, fmt.Errorf("migration %s is in both source %s (%s) and source %s (%s)",
+					migration.Version, other.Source, other.Path, source.Name, migration.Path)
+			}
+			byVersion[migration.Version] = migration
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		migrations = append(migrations, migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return compareVersions(migrations[i].Version, migrations[j].Version) < 0
+	})
+	return migrations, nil
+}
@@ -0,0 +1,35 @@
+//go:build cgo
+
+package pgmigrate
+
+import (
+	"errors"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+	"github.com/pganalyze/pg_query_go/v5/parser"
+)
+
+// sqlParserAvailable reports whether the binary includes PostgreSQL's
+// parser, which needs cgo. The syntax check, the linter, backfills and the
+// recreation of dependent views rely on it.
+const sqlParserAvailable = true
+
+// parseSQL parses a script with PostgreSQL's parser.
+func parseSQL(script string) (*pg_query.ParseResult, error) {
+	return pg_query.Parse(script)
+}
+
+// deparseSQL turns a parse tree back into SQL.
+func deparseSQL(tree *pg_query.ParseResult) (string, error) {
+	return pg_query.Deparse(tree)
+}
+
+// parseErrorPosition returns the 1-based character offset of a parse error
+// in the script, or zero when the parser gives none.
+func parseErrorPosition(err error) int {
+	var parseErr *parser.Error
+	if errors.As(err, &parseErr) {
+		return parseErr.Cursorpos
+	}
+	return 0
+}
@@ -0,0 +1,28 @@
+//go:build !cgo
+
+package pgmigrate
+
+import (
+	"errors"
+
+	pg_query "github.com/pganalyze/pg_query_go/v5"
+)
+
+// sqlParserAvailable is false in builds without cgo, such as static and
+// cross-compiled binaries, which cannot include PostgreSQL's parser.
+const sqlParserAvailable = false
+
+// errNoSQLParser is returned by the parser in builds without cgo.
+var errNoSQLParser = errors.New("SQL parser unavailable: pgmigrate was built without cgo")
+
+func parseSQL(script string) (*pg_query.ParseResult, error) {
+	return nil, errNoSQLParser
+}
+
+func deparseSQL(tree *pg_query.ParseResult) (string, error) {
+	return "", errNoSQLParser
+}
+
+func parseErrorPosition(err error) int {
+	return 0
+}
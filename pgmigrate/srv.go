@@ -0,0 +1,63 @@
+package pgmigrate
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+)
+
+// resolveSRV points a cluster configured with an SRV record at the current
+// primary among the record's targets. Targets are tried in priority order,
+// higher weights first, and the first one that accepts connections and is
+// not in recovery wins. Clusters without an SRV record are returned as is.
+func resolveSRV(ctx context.Context, cluster ClusterConfig) (ClusterConfig, error) {
+	if cluster.SRV == "" {
+		return cluster, nil
+	}
+
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", cluster.SRV)
+	if err != nil {
+		return cluster, fmt.Errorf("lookup SRV %s: %w", cluster.SRV, err)
+	}
+	sort.SliceStable(records, func(i, j int) bool {
+		if records[i].Priority != records[j].Priority {
+			return records[i].Priority < records[j].Priority
+		}
+		return records[i].Weight > records[j].Weight
+	})
+
+	var failures []string
+	for _, record := range records {
+		candidate := cluster
+		candidate.Host = strings.TrimSuffix(record.Target, ".")
+		candidate.Port = int(record.Port)
+
+		primary, err := isPrimary(ctx, candidate)
+		switch {
+		case err != nil:
+			failures = append(failures, fmt.Sprintf("%s: %v", candidate.address(), err))
+		case primary:
+			return candidate, nil
+		default:
+			failures = append(failures, fmt.Sprintf("%s: in recovery", candidate.address()))
+		}
+	}
+	return cluster, fmt.Errorf("no primary found for SRV %s (%s)", cluster.SRV, strings.Join(failures, "; "))
+}
+
+// isPrimary reports whether the server accepts writes.
+func isPrimary(ctx context.Context, cluster ClusterConfig) (bool, error) {
+	db, err := connectToDatabase(ctx, cluster, "")
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+
+	var inRecovery bool
+	if err := db.QueryRowContext(ctx, "SELECT pg_is_in_recovery()").Scan(&inRecovery); err != nil {
+		return false, err
+	}
+	return !inRecovery, nil
+}
@@ -0,0 +1,235 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// SSHConfig describes the bastion host a cluster is reached through.
+type SSHConfig struct {
+	Host string `json:"host"`
+	Port int    `json:"port"`
+	User string `json:"user"`
+
+	// KeyFile is a private key to authenticate with, optionally protected
+	// by KeyPassphrase. Agent authenticates through the agent listening on
+	// $SSH_AUTH_SOCK, which is also used when no key file is given.
+	KeyFile       string `json:"key_file"`
+	KeyPassphrase string `json:"key_passphrase"`
+	Agent         bool   `json:"agent"`
+
+	// KnownHostsFile verifies the bastion's host key. Defaults to
+	// ~/.ssh/known_hosts; InsecureIgnoreHostKey disables verification.
+	KnownHostsFile        string `json:"known_hosts_file"`
+	InsecureIgnoreHostKey bool   `json:"insecure_ignore_host_key"`
+}
+
+func (c SSHConfig) address() string {
+	port := c.Port
+	if port == 0 {
+		port = 22
+	}
+	return net.JoinHostPort(c.Host, strconv.Itoa(port))
+}
+
+// sshDialTimeout bounds connecting to a bastion when the context has no
+// deadline.
+const sshDialTimeout = 30 * time.Second
+
+// sshTunnel is the SSH client of a bastion.
+type sshTunnel struct {
+	// dialing is held while connecting to the bastion, so that concurrent
+	// connections wait for one dial instead of each dialing.
+	dialing chan struct{}
+	// client is the connected client, or nil. It is guarded by sshTunnels.
+	client *ssh.Client
+}
+
+// sshTunnels holds one SSH client per bastion, shared by every connection
+// that goes through it and established on first use.
+var sshTunnels = struct {
+	sync.Mutex
+	tunnels map[SSHConfig]*sshTunnel
+}{tunnels: make(map[SSHConfig]*sshTunnel)}
+
+// sshDialer returns a dialer that opens connections through the bastion.
+func sshDialer(config SSHConfig) dialFunc {
+	return func(ctx context.Context, network, address string) (net.Conn, error) {
+		client, err := sshClient(ctx, config)
+		if err != nil {
+			return nil, fmt.Errorf("ssh tunnel via %s: %w", config.address(), err)
+		}
+		return client.Dial(network, address)
+	}
+}
+
+// sshClient returns the connected client for the bastion, connecting if
+// needed. Only connections through the same bastion wait for its dial.
+func sshClient(ctx context.Context, config SSHConfig) (*ssh.Client, error) {
+	sshTunnels.Lock()
+	tunnel, ok := sshTunnels.tunnels[config]
+	if !ok {
+		tunnel = &sshTunnel{dialing: make(chan struct{}, 1)}
+		sshTunnels.tunnels[config] = tunnel
+	}
+	client := tunnel.client
+	sshTunnels.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	select {
+	case tunnel.dialing <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-tunnel.dialing }()
+
+	// Another connection may have dialed while this one waited.
+	sshTunnels.Lock()
+	client = tunnel.client
+	sshTunnels.Unlock()
+	if client != nil {
+		return client, nil
+	}
+
+	client, err := dialSSH(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	sshTunnels.Lock()
+	tunnel.client = client
+	sshTunnels.Unlock()
+
+	// Forget the client once the connection drops, so the next dial
+	// reconnects.
+	go func() {
+		client.Wait()
+		sshTunnels.Lock()
+		if tunnel.client == client {
+			tunnel.client = nil
+		}
+		sshTunnels.Unlock()
+	}()
+	return client, nil
+}
+
+// dialSSH connects and authenticates to the bastion, giving up at the
+// deadline of ctx, or after sshDialTimeout without one.
+func dialSSH(ctx context.Context, config SSHConfig) (*ssh.Client, error) {
+	clientConfig, closeAgent, err := sshClientConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	// The agent is only asked to sign during the handshake.
+	defer closeAgent()
+
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(sshDialTimeout)
+	}
+	clientConfig.Timeout = time.Until(deadline)
+
+	dialer := net.Dialer{Timeout: clientConfig.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", config.address())
+	if err != nil {
+		return nil, err
+	}
+	// ssh.Dial bounds only the TCP connection; the deadline also bounds
+	// the handshake, so an unresponsive bastion cannot hang the run.
+	conn.SetDeadline(deadline)
+	c, chans, reqs, err := ssh.NewClientConn(conn, config.address(), clientConfig)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// sshClientConfig returns the client configuration of the bastion and a
+// function closing the connection to the SSH agent, if one was opened.
+func sshClientConfig(config SSHConfig) (*ssh.ClientConfig, func(), error) {
+	closeAgent := func() {}
+	var auth []ssh.AuthMethod
+	if config.KeyFile != "" {
+		key, err := os.ReadFile(config.KeyFile)
+		if err != nil {
+			return nil, nil, err
+		}
+		var signer ssh.Signer
+		if config.KeyPassphrase != "" {
+			signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(config.KeyPassphrase))
+		} else {
+			signer, err = ssh.ParsePrivateKey(key)
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", config.KeyFile, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	}
+	if config.Agent || config.KeyFile == "" {
+		socket := os.Getenv("SSH_AUTH_SOCK")
+		if socket == "" {
+			return nil, nil, errors.New("no key_file configured and SSH_AUTH_SOCK is not set")
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, nil, fmt.Errorf("ssh agent: %w", err)
+		}
+		closeAgent = func() { conn.Close() }
+		auth = append(auth, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+
+	hostKeyCallback := ssh.InsecureIgnoreHostKey()
+	if !config.InsecureIgnoreHostKey {
+		path := config.KnownHostsFile
+		if path == "" {
+			home, err := os.UserHomeDir()
+			if err != nil {
+				closeAgent()
+				return nil, nil, err
+			}
+			path = filepath.Join(home, ".ssh", "known_hosts")
+		}
+		var err error
+		hostKeyCallback, err = knownhosts.New(path)
+		if err != nil {
+			closeAgent()
+			return nil, nil, err
+		}
+	}
+
+	user := config.User
+	if user == "" {
+		user = os.Getenv("USER")
+	}
+	return &ssh.ClientConfig{
+		User:            user,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	}, closeAgent, nil
+}
+
+// closeSSHTunnels closes every open bastion connection.
+func closeSSHTunnels() {
+	sshTunnels.Lock()
+	defer sshTunnels.Unlock()
+	for _, tunnel := range sshTunnels.tunnels {
+		if tunnel.client != nil {
+			tunnel.client.Close()
+			tunnel.client = nil
+		}
+	}
+}
@@ -0,0 +1,203 @@
+package pgmigrate
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Statement is a single SQL statement of a migration script.
+type Statement struct {
+	SQL  string
+	Line int // 1-based line of the script the statement starts on
+}
+
+// splitStatements splits a script into its individual statements on
+// top-level semicolons. Quoted strings, quoted identifiers, dollar-quoted
+// bodies and comments are kept intact, as are blocks between goose
+// StatementBegin and StatementEnd annotations and the BEGIN ATOMIC ... END
+// bodies of SQL-standard functions and procedures. Statements consisting
+// only of whitespace and comments are dropped.
+func splitStatements(script string) []Statement {
+	var statements []Statement
+	start, line, startLine := 0, 1, 1
+	// atomic is the nesting of BEGIN ATOMIC and, within it, CASE blocks,
+	// all of which are closed by END.
+	atomic := 0
+
+	flush := func(end int) {
+		text := strings.TrimSpace(script[start:end])
+		if text != "" && !onlyComments(text) {
+			statements = append(statements, Statement{SQL: text, Line: startLine + leadingLines(script[start:end])})
+		}
+	}
+
+	for i := 0; i < len(script); {
+		c := script[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == '-' && strings.HasPrefix(script[i:], gooseStatementBegin):
+			end, marker := gooseStatementEndAt(script, i)
+			line += strings.Count(script[i:end], "\n")
+			flush(marker)
+			i = end
+			start, startLine = i, line
+		case c == '-' && strings.HasPrefix(script[i:], "--"):
+			end := strings.IndexByte(script[i:], '\n')
+			if end < 0 {
+				i = len(script)
+			} else {
+				i += end
+			}
+		case c == '/' && strings.HasPrefix(script[i:], "/*"):
+			end := skipBlockComment(script, i)
+			line += strings.Count(script[i:end], "\n")
+			i = end
+		case c == '\'':
+			escapes := i > 0 && (script[i-1] == 'E' || script[i-1] == 'e') && (i < 2 || !isIdentChar(script[i-2]))
+			end := skipQuoted(script, i, '\'', escapes)
+			line += strings.Count(script[i:end], "\n")
+			i = end
+		case c == '"':
+			end := skipQuoted(script, i, '"', false)
+			line += strings.Count(script[i:end], "\n")
+			i = end
+		case c == '$' && (i == 0 || !isIdentChar(script[i-1])):
+			if tag, ok := dollarTag(script[i:]); ok {
+				end := strings.Index(script[i+len(tag):], tag)
+				if end < 0 {
+					end = len(script)
+				} else {
+					end = i + len(tag) + end + len(tag)
+				}
+				line += strings.Count(script[i:end], "\n")
+				i = end
+			} else {
+				i++
+			}
+		case c == ';' && atomic == 0:
+			flush(i)
+			i++
+			start, startLine = i, line
+		case isIdentChar(c) && (i == 0 || !isIdentChar(script[i-1])):
+			word := script[i : i+identLength(script[i:])]
+			switch {
+			case strings.EqualFold(word, "BEGIN") && isAtomic(script[i+len(word):]):
+				atomic++
+			case atomic > 0 && strings.EqualFold(word, "CASE"):
+				atomic++
+			case atomic > 0 && strings.EqualFold(word, "END"):
+				atomic--
+			}
+			i += len(word)
+		default:
+			i++
+		}
+	}
+	flush(len(script))
+	return statements
+}
+
+// skipQuoted returns the index just past the quoted section starting at i.
+// Doubled quote characters are treated as escapes, as are backslashes when
+// escapes is set.
+func skipQuoted(script string, i int, quote byte, escapes bool) int {
+	for j := i + 1; j < len(script); j++ {
+		switch script[j] {
+		case '\\':
+			if escapes {
+				j++
+			}
+		case quote:
+			if j+1 < len(script) && script[j+1] == quote {
+				j++
+				continue
+			}
+			return j + 1
+		}
+	}
+	return len(script)
+}
+
+// skipBlockComment returns the index just past the (possibly nested) block
+// comment starting at i.
+func skipBlockComment(script string, i int) int {
+	depth := 0
+	for j := i; j < len(script)-1; j++ {
+		switch {
+		case script[j] == '/' && script[j+1] == '*':
+			depth++
+			j++
+		case script[j] == '*' && script[j+1] == '/':
+			depth--
+			j++
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(script)
+}
+
+// dollarTag returns the dollar-quote opening tag ($$ or $name$) at the start
+// of s.
+func dollarTag(s string) (string, bool) {
+	for j := 1; j < len(s); j++ {
+		if s[j] == '$' {
+			return s[:j+1], true
+		}
+		if !isIdentChar(s[j]) || (j == 1 && unicode.IsDigit(rune(s[j]))) {
+			return "", false
+		}
+	}
+	return "", false
+}
+
+// identLength returns the length of the identifier or keyword at the start
+// of s.
+func identLength(s string) int {
+	n := 0
+	for n < len(s) && (isIdentChar(s[n]) || s[n] == '$') {
+		n++
+	}
+	return n
+}
+
+// isAtomic reports whether s, which follows a BEGIN keyword, starts with
+// the ATOMIC keyword.
+func isAtomic(s string) bool {
+	s = strings.TrimLeftFunc(s, unicode.IsSpace)
+	return len(s) >= len("ATOMIC") && strings.EqualFold(s[:len("ATOMIC")], "ATOMIC") &&
+		(len(s) == len("ATOMIC") || !isIdentChar(s[len("ATOMIC")]))
+}
+
+func isIdentChar(c byte) bool {
+	return c == '_' || c >= 0x80 || unicode.IsLetter(rune(c)) || unicode.IsDigit(rune(c))
+}
+
+// onlyComments reports whether text consists solely of comments.
+func onlyComments(text string) bool {
+	for text != "" {
+		text = strings.TrimSpace(text)
+		switch {
+		case strings.HasPrefix(text, "--"):
+			end := strings.IndexByte(text, '\n')
+			if end < 0 {
+				return true
+			}
+			text = text[end:]
+		case strings.HasPrefix(text, "/*"):
+			text = text[skipBlockComment(text, 0):]
+		default:
+			return text == ""
+		}
+	}
+	return true
+}
+
+// leadingLines counts the newlines before the first non-space character.
+func leadingLines(s string) int {
+	trimmed := strings.TrimLeftFunc(s, unicode.IsSpace)
+	return strings.Count(s[:len(s)-len(trimmed)], "\n")
+}
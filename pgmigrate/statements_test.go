@@ -0,0 +1,117 @@
+package pgmigrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitStatements(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		script string
+		want   []Statement
+	}{
+		{
+			name:   "semicolons",
+			script: "CREATE TABLE a ();\nCREATE TABLE b ();\n",
+			want:   []Statement{{SQL: "CREATE TABLE a ()", Line: 1}, {SQL: "CREATE TABLE b ()", Line: 2}},
+		},
+		{
+			name:   "no trailing semicolon",
+			script: "SELECT 1;\n\n\nSELECT 2",
+			want:   []Statement{{SQL: "SELECT 1", Line: 1}, {SQL: "SELECT 2", Line: 4}},
+		},
+		{
+			name:   "quoted strings and identifiers",
+			script: "INSERT INTO \"a;b\" VALUES ('it''s; fine');\nSELECT 1;",
+			want:   []Statement{{SQL: "INSERT INTO \"a;b\" VALUES ('it''s; fine')", Line: 1}, {SQL: "SELECT 1", Line: 2}},
+		},
+		{
+			name:   "escape strings",
+			script: "SELECT E'it\\'s; fine';\nSELECT e'\\\\';\nSELECT 'a\\';",
+			want:   []Statement{{SQL: "SELECT E'it\\'s; fine'", Line: 1}, {SQL: "SELECT e'\\\\'", Line: 2}, {SQL: "SELECT 'a\\'", Line: 3}},
+		},
+		{
+			name:   "backslash in a standard string",
+			script: "SELECT 'C:\\';\nSELECT 2;",
+			want:   []Statement{{SQL: "SELECT 'C:\\'", Line: 1}, {SQL: "SELECT 2", Line: 2}},
+		},
+		{
+			name:   "dollar quoting",
+			script: "CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql;\nSELECT 2;",
+			want: []Statement{
+				{SQL: "CREATE FUNCTION f() RETURNS int AS $$\nBEGIN\n  RETURN 1;\nEND;\n$$ LANGUAGE plpgsql", Line: 1},
+				{SQL: "SELECT 2", Line: 6},
+			},
+		},
+		{
+			name:   "tagged dollar quoting",
+			script: "DO $body$ BEGIN PERFORM $$;$$; END $body$;\nSELECT 2;",
+			want:   []Statement{{SQL: "DO $body$ BEGIN PERFORM $$;$$; END $body$", Line: 1}, {SQL: "SELECT 2", Line: 2}},
+		},
+		{
+			name:   "positional parameters are not dollar quotes",
+			script: "PREPARE p AS SELECT $1;\nSELECT 2;",
+			want:   []Statement{{SQL: "PREPARE p AS SELECT $1", Line: 1}, {SQL: "SELECT 2", Line: 2}},
+		},
+		{
+			name:   "line comments",
+			script: "-- first; not a statement\nSELECT 1; -- trailing;\n-- only a comment;\n",
+			want:   []Statement{{SQL: "-- first; not a statement\nSELECT 1", Line: 1}},
+		},
+		{
+			name:   "nested block comments",
+			script: "/* outer /* inner; */ still; comment */ SELECT 1;\n/* only\n a comment; */\nSELECT 2;",
+			want: []Statement{
+				{SQL: "/* outer /* inner; */ still; comment */ SELECT 1", Line: 1},
+				{SQL: "/* only\n a comment; */\nSELECT 2", Line: 2},
+			},
+		},
+		{
+			name: "goose statement block",
+			script: "-- +goose StatementBegin\nCREATE FUNCTION f() RETURNS void AS 'SELECT 1; SELECT 2' LANGUAGE sql;\n" +
+				"CREATE FUNCTION g() RETURNS void AS 'SELECT 3' LANGUAGE sql;\n-- +goose StatementEnd\nSELECT 4;",
+			want: []Statement{
+				{SQL: "-- +goose StatementBegin\nCREATE FUNCTION f() RETURNS void AS 'SELECT 1; SELECT 2' LANGUAGE sql;\n" +
+					"CREATE FUNCTION g() RETURNS void AS 'SELECT 3' LANGUAGE sql;", Line: 1},
+				{SQL: "SELECT 4", Line: 5},
+			},
+		},
+		{
+			name: "begin atomic",
+			script: "CREATE FUNCTION add(a int, b int) RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n  SELECT 1;\n  SELECT a + b;\nEND;\n" +
+				"CREATE PROCEDURE insert_data(a int) LANGUAGE sql\nbegin atomic\n  INSERT INTO tbl VALUES (a);\n  INSERT INTO tbl VALUES (a + 1);\nend;\nSELECT 2;",
+			want: []Statement{
+				{SQL: "CREATE FUNCTION add(a int, b int) RETURNS int LANGUAGE sql\nBEGIN ATOMIC\n  SELECT 1;\n  SELECT a + b;\nEND", Line: 1},
+				{SQL: "CREATE PROCEDURE insert_data(a int) LANGUAGE sql\nbegin atomic\n  INSERT INTO tbl VALUES (a);\n  INSERT INTO tbl VALUES (a + 1);\nend", Line: 6},
+				{SQL: "SELECT 2", Line: 11},
+			},
+		},
+		{
+			name:   "case inside begin atomic",
+			script: "CREATE FUNCTION sign(a int) RETURNS text LANGUAGE sql\nBEGIN ATOMIC\n  SELECT CASE WHEN a < 0 THEN 'negative; really' ELSE 'other' END;\n  SELECT 'end';\nEND;\nSELECT 2;",
+			want: []Statement{
+				{SQL: "CREATE FUNCTION sign(a int) RETURNS text LANGUAGE sql\nBEGIN ATOMIC\n  SELECT CASE WHEN a < 0 THEN 'negative; really' ELSE 'other' END;\n  SELECT 'end';\nEND", Line: 1},
+				{SQL: "SELECT 2", Line: 6},
+			},
+		},
+		{
+			name:   "begin without atomic",
+			script: "BEGIN;\nSELECT 1;\nEND;",
+			want:   []Statement{{SQL: "BEGIN", Line: 1}, {SQL: "SELECT 1", Line: 2}, {SQL: "END", Line: 3}},
+		},
+		{
+			name:   "atomic as an identifier",
+			script: "SELECT begin_atomic, atomic FROM t;\nSELECT 2;",
+			want:   []Statement{{SQL: "SELECT begin_atomic, atomic FROM t", Line: 1}, {SQL: "SELECT 2", Line: 2}},
+		},
+		{
+			name:   "empty",
+			script: " ;\n;\n",
+		},
+	} {
+		if got := splitStatements(test.script); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package pgmigrate
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// StatsDConfig sends the metrics of each run to a StatsD server once the
+// run is over, which suits batch runs that exit before any scrape.
+type StatsDConfig struct {
+	// Address is the server's host:port. Defaults to 127.0.0.1:8125.
+	Address string `json:"address"`
+
+	// Prefix is prepended to metric names. Defaults to "pgmigrate".
+	Prefix string `json:"prefix"`
+
+	// Tags are added to every metric, e.g. env:prod. Tags are only sent
+	// in DogStatsD format.
+	Tags []string `json:"tags"`
+
+	// DogStatsD sends tags, which plain StatsD servers do not accept.
+	DogStatsD bool `json:"dogstatsd"`
+}
+
+// sendStatsD sends the metrics of a run.
+func sendStatsD(config *StatsDConfig, summary RunSummary) error {
+	address := config.Address
+	if address == "" {
+		address = "127.0.0.1:8125"
+	}
+	prefix := config.Prefix
+	if prefix == "" {
+		prefix = "pgmigrate"
+	}
+	client, err := dialStatsD(address, prefix, config.Tags, config.DogStatsD)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+	sendRunMetrics(client, summary)
+	return nil
+}
+
+// statsdClient sends metrics over UDP in the StatsD line format, with
+// DogStatsD tags when dogstatsd is set.
+type statsdClient struct {
+	conn      net.Conn
+	prefix    string
+	tags      []string
+	dogstatsd bool
+}
+
+func dialStatsD(address, prefix string, tags []string, dogstatsd bool) (*statsdClient, error) {
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, ".") {
+		prefix += "."
+	}
+	return &statsdClient{conn: conn, prefix: prefix, tags: tags, dogstatsd: dogstatsd}, nil
+}
+
+func (c *statsdClient) Close() error {
+	return c.conn.Close()
+}
+
+// send writes a single metric. UDP writes only fail locally, and a lost
+// metric must not fail a run, so errors are dropped.
+func (c *statsdClient) send(name, value, kind string, tags []string) {
+	line := c.prefix + name + ":" + value + "|" + kind
+	if c.dogstatsd {
+		if all := append(c.tags[:len(c.tags):len(c.tags)], tags...); len(all) > 0 {
+			line += "|#" + strings.Join(all, ",")
+		}
+	}
+	c.conn.Write([]byte(line))
+}
+
+func (c *statsdClient) count(name string, value int, tags ...string) {
+	c.send(name, fmt.Sprint(value), "c", tags)
+}
+
+func (c *statsdClient) gauge(name string, value int, tags ...string) {
+	c.send(name, fmt.Sprint(value), "g", tags)
+}
+
+func (c *statsdClient) timing(name string, d time.Duration, tags ...string) {
+	c.send(name, fmt.Sprint(d.Milliseconds()), "ms", tags)
+}
+
+// sendRunMetrics sends the metrics of a run: databases migrated and failed,
+// migrations applied, and the duration of the run and of each database.
+func sendRunMetrics(c *statsdClient, summary RunSummary) {
+	applied := 0
+	for _, database := range summary.Databases {
+		applied += len(database.Applied)
+		status := "success"
+		if !database.Success {
+			status = "failure"
+		}
+		c.timing("database.duration", time.Duration(database.DurationMs)*time.Millisecond,
+			"cluster:"+database.Cluster, "database:"+database.Database, "status:"+status)
+	}
+	c.count("databases.migrated", summary.Succeeded)
+	c.count("databases.failed", summary.Failed)
+	c.count("migrations.applied", applied)
+	c.gauge("run.failed_databases", summary.Failed)
+	c.timing("run.duration", summary.Finished.Sub(summary.Started))
+}
@@ -0,0 +1,88 @@
+package pgmigrate
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SyntaxError reports a migration that PostgreSQL's parser rejects. Line and
+// Column are 1-based and zero when the parser gives no position.
+type SyntaxError struct {
+	Path    string
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *SyntaxError) Error() string {
+	if e.Line == 0 {
+		return fmt.Sprintf("%s: %s", e.Path, e.Message)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.Path, e.Line, e.Column, e.Message)
+}
+
+// validateSyntax parses every migration with the PostgreSQL parser so that
+// syntax errors are reported before any database is touched rather than
+// part way through a run. Scripts that cannot be rendered without a target
+// are left to fail when they are applied.
+func validateSyntax(migrations []Migration, config Configuration) error {
+	var errs []error
+	for _, migration := range migrations {
+		script, ok := analysisScript(migration, config)
+		if !ok {
+			continue
+		}
+		if err := parseScript(migration.Path, script); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// analysisScript returns the script of a migration as it is checked before
+// the run, rendering templated scripts with the global variables. It
+// reports false for scripts that cannot be rendered without a target.
+func analysisScript(migration Migration, config Configuration) (string, bool) {
+	if !isTemplate(migration.Path, migration.Script) {
+		return migration.Script, true
+	}
+	rendered, err := renderScript(migration, templateData{
+		Cluster:  "pgmigrate",
+		Database: "pgmigrate",
+		Vars:     config.Variables,
+	})
+	if err != nil {
+		return "", false
+	}
+	return rendered, true
+}
+
+// parseScript parses a script and converts the parser's cursor position
+// into a line and column.
+func parseScript(path, script string) error {
+	if _, err := parseSQL(script); err != nil {
+		syntaxErr := &SyntaxError{Path: path, Message: err.Error()}
+		if pos := parseErrorPosition(err); pos > 0 {
+			syntaxErr.Line, syntaxErr.Column = position(script, pos)
+		}
+		return syntaxErr
+	}
+	return nil
+}
+
+// position returns the line and column of the 1-based character offset pos.
+func position(script string, pos int) (line, column int) {
+	line, column = 1, 1
+	for _, r := range script {
+		if pos--; pos == 0 {
+			break
+		}
+		if r == '\n' {
+			line++
+			column = 1
+		} else {
+			column++
+		}
+	}
+	return line, column
+}
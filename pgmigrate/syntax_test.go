@@ -0,0 +1,23 @@
+package pgmigrate
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseScript(t *testing.T) {
+	if !sqlParserAvailable {
+		t.Skip("built without cgo")
+	}
+	if err := parseScript("1_ok.sql", "CREATE TABLE a (id int);\nSELECT '{{1,2}}'::int[];"); err != nil {
+		t.Fatalf("valid script: %v", err)
+	}
+	err := parseScript("2_bad.sql", "CREATE TABLE a (id int);\nCREAT TABLE b (id int);")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) {
+		t.Fatalf("error = %v, want a *SyntaxError", err)
+	}
+	if syntaxErr.Line != 2 || syntaxErr.Column != 1 {
+		t.Errorf("position = %d:%d, want 2:1", syntaxErr.Line, syntaxErr.Column)
+	}
+}
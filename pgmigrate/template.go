@@ -0,0 +1,107 @@
+package pgmigrate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/lib/pq"
+)
+
+// maintainedTemplate returns the template database the migrator keeps
+// migrated, or "" when it maintains none.
+func (c Configuration) maintainedTemplate() string {
+	if c.Provisioning == nil || !c.Provisioning.MaintainTemplate {
+		return ""
+	}
+	return c.Provisioning.Template
+}
+
+// MigrateTemplate creates the maintained template database on the named
+// cluster, or the only configured one, if it does not exist yet and applies
+// the pending migrations to it, so that tenants cloned from it start at the
+// latest version.
+func (m *Migrator) MigrateTemplate(ctx context.Context, clusterName string) (MigrationResult, error) {
+	template := m.config.maintainedTemplate()
+	if template == "" {
+		return MigrationResult{}, errors.New("no maintained template database is configured")
+	}
+	cluster, err := m.cluster(clusterName)
+	if err != nil {
+		return MigrationResult{}, err
+	}
+	if err := ensureTemplate(ctx, cluster, template, m.config.Provisioning.Owner); err != nil {
+		return MigrationResult{Cluster: cluster.Name, Database: template, Error: err}, err
+	}
+	result := migrateDatabase(ctx, m.config, m.migrations, Target{Cluster: cluster, Database: template}, template)
+	return result, result.Error
+}
+
+// migrateTemplates migrates the maintained template database of each
+// cluster of targets that has one. Template databases are not discovered
+// as targets, as connections to them would block cloning.
+func (m *Migrator) migrateTemplates(ctx context.Context, targets []Target) []MigrationResult {
+	template := m.config.maintainedTemplate()
+	if template == "" {
+		return nil
+	}
+	var results []MigrationResult
+	seen := make(map[string]bool)
+	for _, target := range targets {
+		if seen[target.Cluster.Name] {
+			continue
+		}
+		seen[target.Cluster.Name] = true
+		exists, err := databaseExists(ctx, target.Cluster, template)
+		if err != nil {
+			results = append(results, notStarted(m.config, Target{Cluster: target.Cluster, Database: template}, err))
+			continue
+		}
+		if exists {
+			result := migrateDatabase(ctx, m.config, m.migrations, Target{Cluster: target.Cluster, Database: template}, template)
+			result.ErrorClass = m.config.classifyError(result.Error)
+			if m.config.Reporter != nil {
+				m.config.Reporter.Report(result)
+			}
+			results = append(results, result)
+		}
+	}
+	return results
+}
+
+// ensureTemplate creates the template database if it does not exist and
+// marks it as a template.
+func ensureTemplate(ctx context.Context, cluster ClusterConfig, template, owner string) error {
+	exists, err := databaseExists(ctx, cluster, template)
+	if err != nil || exists {
+		return err
+	}
+	admin, err := connectToDatabase(ctx, cluster, "")
+	if err != nil {
+		return err
+	}
+	defer admin.Close()
+	stmt := "CREATE DATABASE " + pq.QuoteIdentifier(template)
+	if owner != "" {
+		stmt += " OWNER " + pq.QuoteIdentifier(owner)
+	}
+	if _, err := admin.ExecContext(ctx, stmt); err != nil {
+		return fmt.Errorf("create template database: %w", err)
+	}
+	if _, err := admin.ExecContext(ctx, "ALTER DATABASE "+pq.QuoteIdentifier(template)+" IS_TEMPLATE true"); err != nil {
+		return fmt.Errorf("mark template database: %w", err)
+	}
+	return nil
+}
+
+// databaseExists reports whether the cluster has a database of that name.
+func databaseExists(ctx context.Context, cluster ClusterConfig, name string) (bool, error) {
+	db, err := connectToDatabase(ctx, cluster, "")
+	if err != nil {
+		return false, err
+	}
+	defer db.Close()
+	var exists bool
+	err = db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM pg_database WHERE datname = $1)", name).Scan(&exists)
+	return exists, err
+}
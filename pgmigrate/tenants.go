@@ -0,0 +1,59 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/lib/pq"
+)
+
+// TenantSchemaConfig enables schema-per-tenant mode, where each database
+// holds one schema per tenant. Every tenant schema is then a target of its
+// own: migrations run with the search path set to the schema, followed by
+// public for shared extensions, so that unqualified names refer to the
+// tenant's objects, and scripts get the schema as {{.Schema}}. Each schema
+// has its own history table and advisory lock.
+type TenantSchemaConfig struct {
+	// Query lists the tenant schemas of a database, one per row, e.g.
+	// SELECT nspname FROM pg_namespace WHERE nspname LIKE 'tenant\_%'.
+	Query string `json:"query"`
+}
+
+func (c *TenantSchemaConfig) validate() error {
+	if c.Query == "" {
+		return errors.New("query is required")
+	}
+	return nil
+}
+
+// fetchTenantSchemas runs the tenant schema query on a database.
+func fetchTenantSchemas(ctx context.Context, cluster ClusterConfig, database, query string) ([]string, error) {
+	db, err := connectToDatabase(ctx, cluster, database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var schemas []string
+	for rows.Next() {
+		var schema string
+		if err := rows.Scan(&schema); err != nil {
+			return nil, err
+		}
+		schemas = append(schemas, schema)
+	}
+	return schemas, rows.Err()
+}
+
+// setSearchPath points the session at a tenant schema. Setting a schema that
+// does not exist is not an error.
+func setSearchPath(ctx context.Context, conn *sql.Conn, schema string) error {
+	_, err := conn.ExecContext(ctx, "SET search_path = "+pq.QuoteIdentifier(schema)+", public")
+	return err
+}
@@ -0,0 +1,101 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// LockHolder is a backend holding the advisory lock of a target.
+type LockHolder struct {
+	PID          int
+	User         string
+	Application  string
+	ClientAddr   string
+	State        string
+	BackendStart time.Time
+	// Since is when the backend last changed state, which for a backend
+	// left idle by a crashed run is when the run stopped.
+	Since time.Time
+	Query string
+	// Released is set when the backend was terminated, releasing the lock.
+	Released bool
+}
+
+// UnlockResult reports the holder of the advisory lock of a target, if
+// any, and whether it was released.
+type UnlockResult struct {
+	Cluster  string
+	Database string
+	Schema   string
+	Holder   *LockHolder
+	Error    error
+}
+
+// UnlockOptions controls Unlock.
+type UnlockOptions struct {
+	// DryRun only reports the holders.
+	DryRun bool
+	// Force terminates holders that are running a statement, which are
+	// usually live runs rather than crashed ones.
+	Force bool
+}
+
+// Unlock finds the backends holding the advisory locks of the targets,
+// left behind by runs whose client crashed while the server kept the
+// session, and terminates them to release the locks. The role needs the
+// privilege to signal the backends, such as pg_signal_backend.
+func (m *Migrator) Unlock(ctx context.Context, targets []Target, options UnlockOptions) []UnlockResult {
+	return forEachTarget(ctx, m.config, targets, func(ctx context.Context, target Target) UnlockResult {
+		result := UnlockResult{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema}
+		result.Holder, result.Error = unlockTarget(ctx, target, options)
+		return result
+	}, func(target Target, err error) UnlockResult {
+		return UnlockResult{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema, Error: err}
+	})
+}
+
+func unlockTarget(ctx context.Context, target Target, options UnlockOptions) (*LockHolder, error) {
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// The lock of a tenant schema is derived from the database's as in
+	// lockSchema. A bigint advisory lock is listed in pg_locks with its
+	// high half in classid and its low half in objid.
+	var holder LockHolder
+	var user, application, clientAddr, state, query sql.NullString
+	var since sql.NullTime
+	err = db.QueryRowContext(ctx, `SELECT a.pid, a.usename, a.application_name, host(a.client_addr), a.state, a.backend_start, a.state_change, a.query
+		FROM pg_locks l JOIN pg_stat_activity a ON a.pid = l.pid
+		WHERE l.locktype = 'advisory' AND l.granted AND l.objsubid = 1
+		AND l.database = (SELECT oid FROM pg_database WHERE datname = current_database())
+		AND ((l.classid::bigint << 32) | l.objid::bigint) = CASE WHEN $2::text = '' THEN $1::bigint ELSE $1::bigint # hashtext($2::text)::bigint END`,
+		advisoryLockKey, target.Schema).Scan(&holder.PID, &user, &application, &clientAddr, &state, &holder.BackendStart, &since, &query)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("find lock holder: %w", err)
+	}
+	holder.User, holder.Application, holder.ClientAddr = user.String, application.String, clientAddr.String
+	holder.State, holder.Since, holder.Query = state.String, since.Time, query.String
+
+	if options.DryRun {
+		return &holder, nil
+	}
+	if holder.State == "active" && !options.Force {
+		return &holder, fmt.Errorf("backend %d is running a statement, likely a live run; use force to terminate it anyway", holder.PID)
+	}
+	if err := db.QueryRowContext(ctx, `SELECT pg_terminate_backend($1)`, holder.PID).Scan(&holder.Released); err != nil {
+		return &holder, fmt.Errorf("terminate backend %d: %w", holder.PID, err)
+	}
+	if !holder.Released {
+		return &holder, fmt.Errorf("backend %d was not terminated", holder.PID)
+	}
+	return &holder, nil
+}
@@ -0,0 +1,116 @@
+package pgmigrate
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// VariablesQueryConfig looks up template variables for each database, such
+// as its tenant tier, region or feature flags. The columns of the single row
+// the query returns become variables named after the columns, taking
+// precedence over the configured ones; NULL becomes the empty string. When
+// the query returns no row, the database gets no additional variables.
+type VariablesQueryConfig struct {
+	// Query is the lookup query. Run against a control database, it gets
+	// the name of the database being migrated as $1.
+	Query string `json:"query"`
+
+	// Database is the control database the query runs against. When
+	// empty, the query runs in each migrated database itself.
+	Database string `json:"database"`
+
+	// Cluster names the configured cluster holding the control database.
+	// Defaults to the cluster of the migrated database.
+	Cluster string `json:"cluster"`
+}
+
+func (c *VariablesQueryConfig) validate() error {
+	if c.Query == "" {
+		return errors.New("query is required")
+	}
+	if c.Cluster != "" && c.Database == "" {
+		return errors.New("cluster requires database")
+	}
+	return nil
+}
+
+// lookupVariables adds the variables looked up for target to settings.
+// conn is connected to the migrated database.
+func lookupVariables(ctx context.Context, config Configuration, target Target, conn queryExecer, settings *DatabaseSettings) error {
+	lookup := config.VariablesQuery
+	if lookup == nil {
+		return nil
+	}
+
+	rows, done, err := lookupQuery(ctx, config, target, conn, lookup.Query, lookup.Database, lookup.Cluster)
+	if err != nil {
+		return fmt.Errorf("variables_query: %w", err)
+	}
+	defer done()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return fmt.Errorf("variables_query: %w", err)
+	}
+	if !rows.Next() {
+		return rows.Err()
+	}
+	values := make([]sql.NullString, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	if err := rows.Scan(dest...); err != nil {
+		return fmt.Errorf("variables_query: %w", err)
+	}
+	if rows.Next() {
+		return errors.New("variables_query: returned more than one row")
+	}
+	for i, column := range columns {
+		settings.Variables[column] = values[i].String
+	}
+	return rows.Err()
+}
+
+// lookupQuery runs a lookup query for target, in the migrated database conn
+// is connected to when database is empty, and otherwise in that control
+// database of the named cluster, or of the target's cluster, with the name
+// of the migrated database as $1. done closes the rows and the connection.
+func lookupQuery(ctx context.Context, config Configuration, target Target, conn queryExecer, query, database, clusterName string) (*sql.Rows, func(), error) {
+	if database == "" {
+		rows, err := conn.QueryContext(ctx, query)
+		if err != nil {
+			return nil, nil, err
+		}
+		return rows, func() { rows.Close() }, nil
+	}
+
+	cluster := target.Cluster
+	if clusterName != "" {
+		found := false
+		for _, c := range config.Clusters {
+			if c.Name == clusterName {
+				cluster, found = c, true
+				break
+			}
+		}
+		if !found {
+			return nil, nil, fmt.Errorf("unknown cluster %q", clusterName)
+		}
+	}
+	control, err := connectToDatabase(ctx, cluster, database)
+	if err != nil {
+		return nil, nil, err
+	}
+	rows, err := control.QueryContext(ctx, query, target.Database)
+	if err != nil {
+		control.Close()
+		return nil, nil, err
+	}
+	return rows, func() {
+		rows.Close()
+		control.Close()
+	}, nil
+}
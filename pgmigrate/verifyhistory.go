@@ -0,0 +1,148 @@
+package pgmigrate
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Kinds of HistoryDiscrepancy.
+const (
+	// DiscrepancyChecksum is an applied migration whose file changed.
+	DiscrepancyChecksum = "checksum mismatch"
+	// DiscrepancyMissingFile is an applied migration whose file was
+	// removed without being deprecated with RepairHistory.
+	DiscrepancyMissingFile = "missing file"
+	// DiscrepancyUnknownVersion is an applied migration newer than every
+	// file, applied from a branch or release the files do not include.
+	DiscrepancyUnknownVersion = "unknown applied version"
+)
+
+// HistoryDiscrepancy is a difference between the history of a database and
+// the migration files.
+type HistoryDiscrepancy struct {
+	Kind        string
+	Version     string
+	Description string
+	Detail      string
+}
+
+func (d HistoryDiscrepancy) String() string {
+	s := d.Kind + ": " + d.Version
+	if d.Description != "" {
+		s += " " + d.Description
+	}
+	if d.Detail != "" {
+		s += " (" + d.Detail + ")"
+	}
+	return s
+}
+
+// HistoryVerification is the outcome of VerifyHistory for a target.
+type HistoryVerification struct {
+	Cluster       string
+	Database      string
+	Schema        string
+	Discrepancies []HistoryDiscrepancy
+	// Pending is the number of migrations not applied yet, which is not a
+	// discrepancy.
+	Pending int
+	Error   error
+}
+
+// VerifyHistory compares the history of each target with the migration
+// files without changing anything, not even creating or upgrading the
+// history table.
+func (m *Migrator) VerifyHistory(ctx context.Context, targets []Target) []HistoryVerification {
+	return forEachTarget(ctx, m.config, targets, func(ctx context.Context, target Target) HistoryVerification {
+		result := HistoryVerification{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema}
+		result.Discrepancies, result.Pending, result.Error = m.verifyHistory(ctx, target)
+		return result
+	}, func(target Target, err error) HistoryVerification {
+		return HistoryVerification{Cluster: target.Cluster.Name, Database: target.Database, Schema: target.Schema, Error: err}
+	})
+}
+
+func (m *Migrator) verifyHistory(ctx context.Context, target Target) ([]HistoryDiscrepancy, int, error) {
+	db, err := connectToDatabase(ctx, target.Cluster, target.Database)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer db.Close()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	if err := applySessionSettings(ctx, conn, m.config.settingsFor(target)); err != nil {
+		return nil, 0, err
+	}
+
+	var exists bool
+	if err := conn.QueryRowContext(ctx, `SELECT to_regclass($1) IS NOT NULL`, historyTable).Scan(&exists); err != nil {
+		return nil, 0, err
+	}
+	if !exists {
+		return nil, len(m.migrations), nil
+	}
+	// Rows are read as JSON so that tables created by older versions,
+	// which lack the later columns, are read without upgrading them.
+	rows, err := conn.QueryContext(ctx, `SELECT to_jsonb(h) FROM `+historyTable+` h`)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read history: %w", err)
+	}
+	defer rows.Close()
+	applied := make(map[string]AppliedMigration)
+	for rows.Next() {
+		var data []byte
+		if err := rows.Scan(&data); err != nil {
+			return nil, 0, err
+		}
+		var row HistoryRecord
+		if err := json.Unmarshal(data, &row); err != nil {
+			return nil, 0, fmt.Errorf("read history: %w", err)
+		}
+		applied[row.Version] = AppliedMigration{Version: row.Version, Description: row.Description, Checksum: row.Checksum, Release: row.Release, Deprecated: row.Deprecated}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	pruned, err := prunedThrough(ctx, conn)
+	if err != nil {
+		return nil, 0, fmt.Errorf("read pruned version: %w", err)
+	}
+
+	var discrepancies []HistoryDiscrepancy
+	files := make(map[string]bool)
+	newest := ""
+	pending := 0
+	for _, migration := range m.migrations {
+		files[migration.Version] = true
+		newest = migration.Version
+		record, ok := applied[migration.Version]
+		switch {
+		case !ok && compareVersions(migration.Version, pruned) > 0:
+			pending++
+		case ok && !m.config.Checksum.matches(record.Checksum, migration.Script):
+			discrepancies = append(discrepancies, HistoryDiscrepancy{
+				Kind:        DiscrepancyChecksum,
+				Version:     migration.Version,
+				Description: migration.Description,
+				Detail:      fmt.Sprintf("recorded %s, file has %s", record.Checksum, m.config.Checksum.Sum(migration.Script)),
+			})
+		}
+	}
+	var unknown []HistoryDiscrepancy
+	for version, record := range applied {
+		switch {
+		case files[version] || record.Deprecated:
+		case newest == "" || compareVersions(version, newest) > 0:
+			unknown = append(unknown, HistoryDiscrepancy{Kind: DiscrepancyUnknownVersion, Version: version, Description: record.Description})
+		default:
+			unknown = append(unknown, HistoryDiscrepancy{Kind: DiscrepancyMissingFile, Version: version, Description: record.Description})
+		}
+	}
+	sort.Slice(unknown, func(i, j int) bool { return compareVersions(unknown[i].Version, unknown[j].Version) < 0 })
+	return append(discrepancies, unknown...), pending, nil
+}
@@ -0,0 +1,61 @@
+package pgmigrate
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// Version, Commit and BuildDate describe the build of the tool. Release
+// builds set them with the linker, e.g.
+//
+//	go build -ldflags "-X github.com/postresql-migration-golang/pgmigrate.Version=1.8.0
+//		-X github.com/postresql-migration-golang/pgmigrate.Commit=$(git rev-parse HEAD)
+//		-X github.com/postresql-migration-golang/pgmigrate.BuildDate=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+//
+// Otherwise Commit and BuildDate are the commit and commit time Go embeds
+// in binaries built from a checkout.
+var (
+	Version   = "dev"
+	Commit    = ""
+	BuildDate = ""
+)
+
+// HistorySchemaVersion is the version of the layout of the history table
+// this build creates and reads. Older layouts are upgraded on the next run.
+//
+//	1: version, description, checksum, applied_at, execution_ms
+//	2: release
+//	3: tool_version
+//	4: deprecated
+const HistorySchemaVersion = 4
+
+// BuildInfo describes the build of the tool.
+type BuildInfo struct {
+	Version              string `json:"version"`
+	Commit               string `json:"commit,omitempty"`
+	BuildDate            string `json:"build_date,omitempty"`
+	GoVersion            string `json:"go_version"`
+	HistorySchemaVersion int    `json:"history_schema_version"`
+}
+
+// Build returns the build information of the tool.
+func Build() BuildInfo {
+	info := BuildInfo{
+		Version:              Version,
+		Commit:               Commit,
+		BuildDate:            BuildDate,
+		GoVersion:            runtime.Version(),
+		HistorySchemaVersion: HistorySchemaVersion,
+	}
+	if build, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range build.Settings {
+			switch {
+			case setting.Key == "vcs.revision" && info.Commit == "":
+				info.Commit = setting.Value
+			case setting.Key == "vcs.time" && info.BuildDate == "":
+				info.BuildDate = setting.Value
+			}
+		}
+	}
+	return info
+}
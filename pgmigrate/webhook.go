@@ -0,0 +1,113 @@
+package pgmigrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+)
+
+// WebhookConfig posts a message about each run to a URL, such as a Slack
+// incoming webhook or a chat or ticketing system's API.
+//
+// Body is a text/template executed with the RunSummary: the run's ID, start
+// and finish, its counts and, in Databases, the outcome of every database
+// with its error, how it failed and how long it took. Failures lists the
+// failed databases and TopError their most common error. Templates may use
+// json to encode a value, such as an error in a JSON payload, and duration
+// to format milliseconds. A Slack message listing the failures:
+//
+//	{"text": {{printf "Run %s: %d failed" .ID .Failed | json}}, "blocks": [
+//	  {{range $i, $f := .Failures}}{{if $i}},{{end}}{"type": "section", "text": {"type": "mrkdwn",
+//	    "text": {{printf "*%s/%s* (%s): %s" $f.Cluster $f.Database (duration $f.DurationMs) $f.Error | json}}}}{{end}}]}
+//
+// Without a body the run manifest is posted.
+type WebhookConfig struct {
+	// URL may be a secret reference.
+	URL string `json:"url"`
+
+	// Headers are added to the request, e.g. an Authorization header.
+	// Their values may be secret references.
+	Headers map[string]string `json:"headers"`
+
+	// ContentType defaults to application/json.
+	ContentType string `json:"content_type"`
+
+	Body string `json:"body"`
+
+	// OnFailure only posts for runs in which a database failed.
+	OnFailure bool `json:"on_failure"`
+}
+
+func (c *WebhookConfig) validate() error {
+	if c.URL == "" {
+		return errors.New("url is required")
+	}
+	if _, err := template.New("body").Funcs(summaryFuncs).Parse(c.Body); err != nil {
+		return fmt.Errorf("body: %w", err)
+	}
+	return nil
+}
+
+// sendWebhook posts the message of a run to a webhook.
+func sendWebhook(ctx context.Context, config *WebhookConfig, summary RunSummary) error {
+	if config.OnFailure && summary.Failed == 0 {
+		return nil
+	}
+	var body []byte
+	if config.Body == "" {
+		manifest, err := summary.Manifest()
+		if err != nil {
+			return err
+		}
+		body = manifest
+	} else {
+		text, err := executeTemplate(config.Body, "", summary)
+		if err != nil {
+			return fmt.Errorf("body: %w", err)
+		}
+		body = []byte(text)
+	}
+
+	endpoint, err := resolveSecret(ctx, config.URL)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	contentType := config.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, value := range config.Headers {
+		value, err := resolveSecret(ctx, value)
+		if err != nil {
+			return fmt.Errorf("header %s: %w", name, err)
+		}
+		req.Header.Set(name, value)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		// The path of a webhook is often its secret, so errors only name
+		// its host.
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("%s: %w", req.URL.Host, urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s %s", req.URL.Host, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
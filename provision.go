@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"strings"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runProvision implements the "provision" command, which creates a tenant
+// database, migrates and seeds it and registers it.
+func runProvision(args []string) error {
+	fs := flag.NewFlagSet("provision", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	cluster := fs.String("cluster", "", "cluster to create the database on (defaults to the only configured one)")
+	template := fs.String("template", "", "database to clone (overrides the configured template)")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return errors.New("usage: provision [flags] <database>")
+	}
+	name := fs.Arg(0)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	if *template != "" {
+		provisioning := pgmigrate.ProvisioningConfig{}
+		if config.Provisioning != nil {
+			provisioning = *config.Provisioning
+		}
+		provisioning.Template = *template
+		config.Provisioning = &provisioning
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	result, err := migrator.Provision(context.Background(), *cluster, name)
+	if len(result.Applied) > 0 {
+		fmt.Printf("Applied: %s\n", strings.Join(result.Applied, ", "))
+	}
+	if len(result.Seeds) > 0 {
+		fmt.Printf("Seeded: %s\n", strings.Join(result.Seeds, ", "))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to provision %s: %w", name, err)
+	}
+	fmt.Printf("Provisioned %s\n", name)
+	return nil
+}
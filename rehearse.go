@@ -0,0 +1,62 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runRehearse implements the "rehearse" command, which applies the pending
+// migrations to temporary clones of the databases and reports how long they
+// took, without modifying the databases themselves.
+func runRehearse(args []string) error {
+	fs := flag.NewFlagSet("rehearse", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the databases to rehearse on, such as a representative tenant (defaults to all)")
+	fs.Parse(args)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	results := migrator.Rehearse(ctx, selectTargets(targets, *database))
+
+	failed := 0
+	for _, result := range results {
+		status := "Success"
+		if result.Error != nil {
+			status = "Failed"
+			failed++
+		}
+		database := result.Database
+		if result.Schema != "" {
+			database += " Schema: " + result.Schema
+		}
+		fmt.Printf("[%s] Cluster: %s Database: %s (%s)\n", status, result.Cluster, database, result.Duration.Round(time.Millisecond))
+		if len(result.Applied) > 0 {
+			fmt.Printf("Applied: %s\n", strings.Join(result.Applied, ", "))
+		}
+		if result.Error != nil {
+			fmt.Printf("Error: %v\n", result.Error)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rehearsals failed", failed, len(results))
+	}
+	return nil
+}
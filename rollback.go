@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runRollback implements the "rollback" command, which reverts the
+// migrations of a release on every database with their down migrations.
+func runRollback(args []string) error {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	release := fs.String("release", "", "release whose migrations are reverted")
+	database := fs.String("database", "", "name or pattern of the databases to roll back (defaults to all)")
+	fs.Parse(args)
+	if *release == "" {
+		return errors.New("rollback: -release is required")
+	}
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	targets = selectTargets(targets, *database)
+	return printMaintenanceResults(migrator.RollbackRelease(ctx, targets, *release))
+}
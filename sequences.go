@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runSequences implements the "sequences" command, which reports the
+// sequences behind the values of their column in every database and, with
+// -fix, advances them.
+func runSequences(args []string) error {
+	fs := flag.NewFlagSet("sequences", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the databases to check (defaults to all)")
+	fix := fs.Bool("fix", false, "advance the sequences that are behind")
+	fs.Parse(args)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	results := migrator.CheckSequences(ctx, selectTargets(targets, *database), *fix)
+
+	failed, behind := 0, 0
+	for _, result := range results {
+		if result.Error != nil {
+			failed++
+			fmt.Printf("[Failed] Cluster: %s Database: %s\n", result.Cluster, result.Database)
+			fmt.Printf("Error: %v\n", result.Error)
+			continue
+		}
+		fmt.Printf("[Success] Cluster: %s Database: %s\n", result.Cluster, result.Database)
+		for _, issue := range result.Changes {
+			fmt.Printf("Sequence: %s\n", issue)
+		}
+		behind += len(result.Changes)
+	}
+	switch {
+	case failed > 0:
+		return fmt.Errorf("%d of %d databases failed", failed, len(results))
+	case behind > 0 && !*fix:
+		return fmt.Errorf("%d sequences are behind their column; rerun with -fix to advance them", behind)
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+)
+
+// serviceAccountNamespaceFile holds the namespace of the pod the tool runs
+// in, when it runs inside Kubernetes.
+const serviceAccountNamespaceFile = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+
+// runServe implements the "serve" command, which stays up and migrates the
+// fleet on a fixed interval until it receives SIGINT or SIGTERM. With
+// -leader-elect, replicas elect a leader through a Kubernetes Lease and only
+// the leader runs migrations; the others take over if it goes away. The
+// configuration and migrations are loaded again before every run, so new
+// migrations are picked up without a restart.
+func runServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	interval := fs.Duration("interval", time.Hour, "time between migration runs")
+	leaderElect := fs.Bool("leader-elect", false, "elect a leader through a Kubernetes Lease before running")
+	leaseName := fs.String("lease-name", "pgmigrate", "name of the Kubernetes Lease used for leader election")
+	leaseNamespace := fs.String("lease-namespace", "", "namespace of the Lease (defaults to the pod's namespace)")
+	identity := fs.String("identity", "", "identity of this replica in the election (defaults to the host name)")
+	fs.Parse(args)
+
+	load := func() (*pgmigrate.Migrator, error) {
+		config, err := common.load()
+		if err != nil {
+			return nil, err
+		}
+		return pgmigrate.New(config)
+	}
+	// The first load fails the command, so a broken deployment is noticed
+	// when it starts rather than at its next run.
+	migrator, err := load()
+	if err != nil {
+		return err
+	}
+	schedule := &schedule{migrator: migrator, load: load, interval: *interval, fresh: true}
+	defer schedule.close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if !*leaderElect {
+		schedule.run(ctx)
+		return nil
+	}
+
+	if *identity == "" {
+		*identity, err = os.Hostname()
+		if err != nil {
+			return err
+		}
+	}
+	if *leaseNamespace == "" {
+		data, err := os.ReadFile(serviceAccountNamespaceFile)
+		if err != nil {
+			return fmt.Errorf("-lease-namespace is required outside Kubernetes: %w", err)
+		}
+		*leaseNamespace = strings.TrimSpace(string(data))
+	}
+
+	restConfig, err := rest.InClusterConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load in-cluster Kubernetes configuration: %w", err)
+	}
+	client, err := kubernetes.NewForConfig(restConfig)
+	if err != nil {
+		return err
+	}
+
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock: &resourcelock.LeaseLock{
+			LeaseMeta:  metav1.ObjectMeta{Name: *leaseName, Namespace: *leaseNamespace},
+			Client:     client.CoordinationV1(),
+			LockConfig: resourcelock.ResourceLockConfig{Identity: *identity},
+		},
+		LeaseDuration:   15 * time.Second,
+		RenewDeadline:   10 * time.Second,
+		RetryPeriod:     2 * time.Second,
+		ReleaseOnCancel: true,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(ctx context.Context) {
+				log.Printf("%s is now the leader", *identity)
+				schedule.run(ctx)
+			},
+			OnStoppedLeading: func() {
+				log.Printf("%s is no longer the leader", *identity)
+			},
+			OnNewLeader: func(leader string) {
+				if leader != *identity {
+					log.Printf("standing by; current leader is %s", leader)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	// Run returns whenever leadership is lost; rejoin the election until
+	// the process is asked to stop.
+	for ctx.Err() == nil {
+		elector.Run(ctx)
+	}
+	return nil
+}
+
+// schedule migrates the fleet on an interval, loading the configuration and
+// migrations again before each run.
+type schedule struct {
+	// migrator is the last migrator loaded, which runs again when loading
+	// a new one fails.
+	migrator *pgmigrate.Migrator
+	load     func() (*pgmigrate.Migrator, error)
+	interval time.Duration
+	// fresh is set while the migrator has not run since it was loaded.
+	fresh bool
+}
+
+// run migrates the fleet immediately and then once per interval until ctx
+// is canceled.
+func (s *schedule) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	for {
+		s.reload()
+		results, err := s.migrator.Run(ctx)
+		if err != nil {
+			log.Printf("Migration run failed: %v", err)
+		} else {
+			printMigrationResults(results, false)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// reload replaces the migrator by one loaded from the current
+// configuration and migrations, unless loading them fails, in which case
+// the previous one is kept.
+func (s *schedule) reload() {
+	if s.fresh {
+		s.fresh = false
+		return
+	}
+	migrator, err := s.load()
+	if err != nil {
+		log.Printf("WARNING: failed to reload, running the previous configuration and migrations: %v", err)
+		return
+	}
+	s.migrator.Close()
+	s.migrator = migrator
+}
+
+func (s *schedule) close() {
+	s.migrator.Close()
+}
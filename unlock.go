@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runUnlock implements the "unlock" command, which shows the backends
+// holding the migration locks of the selected databases and terminates
+// them to release the locks of crashed runs.
+func runUnlock(args []string) error {
+	fs := flag.NewFlagSet("unlock", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the databases to unlock")
+	all := fs.Bool("all", false, "unlock every database")
+	dryRun := fs.Bool("dry-run", false, "show the lock holders without terminating them")
+	force := fs.Bool("force", false, "terminate holders that are running a statement too")
+	fs.Parse(args)
+	if (*database == "") == !*all {
+		return errors.New("unlock: give either -database or -all")
+	}
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	results := migrator.Unlock(ctx, selectTargets(targets, *database), pgmigrate.UnlockOptions{DryRun: *dryRun, Force: *force})
+
+	failed, locked := 0, 0
+	for _, result := range results {
+		name := result.Database
+		if result.Schema != "" {
+			name += "/" + result.Schema
+		}
+		if result.Holder == nil && result.Error == nil {
+			continue
+		}
+		if holder := result.Holder; holder != nil {
+			locked++
+			status := "Locked"
+			if holder.Released {
+				status = "Released"
+			}
+			fmt.Printf("[%s] Cluster: %s Database: %s\n", status, result.Cluster, name)
+			fmt.Printf("  backend %d, user %s, application %q, client %s\n", holder.PID, holder.User, holder.Application, holder.ClientAddr)
+			fmt.Printf("  connected %s, %s since %s (%s ago)\n", holder.BackendStart.Format(time.RFC3339), holder.State,
+				holder.Since.Format(time.RFC3339), time.Since(holder.Since).Round(time.Second))
+			if holder.Query != "" {
+				fmt.Printf("  last statement: %s\n", holder.Query)
+			}
+		}
+		if result.Error != nil {
+			failed++
+			if result.Holder == nil {
+				fmt.Printf("[Failed] Cluster: %s Database: %s\n", result.Cluster, name)
+			}
+			fmt.Printf("Error: %v\n", result.Error)
+		}
+	}
+	if locked == 0 && failed == 0 {
+		fmt.Println("No migration locks are held")
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d databases failed", failed, len(results))
+	}
+	return nil
+}
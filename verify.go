@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runVerify implements the "verify" command, which checks the history of
+// every database against the migration files without applying anything and
+// fails on any discrepancy, for use as a CI gate.
+func runVerify(args []string) error {
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the databases to verify (defaults to all)")
+	fs.Parse(args)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	results := migrator.VerifyHistory(ctx, selectTargets(targets, *database))
+
+	failed, discrepancies := 0, 0
+	for _, result := range results {
+		name := result.Database
+		if result.Schema != "" {
+			name += "/" + result.Schema
+		}
+		switch {
+		case result.Error != nil:
+			failed++
+			fmt.Printf("[Failed] Cluster: %s Database: %s\n", result.Cluster, name)
+			fmt.Printf("Error: %v\n", result.Error)
+			continue
+		case len(result.Discrepancies) > 0:
+			fmt.Printf("[Mismatch] Cluster: %s Database: %s\n", result.Cluster, name)
+		default:
+			fmt.Printf("[OK] Cluster: %s Database: %s\n", result.Cluster, name)
+		}
+		for _, discrepancy := range result.Discrepancies {
+			fmt.Printf("  %s\n", discrepancy)
+		}
+		if result.Pending > 0 {
+			fmt.Printf("  %d pending migrations\n", result.Pending)
+		}
+		discrepancies += len(result.Discrepancies)
+	}
+	switch {
+	case failed > 0:
+		return fmt.Errorf("%d of %d databases failed", failed, len(results))
+	case discrepancies > 0:
+		return fmt.Errorf("%d discrepancies between the history and the migration files", discrepancies)
+	}
+	return nil
+}
+
+// runVerifyIdempotent implements the "verify-idempotent" command. It checks
+// the pending migrations against disposable copies of the databases matching
+// -database, or of the first database found when none is given.
+func runVerifyIdempotent(args []string) error {
+	fs := flag.NewFlagSet("verify-idempotent", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the databases to copy (defaults to the first database found)")
+	fromScratch := fs.Bool("from-scratch", false, "verify every migration against an empty database instead of a copy")
+	fs.Parse(args)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	targets = selectTargets(targets, *database)
+	if len(targets) == 0 {
+		return errors.New("no database to verify against")
+	}
+	if *database == "" {
+		targets = targets[:1]
+	}
+
+	failed := false
+	for _, target := range targets {
+		fmt.Printf("Database: %s (cluster %s)\n", target.Database, target.Cluster.Name)
+		results, err := migrator.VerifyIdempotent(ctx, target, *fromScratch)
+		if err != nil {
+			fmt.Printf("Error: %v\n", err)
+			failed = true
+			continue
+		}
+		if len(results) == 0 {
+			fmt.Println("No pending migrations")
+		}
+		for _, result := range results {
+			if result.Error != nil {
+				fmt.Printf("[Not idempotent] %s %s: %v\n", result.Version, result.Description, result.Error)
+				failed = true
+			} else {
+				fmt.Printf("[Idempotent] %s %s\n", result.Version, result.Description)
+			}
+		}
+	}
+	if failed {
+		return errors.New("idempotency verification failed")
+	}
+	return nil
+}
+
+// runVerifyDown implements the "verify-down" command. It checks every down
+// migration on a scratch database of the cluster of the database matching
+// -database, or of the first database found when none is given.
+func runVerifyDown(args []string) error {
+	fs := flag.NewFlagSet("verify-down", flag.ExitOnError)
+	common := addCommonFlags(fs)
+	database := fs.String("database", "", "name or pattern of the database whose cluster and template variables to use (defaults to the first database found)")
+	requireDown := fs.Bool("require", false, "fail when a migration has no down migration")
+	fs.Parse(args)
+
+	config, err := common.load()
+	if err != nil {
+		return err
+	}
+	migrator, err := pgmigrate.New(config)
+	if err != nil {
+		return err
+	}
+	defer migrator.Close()
+
+	ctx := context.Background()
+	targets, err := migrator.Targets(ctx)
+	if err != nil {
+		return err
+	}
+	targets = selectTargets(targets, *database)
+	if len(targets) == 0 {
+		return errors.New("no database to verify against")
+	}
+
+	results, err := migrator.VerifyDown(ctx, targets[0])
+	failed := err != nil
+	for _, result := range results {
+		switch {
+		case result.Missing:
+			fmt.Printf("[No down migration] %s %s\n", result.Version, result.Description)
+			failed = failed || *requireDown
+		case result.Error != nil:
+			fmt.Printf("[Failed] %s %s: %v\n", result.Version, result.Description, result.Error)
+			for _, change := range result.Differences {
+				fmt.Printf("  %s\n", change)
+			}
+			failed = true
+		default:
+			fmt.Printf("[Reversible] %s %s\n", result.Version, result.Description)
+		}
+	}
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+	}
+	if failed {
+		return errors.New("down migration verification failed")
+	}
+	return nil
+}
+
+// selectTargets returns the targets whose database matches pattern, or all
+// of them when pattern is empty.
+func selectTargets(targets []pgmigrate.Target, pattern string) []pgmigrate.Target {
+	if pattern == "" {
+		return targets
+	}
+	var selected []pgmigrate.Target
+	for _, target := range targets {
+		if pgmigrate.MatchDatabase(pattern, target.Database) {
+			selected = append(selected, target)
+		}
+	}
+	return selected
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/postresql-migration-golang/pgmigrate"
+)
+
+// runVersion implements the "version" command, which prints the build of
+// the tool.
+func runVersion(args []string) error {
+	fs := flag.NewFlagSet("version", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "print the build information as JSON")
+	fs.Parse(args)
+
+	build := pgmigrate.Build()
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(build)
+	}
+	fmt.Printf("pgmigrate %s\n", build.Version)
+	if build.Commit != "" {
+		fmt.Printf("Commit: %s\n", build.Commit)
+	}
+	if build.BuildDate != "" {
+		fmt.Printf("Built: %s\n", build.BuildDate)
+	}
+	fmt.Printf("Go: %s\n", build.GoVersion)
+	fmt.Printf("History schema: %d\n", build.HistorySchemaVersion)
+	return nil
+}